@@ -1,8 +1,12 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"github.com/sirupsen/logrus"
 	"go_code_reviewer/pkg/log"
+	"os/signal"
+	"syscall"
 )
 
 const (
@@ -10,8 +14,8 @@ const (
 )
 
 type ServiceInterface interface {
-	Start()
-	Close()
+	// Register adds the components of the service to the lifecycle.
+	Register(lifecycle *Lifecycle) error
 }
 
 func RunService(serviceName string, service ServiceInterface) {
@@ -22,6 +26,32 @@ func RunService(serviceName string, service ServiceInterface) {
 		Service:   serviceName,
 	})
 
-	service.Start()
-	service.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, service); err != nil {
+		log.GetLogger().WithError(err).Fatal("service failed")
+	}
+}
+
+// run starts the components of the service and stops them once ctx is done or one of their loops fails.
+func run(ctx context.Context, service ServiceInterface) error {
+	logger := log.GetLogger()
+	lifecycle := NewLifecycle()
+	if err := service.Register(lifecycle); err != nil {
+		return err
+	}
+	if err := lifecycle.Start(ctx); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		logger.Info("shutdown signal received, stopping service")
+	case <-lifecycle.Failed():
+		logger.WithError(lifecycle.Err()).Error("component failed, stopping service")
+	}
+
+	err := errors.Join(lifecycle.Err(), lifecycle.Stop(context.Background()))
+	logger.Info("service stopped")
+	return err
 }
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *recorder) record(call string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, call)
+}
+
+func (r *recorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.calls...)
+}
+
+func (r *recorder) component(name string, dependsOn ...string) Component {
+	return Component{
+		Name:      name,
+		DependsOn: dependsOn,
+		Start: func(context.Context) error {
+			r.record("start " + name)
+			return nil
+		},
+		Stop: func(context.Context) error {
+			r.record("stop " + name)
+			return nil
+		},
+	}
+}
+
+type serviceFunc func(lifecycle *Lifecycle) error
+
+func (f serviceFunc) Register(lifecycle *Lifecycle) error {
+	return f(lifecycle)
+}
+
+func TestRun_StopsComponentsOnSignal(t *testing.T) {
+	calls := &recorder{}
+	service := serviceFunc(func(lifecycle *Lifecycle) error {
+		return lifecycle.Register(calls.component("kafka"), calls.component("processor", "kafka"))
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	finished := make(chan error)
+	go func() {
+		finished <- run(ctx, service)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-finished:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("run did not return after the context was cancelled")
+	}
+	assert.Equal(t, []string{"start kafka", "start processor", "stop processor", "stop kafka"}, calls.get())
+}
+
+func TestRun_StopsComponentsWhenLoopFails(t *testing.T) {
+	calls := &recorder{}
+	errPoll := errors.New("broker unreachable")
+	service := serviceFunc(func(lifecycle *Lifecycle) error {
+		consumer := calls.component("consumer")
+		consumer.Start = func(context.Context) error {
+			calls.record("start consumer")
+			lifecycle.Go("consumer", func() error { return errPoll })
+			return nil
+		}
+		return lifecycle.Register(consumer)
+	})
+
+	err := run(context.Background(), service)
+	assert.ErrorIs(t, err, errPoll)
+	assert.Equal(t, []string{"start consumer", "stop consumer"}, calls.get())
+}
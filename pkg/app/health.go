@@ -0,0 +1,102 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHealthCacheTTL = 10 * time.Second
+	healthCheckTimeout    = 5 * time.Second
+)
+
+// HealthCache runs the health checks of a lifecycle at most once per ttl, so frequent probes do not put load
+// on the dependencies they check. Concurrent probes share the check in progress.
+type HealthCache struct {
+	lifecycle *Lifecycle
+	ttl       time.Duration
+	timeout   time.Duration
+
+	mu      sync.Mutex
+	report  HealthReport
+	running *healthCheck
+}
+
+// healthCheck is a run of the health checks, its report is set once done is closed.
+type healthCheck struct {
+	done   chan struct{}
+	report HealthReport
+}
+
+func NewHealthCache(lifecycle *Lifecycle, ttl time.Duration) *HealthCache {
+	return &HealthCache{lifecycle: lifecycle, ttl: ttl, timeout: healthCheckTimeout}
+}
+
+// Report returns the cached report, or runs the checks once it is older than the ttl. The checks do not
+// depend on ctx, a probe giving up neither cancels them for the other probes nor gets its cancellation
+// cached; it is reported down.
+func (c *HealthCache) Report(ctx context.Context) HealthReport {
+	c.mu.Lock()
+	if !c.report.CheckedAt.IsZero() && time.Since(c.report.CheckedAt) < c.ttl {
+		defer c.mu.Unlock()
+		return c.report
+	}
+	check := c.running
+	if check == nil {
+		check = &healthCheck{done: make(chan struct{})}
+		c.running = check
+		go c.run(context.WithoutCancel(ctx), check)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-check.done:
+		return check.report
+	case <-ctx.Done():
+		return HealthReport{Status: StatusDown, CheckedAt: time.Now()}
+	}
+}
+
+// run checks the lifecycle with its own timeout and caches the report unless the checks ran out of time.
+func (c *HealthCache) run(ctx context.Context, check *healthCheck) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	check.report = c.lifecycle.Health(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ctx.Err() == nil {
+		c.report = check.report
+	}
+	c.running = nil
+	close(check.done)
+}
+
+// LivenessHandler reports that the process is able to serve requests without checking any dependency,
+// so a dependency outage does not get the pod restarted.
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeHealth(w, http.StatusOK, HealthReport{Status: StatusUp, CheckedAt: time.Now()})
+	})
+}
+
+// ReadinessHandler responds with the cached health report and 503 when any component is down.
+func (c *HealthCache) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Report(r.Context())
+		status := http.StatusOK
+		if !report.Healthy() {
+			status = http.StatusServiceUnavailable
+		}
+		writeHealth(w, status, report)
+	})
+}
+
+func writeHealth(w http.ResponseWriter, status int, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
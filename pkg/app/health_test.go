@@ -0,0 +1,120 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthCache_ReadinessReportsComponents(t *testing.T) {
+	calls := &recorder{}
+	checks := 0
+	kafka := calls.component("kafka")
+	kafka.Health = func(context.Context) error {
+		checks++
+		return errors.New("no brokers reachable")
+	}
+
+	lifecycle := NewLifecycle()
+	require.NoError(t, lifecycle.Register(kafka, calls.component("http-server", "kafka")))
+	require.NoError(t, lifecycle.Start(context.Background()))
+	cache := NewHealthCache(lifecycle, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		recorder := httptest.NewRecorder()
+		cache.ReadinessHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+		var report HealthReport
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+		assert.Equal(t, StatusDown, report.Status)
+		assert.Equal(t, ComponentHealth{Status: StatusDown, Error: "no brokers reachable"}, report.Components["kafka"])
+		assert.Equal(t, ComponentHealth{Status: StatusUp}, report.Components["http-server"])
+	}
+	assert.Equal(t, 1, checks, "checks should be cached for the ttl")
+}
+
+func TestHealthCache_RechecksAfterTTL(t *testing.T) {
+	calls := &recorder{}
+	checks := 0
+	chroma := calls.component("chroma")
+	chroma.Health = func(context.Context) error {
+		checks++
+		return nil
+	}
+
+	lifecycle := NewLifecycle()
+	require.NoError(t, lifecycle.Register(chroma))
+	require.NoError(t, lifecycle.Start(context.Background()))
+	cache := NewHealthCache(lifecycle, 0)
+
+	assert.True(t, cache.Report(context.Background()).Healthy())
+	assert.True(t, cache.Report(context.Background()).Healthy())
+	assert.Equal(t, 2, checks)
+}
+
+func TestHealthCache_ChecksOutliveTheProbe(t *testing.T) {
+	calls := &recorder{}
+	checks := 0
+	release := make(chan struct{})
+	redis := calls.component("redis")
+	redis.Health = func(ctx context.Context) error {
+		<-release
+		checks++
+		return ctx.Err()
+	}
+
+	lifecycle := NewLifecycle()
+	require.NoError(t, lifecycle.Register(redis))
+	require.NoError(t, lifecycle.Start(context.Background()))
+	cache := NewHealthCache(lifecycle, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, cache.Report(ctx).Healthy(), "the probe gave up")
+
+	close(release)
+	assert.True(t, cache.Report(context.Background()).Healthy(), "the check is not cancelled with the probe")
+	assert.True(t, cache.Report(context.Background()).Healthy())
+	assert.Equal(t, 1, checks)
+}
+
+func TestHealthCache_TimedOutChecksAreNotCached(t *testing.T) {
+	calls := &recorder{}
+	checks := 0
+	qdrant := calls.component("qdrant")
+	qdrant.Health = func(ctx context.Context) error {
+		checks++
+		if checks == 1 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}
+
+	lifecycle := NewLifecycle()
+	require.NoError(t, lifecycle.Register(qdrant))
+	require.NoError(t, lifecycle.Start(context.Background()))
+	cache := NewHealthCache(lifecycle, time.Minute)
+	cache.timeout = 10 * time.Millisecond
+
+	report := cache.Report(context.Background())
+	assert.Equal(t, ComponentHealth{Status: StatusDown, Error: context.DeadlineExceeded.Error()}, report.Components["qdrant"])
+	assert.True(t, cache.Report(context.Background()).Healthy())
+	assert.True(t, cache.Report(context.Background()).Healthy())
+	assert.Equal(t, 2, checks)
+}
+
+func TestLivenessHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	LivenessHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/liveness", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"status":"up"`)
+}
@@ -0,0 +1,241 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go_code_reviewer/pkg/log"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDuplicateComponent = errors.New("component is already registered")
+	ErrUnknownDependency  = errors.New("component depends on an unknown component")
+	ErrDependencyCycle    = errors.New("component dependencies form a cycle")
+	ErrNotStarted         = errors.New("component is not started")
+)
+
+// Component is a part of a service with its own start and stop hooks. Start must return once the component
+// is ready; long-running loops belong in Lifecycle.Go. Every hook is optional.
+type Component struct {
+	Name      string
+	DependsOn []string
+	Start     func(ctx context.Context) error
+	Stop      func(ctx context.Context) error
+	Health    func(ctx context.Context) error
+}
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+type ComponentHealth struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type HealthReport struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentHealth `json:"components,omitempty"`
+	CheckedAt  time.Time                  `json:"checked_at"`
+}
+
+func (r HealthReport) Healthy() bool {
+	return r.Status == StatusUp
+}
+
+// Lifecycle starts the registered components in dependency order and stops them in reverse order.
+type Lifecycle struct {
+	mu         sync.Mutex
+	components []*Component
+	started    []*Component
+
+	failOnce sync.Once
+	failed   chan struct{}
+	failure  error
+}
+
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{failed: make(chan struct{})}
+}
+
+func (l *Lifecycle) Register(components ...Component) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, component := range components {
+		for _, registered := range l.components {
+			if registered.Name == component.Name {
+				return fmt.Errorf("%w: %s", ErrDuplicateComponent, component.Name)
+			}
+		}
+		component := component
+		l.components = append(l.components, &component)
+	}
+	return nil
+}
+
+// Start runs the start hooks in dependency order. If a hook fails, the components started so far are stopped.
+func (l *Lifecycle) Start(ctx context.Context) error {
+	l.mu.Lock()
+	ordered, err := l.order()
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	logger := log.GetLogger()
+	for _, component := range ordered {
+		if component.Start != nil {
+			if err := component.Start(ctx); err != nil {
+				logger.WithError(err).WithField("component", component.Name).Error("failed to start component")
+				return errors.Join(fmt.Errorf("start %s: %w", component.Name, err), l.Stop(context.WithoutCancel(ctx)))
+			}
+		}
+		l.mu.Lock()
+		l.started = append(l.started, component)
+		l.mu.Unlock()
+		logger.WithField("component", component.Name).Info("component started")
+	}
+	return nil
+}
+
+// Stop runs the stop hooks of the started components in reverse start order and returns all their errors.
+func (l *Lifecycle) Stop(ctx context.Context) error {
+	l.mu.Lock()
+	started := l.started
+	l.started = nil
+	l.mu.Unlock()
+
+	logger := log.GetLogger()
+	var errs []error
+	for i := len(started) - 1; i >= 0; i-- {
+		component := started[i]
+		if component.Stop == nil {
+			continue
+		}
+		if err := component.Stop(ctx); err != nil {
+			logger.WithError(err).WithField("component", component.Name).Error("failed to stop component")
+			errs = append(errs, fmt.Errorf("stop %s: %w", component.Name, err))
+			continue
+		}
+		logger.WithField("component", component.Name).Info("component stopped")
+	}
+	return errors.Join(errs...)
+}
+
+// Health runs the health checks of every registered component concurrently. The report is up only if all
+// of them pass.
+func (l *Lifecycle) Health(ctx context.Context) HealthReport {
+	l.mu.Lock()
+	components := append([]*Component(nil), l.components...)
+	started := make(map[string]bool, len(l.started))
+	for _, component := range l.started {
+		started[component.Name] = true
+	}
+	l.mu.Unlock()
+
+	errs := make([]error, len(components))
+	var wg sync.WaitGroup
+	for i, component := range components {
+		if !started[component.Name] {
+			errs[i] = ErrNotStarted
+			continue
+		}
+		if component.Health == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = component.Health(ctx)
+		}()
+	}
+	wg.Wait()
+
+	report := HealthReport{Status: StatusUp, Components: make(map[string]ComponentHealth, len(components)), CheckedAt: time.Now()}
+	for i, component := range components {
+		if errs[i] != nil {
+			report.Status = StatusDown
+			report.Components[component.Name] = ComponentHealth{Status: StatusDown, Error: errs[i].Error()}
+			continue
+		}
+		report.Components[component.Name] = ComponentHealth{Status: StatusUp}
+	}
+	return report
+}
+
+// Go runs a long-running loop of a component. If it returns an error the lifecycle is marked as failed.
+func (l *Lifecycle) Go(name string, run func() error) {
+	go func() {
+		if err := run(); err != nil {
+			l.failOnce.Do(func() {
+				l.failure = fmt.Errorf("%s: %w", name, err)
+				close(l.failed)
+			})
+		}
+	}()
+}
+
+// Failed is closed once a loop started with Go returns an error.
+func (l *Lifecycle) Failed() <-chan struct{} {
+	return l.failed
+}
+
+func (l *Lifecycle) Err() error {
+	select {
+	case <-l.failed:
+		return l.failure
+	default:
+		return nil
+	}
+}
+
+// order sorts the components topologically, keeping the registration order among independent components.
+func (l *Lifecycle) order() ([]*Component, error) {
+	byName := make(map[string]*Component, len(l.components))
+	for _, component := range l.components {
+		byName[component.Name] = component
+	}
+	for _, component := range l.components {
+		for _, dependency := range component.DependsOn {
+			if _, ok := byName[dependency]; !ok {
+				return nil, fmt.Errorf("%w: %s depends on %s", ErrUnknownDependency, component.Name, dependency)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(l.components))
+	ordered := make([]*Component, 0, len(l.components))
+	var visit func(component *Component) error
+	visit = func(component *Component) error {
+		switch state[component.Name] {
+		case visiting:
+			return fmt.Errorf("%w: %s", ErrDependencyCycle, component.Name)
+		case visited:
+			return nil
+		}
+		state[component.Name] = visiting
+		for _, dependency := range component.DependsOn {
+			if err := visit(byName[dependency]); err != nil {
+				return err
+			}
+		}
+		state[component.Name] = visited
+		ordered = append(ordered, component)
+		return nil
+	}
+
+	for _, component := range l.components {
+		if err := visit(component); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
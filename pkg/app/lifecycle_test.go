@@ -0,0 +1,85 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLifecycle_StartsInDependencyOrder(t *testing.T) {
+	calls := &recorder{}
+	lifecycle := NewLifecycle()
+	require.NoError(t, lifecycle.Register(
+		calls.component("processor", "consumer", "chroma"),
+		calls.component("consumer"),
+		calls.component("metrics"),
+		calls.component("chroma"),
+	))
+
+	require.NoError(t, lifecycle.Start(context.Background()))
+	require.NoError(t, lifecycle.Stop(context.Background()))
+
+	assert.Equal(t, []string{
+		"start consumer", "start chroma", "start processor", "start metrics",
+		"stop metrics", "stop processor", "stop chroma", "stop consumer",
+	}, calls.get())
+}
+
+func TestLifecycle_StopsStartedComponentsWhenStartFails(t *testing.T) {
+	calls := &recorder{}
+	errConnect := errors.New("connection refused")
+	failing := calls.component("chroma", "kafka")
+	failing.Start = func(context.Context) error {
+		return errConnect
+	}
+
+	lifecycle := NewLifecycle()
+	require.NoError(t, lifecycle.Register(calls.component("kafka"), failing, calls.component("processor", "chroma")))
+
+	err := lifecycle.Start(context.Background())
+	assert.ErrorIs(t, err, errConnect)
+	assert.Equal(t, []string{"start kafka", "stop kafka"}, calls.get())
+}
+
+func TestLifecycle_RejectsInvalidDependencies(t *testing.T) {
+	calls := &recorder{}
+
+	unknown := NewLifecycle()
+	require.NoError(t, unknown.Register(calls.component("processor", "kafka")))
+	assert.ErrorIs(t, unknown.Start(context.Background()), ErrUnknownDependency)
+
+	cycle := NewLifecycle()
+	require.NoError(t, cycle.Register(calls.component("a", "b"), calls.component("b", "a")))
+	assert.ErrorIs(t, cycle.Start(context.Background()), ErrDependencyCycle)
+
+	duplicate := NewLifecycle()
+	require.NoError(t, duplicate.Register(calls.component("a")))
+	assert.ErrorIs(t, duplicate.Register(calls.component("a")), ErrDuplicateComponent)
+
+	assert.Empty(t, calls.get())
+}
+
+func TestLifecycle_Health(t *testing.T) {
+	calls := &recorder{}
+	errUnreachable := errors.New("chroma unreachable")
+	chroma := calls.component("chroma")
+	chroma.Health = func(context.Context) error {
+		return errUnreachable
+	}
+
+	lifecycle := NewLifecycle()
+	require.NoError(t, lifecycle.Register(calls.component("kafka"), chroma))
+
+	report := lifecycle.Health(context.Background())
+	assert.False(t, report.Healthy())
+	assert.Equal(t, ErrNotStarted.Error(), report.Components["kafka"].Error)
+
+	require.NoError(t, lifecycle.Start(context.Background()))
+	report = lifecycle.Health(context.Background())
+	assert.False(t, report.Healthy())
+	assert.Equal(t, StatusUp, report.Components["kafka"].Status)
+	assert.Equal(t, ComponentHealth{Status: StatusDown, Error: errUnreachable.Error()}, report.Components["chroma"])
+}
@@ -1,10 +1,15 @@
 package kafka
 
+import "time"
+
 const (
-	bootstrapServersKey = "bootstrap.servers"
-	groupIdKey          = "group.id"
-	autoOffsetResetKey  = "auto.offset.reset"
-	enableAutoCommitKey = "enable.auto.commit"
+	bootstrapServersKey  = "bootstrap.servers"
+	groupIdKey           = "group.id"
+	autoOffsetResetKey   = "auto.offset.reset"
+	enableAutoCommitKey  = "enable.auto.commit"
+	enableIdempotenceKey = "enable.idempotence"
+	acksKey              = "acks"
+	messageTimeoutKey    = "message.timeout.ms"
 )
 
 type ConsumerConfig struct {
@@ -12,9 +17,34 @@ type ConsumerConfig struct {
 	GroupID    string
 	Topics     []string
 	AutoOffset string
+	// DrainTimeout bounds how long a revoked partition waits for its in-flight messages before they are cancelled.
+	DrainTimeout time.Duration
 }
 
 type ProducerConfig struct {
-	Brokers        string
-	metricsHandler func(status string)
+	Brokers string
+	// DeliveryTimeout bounds how long a message may wait for its delivery report, including retries.
+	DeliveryTimeout time.Duration
+}
+
+// SendTimeout is how long Send waits for a delivery report. It outlasts the DeliveryTimeout, after which
+// librdkafka fails the message itself, so ErrDeliveryTimeout means the report was lost, not that the message
+// is still in flight.
+func (c ProducerConfig) SendTimeout() time.Duration {
+	if c.DeliveryTimeout <= 0 {
+		c.DeliveryTimeout = defaultDeliveryTimeout
+	}
+	return c.DeliveryTimeout + deliveryReportGrace
+}
+
+type RetryTier struct {
+	Topic string
+	Delay time.Duration
+}
+
+type RetryConfig struct {
+	Tiers           []RetryTier
+	DeadLetterTopic string
+	// RouteTimeout bounds how long routing a failed message is retried.
+	RouteTimeout time.Duration
 }
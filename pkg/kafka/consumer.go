@@ -1,20 +1,44 @@
 package kafka
 
 import (
+	"context"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"go_code_reviewer/pkg/log"
+	"sync"
+	"time"
 )
 
 const (
 	defaultTimeoutMeiliSeconds = 1000
 	defaultChannelSize         = 2000
+	defaultDrainTimeout        = 30 * time.Second
+	drainCheckInterval         = 50 * time.Millisecond
 )
 
+// consumerClient is the part of *kafka.Consumer the consumer relies on.
+type consumerClient interface {
+	SubscribeTopics(topics []string, rebalanceCb kafka.RebalanceCb) error
+	Poll(timeoutMs int) kafka.Event
+	Assign(partitions []kafka.TopicPartition) error
+	Unassign() error
+	CommitOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error)
+	Pause(partitions []kafka.TopicPartition) error
+	Resume(partitions []kafka.TopicPartition) error
+	Seek(partition kafka.TopicPartition, timeoutMs int) error
+	GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error)
+	Close() error
+}
+
 type kafkaConsumer struct {
 	conf                  ConsumerConfig
-	client                *kafka.Consumer
+	client                consumerClient
 	messagesChan          chan *kafka.Message
+	offsets               *offsetTracker
 	observeConsumeCounter func(status string)
+
+	stopOnce sync.Once
+	stopping chan struct{}
+	running  sync.Mutex
 }
 
 type Option func(consumer *kafkaConsumer)
@@ -26,10 +50,6 @@ func WithMetricsHandler(handler func(status string)) Option {
 }
 
 func NewConsumer(conf ConsumerConfig, opts ...Option) (Consumer, error) {
-	consumer := kafkaConsumer{}
-	for _, opt := range opts {
-		opt(&consumer)
-	}
 	client, err := kafka.NewConsumer(&kafka.ConfigMap{
 		bootstrapServersKey: conf.Brokers,
 		groupIdKey:          conf.GroupID,
@@ -40,28 +60,72 @@ func NewConsumer(conf ConsumerConfig, opts ...Option) (Consumer, error) {
 		log.GetLogger().WithError(err).Fatal("failed to connect to kafka")
 	}
 
-	if err := client.SubscribeTopics(conf.Topics, nil); err != nil {
+	consumer := newConsumer(conf, client, opts...)
+	if err := client.SubscribeTopics(conf.Topics, func(_ *kafka.Consumer, event kafka.Event) error {
+		return consumer.rebalance(event)
+	}); err != nil {
 		log.GetLogger().WithError(err).Fatal("failed to subscribe to topics")
 	}
 
-	consumer.conf = conf
-	consumer.client = client
-	consumer.messagesChan = make(chan *kafka.Message, defaultChannelSize)
-	return &consumer, nil
+	return consumer, nil
+}
+
+func newConsumer(conf ConsumerConfig, client consumerClient, opts ...Option) *kafkaConsumer {
+	if conf.DrainTimeout <= 0 {
+		conf.DrainTimeout = defaultDrainTimeout
+	}
+
+	consumer := &kafkaConsumer{
+		conf:         conf,
+		client:       client,
+		messagesChan: make(chan *kafka.Message, defaultChannelSize),
+		offsets:      newOffsetTracker(),
+		stopping:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(consumer)
+	}
+	return consumer
 }
 
 func (c *kafkaConsumer) Start() error {
+	c.running.Lock()
+	defer c.running.Unlock()
+
 	for {
+		select {
+		case <-c.stopping:
+			return nil
+		default:
+		}
+
 		ev := c.client.Poll(defaultTimeoutMeiliSeconds)
 		switch e := ev.(type) {
 		case *kafka.Message:
 			if e.TopicPartition.Error != nil {
 				return e.TopicPartition.Error
 			}
+			if delay := time.Until(notBefore(e)); delay > 0 {
+				if err := c.delay(e.TopicPartition, delay); err != nil {
+					return err
+				}
+				continue
+			}
+			if !c.offsets.track(e.TopicPartition) {
+				continue
+			}
 			if c.observeConsumeCounter != nil {
 				go c.observeConsumeCounter("success") // observe consume rate
 			}
-			c.messagesChan <- e
+			select {
+			case c.messagesChan <- e:
+			case <-c.stopping:
+				return nil
+			}
+		case kafka.AssignedPartitions, kafka.RevokedPartitions:
+			if err := c.rebalance(e); err != nil {
+				return err
+			}
 		case kafka.Error:
 			return e
 		case nil:
@@ -69,16 +133,86 @@ func (c *kafkaConsumer) Start() error {
 	}
 }
 
+// rebalance starts tracking newly assigned partitions. For revoked partitions it waits for the in-flight
+// messages to finish until the drain timeout, cancels whatever is still running and commits what completed.
+func (c *kafkaConsumer) rebalance(event kafka.Event) error {
+	logger := log.GetLogger()
+	switch e := event.(type) {
+	case kafka.AssignedPartitions:
+		logger.WithField("partitions", e.Partitions).Info("partitions assigned")
+		c.offsets.assign(e.Partitions)
+		return c.client.Assign(e.Partitions)
+	case kafka.RevokedPartitions:
+		logger.WithField("partitions", e.Partitions).Info("partitions revoked, draining in-flight messages")
+		deadline := time.Now().Add(c.conf.DrainTimeout)
+		for c.offsets.inFlight(e.Partitions) > 0 && time.Now().Before(deadline) {
+			time.Sleep(drainCheckInterval)
+		}
+		if remaining := c.offsets.inFlight(e.Partitions); remaining > 0 {
+			logger.Warnf("cancelling %d in-flight messages of revoked partitions", remaining)
+		}
+		c.offsets.revoke(e.Partitions)
+		return c.client.Unassign()
+	}
+	return nil
+}
+
+// delay pauses the partition of a retried message that is not due yet and rewinds it, so the message is
+// polled again once the partition resumes.
+func (c *kafkaConsumer) delay(partition kafka.TopicPartition, delay time.Duration) error {
+	partitions := []kafka.TopicPartition{partition}
+	if err := c.client.Pause(partitions); err != nil {
+		return err
+	}
+	if err := c.client.Seek(partition, 0); err != nil {
+		return err
+	}
+
+	time.AfterFunc(delay, func() {
+		if err := c.client.Resume(partitions); err != nil {
+			log.GetLogger().WithError(err).Error("failed to resume delayed partition")
+		}
+	})
+	return nil
+}
+
+// Stop makes Start return after its current poll and waits for it, so no new messages reach the workers.
+func (c *kafkaConsumer) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopping)
+	})
+	c.running.Lock()
+	c.running.Unlock()
+}
+
+// Close stops polling and leaves the consumer group. Messages that were not committed by then are
+// redelivered to the next owner of their partition.
 func (c *kafkaConsumer) Close() error {
+	c.Stop()
 	close(c.messagesChan)
+	c.offsets.revokeAll()
 	return c.client.Close()
 }
 
+// CommitMessage marks msg as completed and commits the offset of its partition up to the first message
+// that is still in flight.
 func (c *kafkaConsumer) CommitMessage(msg *kafka.Message) error {
-	_, err := c.client.CommitMessage(msg)
+	offset, ok := c.offsets.done(msg.TopicPartition)
+	if !ok {
+		return nil
+	}
+	_, err := c.client.CommitOffsets([]kafka.TopicPartition{offset})
 	return err
 }
 
+func (c *kafkaConsumer) Context(msg *kafka.Message) context.Context {
+	return c.offsets.context(msg.TopicPartition)
+}
+
+func (c *kafkaConsumer) Health(ctx context.Context) error {
+	return checkMetadata(ctx, c.client)
+}
+
 func (c *kafkaConsumer) Channel() chan *kafka.Message {
 	return c.messagesChan
 }
@@ -0,0 +1,156 @@
+package kafka
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClient struct {
+	mu         sync.Mutex
+	committed  []kafka.TopicPartition
+	unassigned bool
+}
+
+func (f *fakeClient) SubscribeTopics([]string, kafka.RebalanceCb) error { return nil }
+func (f *fakeClient) Poll(int) kafka.Event                              { return nil }
+func (f *fakeClient) Assign([]kafka.TopicPartition) error               { return nil }
+func (f *fakeClient) Pause([]kafka.TopicPartition) error                { return nil }
+func (f *fakeClient) Resume([]kafka.TopicPartition) error               { return nil }
+func (f *fakeClient) Seek(kafka.TopicPartition, int) error              { return nil }
+func (f *fakeClient) Close() error                                      { return nil }
+
+func (f *fakeClient) GetMetadata(*string, bool, int) (*kafka.Metadata, error) {
+	return &kafka.Metadata{Brokers: []kafka.BrokerMetadata{{ID: 1, Host: "kafka", Port: 9092}}}, nil
+}
+
+func (f *fakeClient) Unassign() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unassigned = true
+	return nil
+}
+
+func (f *fakeClient) CommitOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.committed = append(f.committed, offsets...)
+	return offsets, nil
+}
+
+func (f *fakeClient) lastCommitted() kafka.Offset {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.committed) == 0 {
+		return kafka.OffsetInvalid
+	}
+	return f.committed[len(f.committed)-1].Offset
+}
+
+func message(topic string, partition int32, offset kafka.Offset) *kafka.Message {
+	return &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: offset}}
+}
+
+func TestCommitMessage_CommitsOnlyContiguousOffsets(t *testing.T) {
+	client := &fakeClient{}
+	consumer := newConsumer(ConsumerConfig{}, client)
+	topic := "events"
+	require.NoError(t, consumer.rebalance(kafka.AssignedPartitions{Partitions: []kafka.TopicPartition{{Topic: &topic, Partition: 0}}}))
+
+	messages := []*kafka.Message{message(topic, 0, 10), message(topic, 0, 11), message(topic, 0, 12)}
+	for _, msg := range messages {
+		require.True(t, consumer.offsets.track(msg.TopicPartition))
+	}
+
+	// later messages finishing first must not move the committed position past the slow one
+	require.NoError(t, consumer.CommitMessage(messages[2]))
+	require.NoError(t, consumer.CommitMessage(messages[1]))
+	assert.Empty(t, client.committed)
+
+	require.NoError(t, consumer.CommitMessage(messages[0]))
+	require.Len(t, client.committed, 1)
+	assert.Equal(t, kafka.Offset(13), client.lastCommitted())
+}
+
+func TestCommitMessage_PartitionsAreIndependent(t *testing.T) {
+	client := &fakeClient{}
+	consumer := newConsumer(ConsumerConfig{}, client)
+	topic := "events"
+	require.NoError(t, consumer.rebalance(kafka.AssignedPartitions{Partitions: []kafka.TopicPartition{
+		{Topic: &topic, Partition: 0},
+		{Topic: &topic, Partition: 1},
+	}}))
+
+	slow, fast := message(topic, 0, 5), message(topic, 1, 8)
+	consumer.offsets.track(slow.TopicPartition)
+	consumer.offsets.track(fast.TopicPartition)
+
+	require.NoError(t, consumer.CommitMessage(fast))
+	require.Len(t, client.committed, 1)
+	assert.Equal(t, int32(1), client.committed[0].Partition)
+	assert.Equal(t, kafka.Offset(9), client.committed[0].Offset)
+}
+
+func TestRebalance_RevokeWaitsForInFlightMessages(t *testing.T) {
+	client := &fakeClient{}
+	consumer := newConsumer(ConsumerConfig{DrainTimeout: time.Second}, client)
+	topic := "events"
+	partitions := []kafka.TopicPartition{{Topic: &topic, Partition: 0}}
+	require.NoError(t, consumer.rebalance(kafka.AssignedPartitions{Partitions: partitions}))
+
+	msg := message(topic, 0, 3)
+	consumer.offsets.track(msg.TopicPartition)
+	ctx := consumer.Context(msg)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = consumer.CommitMessage(msg)
+	}()
+	require.NoError(t, consumer.rebalance(kafka.RevokedPartitions{Partitions: partitions}))
+
+	assert.Equal(t, kafka.Offset(4), client.lastCommitted())
+	assert.True(t, client.unassigned)
+	assert.Error(t, ctx.Err(), "work of a revoked partition should be cancelled")
+	assert.False(t, consumer.offsets.track(msg.TopicPartition))
+}
+
+func TestRebalance_RevokeCancelsAfterDrainTimeout(t *testing.T) {
+	client := &fakeClient{}
+	consumer := newConsumer(ConsumerConfig{DrainTimeout: 100 * time.Millisecond}, client)
+	topic := "events"
+	partitions := []kafka.TopicPartition{{Topic: &topic, Partition: 0}}
+	require.NoError(t, consumer.rebalance(kafka.AssignedPartitions{Partitions: partitions}))
+
+	msg := message(topic, 0, 3)
+	consumer.offsets.track(msg.TopicPartition)
+	ctx := consumer.Context(msg)
+
+	require.NoError(t, consumer.rebalance(kafka.RevokedPartitions{Partitions: partitions}))
+	assert.Error(t, ctx.Err())
+
+	// a worker finishing after the revoke must not commit for a partition it no longer owns
+	require.NoError(t, consumer.CommitMessage(msg))
+	assert.Empty(t, client.committed)
+}
+
+func TestStop_MakesStartReturn(t *testing.T) {
+	consumer := newConsumer(ConsumerConfig{}, &fakeClient{})
+
+	started := make(chan error)
+	go func() {
+		started <- consumer.Start()
+	}()
+
+	consumer.Stop()
+	select {
+	case err := <-started:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("start did not return after stop")
+	}
+	require.NoError(t, consumer.Close())
+}
@@ -0,0 +1,37 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+const defaultHealthTimeout = 5 * time.Second
+
+var ErrNoBrokers = errors.New("kafka metadata lists no brokers")
+
+type metadataClient interface {
+	GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error)
+}
+
+// checkMetadata asks the cluster for its broker list, which fails if none of the bootstrap brokers is reachable.
+func checkMetadata(ctx context.Context, client metadataClient) error {
+	timeout := defaultHealthTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	if timeout <= 0 {
+		return context.DeadlineExceeded
+	}
+
+	metadata, err := client.GetMetadata(nil, false, int(timeout.Milliseconds()))
+	if err != nil {
+		return err
+	}
+	if len(metadata.Brokers) == 0 {
+		return ErrNoBrokers
+	}
+	return nil
+}
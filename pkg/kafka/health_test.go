@@ -0,0 +1,32 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+type metadataFunc func() (*kafka.Metadata, error)
+
+func (f metadataFunc) GetMetadata(*string, bool, int) (*kafka.Metadata, error) {
+	return f()
+}
+
+func TestCheckMetadata(t *testing.T) {
+	errUnreachable := errors.New("all brokers are down")
+
+	assert.NoError(t, checkMetadata(context.Background(), &fakeClient{}))
+	assert.ErrorIs(t, checkMetadata(context.Background(), metadataFunc(func() (*kafka.Metadata, error) {
+		return &kafka.Metadata{}, nil
+	})), ErrNoBrokers)
+	assert.ErrorIs(t, checkMetadata(context.Background(), metadataFunc(func() (*kafka.Metadata, error) {
+		return nil, errUnreachable
+	})), errUnreachable)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	assert.ErrorIs(t, checkMetadata(ctx, &fakeClient{}), context.DeadlineExceeded)
+}
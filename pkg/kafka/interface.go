@@ -1,17 +1,31 @@
 package kafka
 
 import (
+	"context"
+
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 )
 
 type Consumer interface {
 	Start() error
+	// Stop makes Start return without closing the consumer, so in-flight messages can still be committed.
+	Stop()
 	Close() error
 	CommitMessage(msg *kafka.Message) error
 	Channel() chan *kafka.Message
+	// Context returns a context that is cancelled once the partition of msg is revoked from this consumer.
+	Context(msg *kafka.Message) context.Context
+	// Health fails if the brokers cannot be reached for metadata.
+	Health(ctx context.Context) error
 }
 
 type Producer interface {
-	Send(topic string, value []byte) error
+	// Send and SendMessage wait until the brokers acknowledged the message.
+	Send(topic string, value []byte, opts ...SendOption) error
+	SendMessage(msg *kafka.Message) error
+	// SendAsync returns once the message is queued and reports its delivery to callback.
+	SendAsync(msg *kafka.Message, callback func(msg *kafka.Message, err error)) error
+	// Health fails if the brokers cannot be reached for metadata.
+	Health(ctx context.Context) error
 	Close()
 }
@@ -10,6 +10,8 @@
 package mocks
 
 import (
+	context "context"
+	kafka0 "go_code_reviewer/pkg/kafka"
 	reflect "reflect"
 
 	kafka "github.com/confluentinc/confluent-kafka-go/kafka"
@@ -82,6 +84,34 @@ func (mr *MockConsumerMockRecorder) CommitMessage(msg any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CommitMessage", reflect.TypeOf((*MockConsumer)(nil).CommitMessage), msg)
 }
 
+// Context mocks base method.
+func (m *MockConsumer) Context(msg *kafka.Message) context.Context {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Context", msg)
+	ret0, _ := ret[0].(context.Context)
+	return ret0
+}
+
+// Context indicates an expected call of Context.
+func (mr *MockConsumerMockRecorder) Context(msg any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Context", reflect.TypeOf((*MockConsumer)(nil).Context), msg)
+}
+
+// Health mocks base method.
+func (m *MockConsumer) Health(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Health", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Health indicates an expected call of Health.
+func (mr *MockConsumerMockRecorder) Health(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Health", reflect.TypeOf((*MockConsumer)(nil).Health), ctx)
+}
+
 // Start mocks base method.
 func (m *MockConsumer) Start() error {
 	m.ctrl.T.Helper()
@@ -96,6 +126,18 @@ func (mr *MockConsumerMockRecorder) Start() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Start", reflect.TypeOf((*MockConsumer)(nil).Start))
 }
 
+// Stop mocks base method.
+func (m *MockConsumer) Stop() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Stop")
+}
+
+// Stop indicates an expected call of Stop.
+func (mr *MockConsumerMockRecorder) Stop() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stop", reflect.TypeOf((*MockConsumer)(nil).Stop))
+}
+
 // MockProducer is a mock of Producer interface.
 type MockProducer struct {
 	ctrl     *gomock.Controller
@@ -132,16 +174,63 @@ func (mr *MockProducerMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockProducer)(nil).Close))
 }
 
+// Health mocks base method.
+func (m *MockProducer) Health(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Health", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Health indicates an expected call of Health.
+func (mr *MockProducerMockRecorder) Health(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Health", reflect.TypeOf((*MockProducer)(nil).Health), ctx)
+}
+
 // Send mocks base method.
-func (m *MockProducer) Send(topic string, value []byte) error {
+func (m *MockProducer) Send(topic string, value []byte, opts ...kafka0.SendOption) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Send", topic, value)
+	varargs := []any{topic, value}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Send", varargs...)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Send indicates an expected call of Send.
-func (mr *MockProducerMockRecorder) Send(topic, value any) *gomock.Call {
+func (mr *MockProducerMockRecorder) Send(topic, value any, opts ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{topic, value}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Send", reflect.TypeOf((*MockProducer)(nil).Send), varargs...)
+}
+
+// SendAsync mocks base method.
+func (m *MockProducer) SendAsync(msg *kafka.Message, callback func(*kafka.Message, error)) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendAsync", msg, callback)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendAsync indicates an expected call of SendAsync.
+func (mr *MockProducerMockRecorder) SendAsync(msg, callback any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendAsync", reflect.TypeOf((*MockProducer)(nil).SendAsync), msg, callback)
+}
+
+// SendMessage mocks base method.
+func (m *MockProducer) SendMessage(msg *kafka.Message) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendMessage", msg)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendMessage indicates an expected call of SendMessage.
+func (mr *MockProducerMockRecorder) SendMessage(msg any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Send", reflect.TypeOf((*MockProducer)(nil).Send), topic, value)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMessage", reflect.TypeOf((*MockProducer)(nil).SendMessage), msg)
 }
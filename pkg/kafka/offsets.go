@@ -0,0 +1,136 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+func keyOf(tp kafka.TopicPartition) partitionKey {
+	key := partitionKey{partition: tp.Partition}
+	if tp.Topic != nil {
+		key.topic = *tp.Topic
+	}
+	return key
+}
+
+type partitionState struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	pending   []kafka.Offset
+	completed map[kafka.Offset]bool
+}
+
+// offsetTracker remembers the offsets handed to workers per partition, so only the highest offset below
+// which every message has completed gets committed, no matter in which order workers finish.
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[partitionKey]*partitionState
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: make(map[partitionKey]*partitionState)}
+}
+
+func (t *offsetTracker) assign(partitions []kafka.TopicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tp := range partitions {
+		if _, ok := t.partitions[keyOf(tp)]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		t.partitions[keyOf(tp)] = &partitionState{
+			ctx:       ctx,
+			cancel:    cancel,
+			completed: make(map[kafka.Offset]bool),
+		}
+	}
+}
+
+// revoke forgets the partitions and cancels the work that is still running for them.
+func (t *offsetTracker) revoke(partitions []kafka.TopicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tp := range partitions {
+		if state, ok := t.partitions[keyOf(tp)]; ok {
+			state.cancel()
+			delete(t.partitions, keyOf(tp))
+		}
+	}
+}
+
+func (t *offsetTracker) revokeAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, state := range t.partitions {
+		state.cancel()
+		delete(t.partitions, key)
+	}
+}
+
+// track registers a message handed to the workers and returns false if its partition is not assigned.
+func (t *offsetTracker) track(tp kafka.TopicPartition) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, ok := t.partitions[keyOf(tp)]
+	if !ok {
+		return false
+	}
+	state.pending = append(state.pending, tp.Offset)
+	return true
+}
+
+// done marks a message as completed and returns the offset to commit if the committable position moved.
+func (t *offsetTracker) done(tp kafka.TopicPartition) (kafka.TopicPartition, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, ok := t.partitions[keyOf(tp)]
+	if !ok {
+		return kafka.TopicPartition{}, false
+	}
+
+	state.completed[tp.Offset] = true
+	advanced := false
+	next := kafka.Offset(0)
+	for len(state.pending) > 0 && state.completed[state.pending[0]] {
+		delete(state.completed, state.pending[0])
+		next = state.pending[0] + 1
+		state.pending = state.pending[1:]
+		advanced = true
+	}
+	if !advanced {
+		return kafka.TopicPartition{}, false
+	}
+
+	return kafka.TopicPartition{Topic: tp.Topic, Partition: tp.Partition, Offset: next}, true
+}
+
+func (t *offsetTracker) inFlight(partitions []kafka.TopicPartition) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	count := 0
+	for _, tp := range partitions {
+		if state, ok := t.partitions[keyOf(tp)]; ok {
+			count += len(state.pending)
+		}
+	}
+	return count
+}
+
+func (t *offsetTracker) context(tp kafka.TopicPartition) context.Context {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state, ok := t.partitions[keyOf(tp)]; ok {
+		return state.ctx
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
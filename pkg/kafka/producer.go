@@ -1,36 +1,164 @@
 package kafka
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go_code_reviewer/pkg/log"
+)
+
+const (
+	defaultDeliveryTimeout = 10 * time.Second
+	// deliveryReportGrace is how long Send waits past the message timeout for librdkafka to report.
+	deliveryReportGrace = time.Second
+	flushTimeoutMs      = 5000
 )
 
+// ErrDeliveryTimeout is returned when no delivery report arrived, so whether the message was delivered is unknown.
+var ErrDeliveryTimeout = errors.New("timed out waiting for kafka delivery report")
+
 type kafkaProducer struct {
-	conf   ProducerConfig
-	client *kafka.Producer
+	conf                  ProducerConfig
+	client                *kafka.Producer
+	observeProduceCounter func(status string)
+}
+
+type ProducerOption func(producer *kafkaProducer)
+
+// WithProducerMetricsHandler observes every delivery report with status "success" or "failure".
+func WithProducerMetricsHandler(handler func(status string)) ProducerOption {
+	return func(producer *kafkaProducer) {
+		producer.observeProduceCounter = handler
+	}
 }
 
-func NewProducer(conf ProducerConfig) (Producer, error) {
+// NewProducer creates an idempotent producer, so the retries of librdkafka neither duplicate nor reorder
+// messages, and every message waits for all in-sync replicas.
+func NewProducer(conf ProducerConfig, opts ...ProducerOption) (Producer, error) {
+	if conf.DeliveryTimeout <= 0 {
+		conf.DeliveryTimeout = defaultDeliveryTimeout
+	}
+
 	client, err := kafka.NewProducer(&kafka.ConfigMap{
-		bootstrapServersKey: conf.Brokers,
+		bootstrapServersKey:  conf.Brokers,
+		enableIdempotenceKey: true,
+		acksKey:              "all",
+		messageTimeoutKey:    int(conf.DeliveryTimeout.Milliseconds()),
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return &kafkaProducer{
+	producer := &kafkaProducer{
 		conf:   conf,
 		client: client,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(producer)
+	}
+
+	go producer.handleEvents()
+	return producer, nil
+}
+
+// handleEvents dispatches the delivery reports of asynchronous sends to their callbacks.
+func (p *kafkaProducer) handleEvents() {
+	for event := range p.client.Events() {
+		switch e := event.(type) {
+		case *kafka.Message:
+			err := e.TopicPartition.Error
+			p.observe(err)
+			if callback, ok := e.Opaque.(func(*kafka.Message, error)); ok {
+				callback(e, err)
+			} else if err != nil {
+				log.GetLogger().WithError(err).Error("failed to deliver kafka message")
+			}
+		case kafka.Error:
+			log.GetLogger().WithError(e).Warn("kafka producer error")
+		}
+	}
+}
+
+type SendOption func(msg *kafka.Message)
+
+// WithKey keys the message, so all messages with the same key land on the same partition in order.
+func WithKey(key string) SendOption {
+	return func(msg *kafka.Message) {
+		msg.Key = []byte(key)
+	}
 }
 
-func (p *kafkaProducer) Send(topic string, value []byte) error {
-	return p.client.Produce(&kafka.Message{
+func WithHeader(key, value string) SendOption {
+	return func(msg *kafka.Message) {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
+}
+
+func (p *kafkaProducer) Send(topic string, value []byte, opts ...SendOption) error {
+	msg := &kafka.Message{
 		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
 		Value:          value,
-	}, nil)
+	}
+	for _, opt := range opts {
+		opt(msg)
+	}
+	return p.SendMessage(msg)
+}
+
+// SendMessage returns once the brokers acknowledged msg, librdkafka failed it or the send timeout passed.
+func (p *kafkaProducer) SendMessage(msg *kafka.Message) error {
+	deliveryChan := make(chan kafka.Event, 1)
+	if err := p.client.Produce(msg, deliveryChan); err != nil {
+		p.observe(err)
+		return err
+	}
+
+	timer := time.NewTimer(p.conf.SendTimeout())
+	defer timer.Stop()
+	select {
+	case event := <-deliveryChan:
+		report, ok := event.(*kafka.Message)
+		if !ok {
+			return fmt.Errorf("unexpected delivery event %v", event)
+		}
+		err := report.TopicPartition.Error
+		p.observe(err)
+		return err
+	case <-timer.C:
+		p.observe(ErrDeliveryTimeout)
+		return ErrDeliveryTimeout
+	}
+}
+
+// SendAsync enqueues msg and reports its delivery to callback from the producer's event loop.
+func (p *kafkaProducer) SendAsync(msg *kafka.Message, callback func(msg *kafka.Message, err error)) error {
+	msg.Opaque = callback
+	if err := p.client.Produce(msg, nil); err != nil {
+		p.observe(err)
+		return err
+	}
+	return nil
+}
+
+func (p *kafkaProducer) observe(err error) {
+	if p.observeProduceCounter == nil {
+		return
+	}
+	if err != nil {
+		p.observeProduceCounter("failure")
+		return
+	}
+	p.observeProduceCounter("success")
+}
+
+func (p *kafkaProducer) Health(ctx context.Context) error {
+	return checkMetadata(ctx, p.client)
 }
 
 func (p *kafkaProducer) Close() {
-	p.client.Flush(5000)
+	p.client.Flush(flushTimeoutMs)
 	p.client.Close()
 }
@@ -0,0 +1,71 @@
+package kafka
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unreachableProducer points at a port nothing listens on, so every delivery report is a failure.
+func unreachableProducer(t *testing.T, observed *[]string) Producer {
+	var mu sync.Mutex
+	producer, err := NewProducer(ProducerConfig{
+		Brokers:         "127.0.0.1:1",
+		DeliveryTimeout: 300 * time.Millisecond,
+	}, WithProducerMetricsHandler(func(status string) {
+		mu.Lock()
+		defer mu.Unlock()
+		*observed = append(*observed, status)
+	}))
+	require.NoError(t, err)
+	t.Cleanup(producer.Close)
+	return producer
+}
+
+func TestSend_WaitsForDeliveryReport(t *testing.T) {
+	var observed []string
+	producer := unreachableProducer(t, &observed)
+
+	start := time.Now()
+	err := producer.Send("events", []byte("payload"))
+	require.Error(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 250*time.Millisecond, "send should wait for the delivery report")
+	assert.Equal(t, []string{"failure"}, observed)
+}
+
+func TestSendAsync_ReportsDeliveryToCallback(t *testing.T) {
+	var observed []string
+	producer := unreachableProducer(t, &observed)
+	topic := "events"
+
+	reports := make(chan error, 1)
+	err := producer.SendAsync(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Value:          []byte("payload"),
+	}, func(msg *kafka.Message, err error) {
+		assert.Equal(t, []byte("payload"), msg.Value)
+		reports <- err
+	})
+	require.NoError(t, err)
+
+	select {
+	case err := <-reports:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery callback was not called")
+	}
+}
+
+func TestSendOptions_SetKeyAndHeaders(t *testing.T) {
+	msg := &kafka.Message{}
+	for _, opt := range []SendOption{WithKey("owner/repo#1"), WithHeader("x-event-type", "pull_request"), WithHeader("traceparent", "00-abc-def-01")} {
+		opt(msg)
+	}
+
+	assert.Equal(t, []byte("owner/repo#1"), msg.Key)
+	assert.Equal(t, map[string]string{"x-event-type": "pull_request", "traceparent": "00-abc-def-01"}, Headers(msg))
+}
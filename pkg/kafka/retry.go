@@ -0,0 +1,166 @@
+package kafka
+
+import (
+	"errors"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go_code_reviewer/pkg/log"
+	"strconv"
+	"time"
+)
+
+const (
+	HeaderFailureReason     = "x-failure-reason"
+	HeaderAttempt           = "x-attempt"
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+	HeaderNotBefore         = "x-not-before"
+)
+
+const defaultRouteTimeout = 5 * time.Minute
+
+var ErrNoRetryTier = errors.New("no retry tier is configured")
+
+// FailureRouter moves messages that failed processing out of the way of the partition, either to the next
+// retry tier or to the dead-letter topic, so the failed offset can be committed.
+type FailureRouter struct {
+	conf     RetryConfig
+	producer Producer
+}
+
+func NewFailureRouter(producer Producer, conf RetryConfig) *FailureRouter {
+	return &FailureRouter{
+		conf:     conf,
+		producer: producer,
+	}
+}
+
+// RouteTimeout is how long routing a failed message may be retried.
+func (r *FailureRouter) RouteTimeout() time.Duration {
+	if r.conf.RouteTimeout <= 0 {
+		return defaultRouteTimeout
+	}
+	return r.conf.RouteTimeout
+}
+
+// Route publishes msg to the retry tier matching its attempt count, or to the dead-letter topic when the
+// failure is not retryable or every tier has been used. It returns the topic the message was sent to.
+func (r *FailureRouter) Route(msg *kafka.Message, cause error, retryable bool) (string, error) {
+	attempt := Attempt(msg) + 1
+	topic := r.conf.DeadLetterTopic
+	var notBefore time.Time
+	if retryable && attempt <= len(r.conf.Tiers) {
+		tier := r.conf.Tiers[attempt-1]
+		topic = tier.Topic
+		notBefore = time.Now().Add(tier.Delay)
+	}
+
+	return r.publish(msg, topic, attempt, notBefore, cause)
+}
+
+// CanPostpone reports whether a retry tier is configured, without one Postpone returns ErrNoRetryTier.
+func (r *FailureRouter) CanPostpone() bool {
+	return len(r.conf.Tiers) > 0
+}
+
+// Postpone publishes msg to the first retry tier without counting a failed attempt, for messages that could
+// not be processed yet, e.g. because a concurrency limit was reached. It returns the topic the message was sent to.
+func (r *FailureRouter) Postpone(msg *kafka.Message, cause error) (string, error) {
+	if len(r.conf.Tiers) == 0 {
+		return "", ErrNoRetryTier
+	}
+	tier := r.conf.Tiers[0]
+	return r.publish(msg, tier.Topic, Attempt(msg), time.Now().Add(tier.Delay), cause)
+}
+
+func (r *FailureRouter) publish(msg *kafka.Message, topic string, attempt int, notBefore time.Time, cause error) (string, error) {
+	headers := map[string]string{
+		HeaderFailureReason: cause.Error(),
+		HeaderAttempt:       strconv.Itoa(attempt),
+	}
+	if HeaderValue(msg, HeaderOriginalTopic) == "" && msg.TopicPartition.Topic != nil {
+		headers[HeaderOriginalTopic] = *msg.TopicPartition.Topic
+		headers[HeaderOriginalPartition] = strconv.Itoa(int(msg.TopicPartition.Partition))
+		headers[HeaderOriginalOffset] = msg.TopicPartition.Offset.String()
+	}
+	if !notBefore.IsZero() {
+		headers[HeaderNotBefore] = strconv.FormatInt(notBefore.UnixMilli(), 10)
+	}
+
+	routed := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        withHeaders(msg.Headers, headers),
+	}
+	if err := r.producer.SendMessage(routed); err != nil {
+		return "", err
+	}
+
+	log.GetLogger().WithError(cause).WithField("attempt", attempt).Warnf("routed message to %s", topic)
+	return topic, nil
+}
+
+// Rejected reports whether err rejects a produced message itself, e.g. because it is too large, so producing
+// it again fails the same way.
+func Rejected(err error) bool {
+	var kafkaErr kafka.Error
+	if !errors.As(err, &kafkaErr) {
+		return false
+	}
+	switch kafkaErr.Code() {
+	case kafka.ErrMsgSizeTooLarge, kafka.ErrInvalidMsgSize, kafka.ErrInvalidMsg, kafka.ErrRecordListTooLarge, kafka.ErrInvalidRecord:
+		return true
+	default:
+		return false
+	}
+}
+
+// Attempt returns how many times processing of msg has already failed.
+func Attempt(msg *kafka.Message) int {
+	attempt, err := strconv.Atoi(HeaderValue(msg, HeaderAttempt))
+	if err != nil {
+		return 0
+	}
+	return attempt
+}
+
+func HeaderValue(msg *kafka.Message, key string) string {
+	for i := len(msg.Headers) - 1; i >= 0; i-- {
+		if msg.Headers[i].Key == key {
+			return string(msg.Headers[i].Value)
+		}
+	}
+	return ""
+}
+
+// Headers returns the headers of msg by key, the last value winning for repeated keys.
+func Headers(msg *kafka.Message) map[string]string {
+	headers := make(map[string]string, len(msg.Headers))
+	for _, header := range msg.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+	return headers
+}
+
+// notBefore returns the time before which a retried message must not be processed.
+func notBefore(msg *kafka.Message) time.Time {
+	millis, err := strconv.ParseInt(HeaderValue(msg, HeaderNotBefore), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis)
+}
+
+func withHeaders(original []kafka.Header, values map[string]string) []kafka.Header {
+	headers := make([]kafka.Header, 0, len(original)+len(values))
+	for _, header := range original {
+		if _, replaced := values[header.Key]; !replaced {
+			headers = append(headers, header)
+		}
+	}
+	for key, value := range values {
+		headers = append(headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
+	return headers
+}
@@ -0,0 +1,142 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errProcessing = errors.New("llm unavailable")
+
+// fakeProducer records the messages sent through SendMessage. The generated mock imports this package, so
+// the in-package tests cannot use it.
+type fakeProducer struct {
+	Producer
+	sent []*kafka.Message
+	err  error
+}
+
+func (p *fakeProducer) SendMessage(msg *kafka.Message) error {
+	p.sent = append(p.sent, msg)
+	return p.err
+}
+
+func newRouter(t *testing.T) (*FailureRouter, *fakeProducer) {
+	producer := &fakeProducer{}
+	return NewFailureRouter(producer, RetryConfig{
+		Tiers: []RetryTier{
+			{Topic: "events-retry-1", Delay: time.Minute},
+			{Topic: "events-retry-2", Delay: 10 * time.Minute},
+		},
+		DeadLetterTopic: "events-dlq",
+	}), producer
+}
+
+func TestRoute_FirstFailureGoesToFirstTier(t *testing.T) {
+	router, producer := newRouter(t)
+	topic := "events"
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 3, Offset: 42},
+		Key:            []byte("owner/repo#1"),
+		Value:          []byte("payload"),
+	}
+
+	destination, err := router.Route(msg, errProcessing, true)
+	require.NoError(t, err)
+	require.Len(t, producer.sent, 1)
+	routed := producer.sent[0]
+	assert.Equal(t, "events-retry-1", destination)
+	assert.Equal(t, "events-retry-1", *routed.TopicPartition.Topic)
+	assert.Equal(t, msg.Key, routed.Key)
+	assert.Equal(t, msg.Value, routed.Value)
+	assert.Equal(t, "1", HeaderValue(routed, HeaderAttempt))
+	assert.Equal(t, errProcessing.Error(), HeaderValue(routed, HeaderFailureReason))
+	assert.Equal(t, "events", HeaderValue(routed, HeaderOriginalTopic))
+	assert.Equal(t, "3", HeaderValue(routed, HeaderOriginalPartition))
+	assert.Equal(t, "42", HeaderValue(routed, HeaderOriginalOffset))
+	assert.WithinDuration(t, time.Now().Add(time.Minute), notBefore(routed), time.Second)
+}
+
+func TestRoute_ExhaustedRetriesGoToDeadLetterTopic(t *testing.T) {
+	router, producer := newRouter(t)
+	topic := "events-retry-2"
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0, Offset: 7},
+		Headers: []kafka.Header{
+			{Key: HeaderAttempt, Value: []byte("2")},
+			{Key: HeaderOriginalTopic, Value: []byte("events")},
+			{Key: HeaderOriginalOffset, Value: []byte("42")},
+			{Key: "traceparent", Value: []byte("00-abc-def-01")},
+		},
+	}
+
+	destination, err := router.Route(msg, errProcessing, true)
+	require.NoError(t, err)
+	require.Len(t, producer.sent, 1)
+	routed := producer.sent[0]
+	assert.Equal(t, "events-dlq", destination)
+	assert.Equal(t, "3", HeaderValue(routed, HeaderAttempt))
+	assert.Equal(t, "events", HeaderValue(routed, HeaderOriginalTopic))
+	assert.Equal(t, "42", HeaderValue(routed, HeaderOriginalOffset))
+	assert.Equal(t, "00-abc-def-01", HeaderValue(routed, "traceparent"))
+	assert.True(t, notBefore(routed).IsZero())
+}
+
+func TestRoute_PermanentFailureSkipsRetries(t *testing.T) {
+	router, producer := newRouter(t)
+	topic := "events"
+	msg := &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic}}
+
+	destination, err := router.Route(msg, errProcessing, false)
+	require.NoError(t, err)
+	assert.Equal(t, "events-dlq", destination)
+	require.Len(t, producer.sent, 1)
+	assert.Equal(t, "events-dlq", *producer.sent[0].TopicPartition.Topic)
+}
+
+func TestRoute_ProducerError(t *testing.T) {
+	router, producer := newRouter(t)
+	topic := "events"
+	msg := &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic}}
+
+	producer.err = errors.New("broker down")
+	_, err := router.Route(msg, errProcessing, true)
+	require.Error(t, err)
+	assert.Len(t, producer.sent, 1)
+}
+
+func TestRejected(t *testing.T) {
+	assert.True(t, Rejected(kafka.NewError(kafka.ErrMsgSizeTooLarge, "message too large", false)))
+	assert.False(t, Rejected(kafka.NewError(kafka.ErrMsgTimedOut, "message timed out", false)))
+	assert.False(t, Rejected(ErrDeliveryTimeout))
+}
+
+func TestPostpone_KeepsAttemptCount(t *testing.T) {
+	router, producer := newRouter(t)
+	topic := "events-retry-2"
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic},
+		Headers:        []kafka.Header{{Key: HeaderAttempt, Value: []byte("1")}},
+	}
+
+	destination, err := router.Postpone(msg, errProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, "events-retry-1", destination)
+	require.Len(t, producer.sent, 1)
+	assert.Equal(t, "1", HeaderValue(producer.sent[0], HeaderAttempt))
+	assert.WithinDuration(t, time.Now().Add(time.Minute), notBefore(producer.sent[0]), time.Second)
+}
+
+func TestPostpone_WithoutRetryTiers(t *testing.T) {
+	producer := &fakeProducer{}
+	router := NewFailureRouter(producer, RetryConfig{DeadLetterTopic: "events-dlq"})
+
+	assert.False(t, router.CanPostpone())
+	_, err := router.Postpone(&kafka.Message{}, errProcessing)
+	assert.ErrorIs(t, err, ErrNoRetryTier)
+	assert.Empty(t, producer.sent)
+}
@@ -0,0 +1,27 @@
+package redis
+
+import (
+	"context"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+type Config struct {
+	Address  string
+	Password string
+	DB       int
+}
+
+// NewClient connects to the redis server of conf and pings it, so a wrong address or password fails the startup.
+func NewClient(ctx context.Context, conf Config) (*goredis.Client, error) {
+	client := goredis.NewClient(&goredis.Options{
+		Addr:     conf.Address,
+		Password: conf.Password,
+		DB:       conf.DB,
+	})
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	return client, nil
+}
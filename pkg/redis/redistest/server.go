@@ -0,0 +1,179 @@
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Server implements the few redis commands the services send, and answers the handshake commands of RESP3 with
+// an error so the client falls back to RESP2. Expiry is recorded but not enforced.
+type Server struct {
+	address  string
+	password string
+
+	mu     sync.Mutex
+	values map[string]string
+	ttls   map[string]time.Duration
+}
+
+// NewServer listens on a free local port until the test ends. Clients have to authenticate with password unless
+// it is empty.
+func NewServer(t testing.TB, password string) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &Server{
+		address:  listener.Addr().String(),
+		password: password,
+		values:   make(map[string]string),
+		ttls:     make(map[string]time.Duration),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *Server) Address() string {
+	return s.address
+}
+
+// TTL returns the expiry the key was last set with, zero if it has none.
+func (s *Server) TTL(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ttls[key]
+}
+
+// Keys returns the number of keys stored.
+func (s *Server) Keys() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.values)
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := s.password == ""
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		var reply string
+		switch command := strings.ToUpper(args[0]); {
+		case command == "AUTH":
+			if args[len(args)-1] != s.password {
+				reply = "-WRONGPASS invalid username-password pair\r\n"
+				break
+			}
+			authenticated = true
+			reply = "+OK\r\n"
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case command == "PING":
+			reply = "+PONG\r\n"
+		case command == "SET":
+			reply = s.set(args)
+		case command == "GET":
+			reply = s.get(args[1])
+		case command == "DEL":
+			reply = s.del(args[1])
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// set handles SET key value with the options NX, EX and PX.
+func (s *Server) set(args []string) string {
+	var ttl time.Duration
+	nx := false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "EX":
+			seconds, _ := strconv.ParseInt(args[i+1], 10, 64)
+			ttl = time.Duration(seconds) * time.Second
+		case "PX":
+			millis, _ := strconv.ParseInt(args[i+1], 10, 64)
+			ttl = time.Duration(millis) * time.Millisecond
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[args[1]]; ok && nx {
+		return "$-1\r\n"
+	}
+	s.values[args[1]] = args[2]
+	s.ttls[args[1]] = ttl
+	return "+OK\r\n"
+}
+
+func (s *Server) get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	if !ok {
+		return "$-1\r\n"
+	}
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+func (s *Server) del(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; !ok {
+		return ":0\r\n"
+	}
+	delete(s.values, key)
+	delete(s.ttls, key)
+	return ":1\r\n"
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid array header %q", line)
+	}
+
+	args := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		if _, err := reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, strings.TrimSuffix(arg, "\r\n"))
+	}
+	return args, nil
+}
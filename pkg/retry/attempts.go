@@ -0,0 +1,53 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+type attemptKey struct{}
+
+// AttemptFromContext returns the number of the running attempt, starting at 1, inside DoContext and 0
+// outside of it.
+func AttemptFromContext(ctx context.Context) int {
+	attempt, _ := ctx.Value(attemptKey{}).(int)
+	return attempt
+}
+
+// AttemptsError keeps the failure of every attempt in order. errors.Is and errors.As look at the last
+// failure first, so its classification wins.
+type AttemptsError struct {
+	Errors []error
+}
+
+func (e *AttemptsError) Error() string {
+	var builder strings.Builder
+	for i, err := range e.Errors {
+		if i > 0 {
+			builder.WriteString("; ")
+		}
+		fmt.Fprintf(&builder, "attempt %d: %v", i+1, err)
+	}
+	return builder.String()
+}
+
+func (e *AttemptsError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for i := len(e.Errors) - 1; i >= 0; i-- {
+		errs = append(errs, e.Errors[i])
+	}
+	return errs
+}
+
+// joinAttempts returns the only failure as it is, so a call failing once keeps its error.
+func joinAttempts(failures []error) error {
+	switch len(failures) {
+	case 0:
+		return nil
+	case 1:
+		return failures[0]
+	default:
+		return &AttemptsError{Errors: failures}
+	}
+}
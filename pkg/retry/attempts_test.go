@@ -0,0 +1,127 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoContext_PassesAttemptNumber(t *testing.T) {
+	r := New[int](Options{MaxRetries: 3, Strategy: ExponentialBackoff(time.Millisecond)})
+
+	var attempts []int
+	resp, err := r.DoContext(context.Background(), func(ctx context.Context) (int, error) {
+		attempts = append(attempts, AttemptFromContext(ctx))
+		if len(attempts) < 3 {
+			return 0, errFail
+		}
+		return AttemptFromContext(ctx), nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, resp)
+	assert.Equal(t, []int{1, 2, 3}, attempts)
+	assert.Zero(t, AttemptFromContext(context.Background()))
+}
+
+func TestOnRetry_CalledBeforeEveryRetry(t *testing.T) {
+	var events []RetryEvent
+	r := New[string](Options{
+		MaxRetries: 3,
+		Strategy:   ExponentialBackoff(time.Millisecond),
+		Operation:  "github",
+		OnRetry: func(event RetryEvent) {
+			events = append(events, event)
+		},
+	})
+
+	_, err := r.Do(context.Background(), func() (string, error) {
+		return "", errFail
+	})
+	require.Error(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, RetryEvent{Operation: "github", Attempt: 1, Err: errFail, Delay: time.Millisecond}, events[0])
+	assert.Equal(t, 2, events[1].Attempt)
+}
+
+func TestMaxElapsed_StopsBeforeExceedingBudget(t *testing.T) {
+	r := New[string](Options{
+		MaxRetries: 10,
+		Strategy:   func(int) time.Duration { return 20 * time.Millisecond },
+		MaxElapsed: 50 * time.Millisecond,
+	})
+
+	called := 0
+	start := time.Now()
+	_, err := r.Do(context.Background(), func() (string, error) {
+		called++
+		return "", errFail
+	})
+	require.ErrorIs(t, err, errFail)
+	assert.Equal(t, 3, called)
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestFailedAttempts_JoinedInOrder(t *testing.T) {
+	errFirst := errors.New("first")
+	errLast := Permanent(errors.New("last"))
+	r := New[string](Options{MaxRetries: 3, Strategy: ExponentialBackoff(time.Millisecond)})
+
+	called := 0
+	_, err := r.Do(context.Background(), func() (string, error) {
+		called++
+		if called == 1 {
+			return "", errFirst
+		}
+		return "", errLast
+	})
+
+	var attempts *AttemptsError
+	require.ErrorAs(t, err, &attempts)
+	assert.Equal(t, []error{errFirst, errLast}, attempts.Errors)
+	assert.ErrorIs(t, err, errFirst)
+	assert.True(t, IsPermanent(err))
+	assert.Equal(t, "attempt 1: first; attempt 2: last", err.Error())
+}
+
+func TestSingleAttempt_KeepsItsError(t *testing.T) {
+	r := New[string](Options{MaxRetries: 1})
+
+	_, err := r.Do(context.Background(), func() (string, error) {
+		return "", errFail
+	})
+	assert.Equal(t, errFail, err)
+}
+
+func TestCancelledRetries_KeepFailedAttempts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := New[string](Options{
+		MaxRetries: 3,
+		Strategy:   ExponentialBackoff(time.Hour),
+		OnRetry: func(RetryEvent) {
+			cancel()
+		},
+	})
+
+	_, err := r.Do(ctx, func() (string, error) {
+		return "", errFail
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, err, errFail)
+}
+
+func TestPrometheusObserver_CountsRetriesByOperation(t *testing.T) {
+	observer := NewPrometheusObserver()
+	observer.OnRetry(RetryEvent{Operation: "llm", Err: errFail})
+	observer.OnRetry(RetryEvent{Operation: "llm", Err: RetryAfter(errFail, time.Second)})
+	observer.OnRetry(RetryEvent{Operation: "github", Err: errFail})
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(observer.retries.WithLabelValues("llm", ReasonError)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(observer.retries.WithLabelValues("llm", ReasonRateLimited)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(observer.retries.WithLabelValues("github", ReasonError)))
+	assert.Equal(t, 3, testutil.CollectAndCount(observer))
+}
@@ -14,7 +14,8 @@ func ExponentialBackoff(base time.Duration) Strategy {
 func ExponentialJitterBackoff(base, max time.Duration) Strategy {
 	return func(attempt int) time.Duration {
 		backoff := base * (1 << (attempt - 1))
-		if backoff > max {
+		// the shift overflows after enough attempts
+		if backoff > max || backoff <= 0 {
 			backoff = max
 		}
 		jitter := time.Duration(rand.Int63n(int64(backoff / 2)))
@@ -0,0 +1,240 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type State int
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+const windowBuckets = 10
+
+var (
+	defaultWindow           = time.Minute
+	defaultMinRequests      = 10
+	defaultFailureRate      = 0.5
+	defaultCoolDown         = 30 * time.Second
+	defaultHalfOpenRequests = 1
+	defaultIsFailure        = func(err error) bool { return !errors.Is(err, context.Canceled) && !IsPermanent(err) }
+)
+
+type BreakerOptions struct {
+	Name string
+	// Window is the rolling period over which the failure rate is measured.
+	Window time.Duration
+	// MinRequests is the number of calls in the window below which the breaker never opens.
+	MinRequests int
+	// FailureRate between 0 and 1 opens the breaker once reached.
+	FailureRate float64
+	// CoolDown is how long the breaker stays open before it lets probe calls through.
+	CoolDown time.Duration
+	// HalfOpenRequests probe calls must succeed in a row to close the breaker again.
+	HalfOpenRequests int
+	// IsFailure decides which errors count against the provider; cancelled calls and permanent errors do
+	// not by default.
+	IsFailure func(error) bool
+	// OnStateChange is called with the breaker locked, so it must not call the breaker.
+	OnStateChange func(name string, from, to State)
+}
+
+type bucket struct {
+	start     time.Time
+	successes int
+	failures  int
+}
+
+// Breaker stops calls to a provider that keeps failing. It opens once the failure rate over the window is
+// reached, rejects calls with ErrCircuitOpen for the cool-down and then lets a few probe calls through
+// (half-open) to decide whether to close again.
+type Breaker struct {
+	opts BreakerOptions
+	now  func() time.Time
+
+	mu        sync.Mutex
+	state     State
+	openedAt  time.Time
+	buckets   [windowBuckets]bucket
+	probes    int
+	succeeded int
+	// generation counts the transitions, so outcomes of calls admitted in an earlier state are ignored.
+	generation uint64
+}
+
+func NewBreaker(opts BreakerOptions) *Breaker {
+	if opts.Window <= 0 {
+		opts.Window = defaultWindow
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = defaultMinRequests
+	}
+	if opts.FailureRate <= 0 || opts.FailureRate > 1 {
+		opts.FailureRate = defaultFailureRate
+	}
+	if opts.CoolDown <= 0 {
+		opts.CoolDown = defaultCoolDown
+	}
+	if opts.HalfOpenRequests <= 0 {
+		opts.HalfOpenRequests = defaultHalfOpenRequests
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = defaultIsFailure
+	}
+	return &Breaker{opts: opts, now: time.Now}
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.coolDown()
+	return b.state
+}
+
+// Allow returns ErrCircuitOpen if the call must not be made. Otherwise the returned func must be called with
+// the outcome of the call.
+func (b *Breaker) Allow() (func(err error), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.coolDown()
+
+	switch b.state {
+	case StateOpen:
+		return nil, ErrCircuitOpen
+	case StateHalfOpen:
+		if b.probes >= b.opts.HalfOpenRequests {
+			return nil, ErrCircuitOpen
+		}
+		b.probes++
+	}
+
+	generation := b.generation
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() {
+			b.record(generation, err)
+		})
+	}, nil
+}
+
+// Do runs fn unless the breaker is open.
+func (b *Breaker) Do(fn func() error) error {
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	done(err)
+	return err
+}
+
+// record counts the outcome of a call admitted in generation. A call admitted while closed that finishes
+// after the breaker opened is not a probe, so its outcome is dropped.
+func (b *Breaker) record(generation uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation != b.generation {
+		return
+	}
+
+	failed := err != nil && b.opts.IsFailure(err)
+	if err != nil && !failed {
+		// neither a success nor a failure of the provider, e.g. a cancelled call
+		if b.state == StateHalfOpen && b.probes > 0 {
+			b.probes--
+		}
+		return
+	}
+
+	switch b.state {
+	case StateHalfOpen:
+		if failed {
+			b.open()
+			return
+		}
+		b.succeeded++
+		if b.succeeded >= b.opts.HalfOpenRequests {
+			b.transition(StateClosed)
+		}
+	case StateClosed:
+		current := b.bucket()
+		if failed {
+			current.failures++
+		} else {
+			current.successes++
+		}
+		if failed && b.tripped() {
+			b.open()
+		}
+	}
+}
+
+// bucket returns the bucket of the current time, reusing the slot of a bucket that left the window.
+func (b *Breaker) bucket() *bucket {
+	width := b.opts.Window / windowBuckets
+	now := b.now()
+	start := now.Truncate(width)
+	current := &b.buckets[(start.UnixNano()/int64(width))%windowBuckets]
+	if !current.start.Equal(start) {
+		*current = bucket{start: start}
+	}
+	return current
+}
+
+func (b *Breaker) tripped() bool {
+	windowStart := b.now().Add(-b.opts.Window)
+	var successes, failures int
+	for _, bucket := range b.buckets {
+		if bucket.start.After(windowStart) {
+			successes += bucket.successes
+			failures += bucket.failures
+		}
+	}
+	total := successes + failures
+	return total >= b.opts.MinRequests && float64(failures)/float64(total) >= b.opts.FailureRate
+}
+
+// coolDown moves an open breaker to half-open once the cool-down passed.
+func (b *Breaker) coolDown() {
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.opts.CoolDown {
+		b.transition(StateHalfOpen)
+	}
+}
+
+func (b *Breaker) open() {
+	b.openedAt = b.now()
+	b.transition(StateOpen)
+}
+
+func (b *Breaker) transition(to State) {
+	from := b.state
+	b.state = to
+	b.generation++
+	b.probes = 0
+	b.succeeded = 0
+	if to == StateClosed {
+		b.buckets = [windowBuckets]bucket{}
+	}
+	if b.opts.OnStateChange != nil && from != to {
+		b.opts.OnStateChange(b.opts.Name, from, to)
+	}
+}
@@ -0,0 +1,152 @@
+package retry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type transition struct {
+	from, to State
+}
+
+func newTestBreaker(opts BreakerOptions) (*Breaker, *time.Time, *[]transition) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var transitions []transition
+	opts.OnStateChange = func(_ string, from, to State) {
+		transitions = append(transitions, transition{from, to})
+	}
+	breaker := NewBreaker(opts)
+	breaker.now = func() time.Time { return now }
+	return breaker, &now, &transitions
+}
+
+func fail(breaker *Breaker, times int) {
+	for i := 0; i < times; i++ {
+		_ = breaker.Do(func() error { return errFail })
+	}
+}
+
+func succeed(breaker *Breaker, times int) {
+	for i := 0; i < times; i++ {
+		_ = breaker.Do(func() error { return nil })
+	}
+}
+
+func TestBreaker_OpensAtFailureRate(t *testing.T) {
+	breaker, _, transitions := newTestBreaker(BreakerOptions{MinRequests: 4, FailureRate: 0.5})
+
+	fail(breaker, 3)
+	assert.Equal(t, StateClosed, breaker.State(), "below the minimum number of requests")
+	succeed(breaker, 5)
+	fail(breaker, 1)
+	assert.Equal(t, StateClosed, breaker.State(), "4 of 9 calls failed")
+	fail(breaker, 1)
+	assert.Equal(t, StateOpen, breaker.State())
+	assert.Equal(t, []transition{{StateClosed, StateOpen}}, *transitions)
+
+	called := false
+	err := breaker.Do(func() error {
+		called = true
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.False(t, called)
+}
+
+func TestBreaker_FailuresLeaveTheWindow(t *testing.T) {
+	breaker, now, _ := newTestBreaker(BreakerOptions{Window: time.Minute, MinRequests: 4, FailureRate: 0.5})
+
+	fail(breaker, 3)
+	*now = now.Add(2 * time.Minute)
+	fail(breaker, 1)
+	succeed(breaker, 3)
+	assert.Equal(t, StateClosed, breaker.State())
+}
+
+func TestBreaker_HalfOpenProbes(t *testing.T) {
+	breaker, now, transitions := newTestBreaker(BreakerOptions{MinRequests: 1, CoolDown: 30 * time.Second, HalfOpenRequests: 2})
+	fail(breaker, 1)
+	require.Equal(t, StateOpen, breaker.State())
+
+	*now = now.Add(30 * time.Second)
+	assert.Equal(t, StateHalfOpen, breaker.State())
+	first, err := breaker.Allow()
+	require.NoError(t, err)
+	second, err := breaker.Allow()
+	require.NoError(t, err)
+	_, err = breaker.Allow()
+	assert.ErrorIs(t, err, ErrCircuitOpen, "only the probes go through")
+
+	first(nil)
+	assert.Equal(t, StateHalfOpen, breaker.State())
+	second(nil)
+	assert.Equal(t, StateClosed, breaker.State())
+	assert.Equal(t, []transition{{StateClosed, StateOpen}, {StateOpen, StateHalfOpen}, {StateHalfOpen, StateClosed}}, *transitions)
+}
+
+func TestBreaker_FailedProbeReopens(t *testing.T) {
+	breaker, now, _ := newTestBreaker(BreakerOptions{MinRequests: 1, CoolDown: 30 * time.Second})
+	fail(breaker, 1)
+
+	*now = now.Add(30 * time.Second)
+	fail(breaker, 1)
+	assert.Equal(t, StateOpen, breaker.State())
+
+	*now = now.Add(10 * time.Second)
+	assert.Equal(t, StateOpen, breaker.State(), "the cool-down starts over")
+}
+
+func TestBreaker_IgnoresCallsAdmittedBeforeHalfOpen(t *testing.T) {
+	breaker, now, _ := newTestBreaker(BreakerOptions{MinRequests: 2, CoolDown: 30 * time.Second})
+	slowSuccess, err := breaker.Allow()
+	require.NoError(t, err)
+	slowFailure, err := breaker.Allow()
+	require.NoError(t, err)
+	fail(breaker, 2)
+	require.Equal(t, StateOpen, breaker.State())
+
+	*now = now.Add(30 * time.Second)
+	require.Equal(t, StateHalfOpen, breaker.State())
+	slowSuccess(nil)
+	assert.Equal(t, StateHalfOpen, breaker.State(), "a call admitted while closed is not a probe")
+	slowFailure(errFail)
+	assert.Equal(t, StateHalfOpen, breaker.State())
+
+	probe, err := breaker.Allow()
+	require.NoError(t, err, "the probe is still let through")
+	probe(nil)
+	assert.Equal(t, StateClosed, breaker.State())
+}
+
+func TestBreaker_IgnoresCancelledCalls(t *testing.T) {
+	breaker, _, _ := newTestBreaker(BreakerOptions{MinRequests: 1})
+	_ = breaker.Do(func() error { return context.Canceled })
+	assert.Equal(t, StateClosed, breaker.State())
+}
+
+func TestRetrier_StopsWhenBreakerOpens(t *testing.T) {
+	breaker, _, _ := newTestBreaker(BreakerOptions{MinRequests: 2})
+	r := New[string](Options{
+		MaxRetries: 5,
+		Strategy:   ExponentialBackoff(time.Millisecond),
+		Breaker:    breaker,
+	})
+
+	called := 0
+	_, err := r.Do(context.Background(), func() (string, error) {
+		called++
+		return "", errFail
+	})
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 2, called)
+
+	_, err = r.Do(context.Background(), func() (string, error) {
+		t.Fatal("function should not have been called")
+		return "", nil
+	})
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+}
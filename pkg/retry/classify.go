@@ -0,0 +1,118 @@
+package retry
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as one that fails the same way however often the call is repeated, e.g. a 404.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+type retryAfterError struct {
+	err   error
+	delay time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryAfterError) Unwrap() error {
+	return e.err
+}
+
+// RetryAfter marks err as retryable once delay passed, the delay the server asked for. It replaces the
+// delay of the Strategy for the next attempt.
+func RetryAfter(err error, delay time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return &retryAfterError{err: err, delay: delay}
+}
+
+// RetryDelay returns the delay set with RetryAfter.
+func RetryDelay(err error) (time.Duration, bool) {
+	var retryAfter *retryAfterError
+	if errors.As(err, &retryAfter) {
+		return retryAfter.delay, true
+	}
+	return 0, false
+}
+
+// ClassifyResponse marks err, the error of a failed HTTP response, by the status code and headers of resp:
+// client errors are permanent, except for timeouts and rate limits. A Retry-After header, also sent with the
+// secondary rate limits of GitHub, sets the delay, and so does the reset time of an exhausted GitHub rate limit.
+func ClassifyResponse(resp *http.Response, err error) error {
+	if err == nil || resp == nil {
+		return err
+	}
+	if delay, ok := retryAfterHeader(resp.Header); ok {
+		return RetryAfter(err, delay)
+	}
+	if delay, ok := rateLimitResetHeader(resp.Header); ok {
+		return RetryAfter(err, delay)
+	}
+	if permanentStatus(resp.StatusCode) {
+		return Permanent(err)
+	}
+	return err
+}
+
+func permanentStatus(statusCode int) bool {
+	return statusCode >= 400 && statusCode < 500 && statusCode != http.StatusRequestTimeout && statusCode != http.StatusTooManyRequests
+}
+
+// retryAfterHeader parses the Retry-After header, given either in seconds or as an HTTP date.
+func retryAfterHeader(header http.Header) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date), true
+	}
+	return 0, false
+}
+
+// rateLimitResetHeader returns the time until the rate limit resets when X-RateLimit-Remaining says it is
+// exhausted. X-RateLimit-Reset is given in unix seconds.
+func rateLimitResetHeader(header http.Header) (time.Duration, bool) {
+	if header.Get("X-RateLimit-Remaining") != "0" {
+		return 0, false
+	}
+	reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Until(time.Unix(reset, 0)), true
+}
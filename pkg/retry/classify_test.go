@@ -0,0 +1,212 @@
+package retry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetrier_DoesNotRetryPermanentErrors(t *testing.T) {
+	r := New[string](Options{
+		MaxRetries: 5,
+		Strategy:   ExponentialBackoff(time.Millisecond),
+		Classify:   Permanent,
+	})
+
+	called := 0
+	_, err := r.Do(context.Background(), func() (string, error) {
+		called++
+		return "", errFail
+	})
+	assert.ErrorIs(t, err, errFail)
+	assert.True(t, IsPermanent(err))
+	assert.Equal(t, 1, called)
+}
+
+func TestRetrier_RetryAfterOverridesStrategy(t *testing.T) {
+	r := New[string](Options{
+		MaxRetries: 2,
+		Strategy:   ExponentialBackoff(time.Hour),
+		Classify: func(err error) error {
+			return RetryAfter(err, time.Millisecond)
+		},
+	})
+
+	called := 0
+	start := time.Now()
+	resp, err := r.Do(context.Background(), func() (string, error) {
+		called++
+		if called == 1 {
+			return "", errFail
+		}
+		return "ok", nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestRetrier_StopsWhenServerAsksToWaitTooLong(t *testing.T) {
+	r := New[string](Options{
+		MaxRetries: 3,
+		MaxDelay:   time.Minute,
+		Classify: func(err error) error {
+			return RetryAfter(err, time.Hour)
+		},
+	})
+
+	called := 0
+	_, err := r.Do(context.Background(), func() (string, error) {
+		called++
+		return "", errFail
+	})
+	delay, ok := RetryDelay(err)
+	assert.True(t, ok)
+	assert.Equal(t, time.Hour, delay)
+	assert.Equal(t, 1, called)
+}
+
+func TestBreaker_IgnoresPermanentErrors(t *testing.T) {
+	breaker, _, _ := newTestBreaker(BreakerOptions{MinRequests: 1})
+	_ = breaker.Do(func() error { return Permanent(errFail) })
+	assert.Equal(t, StateClosed, breaker.State())
+}
+
+func githubResponse(statusCode int, header http.Header) *http.Response {
+	request, _ := http.NewRequest(http.MethodGet, "https://api.github.com/repos/acme/api/pulls/1", nil)
+	return &http.Response{StatusCode: statusCode, Header: header, Request: request}
+}
+
+func TestClassifyResponse(t *testing.T) {
+	reset := time.Now().Add(10 * time.Minute)
+	tests := []struct {
+		name      string
+		status    int
+		header    http.Header
+		permanent bool
+		delay     time.Duration
+		delayed   bool
+	}{
+		{name: "not found", status: http.StatusNotFound, header: http.Header{}, permanent: true},
+		{name: "forbidden", status: http.StatusForbidden, header: http.Header{"X-Ratelimit-Remaining": {"4999"}}, permanent: true},
+		{name: "server error", status: http.StatusBadGateway, header: http.Header{}},
+		{
+			name:    "exhausted primary rate limit",
+			status:  http.StatusForbidden,
+			header:  http.Header{"X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Reset": {strconv.FormatInt(reset.Unix(), 10)}},
+			delay:   time.Until(reset),
+			delayed: true,
+		},
+		{
+			name:    "secondary rate limit",
+			status:  http.StatusForbidden,
+			header:  http.Header{"Retry-After": {"60"}},
+			delay:   time.Minute,
+			delayed: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ClassifyResponse(githubResponse(test.status, test.header), errFail)
+			assert.Equal(t, test.permanent, IsPermanent(err))
+			delay, ok := RetryDelay(err)
+			assert.Equal(t, test.delayed, ok)
+			assert.InDelta(t, test.delay, delay, float64(time.Second))
+		})
+	}
+}
+
+func TestClassifyGithub(t *testing.T) {
+	notFound := &github.ErrorResponse{Response: githubResponse(http.StatusNotFound, http.Header{})}
+	assert.True(t, IsPermanent(ClassifyGithub(notFound)))
+
+	unavailable := &github.ErrorResponse{Response: githubResponse(http.StatusBadGateway, http.Header{})}
+	assert.False(t, IsPermanent(ClassifyGithub(unavailable)))
+	_, ok := RetryDelay(ClassifyGithub(unavailable))
+	assert.False(t, ok)
+
+	tooMany := &github.ErrorResponse{Response: githubResponse(http.StatusTooManyRequests, http.Header{"Retry-After": {"30"}})}
+	delay, ok := RetryDelay(ClassifyGithub(tooMany))
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, delay)
+
+	rateLimit := &github.RateLimitError{
+		Rate:     github.Rate{Reset: github.Timestamp{Time: time.Now().Add(10 * time.Minute)}},
+		Response: githubResponse(http.StatusForbidden, http.Header{}),
+	}
+	delay, ok = RetryDelay(ClassifyGithub(rateLimit))
+	assert.True(t, ok)
+	assert.InDelta(t, 10*time.Minute, delay, float64(time.Second))
+
+	retryAfter := 45 * time.Second
+	abuse := &github.AbuseRateLimitError{Response: githubResponse(http.StatusForbidden, http.Header{}), RetryAfter: &retryAfter}
+	delay, ok = RetryDelay(ClassifyGithub(abuse))
+	assert.True(t, ok)
+	assert.Equal(t, retryAfter, delay)
+
+	assert.Equal(t, errFail, ClassifyGithub(errFail))
+	assert.NoError(t, ClassifyGithub(nil))
+}
+
+func TestClassifyOpenAI(t *testing.T) {
+	assert.True(t, IsPermanent(ClassifyOpenAI(&openai.APIError{HTTPStatusCode: http.StatusBadRequest})))
+	assert.False(t, IsPermanent(ClassifyOpenAI(&openai.APIError{HTTPStatusCode: http.StatusTooManyRequests})))
+	assert.False(t, IsPermanent(ClassifyOpenAI(&openai.RequestError{HTTPStatusCode: http.StatusServiceUnavailable})))
+	assert.Equal(t, errFail, ClassifyOpenAI(errFail))
+}
+
+func TestOpenAITransport(t *testing.T) {
+	var statusCode int
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for key, values := range header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(statusCode)
+		_, _ = w.Write([]byte(`{"error": {"message": "slow down"}}`))
+	}))
+	defer server.Close()
+
+	clientConfig := openai.DefaultConfig("token")
+	clientConfig.BaseURL = server.URL
+	clientConfig.HTTPClient = &http.Client{Transport: NewOpenAITransport(nil)}
+	client := openai.NewClientWithConfig(clientConfig)
+	request := openai.EmbeddingRequest{Input: []string{"package main"}, Model: openai.SmallEmbedding3}
+
+	statusCode, header = http.StatusTooManyRequests, http.Header{
+		"X-Ratelimit-Remaining-Requests": {"12"},
+		"X-Ratelimit-Reset-Requests":     {"1s"},
+		"X-Ratelimit-Remaining-Tokens":   {"0"},
+		"X-Ratelimit-Reset-Tokens":       {"6m0s"},
+	}
+	_, err := client.CreateEmbeddings(context.Background(), request)
+	delay, ok := RetryDelay(ClassifyOpenAI(err))
+	assert.True(t, ok)
+	assert.Equal(t, 6*time.Minute, delay)
+	assert.Contains(t, err.Error(), "slow down")
+
+	statusCode, header = http.StatusTooManyRequests, http.Header{"Retry-After-Ms": {"250"}}
+	_, err = client.CreateEmbeddings(context.Background(), request)
+	delay, _ = RetryDelay(err)
+	assert.Equal(t, 250*time.Millisecond, delay)
+
+	statusCode, header = http.StatusUnauthorized, http.Header{}
+	_, err = client.CreateEmbeddings(context.Background(), request)
+	assert.True(t, IsPermanent(ClassifyOpenAI(err)))
+
+	// server errors are left to the client and retried on the Strategy
+	statusCode, header = http.StatusInternalServerError, http.Header{}
+	_, err = client.CreateEmbeddings(context.Background(), request)
+	classified := ClassifyOpenAI(err)
+	assert.False(t, IsPermanent(classified))
+	_, ok = RetryDelay(classified)
+	assert.False(t, ok)
+}
@@ -0,0 +1,34 @@
+package retry
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// ClassifyGithub marks the errors of go-github: rate limits are retried once they reset and client errors,
+// such as a missing pull request, are permanent.
+func ClassifyGithub(err error) error {
+	var rateLimit *github.RateLimitError
+	var abuseRateLimit *github.AbuseRateLimitError
+	var accepted *github.AcceptedError
+	var response *github.ErrorResponse
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &rateLimit):
+		return RetryAfter(err, time.Until(rateLimit.Rate.Reset.Time))
+	case errors.As(err, &abuseRateLimit):
+		if abuseRateLimit.RetryAfter != nil {
+			return RetryAfter(err, *abuseRateLimit.RetryAfter)
+		}
+		return err
+	case errors.As(err, &accepted):
+		// the result is computed in the background, asking again later succeeds
+		return err
+	case errors.As(err, &response):
+		return ClassifyResponse(response.Response, err)
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const maxErrorBody = 64 * 1024
+
+// ClassifyOpenAI marks the errors of go-openai by their status code. Those errors do not keep the rate-limit
+// headers; clients sending through OpenAITransport get errors that already carry the delay.
+func ClassifyOpenAI(err error) error {
+	if err == nil || IsPermanent(err) {
+		return err
+	}
+	if _, ok := RetryDelay(err); ok {
+		return err
+	}
+
+	var apiErr *openai.APIError
+	var requestErr *openai.RequestError
+	var statusCode int
+	switch {
+	case errors.As(err, &apiErr):
+		statusCode = apiErr.HTTPStatusCode
+	case errors.As(err, &requestErr):
+		statusCode = requestErr.HTTPStatusCode
+	default:
+		return err
+	}
+	if permanentStatus(statusCode) {
+		return Permanent(err)
+	}
+	return err
+}
+
+// StatusError is a failed response turned into an error by OpenAITransport.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected response: %d: %s", e.StatusCode, e.Body)
+}
+
+type openAITransport struct {
+	next http.RoundTripper
+}
+
+// NewOpenAITransport fails rate-limited responses of the OpenAI API with an error carrying the delay of its
+// rate-limit headers, and client errors with a permanent error. The clients built on it, go-openai and
+// langchaingo alike, return these errors as they are. Other responses are passed through.
+func NewOpenAITransport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &openAITransport{next: next}
+}
+
+func (t *openAITransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode < http.StatusBadRequest {
+		return resp, err
+	}
+
+	delay, limited := openAIRetryDelay(resp.Header)
+	if !limited && !permanentStatus(resp.StatusCode) {
+		return resp, nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	_ = resp.Body.Close()
+	statusErr := &StatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	if limited {
+		return nil, RetryAfter(statusErr, delay)
+	}
+	return nil, Permanent(statusErr)
+}
+
+// openAIRetryDelay reads retry-after-ms and Retry-After, falling back to the reset time of the exhausted
+// request or token limit.
+func openAIRetryDelay(header http.Header) (time.Duration, bool) {
+	if millis, err := strconv.Atoi(header.Get("Retry-After-Ms")); err == nil {
+		return time.Duration(millis) * time.Millisecond, true
+	}
+	if delay, ok := retryAfterHeader(header); ok {
+		return delay, true
+	}
+
+	var delay time.Duration
+	var limited bool
+	for _, limit := range []string{"requests", "tokens"} {
+		if header.Get("X-Ratelimit-Remaining-"+limit) != "0" {
+			continue
+		}
+		reset, err := time.ParseDuration(header.Get("X-Ratelimit-Reset-" + limit))
+		if err != nil {
+			continue
+		}
+		limited = true
+		delay = max(delay, reset)
+	}
+	return delay, limited
+}
@@ -0,0 +1,45 @@
+package retry
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	ReasonRateLimited = "rate_limited"
+	ReasonError       = "error"
+)
+
+// PrometheusObserver counts the retries of every operation. Register it as a collector and pass its OnRetry
+// to Options.
+type PrometheusObserver struct {
+	retries *prometheus.CounterVec
+}
+
+func NewPrometheusObserver() *PrometheusObserver {
+	return &PrometheusObserver{
+		retries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "retries_total",
+				Help: "Total number of retried calls by operation and reason",
+			},
+			[]string{"operation", "reason"},
+		),
+	}
+}
+
+// OnRetry counts the retry of event, as rate-limited when the server asked for the delay.
+func (o *PrometheusObserver) OnRetry(event RetryEvent) {
+	reason := ReasonError
+	if _, ok := RetryDelay(event.Err); ok {
+		reason = ReasonRateLimited
+	}
+	o.retries.WithLabelValues(event.Operation, reason).Inc()
+}
+
+func (o *PrometheusObserver) Describe(ch chan<- *prometheus.Desc) {
+	o.retries.Describe(ch)
+}
+
+func (o *PrometheusObserver) Collect(ch chan<- prometheus.Metric) {
+	o.retries.Collect(ch)
+}
@@ -2,6 +2,7 @@ package retry
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
@@ -17,10 +18,34 @@ type Options struct {
 	MaxRetries  int
 	Strategy    Strategy
 	ShouldRetry func(error) bool
+	// Classify marks the errors of fn with Permanent or RetryAfter, e.g. ClassifyGithub.
+	Classify func(error) error
+	// MaxDelay stops retrying when the server asks to wait longer. Zero waits as long as asked.
+	MaxDelay time.Duration
+	// MaxElapsed stops retrying when the next attempt would start after this budget. Zero has no budget.
+	MaxElapsed time.Duration
+	// Breaker guards every attempt. Once it is open, Do stops retrying and returns ErrCircuitOpen.
+	Breaker *Breaker
+	// Operation names the retried call in RetryEvent, e.g. for metrics.
+	Operation string
+	// OnRetry is called after a failed attempt, before waiting for the next one.
+	OnRetry func(event RetryEvent)
 }
 
+type RetryEvent struct {
+	Operation string
+	// Attempt is the number of the attempt that failed, starting at 1.
+	Attempt int
+	Err     error
+	// Delay is how long Do waits before the next attempt.
+	Delay time.Duration
+}
+
+// Retrier calls fn until it succeeds or the options give up. When every attempt failed, the error wraps the
+// failure of each of them.
 type Retrier[T any] interface {
 	Do(ctx context.Context, fn func() (T, error)) (T, error)
+	DoContext(ctx context.Context, fn func(ctx context.Context) (T, error)) (T, error)
 }
 
 type retrier[T any] struct {
@@ -41,33 +66,71 @@ func New[T any](opts Options) Retrier[T] {
 }
 
 func (r *retrier[T]) Do(ctx context.Context, fn func() (T, error)) (T, error) {
-	var zero, resp T
-	var err error
+	return r.DoContext(ctx, func(context.Context) (T, error) {
+		return fn()
+	})
+}
+
+// DoContext calls fn with a context carrying the attempt number, see AttemptFromContext.
+func (r *retrier[T]) DoContext(ctx context.Context, fn func(ctx context.Context) (T, error)) (T, error) {
+	var zero T
+	var failures []error
+	start := time.Now()
 
-	for attempt := 1; attempt <= r.opts.MaxRetries; attempt++ {
+	for attempt := 1; ; attempt++ {
 		if ctx.Err() != nil {
-			return zero, ctx.Err()
+			return zero, errors.Join(ctx.Err(), joinAttempts(failures))
 		}
 
-		resp, err = fn()
+		resp, err := r.attempt(context.WithValue(ctx, attemptKey{}, attempt), fn)
 		if err == nil {
 			return resp, nil
 		}
+		failures = append(failures, err)
 
-		if !r.opts.ShouldRetry(err) {
-			return zero, err
+		if errors.Is(err, ErrCircuitOpen) || IsPermanent(err) || !r.opts.ShouldRetry(err) || attempt == r.opts.MaxRetries {
+			return zero, joinAttempts(failures)
 		}
 
-		if attempt == r.opts.MaxRetries {
-			break
+		delay := r.opts.Strategy(attempt)
+		if suggested, ok := RetryDelay(err); ok {
+			if r.opts.MaxDelay > 0 && suggested > r.opts.MaxDelay {
+				return zero, joinAttempts(failures)
+			}
+			delay = suggested
+		}
+		if r.opts.MaxElapsed > 0 && time.Since(start)+delay > r.opts.MaxElapsed {
+			return zero, joinAttempts(failures)
+		}
+		if r.opts.OnRetry != nil {
+			r.opts.OnRetry(RetryEvent{Operation: r.opts.Operation, Attempt: attempt, Err: err, Delay: delay})
 		}
 
 		select {
-		case <-time.After(r.opts.Strategy(attempt)):
+		case <-time.After(delay):
 		case <-ctx.Done():
-			return zero, ctx.Err()
+			return zero, errors.Join(ctx.Err(), joinAttempts(failures))
 		}
 	}
+}
 
-	return zero, err
+// attempt classifies the error of fn before the breaker sees it, so permanent errors do not open it.
+func (r *retrier[T]) attempt(ctx context.Context, fn func(ctx context.Context) (T, error)) (T, error) {
+	var done func(error)
+	if r.opts.Breaker != nil {
+		var err error
+		if done, err = r.opts.Breaker.Allow(); err != nil {
+			var zero T
+			return zero, err
+		}
+	}
+
+	resp, err := fn(ctx)
+	if err != nil && r.opts.Classify != nil {
+		err = r.opts.Classify(err)
+	}
+	if done != nil {
+		done(err)
+	}
+	return resp, err
 }
@@ -114,3 +114,12 @@ func TestContextCancelledDuringRetry(t *testing.T) {
 	assert.Equal(t, "", resp)
 	assert.GreaterOrEqual(t, called, 1)
 }
+
+func TestExponentialJitterBackoff_CapsLateAttempts(t *testing.T) {
+	strategy := ExponentialJitterBackoff(100*time.Millisecond, 10*time.Second)
+	for _, attempt := range []int{1, 38, 64, 100} {
+		delay := strategy(attempt)
+		assert.Positive(t, delay)
+		assert.LessOrEqual(t, delay, 10*time.Second)
+	}
+}
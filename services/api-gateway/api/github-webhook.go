@@ -1,13 +1,23 @@
 package api
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/go-github/v58/github"
+	"go_code_reviewer/pkg/kafka"
 	"go_code_reviewer/pkg/log"
+	serviceErrors "go_code_reviewer/services/api-gateway/internal/errors"
 	"go_code_reviewer/services/api-gateway/pkg/models"
+	"net/http"
+	"regexp"
 	"strings"
 )
 
+var traceParentPattern = regexp.MustCompile(`^[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
 func (h *Handler) githubWebhook(c *gin.Context) {
 	logger := log.GetLogger()
 	logger.Info("Received request for github webhook")
@@ -33,19 +43,56 @@ func (h *Handler) githubWebhook(c *gin.Context) {
 		}
 		logger.Infof("Received pull request event %v", event)
 
-		err = h.module.ProcessEvent(c, event)
-		if err != nil {
-			h.handleErrorApiResponse(c, err, "failed to send event to kafka")
+		h.publish(c, models.EventTypePullRequest, func(metadata models.EventMetadata) error {
+			return h.module.ProcessEvent(c, event, metadata)
+		})
+		return
+	case *github.PushEvent:
+		event, ok := convertPushEvent(e)
+		if !ok {
+			h.handleSuccessfulApiResponse(c, "ignored push event")
 			return
 		}
-		logger.Info("Successfully send webhook to kafka")
-		h.handleSuccessfulApiResponse(c, "received pull request event")
+		logger.Infof("Received push event %v", event)
+
+		h.publish(c, models.EventTypePush, func(metadata models.EventMetadata) error {
+			return h.module.ProcessPushEvent(c, event, metadata)
+		})
 		return
 	}
 
 	h.handleSuccessfulApiResponse(c, "event not found")
 }
 
+// publish sends an event of eventType to kafka once per webhook delivery.
+func (h *Handler) publish(c *gin.Context, eventType string, send func(metadata models.EventMetadata) error) {
+	logger := log.GetLogger()
+	deliveryID := github.DeliveryID(c.Request)
+	if err := h.rememberDelivery(c, deliveryID); err != nil {
+		h.handleErrorApiResponse(c, err, "failed to check webhook delivery")
+		return
+	}
+
+	err := send(models.EventMetadata{
+		EventType:     eventType,
+		SchemaVersion: models.SchemaVersion,
+		DeliveryID:    deliveryID,
+		TraceParent:   traceParent(c.Request),
+	})
+	if err != nil {
+		// let github redeliver the webhook, unless the event may still be delivered and a redelivery would duplicate it
+		if errors.Is(err, kafka.ErrDeliveryTimeout) {
+			logger.WithError(err).Warn("unknown whether the event reached kafka, keeping the webhook delivery")
+		} else if forgetErr := h.deliveries.Forget(c, deliveryID); forgetErr != nil {
+			logger.WithError(forgetErr).Error("failed to forget webhook delivery")
+		}
+		h.handleErrorApiResponse(c, err, "failed to send event to kafka")
+		return
+	}
+	logger.Info("Successfully send webhook to kafka")
+	h.handleSuccessfulApiResponse(c, "received "+strings.ReplaceAll(eventType, "_", " ")+" event")
+}
+
 func convertGitHubEvent(event *github.PullRequestEvent) (*models.PullRequestEvent, bool) {
 	if event == nil || event.PullRequest == nil || event.Repo == nil || event.Repo.Owner == nil {
 		return nil, false
@@ -57,13 +104,77 @@ func convertGitHubEvent(event *github.PullRequestEvent) (*models.PullRequestEven
 	}
 
 	return &models.PullRequestEvent{
-		Owner:    parts[0],
-		Repo:     parts[1],
-		Number:   event.GetPullRequest().GetNumber(),
-		CloneURL: event.GetRepo().GetCloneURL(),
-		Branch:   event.GetPullRequest().GetHead().GetRef(),
-		Title:    event.GetPullRequest().GetTitle(),
-		Author:   event.GetPullRequest().GetUser().GetLogin(),
-		DiffURL:  event.GetPullRequest().GetDiffURL(),
+		Owner:        parts[0],
+		Repo:         parts[1],
+		Number:       event.GetPullRequest().GetNumber(),
+		Action:       event.GetAction(),
+		CloneURL:     event.GetRepo().GetCloneURL(),
+		HeadCloneURL: event.GetPullRequest().GetHead().GetRepo().GetCloneURL(),
+		Branch:       event.GetPullRequest().GetHead().GetRef(),
+		HeadSHA:      event.GetPullRequest().GetHead().GetSHA(),
+		BaseBranch:   event.GetPullRequest().GetBase().GetRef(),
+		BaseSHA:      event.GetPullRequest().GetBase().GetSHA(),
+		Title:        event.GetPullRequest().GetTitle(),
+		Author:       event.GetPullRequest().GetUser().GetLogin(),
+		DiffURL:      event.GetPullRequest().GetDiffURL(),
+
+		DefaultBranch: event.GetRepo().GetDefaultBranch(),
 	}, true
 }
+
+// convertPushEvent converts pushes of commits to the default branch, the only branch with a base index.
+func convertPushEvent(event *github.PushEvent) (*models.PushEvent, bool) {
+	if event == nil || event.Repo == nil || event.GetDeleted() {
+		return nil, false
+	}
+
+	branch, ok := strings.CutPrefix(event.GetRef(), "refs/heads/")
+	if !ok || branch != event.GetRepo().GetDefaultBranch() {
+		return nil, false
+	}
+
+	parts := strings.Split(event.GetRepo().GetFullName(), "/")
+	if len(parts) != 2 {
+		return nil, false
+	}
+
+	return &models.PushEvent{
+		Owner:     parts[0],
+		Repo:      parts[1],
+		Branch:    branch,
+		CloneURL:  event.GetRepo().GetCloneURL(),
+		BeforeSHA: event.GetBefore(),
+		HeadSHA:   event.GetAfter(),
+	}, true
+}
+
+// rememberDelivery rejects deliveries that were already received. The delivery id is not covered by the
+// signature, so a replay with a forged id still gets through; the code-reviewer skips head shas it has reviewed.
+func (h *Handler) rememberDelivery(ctx context.Context, deliveryID string) error {
+	if deliveryID == "" {
+		return serviceErrors.ErrMissingDeliveryID
+	}
+
+	firstSeen, err := h.deliveries.Remember(ctx, deliveryID, h.config.Deliveries.TTL)
+	if err != nil {
+		return err
+	}
+	if !firstSeen {
+		log.GetLogger().WithField("delivery_id", deliveryID).Warn("rejected duplicate webhook delivery")
+		return serviceErrors.ErrDuplicateDelivery
+	}
+	return nil
+}
+
+// traceParent continues the w3c trace context of the request, or starts a new trace if it has none.
+func traceParent(r *http.Request) string {
+	if header := r.Header.Get(models.HeaderTraceParent); traceParentPattern.MatchString(header) {
+		return header
+	}
+
+	ids := make([]byte, 24)
+	if _, err := rand.Read(ids); err != nil {
+		return ""
+	}
+	return "00-" + hex.EncodeToString(ids[:16]) + "-" + hex.EncodeToString(ids[16:]) + "-01"
+}
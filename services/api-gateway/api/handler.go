@@ -4,39 +4,39 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/gin-gonic/gin"
+	"go_code_reviewer/pkg/app"
 	"go_code_reviewer/pkg/log"
 	"go_code_reviewer/services/api-gateway/internal/config"
+	"go_code_reviewer/services/api-gateway/internal/deliveries"
 	serviceErrors "go_code_reviewer/services/api-gateway/internal/errors"
 	eventprocessor "go_code_reviewer/services/api-gateway/internal/event-sender"
+	"go_code_reviewer/services/api-gateway/internal/metrics"
 	"net/http"
 )
 
 type Handler struct {
-	config *config.Config
-	module *eventprocessor.Module
+	config     *config.Config
+	module     *eventprocessor.Module
+	health     *app.HealthCache
+	deliveries deliveries.Store
 }
 
-func NewHandler(config *config.Config, module *eventprocessor.Module) *Handler {
+func NewHandler(config *config.Config, module *eventprocessor.Module, health *app.HealthCache, deliveries deliveries.Store) *Handler {
 	return &Handler{
-		config: config,
-		module: module,
+		config:     config,
+		module:     module,
+		health:     health,
+		deliveries: deliveries,
 	}
 }
 
-func (h *Handler) liveness(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": 1})
-}
-
-func (h *Handler) readiness(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": 1})
-}
-
 func (h *Handler) RegisterRoutes() *gin.Engine {
 	r := gin.New()
 	r.Use(gin.Recovery())
 
-	r.GET("/readiness", h.readiness)
-	r.POST("/liveness", h.liveness)
+	r.GET("/readiness", gin.WrapH(h.health.ReadinessHandler()))
+	r.GET("/liveness", gin.WrapH(app.LivenessHandler()))
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
 	r.POST("/github-webhook", h.githubWebhook)
 
 	return r
@@ -1,15 +1,41 @@
 package config
 
 import (
+	"fmt"
+	"go_code_reviewer/pkg/kafka"
 	"gopkg.in/yaml.v3"
 	"os"
+	"time"
+)
+
+const (
+	// WebhookTimeout is how long github waits for the response to a webhook before it gives up on the delivery.
+	WebhookTimeout = 10 * time.Second
+	// SendBudget is the part of the WebhookTimeout a webhook may spend sending its event to kafka.
+	SendBudget = 9 * time.Second
+
+	defaultDeliveryTimeout = 5 * time.Second
 )
 
 type Config struct {
-	Env        string        `yaml:"env" json:"env"`
-	HttpServer HttpServer    `yaml:"http_server" json:"http_server"`
-	Github     GithubSection `yaml:"github" json:"github"`
-	Kafka      KafkaSection  `yaml:"kafka" json:"kafka"`
+	Env        string            `yaml:"env" json:"env"`
+	HttpServer HttpServer        `yaml:"http_server" json:"http_server"`
+	Github     GithubSection     `yaml:"github" json:"github"`
+	Kafka      KafkaSection      `yaml:"kafka" json:"kafka"`
+	Deliveries DeliveriesSection `yaml:"deliveries" json:"deliveries"`
+}
+
+// DeliveriesSection configures the store of seen webhook delivery ids. Without a redis address the ids are
+// kept in memory, which only deduplicates deliveries that reach the same instance.
+type DeliveriesSection struct {
+	TTL   time.Duration `yaml:"ttl" json:"ttl"`
+	Redis RedisSection  `yaml:"redis" json:"redis"`
+}
+
+type RedisSection struct {
+	Address  string `yaml:"address" json:"address"`
+	Password string `yaml:"password" json:"password"`
+	DB       int    `yaml:"db" json:"db"`
 }
 
 type GithubSection struct {
@@ -21,8 +47,16 @@ type HttpServer struct {
 }
 
 type KafkaSection struct {
-	Brokers string `yaml:"brokers" json:"brokers"`
-	Topic   string `yaml:"topic" json:"topic"`
+	Brokers         string        `yaml:"brokers" json:"brokers"`
+	Topic           string        `yaml:"topic" json:"topic"`
+	DeliveryTimeout time.Duration `yaml:"delivery_timeout" json:"delivery_timeout"`
+}
+
+func (k KafkaSection) ProducerConfig() kafka.ProducerConfig {
+	return kafka.ProducerConfig{
+		Brokers:         k.Brokers,
+		DeliveryTimeout: k.DeliveryTimeout,
+	}
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -30,6 +64,11 @@ func LoadConfig(path string) (*Config, error) {
 		Github: GithubSection{
 			WebhookSecret: os.Getenv("GITHUB_WEBHOOK_SECRET"),
 		},
+		Deliveries: DeliveriesSection{
+			Redis: RedisSection{
+				Password: os.Getenv("DELIVERIES_REDIS_PASSWORD"),
+			},
+		},
 	}
 
 	file, err := os.ReadFile(path)
@@ -42,5 +81,13 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
+	if config.Kafka.DeliveryTimeout <= 0 {
+		config.Kafka.DeliveryTimeout = defaultDeliveryTimeout
+	}
+	if sendTimeout := config.Kafka.ProducerConfig().SendTimeout(); sendTimeout > SendBudget {
+		return nil, fmt.Errorf("kafka delivery_timeout %s waits %s for a delivery report, more than the %s a webhook may take",
+			config.Kafka.DeliveryTimeout, sendTimeout, SendBudget)
+	}
+
 	return config, nil
 }
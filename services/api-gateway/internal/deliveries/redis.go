@@ -0,0 +1,39 @@
+package deliveries
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	pkgredis "go_code_reviewer/pkg/redis"
+	"time"
+)
+
+const keyPrefix = "api-gateway:delivery:"
+
+// redisStore keeps the delivery ids in Redis, so every replica of the gateway rejects the same redeliveries.
+type redisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(ctx context.Context, conf pkgredis.Config) (Store, error) {
+	client, err := pkgredis.NewClient(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	return &redisStore{client: client}, nil
+}
+
+func (s *redisStore) Remember(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, keyPrefix+id, "1", ttl).Result()
+}
+
+func (s *redisStore) Forget(ctx context.Context, id string) error {
+	return s.client.Del(ctx, keyPrefix+id).Err()
+}
+
+func (s *redisStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,72 @@
+package deliveries
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store remembers webhook delivery ids for a while, so redelivered or replayed webhooks are only published once.
+type Store interface {
+	// Remember records id and reports whether it was not seen within the ttl.
+	Remember(ctx context.Context, id string, ttl time.Duration) (bool, error)
+	// Forget drops id, so a delivery that failed to publish can be retried by GitHub.
+	Forget(ctx context.Context, id string) error
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+const evictionInterval = time.Minute
+
+type inMemoryStore struct {
+	mu          sync.Mutex
+	expires     map[string]time.Time
+	lastEvicted time.Time
+	now         func() time.Time
+}
+
+func NewInMemoryStore() Store {
+	return &inMemoryStore{
+		expires: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+func (s *inMemoryStore) Remember(_ context.Context, id string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastEvicted) >= evictionInterval {
+		s.evictExpired(now)
+		s.lastEvicted = now
+	}
+	if expiresAt, ok := s.expires[id]; ok && now.Before(expiresAt) {
+		return false, nil
+	}
+	s.expires[id] = now.Add(ttl)
+	return true, nil
+}
+
+func (s *inMemoryStore) Forget(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.expires, id)
+	return nil
+}
+
+func (s *inMemoryStore) evictExpired(now time.Time) {
+	for id, expiresAt := range s.expires {
+		if !now.Before(expiresAt) {
+			delete(s.expires, id)
+		}
+	}
+}
+
+func (s *inMemoryStore) Ping(context.Context) error {
+	return nil
+}
+
+func (s *inMemoryStore) Close() error {
+	return nil
+}
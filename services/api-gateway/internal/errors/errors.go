@@ -1,5 +1,7 @@
 package errors
 
+import "net/http"
+
 type HttpError struct {
 	IsUserError bool
 	Description string
@@ -9,3 +11,16 @@ type HttpError struct {
 func (e *HttpError) Error() string {
 	return e.Description
 }
+
+var (
+	ErrMissingDeliveryID = &HttpError{
+		IsUserError: true,
+		Description: "missing X-GitHub-Delivery header",
+		StatusCode:  http.StatusBadRequest,
+	}
+	ErrDuplicateDelivery = &HttpError{
+		IsUserError: true,
+		Description: "webhook delivery was already received",
+		StatusCode:  http.StatusConflict,
+	}
+)
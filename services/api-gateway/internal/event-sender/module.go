@@ -3,26 +3,54 @@ package event_sender
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"go_code_reviewer/pkg/kafka"
 	"go_code_reviewer/pkg/log"
 	"go_code_reviewer/pkg/retry"
 	"go_code_reviewer/services/api-gateway/pkg/models"
+	"sort"
 	"time"
 )
 
 type Module struct {
 	eventTopic string
 	producer   kafka.Producer
+	// maxElapsed is the time after which no further attempt is started, zero retries without a budget.
+	maxElapsed time.Duration
 }
 
-func New(producer kafka.Producer, eventTopic string) *Module {
-	return &Module{
+type Option func(module *Module)
+
+// WithBudget makes a send return within budget when a single attempt takes up to attemptTimeout, e.g. the
+// SendTimeout of the producer. Attempts that would not finish within the budget are not started.
+func WithBudget(budget, attemptTimeout time.Duration) Option {
+	return func(module *Module) {
+		module.maxElapsed = max(budget-attemptTimeout, time.Nanosecond)
+	}
+}
+
+func New(producer kafka.Producer, eventTopic string, opts ...Option) *Module {
+	module := &Module{
 		producer:   producer,
 		eventTopic: eventTopic,
 	}
+	for _, opt := range opts {
+		opt(module)
+	}
+	return module
+}
+
+// ProcessEvent publishes event keyed by its pull request, so pushes to the same pull request stay ordered on one partition.
+func (m *Module) ProcessEvent(ctx context.Context, event *models.PullRequestEvent, metadata models.EventMetadata) error {
+	return m.send(ctx, models.GetPullRequestKey(event), event, metadata)
+}
+
+// ProcessPushEvent publishes event keyed by its branch, so the base index of the branch is updated in order.
+func (m *Module) ProcessPushEvent(ctx context.Context, event *models.PushEvent, metadata models.EventMetadata) error {
+	return m.send(ctx, models.GetBaseProjectIdentifier(event.Owner, event.Repo, event.Branch), event, metadata)
 }
 
-func (m *Module) ProcessEvent(ctx context.Context, event *models.PullRequestEvent) error {
+func (m *Module) send(ctx context.Context, key string, event any, metadata models.EventMetadata) error {
 	logger := log.GetLogger()
 
 	eventBytes, err := json.Marshal(event)
@@ -31,12 +59,26 @@ func (m *Module) ProcessEvent(ctx context.Context, event *models.PullRequestEven
 		return err
 	}
 
+	opts := []kafka.SendOption{kafka.WithKey(key)}
+	headers := metadata.Headers()
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		opts = append(opts, kafka.WithHeader(key, headers[key]))
+	}
+
+	// a send that timed out may still be delivered, sending it again would duplicate the event
 	retrier := retry.New[bool](retry.Options{
-		MaxRetries: 3,
-		Strategy:   retry.ExponentialBackoff(time.Second),
+		MaxRetries:  3,
+		Strategy:    retry.ExponentialBackoff(time.Second),
+		ShouldRetry: func(err error) bool { return !errors.Is(err, kafka.ErrDeliveryTimeout) },
+		MaxElapsed:  m.maxElapsed,
 	})
 	_, err = retrier.Do(ctx, func() (bool, error) {
-		err = m.producer.Send(m.eventTopic, eventBytes)
+		err = m.producer.Send(m.eventTopic, eventBytes, opts...)
 		if err != nil {
 			return false, err
 		}
@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"sync"
+)
+
+var (
+	once     sync.Once
+	instance *Metrics
+)
+
+// Get returns the metrics of the gateway, registered with the default registry on first use.
+func Get() *Metrics {
+	once.Do(func() {
+		instance = newMetrics()
+		prometheus.MustRegister(instance.kafkaProduceCounter)
+	})
+	return instance
+}
+
+type Metrics struct {
+	kafkaProduceCounter *prometheus.CounterVec
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		kafkaProduceCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kafka_produce_total",
+				Help: "Total number of kafka delivery reports",
+			},
+			[]string{"status"},
+		),
+	}
+}
+
+func (m *Metrics) ObserveKafkaProduce(status string) {
+	m.kafkaProduceCounter.WithLabelValues(status).Inc()
+}
+
+// Handler serves the registered metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
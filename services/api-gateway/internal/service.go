@@ -3,61 +3,130 @@ package internal
 import (
 	"context"
 	"errors"
+	"fmt"
+	"go_code_reviewer/pkg/app"
 	"go_code_reviewer/pkg/kafka"
 	"go_code_reviewer/pkg/log"
+	"go_code_reviewer/pkg/redis"
 	"go_code_reviewer/services/api-gateway/api"
 	"go_code_reviewer/services/api-gateway/internal/config"
+	"go_code_reviewer/services/api-gateway/internal/deliveries"
 	eventprocessor "go_code_reviewer/services/api-gateway/internal/event-sender"
+	"go_code_reviewer/services/api-gateway/internal/metrics"
+	"net"
 	"net/http"
 	"time"
 )
 
+const httpShutdownTimeout = time.Minute
+
+const defaultDeliveryTTL = 72 * time.Hour
+
 type Service struct {
+	config        *config.Config
 	httpServer    *http.Server
 	kafkaProducer kafka.Producer
+	deliveries    deliveries.Store
 }
 
-func (s *Service) Start() {
-	logger := log.GetLogger()
+func (s *Service) Register(lifecycle *app.Lifecycle) error {
 	serviceConfig, err := config.LoadConfig("./config.yaml")
 	if err != nil {
-		logger.WithError(err).Fatal("failed to load config.yaml")
+		return fmt.Errorf("failed to load config.yaml: %w", err)
 	}
+	s.config = serviceConfig
 
-	err = s.connectToServices(serviceConfig)
+	return lifecycle.Register(
+		app.Component{Name: "kafka-producer", Start: s.connectKafkaProducer, Stop: s.closeKafkaProducer, Health: s.kafkaProducerHealth},
+		app.Component{Name: "deliveries", Start: s.connectDeliveries, Stop: s.closeDeliveries, Health: s.deliveriesHealth},
+		app.Component{
+			Name:      "http-server",
+			DependsOn: []string{"kafka-producer", "deliveries"},
+			Start: func(ctx context.Context) error {
+				return s.startHttpServer(lifecycle)
+			},
+			Stop: s.stopHttpServer,
+		},
+	)
+}
+
+func (s *Service) connectKafkaProducer(context.Context) error {
+	kafkaProducer, err := kafka.NewProducer(s.config.Kafka.ProducerConfig(), kafka.WithProducerMetricsHandler(metrics.Get().ObserveKafkaProduce))
 	if err != nil {
-		logger.WithError(err).Fatal("failed to connect to services")
+		return err
 	}
+	s.kafkaProducer = kafkaProducer
+	return nil
+}
 
-	eventProcessorModule := eventprocessor.New(s.kafkaProducer, serviceConfig.Kafka.Topic)
-	handler := api.NewHandler(serviceConfig, eventProcessorModule)
-	r := handler.RegisterRoutes()
-	s.httpServer = &http.Server{
-		Addr:    serviceConfig.HttpServer.Address,
-		Handler: r,
+func (s *Service) closeKafkaProducer(context.Context) error {
+	s.kafkaProducer.Close()
+	return nil
+}
+
+func (s *Service) kafkaProducerHealth(ctx context.Context) error {
+	return s.kafkaProducer.Health(ctx)
+}
+
+func (s *Service) connectDeliveries(ctx context.Context) error {
+	if s.config.Deliveries.TTL <= 0 {
+		s.config.Deliveries.TTL = defaultDeliveryTTL
 	}
 
-	logger.Info("server running on " + serviceConfig.HttpServer.Address)
-	if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		logger.WithError(err).Fatal("failed to start http server")
+	redisConfig := s.config.Deliveries.Redis
+	if redisConfig.Address == "" {
+		log.GetLogger().Warn("no redis configured for webhook deliveries, deduplicating in memory")
+		s.deliveries = deliveries.NewInMemoryStore()
+		return nil
 	}
+
+	store, err := deliveries.NewRedisStore(ctx, redis.Config{
+		Address:  redisConfig.Address,
+		Password: redisConfig.Password,
+		DB:       redisConfig.DB,
+	})
+	if err != nil {
+		return err
+	}
+	s.deliveries = store
+	return nil
 }
 
-func (s *Service) Close() {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
-	defer cancel()
-	s.httpServer.Shutdown(ctx)
-	s.kafkaProducer.Close()
+func (s *Service) closeDeliveries(context.Context) error {
+	return s.deliveries.Close()
 }
 
-func (s *Service) connectToServices(serviceConfig *config.Config) error {
-	// connect to kafka
-	kafkaProducer, err := kafka.NewProducer(kafka.ProducerConfig{
-		Brokers: serviceConfig.Kafka.Brokers,
-	})
+func (s *Service) deliveriesHealth(ctx context.Context) error {
+	return s.deliveries.Ping(ctx)
+}
+
+// startHttpServer binds the address before returning, so a port that is already in use fails the startup.
+func (s *Service) startHttpServer(lifecycle *app.Lifecycle) error {
+	eventProcessorModule := eventprocessor.New(s.kafkaProducer, s.config.Kafka.Topic,
+		eventprocessor.WithBudget(config.SendBudget, s.config.Kafka.ProducerConfig().SendTimeout()))
+	handler := api.NewHandler(s.config, eventProcessorModule, app.NewHealthCache(lifecycle, app.DefaultHealthCacheTTL), s.deliveries)
+	s.httpServer = &http.Server{
+		Addr:    s.config.HttpServer.Address,
+		Handler: handler.RegisterRoutes(),
+	}
+
+	listener, err := net.Listen("tcp", s.config.HttpServer.Address)
 	if err != nil {
 		return err
 	}
-	s.kafkaProducer = kafkaProducer
+
+	log.GetLogger().Info("server running on " + s.config.HttpServer.Address)
+	lifecycle.Go("http-server", func() error {
+		if err := s.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	})
 	return nil
 }
+
+func (s *Service) stopHttpServer(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, httpShutdownTimeout)
+	defer cancel()
+	return s.httpServer.Shutdown(ctx)
+}
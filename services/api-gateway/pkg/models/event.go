@@ -1,18 +1,61 @@
 package models
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	ActionSynchronize = "synchronize"
+	// ActionClosed is sent for merged pull requests as well.
+	ActionClosed = "closed"
+)
 
 type PullRequestEvent struct {
-	Owner    string
-	Repo     string
-	Number   int
-	CloneURL string
-	Branch   string
-	Title    string
-	Author   string
-	DiffURL  string
+	Owner        string
+	Repo         string
+	Number       int
+	Action       string
+	CloneURL     string
+	HeadCloneURL string
+	Branch       string
+	HeadSHA      string
+	BaseBranch   string
+	BaseSHA      string
+	Title        string
+	Author       string
+	DiffURL      string
+
+	// DefaultBranch is the default branch of the repository, the only branch with a shared base index.
+	DefaultBranch string
+}
+
+// PushEvent is a push to the default branch of a repository, it updates the base index of the repository.
+type PushEvent struct {
+	Owner     string
+	Repo      string
+	Branch    string
+	CloneURL  string
+	BeforeSHA string
+	HeadSHA   string
 }
 
 func GetProjectIdentifier(pr *PullRequestEvent) string {
 	return fmt.Sprintf("%s/%s/%s/%d", pr.Owner, pr.Repo, pr.Branch, pr.Number)
 }
+
+func GetPullRequestKey(pr *PullRequestEvent) string {
+	return fmt.Sprintf("%s/%s#%d", pr.Owner, pr.Repo, pr.Number)
+}
+
+// GetBaseProjectIdentifier names the index of a branch shared by the pull requests into it. Unlike the project
+// identifier of a pull request, the repository segment contains an @.
+func GetBaseProjectIdentifier(owner, repo, branch string) string {
+	return fmt.Sprintf("%s/%s@%s", owner, repo, branch)
+}
+
+func IsBaseProjectIdentifier(projectId string) bool {
+	_, rest, _ := strings.Cut(projectId, "/")
+	repo, _, _ := strings.Cut(rest, "/")
+	return strings.Contains(repo, "@")
+}
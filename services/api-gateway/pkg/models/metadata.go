@@ -0,0 +1,46 @@
+package models
+
+const (
+	// SchemaVersion is the version of the PullRequestEvent and PushEvent payloads. Bump it on incompatible changes.
+	SchemaVersion = "1"
+
+	EventTypePullRequest = "pull_request"
+	EventTypePush        = "push"
+
+	HeaderEventType     = "x-event-type"
+	HeaderSchemaVersion = "x-schema-version"
+	HeaderDeliveryID    = "x-github-delivery"
+	HeaderTraceParent   = "traceparent"
+)
+
+// EventMetadata travels in the kafka headers next to the event payload.
+type EventMetadata struct {
+	EventType     string
+	SchemaVersion string
+	DeliveryID    string
+	TraceParent   string
+}
+
+func (m EventMetadata) Headers() map[string]string {
+	headers := map[string]string{
+		HeaderEventType:     m.EventType,
+		HeaderSchemaVersion: m.SchemaVersion,
+		HeaderDeliveryID:    m.DeliveryID,
+		HeaderTraceParent:   m.TraceParent,
+	}
+	for key, value := range headers {
+		if value == "" {
+			delete(headers, key)
+		}
+	}
+	return headers
+}
+
+func MetadataFromHeaders(headers map[string]string) EventMetadata {
+	return EventMetadata{
+		EventType:     headers[HeaderEventType],
+		SchemaVersion: headers[HeaderSchemaVersion],
+		DeliveryID:    headers[HeaderDeliveryID],
+		TraceParent:   headers[HeaderTraceParent],
+	}
+}
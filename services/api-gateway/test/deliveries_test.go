@@ -0,0 +1,74 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go_code_reviewer/pkg/redis"
+	"go_code_reviewer/pkg/redis/redistest"
+	"go_code_reviewer/services/api-gateway/internal/deliveries"
+)
+
+func TestInMemoryStore_RejectsDuplicatesUntilExpired(t *testing.T) {
+	store := deliveries.NewInMemoryStore()
+	ctx := context.Background()
+
+	firstSeen, err := store.Remember(ctx, "delivery-1", 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, firstSeen)
+
+	firstSeen, err = store.Remember(ctx, "delivery-1", 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.False(t, firstSeen)
+
+	time.Sleep(60 * time.Millisecond)
+	firstSeen, err = store.Remember(ctx, "delivery-1", 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, firstSeen, "an expired delivery id should be accepted again")
+}
+
+func TestInMemoryStore_Forget(t *testing.T) {
+	store := deliveries.NewInMemoryStore()
+	ctx := context.Background()
+
+	_, err := store.Remember(ctx, "delivery-1", time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, store.Forget(ctx, "delivery-1"))
+
+	firstSeen, err := store.Remember(ctx, "delivery-1", time.Hour)
+	require.NoError(t, err)
+	assert.True(t, firstSeen)
+}
+
+func TestRedisStore_RejectsDuplicates(t *testing.T) {
+	server := redistest.NewServer(t, "secret")
+	ctx := context.Background()
+
+	store, err := deliveries.NewRedisStore(ctx, redis.Config{Address: server.Address(), Password: "secret"})
+	require.NoError(t, err)
+	defer store.Close()
+
+	firstSeen, err := store.Remember(ctx, "delivery-1", time.Hour)
+	require.NoError(t, err)
+	assert.True(t, firstSeen)
+
+	firstSeen, err = store.Remember(ctx, "delivery-1", time.Hour)
+	require.NoError(t, err)
+	assert.False(t, firstSeen)
+	assert.Equal(t, time.Hour, server.TTL("api-gateway:delivery:delivery-1"))
+
+	require.NoError(t, store.Forget(ctx, "delivery-1"))
+	firstSeen, err = store.Remember(ctx, "delivery-1", time.Hour)
+	require.NoError(t, err)
+	assert.True(t, firstSeen)
+}
+
+func TestRedisStore_RejectsWrongPassword(t *testing.T) {
+	server := redistest.NewServer(t, "secret")
+
+	_, err := deliveries.NewRedisStore(context.Background(), redis.Config{Address: server.Address(), Password: "wrong"})
+	assert.ErrorContains(t, err, "WRONGPASS")
+}
@@ -0,0 +1,49 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"go_code_reviewer/pkg/kafka"
+	"go_code_reviewer/pkg/kafka/mocks"
+	eventsender "go_code_reviewer/services/api-gateway/internal/event-sender"
+	"go_code_reviewer/services/api-gateway/pkg/models"
+)
+
+func pullRequestEvent() *models.PullRequestEvent {
+	return &models.PullRequestEvent{Owner: "owner", Repo: "repo", Number: 1, Action: "opened"}
+}
+
+func TestProcessEvent_RetriesFailedSends(t *testing.T) {
+	producer := mocks.NewMockProducer(gomock.NewController(t))
+	gomock.InOrder(
+		producer.EXPECT().Send("pr-events", gomock.Any(), gomock.Any()).Return(errors.New("local queue full")),
+		producer.EXPECT().Send("pr-events", gomock.Any(), gomock.Any()).Return(nil),
+	)
+
+	module := eventsender.New(producer, "pr-events")
+	assert.NoError(t, module.ProcessEvent(context.Background(), pullRequestEvent(), models.EventMetadata{}))
+}
+
+func TestProcessEvent_DoesNotResendTimedOutSends(t *testing.T) {
+	producer := mocks.NewMockProducer(gomock.NewController(t))
+	producer.EXPECT().Send("pr-events", gomock.Any(), gomock.Any()).Return(kafka.ErrDeliveryTimeout).Times(1)
+
+	module := eventsender.New(producer, "pr-events")
+	err := module.ProcessEvent(context.Background(), pullRequestEvent(), models.EventMetadata{})
+	assert.ErrorIs(t, err, kafka.ErrDeliveryTimeout)
+}
+
+func TestProcessEvent_StaysWithinBudget(t *testing.T) {
+	producer := mocks.NewMockProducer(gomock.NewController(t))
+	producer.EXPECT().Send("pr-events", gomock.Any(), gomock.Any()).Return(errors.New("local queue full")).Times(1)
+
+	module := eventsender.New(producer, "pr-events", eventsender.WithBudget(1500*time.Millisecond, time.Second))
+	start := time.Now()
+	assert.Error(t, module.ProcessEvent(context.Background(), pullRequestEvent(), models.EventMetadata{}))
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "an attempt after the backoff would not finish within the budget")
+}
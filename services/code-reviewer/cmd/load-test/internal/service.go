@@ -27,14 +27,18 @@ func (m *LoadTestModule) Start() {
 	defer ticker.Stop()
 
 	prEvent := &models.PullRequestEvent{
-		Owner:    "MSaeed1381",
-		Repo:     "message-broker",
-		Number:   51,
-		CloneURL: "https://github.com/MSaeed1381/message-broker.git",
-		Branch:   "MSaeed1381-patch-52",
-		Title:    "Update main.go",
-		Author:   "MSaeed1381",
-		DiffURL:  "https://github.com/MSaeed1381/message-broker/pull/51.diff",
+		Owner:        "MSaeed1381",
+		Repo:         "message-broker",
+		Number:       51,
+		Action:       "opened",
+		CloneURL:     "https://github.com/MSaeed1381/message-broker.git",
+		HeadCloneURL: "https://github.com/MSaeed1381/message-broker.git",
+		Branch:       "MSaeed1381-patch-52",
+		HeadSHA:      "6dcb09b5b57875f334f61aebed695e2e4193db5e",
+		BaseSHA:      "9049f1265b7d61be4a8904a9a27120d2064dab3b",
+		Title:        "Update main.go",
+		Author:       "MSaeed1381",
+		DiffURL:      "https://github.com/MSaeed1381/message-broker/pull/51.diff",
 	}
 	marshal, err := json.Marshal(prEvent)
 	if err != nil {
@@ -46,7 +50,11 @@ func (m *LoadTestModule) Start() {
 		select {
 		case <-ticker.C:
 			go func() {
-				m.producer.Send(m.config.KafkaTopic, marshal)
+				m.producer.Send(m.config.KafkaTopic, marshal,
+					kafka.WithKey(models.GetPullRequestKey(prEvent)),
+					kafka.WithHeader(models.HeaderEventType, models.EventTypePullRequest),
+					kafka.WithHeader(models.HeaderSchemaVersion, models.SchemaVersion),
+				)
 				i = i + 1
 			}()
 		}
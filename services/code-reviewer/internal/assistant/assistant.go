@@ -11,7 +11,10 @@ import (
 	"go_code_reviewer/pkg/retry"
 	"go_code_reviewer/services/code-reviewer/internal/config"
 	"go_code_reviewer/services/code-reviewer/internal/embedder"
+	"go_code_reviewer/services/code-reviewer/internal/models"
 	"go_code_reviewer/services/code-reviewer/internal/repositories"
+	"go_code_reviewer/services/code-reviewer/internal/retrieval"
+	"go_code_reviewer/services/code-reviewer/internal/review"
 	"strings"
 	"time"
 
@@ -23,20 +26,65 @@ type Assistant struct {
 	embeddingRepo   repositories.EmbeddingsRepository
 	llm             llms.Model
 	embeddingClient embedder.EmbeddingClient
+	breaker         *retry.Breaker
+	onRetry         func(event retry.RetryEvent)
+	retriever       *retrieval.Retriever
+	symbolGraph     *retrieval.SymbolGraph
+	maxNeighbors    int
 }
 
-func NewAssistant(config *config.Config, embeddingRepo repositories.EmbeddingsRepository, llm llms.Model, embeddingClient embedder.EmbeddingClient) *Assistant {
-	return &Assistant{
+type Option func(assistant *Assistant)
+
+// WithBreaker stops retrying the LLM while the breaker is open.
+func WithBreaker(breaker *retry.Breaker) Option {
+	return func(assistant *Assistant) {
+		assistant.breaker = breaker
+	}
+}
+
+// WithRetryObserver is called before every retry of the LLM, e.g. to count it.
+func WithRetryObserver(onRetry func(event retry.RetryEvent)) Option {
+	return func(assistant *Assistant) {
+		assistant.onRetry = onRetry
+	}
+}
+
+// WithRetriever fuses the nearest snippets with keyword and symbol matches to choose the context.
+func WithRetriever(retriever *retrieval.Retriever) Option {
+	return func(assistant *Assistant) {
+		assistant.retriever = retriever
+	}
+}
+
+// WithSymbolGraph puts up to maxNeighbors callers and callees of the definitions a diff changes into the context,
+// ahead of the snippets found by search.
+func WithSymbolGraph(graph *retrieval.SymbolGraph, maxNeighbors int) Option {
+	return func(assistant *Assistant) {
+		assistant.symbolGraph = graph
+		assistant.maxNeighbors = maxNeighbors
+	}
+}
+
+func NewAssistant(config *config.Config, embeddingRepo repositories.EmbeddingsRepository, llm llms.Model, embeddingClient embedder.EmbeddingClient, opts ...Option) *Assistant {
+	assistant := &Assistant{
 		config:          config,
 		embeddingRepo:   embeddingRepo,
-		llm:             llm,
+		llm:             meteredModel{Model: llm},
 		embeddingClient: embeddingClient,
 	}
+	for _, opt := range opts {
+		opt(assistant)
+	}
+	return assistant
 }
 
 func (a *Assistant) PerformTask(ctx context.Context, task Task, queryText, projectId string) (string, error) {
+	return a.performTask(ctx, task, queryText, repositories.Overlay{ProjectId: projectId})
+}
+
+func (a *Assistant) performTask(ctx context.Context, task Task, queryText string, overlay repositories.Overlay) (string, error) {
 	logger := log.GetLogger()
-	contextString, err := a.getContextFromChroma(ctx, projectId, queryText)
+	contextString, err := a.getContextFromChroma(ctx, overlay, queryText)
 	if err != nil {
 		logger.WithError(err).Error("failed to get context from chroma")
 		return "", err
@@ -51,7 +99,28 @@ func (a *Assistant) PerformTask(ctx context.Context, task Task, queryText, proje
 	return response, nil
 }
 
-func (a *Assistant) getContextFromChroma(ctx context.Context, projectId, queryText string) (string, error) {
+func (a *Assistant) ReviewDiff(ctx context.Context, diff, projectId string) (*review.Review, error) {
+	return a.ReviewDiffWithOverlay(ctx, diff, repositories.Overlay{ProjectId: projectId})
+}
+
+// ReviewDiffWithOverlay reviews diff with context from the files the pull request changed and the base index.
+func (a *Assistant) ReviewDiffWithOverlay(ctx context.Context, diff string, overlay repositories.Overlay) (*review.Review, error) {
+	logger := log.GetLogger()
+	response, err := a.performTask(ctx, TaskCodeReview, diff, overlay)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := review.Parse(response)
+	if err != nil {
+		logger.WithError(err).Warn("llm did not return a structured review, using raw response as summary")
+		return &review.Review{Summary: response}, nil
+	}
+
+	return result, nil
+}
+
+func (a *Assistant) getContextFromChroma(ctx context.Context, overlay repositories.Overlay, queryText string) (string, error) {
 	logger := log.GetLogger()
 
 	resp, err := a.embeddingClient.CreateEmbeddings(ctx, string(openai.SmallEmbedding3), []string{queryText})
@@ -60,15 +129,38 @@ func (a *Assistant) getContextFromChroma(ctx context.Context, projectId, queryTe
 		return "", err
 	}
 
-	records, err := a.embeddingRepo.GetNearestRecord(ctx, resp[0].Embedding, 5, projectId)
+	var neighbors []retrieval.Neighbor
+	if a.symbolGraph != nil {
+		neighbors = a.symbolGraph.Neighbors(overlay, review.ChangedLines(queryText), a.maxNeighbors)
+	}
+
+	// ask for more, the snippets already among the neighbors are dropped
+	nResult := 5 + len(neighbors)
+	searchResult := nResult
+	if a.retriever != nil {
+		searchResult = a.retriever.Candidates(nResult)
+	}
+	records, err := overlay.GetNearestRecord(ctx, a.embeddingRepo, resp[0].Embedding, searchResult)
 	if err != nil {
 		logger.WithError(err).Error("failed to get nearest records")
 		return "", err
 	}
+	if a.retriever != nil {
+		records = a.retriever.Retrieve(ctx, overlay, queryText, records, nResult)
+	}
+	records = withoutNeighbors(records, neighbors)
+	if len(records) > 5 {
+		records = records[:5]
+	}
 
 	var contextBuilder strings.Builder
+	for i, neighbor := range neighbors {
+		contextBuilder.WriteString(fmt.Sprintf("--- Context Snippet %d from file %s, %s ---\n", i, neighbor.Snippet.Filename, neighbor.Relation))
+		contextBuilder.WriteString(neighbor.Snippet.Content)
+		contextBuilder.WriteString("\n\n")
+	}
 	for i, record := range records {
-		contextBuilder.WriteString(fmt.Sprintf("--- Context Snippet %d from file %s ---\n", i, record.Filename))
+		contextBuilder.WriteString(fmt.Sprintf("--- Context Snippet %d from file %s ---\n", len(neighbors)+i, record.Filename))
 		contextBuilder.WriteString(record.Content)
 		contextBuilder.WriteString("\n\n")
 	}
@@ -76,6 +168,24 @@ func (a *Assistant) getContextFromChroma(ctx context.Context, projectId, queryTe
 	return contextBuilder.String(), nil
 }
 
+// withoutNeighbors drops the records that are also neighbors.
+func withoutNeighbors(records []*models.Snippet, neighbors []retrieval.Neighbor) []*models.Snippet {
+	if len(neighbors) == 0 {
+		return records
+	}
+	included := make(map[string]bool, len(neighbors))
+	for _, neighbor := range neighbors {
+		included[neighbor.Snippet.Filename+"\x00"+neighbor.Snippet.Content] = true
+	}
+	result := make([]*models.Snippet, 0, len(records))
+	for _, record := range records {
+		if !included[record.Filename+"\x00"+record.Content] {
+			result = append(result, record)
+		}
+	}
+	return result
+}
+
 func (a *Assistant) callLLMToPerformTask(ctx context.Context, task Task, queryText, contextString string) (string, error) {
 	logger := log.GetLogger()
 	logger.WithFields(logrus.Fields{
@@ -110,8 +220,14 @@ func (a *Assistant) callLLMToPerformTask(ctx context.Context, task Task, queryTe
 	retrier := retry.New[string](retry.Options{
 		MaxRetries: 5,
 		Strategy:   retry.ExponentialJitterBackoff(500*time.Millisecond, 10*time.Second),
+		Classify:   retry.ClassifyOpenAI,
+		MaxDelay:   time.Minute,
+		Breaker:    a.breaker,
+		Operation:  "llm",
+		OnRetry:    a.onRetry,
 	})
-	result, err := retrier.Do(ctx, func() (string, error) {
+	result, err := retrier.DoContext(ctx, func(attemptCtx context.Context) (string, error) {
+		logger.WithField("attempt", retry.AttemptFromContext(attemptCtx)).Debug("calling llm")
 		return chains.Predict(ctx, chain, map[string]any{
 			"text":     queryText,
 			"context":  contextString,
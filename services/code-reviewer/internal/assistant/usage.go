@@ -0,0 +1,79 @@
+package assistant
+
+import (
+	"context"
+	"github.com/tmc/langchaingo/llms"
+	"go_code_reviewer/pkg/retry"
+	"sync/atomic"
+	"time"
+)
+
+type tokenUsageKey struct{}
+
+// WithTokenUsage returns a context in which the assistant counts the LLM tokens it uses, see TokensUsed.
+func WithTokenUsage(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tokenUsageKey{}, new(atomic.Int64))
+}
+
+// TokensUsed returns the LLM tokens used so far with a context of WithTokenUsage.
+func TokensUsed(ctx context.Context) int {
+	used, ok := ctx.Value(tokenUsageKey{}).(*atomic.Int64)
+	if !ok {
+		return 0
+	}
+	return int(used.Load())
+}
+
+// meteredModel adds the token usage the provider reports in the generation info to the context.
+type meteredModel struct {
+	llms.Model
+}
+
+func (m meteredModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	resp, err := m.Model.GenerateContent(ctx, messages, options...)
+	used, ok := ctx.Value(tokenUsageKey{}).(*atomic.Int64)
+	if !ok || resp == nil {
+		return resp, err
+	}
+	for _, choice := range resp.Choices {
+		if tokens, ok := choice.GenerationInfo["TotalTokens"].(int); ok {
+			used.Add(int64(tokens))
+		}
+	}
+	return resp, err
+}
+
+// guardedModel meters and retries the calls of a model the way the assistant calls its own.
+type guardedModel struct {
+	llms.Model
+	retrier retry.Retrier[*llms.ContentResponse]
+}
+
+// NewGuardedModel returns llm as the assistant calls it: its tokens count toward the usage of WithTokenUsage and
+// failed calls are retried until breaker opens. Give it to the LLM calls made outside the assistant, e.g. by the
+// reranker, so they share its quota and breaker.
+func NewGuardedModel(llm llms.Model, breaker *retry.Breaker, onRetry func(event retry.RetryEvent)) llms.Model {
+	return &guardedModel{
+		Model: meteredModel{Model: llm},
+		retrier: retry.New[*llms.ContentResponse](retry.Options{
+			MaxRetries: 2,
+			Strategy:   retry.ExponentialJitterBackoff(500*time.Millisecond, 10*time.Second),
+			Classify:   retry.ClassifyOpenAI,
+			MaxDelay:   time.Minute,
+			Breaker:    breaker,
+			Operation:  "llm",
+			OnRetry:    onRetry,
+		}),
+	}
+}
+
+func (m *guardedModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	return m.retrier.Do(ctx, func() (*llms.ContentResponse, error) {
+		return m.Model.GenerateContent(ctx, messages, options...)
+	})
+}
+
+// Call goes through GenerateContent, the wrapped model would call its own.
+func (m *guardedModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
@@ -1,20 +1,47 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"gopkg.in/yaml.v3"
 	"os"
+	"time"
 )
 
 type Config struct {
-	Env         string           `yaml:"env" json:"env"`
-	WorkerCount int32            `yaml:"worker_count" json:"worker_count"`
-	Prometheus  PrometheusConfig `yaml:"prometheus" json:"prometheus"`
-	LLM         LLMSection       `yaml:"llm" json:"llm"`
-	Embedding   EmbeddingSection `yaml:"embedding" json:"embedding"`
-	Tasks       TasksSection     `yaml:"tasks" json:"tasks"`
-	ChromaDB    ChromaDBSection  `yaml:"chroma_db" json:"chroma_db"`
-	Github      GithubSection    `yaml:"github" json:"github"`
-	Kafka       KafkaSection     `yaml:"kafka" json:"kafka"`
+	Env         string `yaml:"env" json:"env"`
+	WorkerCount int32  `yaml:"worker_count" json:"worker_count"`
+	// ShutdownTimeout bounds how long reviews in progress may run after a shutdown signal before they are cancelled.
+	ShutdownTimeout time.Duration      `yaml:"shutdown_timeout" json:"shutdown_timeout"`
+	Prometheus      PrometheusConfig   `yaml:"prometheus" json:"prometheus"`
+	LLM             LLMSection         `yaml:"llm" json:"llm"`
+	Embedding       EmbeddingSection   `yaml:"embedding" json:"embedding"`
+	Tasks           TasksSection       `yaml:"tasks" json:"tasks"`
+	ChromaDB        ChromaDBSection    `yaml:"chroma_db" json:"chroma_db"`
+	VectorStore     VectorStoreSection `yaml:"vector_store" json:"vector_store"`
+	Janitor         JanitorSection     `yaml:"janitor" json:"janitor"`
+	Retrieval       RetrievalSection   `yaml:"retrieval" json:"retrieval"`
+	Github          GithubSection      `yaml:"github" json:"github"`
+	Kafka           KafkaSection       `yaml:"kafka" json:"kafka"`
+	Review          ReviewSection      `yaml:"review" json:"review"`
+	Limits          LimitsSection      `yaml:"limits" json:"limits"`
+	CircuitBreaker  BreakerSection     `yaml:"circuit_breaker" json:"circuit_breaker"`
+	RateLimits      []RateLimitSection `yaml:"rate_limits" json:"rate_limits"`
+	ReviewState     ReviewStateSection `yaml:"review_state" json:"review_state"`
+}
+
+// ReviewStateSection configures the store of the last reviewed head commit of each pull request. Without a
+// redis address it is kept in memory, so it is lost on restart and not shared between replicas.
+type ReviewStateSection struct {
+	// TTL is how long a pull request is remembered after its last review, in case it is never seen closed.
+	TTL   time.Duration `yaml:"ttl" json:"ttl"`
+	Redis RedisSection  `yaml:"redis" json:"redis"`
+}
+
+type RedisSection struct {
+	Address  string `yaml:"address" json:"address"`
+	Password string `yaml:"password" json:"password"`
+	DB       int    `yaml:"db" json:"db"`
 }
 
 type PrometheusConfig struct {
@@ -22,14 +49,73 @@ type PrometheusConfig struct {
 }
 
 type KafkaSection struct {
-	Brokers    string `yaml:"brokers" json:"brokers"`
-	GroupID    string `yaml:"group_id" json:"group_id"`
-	Topics     string `yaml:"topics" json:"topics"`
-	AutoOffset string `yaml:"auto_offset" json:"auto_offset"`
+	Brokers         string              `yaml:"brokers" json:"brokers"`
+	GroupID         string              `yaml:"group_id" json:"group_id"`
+	Topics          string              `yaml:"topics" json:"topics"`
+	AutoOffset      string              `yaml:"auto_offset" json:"auto_offset"`
+	RetryTopics     []RetryTopicSection `yaml:"retry_topics" json:"retry_topics"`
+	DeadLetterTopic string              `yaml:"dead_letter_topic" json:"dead_letter_topic"`
+	DrainTimeout    time.Duration       `yaml:"drain_timeout" json:"drain_timeout"`
+	DeliveryTimeout time.Duration       `yaml:"delivery_timeout" json:"delivery_timeout"`
+	RouteTimeout    time.Duration       `yaml:"route_timeout" json:"route_timeout"`
+}
+
+type RetryTopicSection struct {
+	Topic string        `yaml:"topic" json:"topic"`
+	Delay time.Duration `yaml:"delay" json:"delay"`
 }
 
 type GithubSection struct {
-	AccessToken string `yaml:"access_token" json:"access_token"`
+	AccessToken     string                 `yaml:"access_token" json:"access_token"`
+	RepositoryCache RepositoryCacheSection `yaml:"repository_cache" json:"repository_cache"`
+}
+
+type RepositoryCacheSection struct {
+	Dir       string `yaml:"dir" json:"dir"`
+	MaxSizeMB int64  `yaml:"max_size_mb" json:"max_size_mb"`
+}
+
+type ReviewSection struct {
+	MinSeverity        string                             `yaml:"min_severity" json:"min_severity"`
+	MinConfidence      float64                            `yaml:"min_confidence" json:"min_confidence"`
+	MaxCommentsPerFile int                                `yaml:"max_comments_per_file" json:"max_comments_per_file"`
+	MaxCommentsPerPR   int                                `yaml:"max_comments_per_pr" json:"max_comments_per_pr"`
+	DisabledCategories []string                           `yaml:"disabled_categories" json:"disabled_categories"`
+	Repositories       map[string]RepositoryReviewSection `yaml:"repositories" json:"repositories"`
+}
+
+type RepositoryReviewSection struct {
+	DisabledCategories []string `yaml:"disabled_categories" json:"disabled_categories"`
+}
+
+// LimitsSection bounds the reviews of every repository and of every organization. Zero values are unlimited.
+type LimitsSection struct {
+	Repository   LimitSection `yaml:"repository" json:"repository"`
+	Organization LimitSection `yaml:"organization" json:"organization"`
+}
+
+type LimitSection struct {
+	MaxConcurrent int `yaml:"max_concurrent" json:"max_concurrent"`
+	DailyReviews  int `yaml:"daily_reviews" json:"daily_reviews"`
+	DailyTokens   int `yaml:"daily_tokens" json:"daily_tokens"`
+}
+
+// BreakerSection configures the circuit breakers of the LLM, embedding, Chroma and GitHub clients.
+type BreakerSection struct {
+	Window           time.Duration `yaml:"window" json:"window"`
+	MinRequests      int           `yaml:"min_requests" json:"min_requests"`
+	FailureRate      float64       `yaml:"failure_rate" json:"failure_rate"`
+	CoolDown         time.Duration `yaml:"cool_down" json:"cool_down"`
+	HalfOpenRequests int           `yaml:"half_open_requests" json:"half_open_requests"`
+}
+
+// RateLimitSection caps the requests and tokens per minute sent to a model of a provider, shared by all
+// workers. Zero values are unlimited.
+type RateLimitSection struct {
+	Provider          string `yaml:"provider" json:"provider"`
+	Model             string `yaml:"model" json:"model"`
+	RequestsPerMinute int    `yaml:"requests_per_minute" json:"requests_per_minute"`
+	TokensPerMinute   int    `yaml:"tokens_per_minute" json:"tokens_per_minute"`
 }
 
 type ChromaDBSection struct {
@@ -37,7 +123,89 @@ type ChromaDBSection struct {
 	CollectionName string `yaml:"collection_name" json:"collection_name"`
 }
 
+const (
+	VectorStoreChroma   = "chroma"
+	VectorStorePgvector = "pgvector"
+	VectorStoreQdrant   = "qdrant"
+	VectorStoreDisk     = "disk"
+	VectorStoreMemory   = "memory"
+)
+
+// VectorStoreSection selects where the embeddings are stored: chroma, the default, pgvector, qdrant, disk or
+// memory.
+// The dsn of pgvector and the api key of qdrant are read from PGVECTOR_DSN and QDRANT_API_KEY.
+type VectorStoreSection struct {
+	Backend  string          `yaml:"backend" json:"backend"`
+	Pgvector PgvectorSection `yaml:"pgvector" json:"pgvector"`
+	Qdrant   QdrantSection   `yaml:"qdrant" json:"qdrant"`
+	Disk     DiskSection     `yaml:"disk" json:"disk"`
+	Memory   MemorySection   `yaml:"memory" json:"memory"`
+}
+
+type PgvectorSection struct {
+	DSN   string `yaml:"dsn"`
+	Table string `yaml:"table" json:"table"`
+}
+
+type QdrantSection struct {
+	Address    string `yaml:"address" json:"address"`
+	APIKey     string `yaml:"api_key"`
+	Collection string `yaml:"collection" json:"collection"`
+}
+
+// DiskSection stores the embeddings in files of a local directory, for single-node deployments.
+type DiskSection struct {
+	Dir string `yaml:"dir" json:"dir"`
+}
+
+// MemorySection keeps the embeddings in process, ranked by metric, cosine or dot. Snapshot is an optional file
+// they are restored from on start and saved to on shutdown.
+type MemorySection struct {
+	Metric   string `yaml:"metric" json:"metric"`
+	Snapshot string `yaml:"snapshot" json:"snapshot"`
+}
+
+// JanitorSection removes the embeddings of projects not indexed within IdleTTL, checked every Interval. Zero
+// values keep them until their pull request is closed.
+type JanitorSection struct {
+	IdleTTL  time.Duration `yaml:"idle_ttl" json:"idle_ttl"`
+	Interval time.Duration `yaml:"interval" json:"interval"`
+}
+
+// RetrievalSection chooses the context of a review. Hybrid fuses the vector search with BM25 and the definitions
+// of the identifiers in the diff, Candidates is the number of snippets every ranking contributes and FusionK the
+// constant of reciprocal rank fusion.
+type RetrievalSection struct {
+	Hybrid     bool             `yaml:"hybrid" json:"hybrid"`
+	Candidates int              `yaml:"candidates" json:"candidates"`
+	FusionK    int              `yaml:"fusion_k" json:"fusion_k"`
+	Reranker   RerankerSection  `yaml:"reranker" json:"reranker"`
+	CallGraph  CallGraphSection `yaml:"call_graph" json:"call_graph"`
+}
+
+// CallGraphSection puts up to MaxSnippets callers and callees of the changed definitions into the context, ahead
+// of the search results.
+type CallGraphSection struct {
+	Enabled     bool `yaml:"enabled" json:"enabled"`
+	MaxSnippets int  `yaml:"max_snippets" json:"max_snippets"`
+}
+
+const (
+	RerankerLLM          = "llm"
+	RerankerCrossEncoder = "cross-encoder"
+)
+
+// RerankerSection optionally chooses the final context from the fused candidates: llm asks the review model,
+// cross-encoder calls a rerank API at URL. The api key is read from RERANKER_API_KEY.
+type RerankerSection struct {
+	Type   string `yaml:"type" json:"type"`
+	URL    string `yaml:"url" json:"url"`
+	Model  string `yaml:"model" json:"model"`
+	APIKey string `yaml:"api_key"`
+}
+
 type LLMSection struct {
+	Provider    string  `yaml:"provider"`
 	APIBaseURL  string  `yaml:"api_base_url"`
 	OpenApiKey  string  `yaml:"openapi_key"`
 	Model       string  `yaml:"model"`
@@ -46,6 +214,7 @@ type LLMSection struct {
 }
 
 type EmbeddingSection struct {
+	Provider   string `yaml:"provider"`
 	APIBaseURL string `yaml:"api_base_url"`
 	Model      string `yaml:"model"`
 }
@@ -86,6 +255,16 @@ func LoadConfig(path string) (*Config, error) {
 		Github: GithubSection{
 			AccessToken: os.Getenv("GITHUB_ACCESS_TOKEN"),
 		},
+		VectorStore: VectorStoreSection{
+			Pgvector: PgvectorSection{DSN: os.Getenv("PGVECTOR_DSN")},
+			Qdrant:   QdrantSection{APIKey: os.Getenv("QDRANT_API_KEY")},
+		},
+		Retrieval: RetrievalSection{
+			Reranker: RerankerSection{APIKey: os.Getenv("RERANKER_API_KEY")},
+		},
+		ReviewState: ReviewStateSection{
+			Redis: RedisSection{Password: os.Getenv("REVIEW_STATE_REDIS_PASSWORD")},
+		},
 	}
 
 	file, err := os.ReadFile(path)
@@ -98,5 +277,22 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
+	if err := config.Kafka.validate(); err != nil {
+		return nil, err
+	}
+
 	return config, nil
 }
+
+// validate rejects topics that would fail every message routed to them.
+func (k KafkaSection) validate() error {
+	if k.DeadLetterTopic == "" {
+		return errors.New("kafka.dead_letter_topic is required, messages that keep failing are routed to it")
+	}
+	for i, retryTopic := range k.RetryTopics {
+		if retryTopic.Topic == "" {
+			return fmt.Errorf("kafka.retry_topics[%d] has no topic", i)
+		}
+	}
+	return nil
+}
@@ -23,6 +23,9 @@ func NewProjectEmbedder(embeddingClient EmbeddingClient, embeddingsRepo reposito
 
 func (p *ProjectEmbedder) EmbedProject(ctx context.Context, projectId string, snippets []*models.Snippet) error {
 	logger := log.GetLogger()
+	if len(snippets) == 0 {
+		return nil
+	}
 	var texts []string
 	for _, snippet := range snippets {
 		texts = append(texts, snippet.Content)
@@ -46,3 +49,35 @@ func (p *ProjectEmbedder) EmbedProject(ctx context.Context, projectId string, sn
 
 	return nil
 }
+
+// ReplaceProject embeds snippets in place of every snippet the project had.
+func (p *ProjectEmbedder) ReplaceProject(ctx context.Context, projectId string, snippets []*models.Snippet) error {
+	if err := p.embeddingsRepo.DeleteProject(ctx, projectId); err != nil {
+		log.GetLogger().WithError(err).Error("failed to delete embeddings")
+		return err
+	}
+	return p.EmbedProject(ctx, projectId, snippets)
+}
+
+// ReplaceFiles embeds snippets in place of the snippets of filenames, the other files of the project are kept.
+func (p *ProjectEmbedder) ReplaceFiles(ctx context.Context, projectId string, filenames []string, snippets []*models.Snippet) error {
+	if err := p.embeddingsRepo.DeleteFiles(ctx, projectId, filenames); err != nil {
+		log.GetLogger().WithError(err).Error("failed to delete embeddings")
+		return err
+	}
+	return p.EmbedProject(ctx, projectId, snippets)
+}
+
+// NeedsRestore reports whether the repository keeps in-memory indexes that lack the project, as after a restart.
+func (p *ProjectEmbedder) NeedsRestore(projectId string) bool {
+	restorer, ok := p.embeddingsRepo.(repositories.IndexRestorer)
+	return ok && !restorer.Restored(projectId)
+}
+
+// Restore fills the in-memory indexes of the repository with the stored snippets of the project, without
+// embedding them again.
+func (p *ProjectEmbedder) Restore(projectId string, snippets []*models.Snippet) {
+	if restorer, ok := p.embeddingsRepo.(repositories.IndexRestorer); ok {
+		restorer.Restore(projectId, snippets)
+	}
+}
@@ -17,41 +17,23 @@ type EmbeddingClient interface {
 
 type OpenAiEmbeddingClient struct {
 	openaiClient *openai.Client
-	retrier      retry.Retrier[openai.EmbeddingResponse]
 }
 
-type OpenAiEmbeddingOption func(client *OpenAiEmbeddingClient)
-
-func WithRetrier(retrier retry.Retrier[openai.EmbeddingResponse]) OpenAiEmbeddingOption {
-	return func(client *OpenAiEmbeddingClient) {
-		client.retrier = retrier
-	}
-}
-
-func NewOpenAiEmbeddingClient(openaiClient *openai.Client, opts ...OpenAiEmbeddingOption) EmbeddingClient {
-	client := &OpenAiEmbeddingClient{
+// NewOpenAiEmbeddingClient makes a single call per CreateEmbeddings, see NewRetryingEmbeddingClient.
+func NewOpenAiEmbeddingClient(openaiClient *openai.Client) EmbeddingClient {
+	return &OpenAiEmbeddingClient{
 		openaiClient: openaiClient,
 	}
-
-	for _, opt := range opts {
-		opt(client)
-	}
-
-	return client
 }
 
 func (e *OpenAiEmbeddingClient) CreateEmbeddings(ctx context.Context, embeddingModel string, texts []string) ([]Embedding, error) {
-	logger := log.GetLogger()
 	req := openai.EmbeddingRequest{
 		Input: texts,
 		Model: openai.EmbeddingModel(embeddingModel),
 	}
 
-	resp, err := e.retrier.Do(ctx, func() (openai.EmbeddingResponse, error) {
-		return e.openaiClient.CreateEmbeddings(ctx, req)
-	})
+	resp, err := e.openaiClient.CreateEmbeddings(ctx, req)
 	if err != nil {
-		logger.WithError(err).Error("failed to call openai to create embedding")
 		return nil, err
 	}
 
@@ -64,3 +46,25 @@ func (e *OpenAiEmbeddingClient) CreateEmbeddings(ctx context.Context, embeddingM
 
 	return result, nil
 }
+
+type retryingEmbeddingClient struct {
+	EmbeddingClient
+	retrier retry.Retrier[[]Embedding]
+}
+
+// NewRetryingEmbeddingClient calls client again with retrier when it fails. Wrap the rate limited client, so
+// every attempt waits for the limiter.
+func NewRetryingEmbeddingClient(client EmbeddingClient, retrier retry.Retrier[[]Embedding]) EmbeddingClient {
+	return &retryingEmbeddingClient{EmbeddingClient: client, retrier: retrier}
+}
+
+func (c *retryingEmbeddingClient) CreateEmbeddings(ctx context.Context, embeddingModel string, texts []string) ([]Embedding, error) {
+	result, err := c.retrier.DoContext(ctx, func(ctx context.Context) ([]Embedding, error) {
+		return c.EmbeddingClient.CreateEmbeddings(ctx, embeddingModel, texts)
+	})
+	if err != nil {
+		log.GetLogger().WithError(err).Error("failed to call openai to create embedding")
+		return nil, err
+	}
+	return result, nil
+}
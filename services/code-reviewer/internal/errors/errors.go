@@ -1,6 +1,10 @@
 package errors
 
-import "net/http"
+import (
+	"errors"
+	"go_code_reviewer/pkg/retry"
+	"net/http"
+)
 
 type HttpError struct {
 	IsUserError bool
@@ -18,4 +22,42 @@ var (
 		Description: "no snippet found",
 		StatusCode:  http.StatusBadRequest,
 	}
+	ErrInvalidEvent = &HttpError{
+		IsUserError: true,
+		Description: "invalid pull request event",
+		StatusCode:  http.StatusBadRequest,
+	}
+	ErrReviewSuperseded = &HttpError{
+		IsUserError: true,
+		Description: "review superseded by a newer push",
+		StatusCode:  http.StatusConflict,
+	}
+	ErrConcurrencyLimit = &HttpError{
+		IsUserError: false,
+		Description: "concurrency limit reached",
+		StatusCode:  http.StatusTooManyRequests,
+	}
+	ErrQuotaExceeded = &HttpError{
+		IsUserError: true,
+		Description: "daily review quota exceeded",
+		StatusCode:  http.StatusTooManyRequests,
+	}
+	ErrFailureRouting = &HttpError{
+		IsUserError: false,
+		Description: "failed message could not be routed",
+		StatusCode:  http.StatusServiceUnavailable,
+	}
 )
+
+// IsRetryable reports whether processing the same event again may succeed; user errors and permanent
+// provider errors never will.
+func IsRetryable(err error) bool {
+	if retry.IsPermanent(err) {
+		return false
+	}
+	var httpErr *HttpError
+	if errors.As(err, &httpErr) {
+		return !httpErr.IsUserError
+	}
+	return true
+}
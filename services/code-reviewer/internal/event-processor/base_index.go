@@ -0,0 +1,210 @@
+package event_processor
+
+import (
+	"context"
+	confluentkafka "github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/sirupsen/logrus"
+	"go_code_reviewer/pkg/log"
+	"go_code_reviewer/services/api-gateway/pkg/models"
+	codemodels "go_code_reviewer/services/code-reviewer/internal/models"
+	"go_code_reviewer/services/code-reviewer/internal/repositories"
+	"go_code_reviewer/services/code-reviewer/internal/review"
+	"go_code_reviewer/services/code-reviewer/internal/vsc"
+	"sync"
+	"time"
+)
+
+// baseBranch is the commit of a branch its base index is built from.
+type baseBranch struct {
+	owner    string
+	repo     string
+	branch   string
+	cloneURL string
+	sha      string
+}
+
+func (b baseBranch) projectId() string {
+	return models.GetBaseProjectIdentifier(b.owner, b.repo, b.branch)
+}
+
+// baseLocks serializes the updates of each base index, so a pull request and a push never build it at once.
+type baseLocks struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+func newBaseLocks() *baseLocks {
+	return &baseLocks{locks: make(map[string]chan struct{})}
+}
+
+func (l *baseLocks) lock(ctx context.Context, projectId string) (func(), error) {
+	l.mu.Lock()
+	lock, ok := l.locks[projectId]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		l.locks[projectId] = lock
+	}
+	l.mu.Unlock()
+
+	select {
+	case lock <- struct{}{}:
+		return func() { <-lock }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// indexPush updates the base index of the pushed branch unless a newer push of it was already seen. A newer push
+// cancels the update, it indexes the files changed since the last indexed commit itself.
+func (m *Module) indexPush(ctx context.Context, kafkaMessage *confluentkafka.Message, event *models.PushEvent, logger *logrus.Entry) error {
+	base := baseBranch{owner: event.Owner, repo: event.Repo, branch: event.Branch, cloneURL: event.CloneURL, sha: event.HeadSHA}
+	ctx, done, newest := m.jobs.start(ctx, base.projectId(), positionOf(kafkaMessage))
+	defer done()
+	if !newest {
+		logger.WithField("head_sha", event.HeadSHA).Info("a newer push of the branch was already seen, skipping event")
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	err := m.indexBase(ctx, base, true)
+	if err != nil && superseded(ctx) {
+		logger.WithField("head_sha", event.HeadSHA).Info("a newer push of the branch arrived, indexing cancelled")
+		return nil
+	}
+	return err
+}
+
+// indexOverlay embeds the snippets of the files the pull request changed on top of the base index of the branch
+// it merges into, building that first if needed. fullDiff is the diff of the whole pull request, or empty to
+// download it. Only the default branch has a base index, pushes to other branches are not forwarded to keep
+// theirs up to date. Pull requests into other branches, and events predating the base index, embed the whole
+// project.
+func (m *Module) indexOverlay(ctx context.Context, event *models.PullRequestEvent, snippets []*codemodels.Snippet, fullDiff string) (repositories.Overlay, error) {
+	projectId := models.GetProjectIdentifier(event)
+	if event.BaseBranch == "" || event.BaseSHA == "" || event.BaseBranch != event.DefaultBranch {
+		return repositories.Overlay{ProjectId: projectId}, m.projectEmbedder.EmbedProject(ctx, projectId, snippets)
+	}
+
+	base := baseBranch{owner: event.Owner, repo: event.Repo, branch: event.BaseBranch, cloneURL: event.CloneURL, sha: event.BaseSHA}
+	if err := m.indexBase(ctx, base, false); err != nil {
+		return repositories.Overlay{}, err
+	}
+
+	if fullDiff == "" {
+		var err error
+		fullDiff, err = m.versionControl.DownloadUrl(ctx, event.DiffURL)
+		if err != nil {
+			return repositories.Overlay{}, err
+		}
+	}
+	overlay := repositories.Overlay{
+		ProjectId:     projectId,
+		BaseProjectId: base.projectId(),
+		ChangedFiles:  review.ChangedFiles(fullDiff),
+	}
+	// rebuilt on every push, files the pull request no longer changes leave the overlay
+	err := m.projectEmbedder.ReplaceProject(ctx, projectId, snippetsOf(snippets, overlay.ChangedFiles))
+	return overlay, err
+}
+
+// indexBase builds the base index of the branch at base.sha. Once built, update only indexes the files changed
+// since the indexed commit again; without update an existing index is kept as is. An index stored before a
+// restart is kept as well, only the in-memory indexes of the repository are rebuilt from a clone.
+func (m *Module) indexBase(ctx context.Context, base baseBranch, update bool) error {
+	projectId := base.projectId()
+	logger := log.GetLogger().WithFields(logrus.Fields{
+		"project_id": projectId,
+		"sha":        base.sha,
+	})
+
+	unlock, err := m.baseLocks.lock(ctx, projectId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	indexedSHA, indexed, err := m.indexState.GetIndexedSHA(ctx, projectId)
+	if err != nil {
+		logger.WithError(err).Error("failed to load indexed sha")
+		return err
+	}
+	restore := indexed && m.projectEmbedder.NeedsRestore(projectId)
+	if indexed && (!update || indexedSHA == base.sha) {
+		if !restore {
+			return nil
+		}
+		// the in-memory indexes are empty after a restart, they are rebuilt at the indexed commit without
+		// embedding it again
+		base.sha = indexedSHA
+	}
+
+	repoPath, cleanup, err := m.versionControl.Clone(ctx, vsc.CloneRequest{URL: base.cloneURL, Branch: base.branch, SHA: base.sha})
+	if err != nil {
+		logger.WithError(err).Error("failed to clone base branch")
+		return err
+	}
+	defer cleanup()
+
+	snippets, err := m.projectParser.ParseProject(ctx, repoPath)
+	if err != nil {
+		logger.WithError(err).Error("failed to parse base branch")
+		return err
+	}
+
+	for _, snippet := range snippets {
+		snippet.Commit = base.sha
+	}
+	if restore {
+		m.projectEmbedder.Restore(projectId, snippets)
+		if base.sha == indexedSHA {
+			logger.WithField("snippets", len(snippets)).Info("base index restored")
+			return nil
+		}
+	}
+
+	var changed []string
+	if indexed {
+		diff, diffErr := m.versionControl.DownloadCompareDiff(ctx, base.owner, base.repo, indexedSHA, base.sha)
+		if diffErr != nil {
+			logger.WithError(diffErr).Warn("failed to download changes since the indexed sha, indexing the whole branch")
+			indexed = false
+		} else {
+			changed = review.ChangedFiles(diff)
+		}
+	}
+
+	if indexed {
+		snippets = snippetsOf(snippets, changed)
+		err = m.projectEmbedder.ReplaceFiles(ctx, projectId, changed, snippets)
+	} else {
+		err = m.projectEmbedder.ReplaceProject(ctx, projectId, snippets)
+	}
+	if err != nil {
+		return err
+	}
+	logger.WithFields(logrus.Fields{
+		"incremental":   indexed,
+		"changed_files": len(changed),
+		"snippets":      len(snippets),
+	}).Info("base index updated")
+
+	return m.indexState.SetIndexedSHA(ctx, projectId, base.sha)
+}
+
+// snippetsOf returns the snippets of filenames.
+func snippetsOf(snippets []*codemodels.Snippet, filenames []string) []*codemodels.Snippet {
+	files := make(map[string]bool, len(filenames))
+	for _, filename := range filenames {
+		files[filename] = true
+	}
+
+	result := make([]*codemodels.Snippet, 0)
+	for _, snippet := range snippets {
+		if files[snippet.Filename] {
+			result = append(result, snippet)
+		}
+	}
+	return result
+}
@@ -3,16 +3,26 @@ package event_processor
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	confluentkafka "github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/sirupsen/logrus"
 	"go_code_reviewer/pkg/kafka"
 	"go_code_reviewer/pkg/log"
+	"go_code_reviewer/pkg/retry"
 	"go_code_reviewer/services/api-gateway/pkg/models"
 	"go_code_reviewer/services/code-reviewer/internal/assistant"
 	"go_code_reviewer/services/code-reviewer/internal/embedder"
 	"go_code_reviewer/services/code-reviewer/internal/errors"
+	"go_code_reviewer/services/code-reviewer/internal/janitor"
+	"go_code_reviewer/services/code-reviewer/internal/limits"
 	"go_code_reviewer/services/code-reviewer/internal/metrics"
 	"go_code_reviewer/services/code-reviewer/internal/parser"
+	"go_code_reviewer/services/code-reviewer/internal/repositories"
+	"go_code_reviewer/services/code-reviewer/internal/review"
 	"go_code_reviewer/services/code-reviewer/internal/vsc"
+	"math"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,61 +30,359 @@ type Module struct {
 	projectParser   *parser.ProjectParser
 	projectEmbedder *embedder.ProjectEmbedder
 	codeAssistant   *assistant.Assistant
+	reviewFilter    *review.Filter
+	reviewPublisher *review.Publisher
+	reviewState     repositories.ReviewStateRepository
+	indexState      repositories.IndexStateRepository
 	versionControl  vsc.VersionControlSystem
 	consumerClint   kafka.Consumer
+	failureRouter   *kafka.FailureRouter
+	limiter         *limits.Limiter
+	janitor         *janitor.Janitor
 	workerCount     int32
+	jobs            *jobRegistry
+	baseLocks       *baseLocks
+
+	workers  sync.WaitGroup
+	quit     chan struct{}
+	jobsCtx  context.Context
+	stopJobs context.CancelFunc
+
+	failOnce sync.Once
+	failed   chan struct{}
+	failure  error
+	// lastRouted is the time in unix nanoseconds a failed message was last routed.
+	lastRouted atomic.Int64
 }
 
-func NewModule(projectParser *parser.ProjectParser, projectEmbedder *embedder.ProjectEmbedder, codeAssistant *assistant.Assistant, versionControl vsc.VersionControlSystem, consumerClint kafka.Consumer, workerCount int32) *Module {
-	return &Module{
+type Option func(module *Module)
+
+// WithClock replaces the clock deciding when the newest event seen of a pull request or branch is forgotten.
+func WithClock(now func() time.Time) Option {
+	return func(module *Module) {
+		module.jobs.now = now
+	}
+}
+
+func NewModule(projectParser *parser.ProjectParser, projectEmbedder *embedder.ProjectEmbedder, codeAssistant *assistant.Assistant, reviewFilter *review.Filter, reviewState repositories.ReviewStateRepository, indexState repositories.IndexStateRepository, versionControl vsc.VersionControlSystem, consumerClint kafka.Consumer, failureRouter *kafka.FailureRouter, limiter *limits.Limiter, janitor *janitor.Janitor, workerCount int32, opts ...Option) *Module {
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	module := &Module{
 		projectParser:   projectParser,
 		projectEmbedder: projectEmbedder,
 		codeAssistant:   codeAssistant,
+		reviewFilter:    reviewFilter,
+		reviewPublisher: review.NewPublisher(versionControl),
+		reviewState:     reviewState,
+		indexState:      indexState,
 		versionControl:  versionControl,
 		consumerClint:   consumerClint,
+		failureRouter:   failureRouter,
+		limiter:         limiter,
+		janitor:         janitor,
 		workerCount:     workerCount,
+		jobs:            newJobRegistry(),
+		baseLocks:       newBaseLocks(),
+		quit:            make(chan struct{}),
+		jobsCtx:         jobsCtx,
+		stopJobs:        stopJobs,
+		failed:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(module)
 	}
+	return module
 }
 
 func (m *Module) Start() {
-	logger := log.GetLogger()
 	for i := 0; i < int(m.workerCount); i++ {
+		m.workers.Add(1)
 		go func() {
-			for kafkaMessage := range m.consumerClint.Channel() {
-				start := time.Now()
-				var err error
-				var event models.PullRequestEvent
-				err = json.Unmarshal(kafkaMessage.Value, &event)
-				if err != nil {
-					logger.WithError(err).Error("failed to unmarshal event")
-					go observeMetrics(start, err)
-					continue
-				}
-
-				if err = m.process(&event); err == nil {
-					if err := m.consumerClint.CommitMessage(kafkaMessage); err != nil {
-						logger.WithError(err).Error("failed to commit message")
+			defer m.workers.Done()
+			for {
+				select {
+				case <-m.quit:
+					return
+				case kafkaMessage, ok := <-m.consumerClint.Channel():
+					if !ok || m.stopping() {
+						return
 					}
-				} else {
-					logger.WithError(err).Warn("failed to process message")
+					m.handleMessage(kafkaMessage)
 				}
-				go observeMetrics(start, err)
 			}
 		}()
 	}
 }
 
-func (m *Module) process(event *models.PullRequestEvent) error {
+// Wait blocks until the module shuts down or fails, see routeFailure. A failed module has to be restarted, so
+// the message it could not route is delivered again.
+func (m *Module) Wait() error {
+	select {
+	case <-m.quit:
+		return nil
+	case <-m.failed:
+		return m.failure
+	}
+}
+
+// Health reports the failure of the module, if any.
+func (m *Module) Health(context.Context) error {
+	select {
+	case <-m.failed:
+		return m.failure
+	default:
+		return nil
+	}
+}
+
+func (m *Module) fail(err error) {
+	m.failOnce.Do(func() {
+		m.failure = err
+		close(m.failed)
+	})
+}
+
+func (m *Module) stopping() bool {
+	select {
+	case <-m.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// Shutdown stops the workers from taking new messages and waits for the reviews in progress. Reviews still
+// running when ctx is done are cancelled and left uncommitted, so they are redelivered after the restart.
+func (m *Module) Shutdown(ctx context.Context) error {
+	close(m.quit)
+
+	finished := make(chan struct{})
+	go func() {
+		m.workers.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		log.GetLogger().Warn("shutdown deadline exceeded, cancelling reviews in progress")
+		m.stopJobs()
+		<-finished
+		return ctx.Err()
+	}
+}
+
+func (m *Module) handleMessage(kafkaMessage *confluentkafka.Message) {
+	metadata := models.MetadataFromHeaders(kafka.Headers(kafkaMessage))
 	logger := log.GetLogger().WithFields(logrus.Fields{
-		"clone_url": event.CloneURL,
-		"branch":    event.Branch,
+		"delivery_id":  metadata.DeliveryID,
+		"trace_parent": metadata.TraceParent,
+	})
+	start := time.Now()
+
+	// the partition was revoked or the service is shutting down, the message is redelivered later
+	ctx, cancel := context.WithCancel(m.consumerClint.Context(kafkaMessage))
+	defer cancel()
+	stopAfterFunc := context.AfterFunc(m.jobsCtx, cancel)
+	defer stopAfterFunc()
+	if ctx.Err() != nil {
+		logger.WithField("partition", kafkaMessage.TopicPartition).Info("skipping cancelled message")
+		return
+	}
+
+	err := checkMetadata(metadata)
+	switch {
+	case err != nil:
+	case metadata.EventType == models.EventTypePush:
+		var event models.PushEvent
+		if err = decodeEvent(kafkaMessage, &event, logger); err == nil {
+			err = m.indexPush(ctx, kafkaMessage, &event, logger)
+		}
+	default:
+		var event models.PullRequestEvent
+		if err = decodeEvent(kafkaMessage, &event, logger); err == nil {
+			err = m.review(ctx, kafkaMessage, &event, metadata, logger)
+		}
+	}
+	go observeMetrics(start, err)
+
+	if ctx.Err() != nil {
+		logger.Warn("message processing was cancelled, leaving it uncommitted")
+		return
+	}
+	if err != nil {
+		logger.WithError(err).Warn("failed to process message")
+		if !m.routeFailure(ctx, kafkaMessage, err) {
+			return
+		}
+	}
+
+	if err := m.consumerClint.CommitMessage(kafkaMessage); err != nil {
+		logger.WithError(err).Error("failed to commit message")
+	}
+}
+
+// review processes event unless a newer push of the same pull request was already seen or a daily quota is
+// used up. A review cancelled by a newer push is dropped without error, the newer event publishes its own review.
+// Closing the pull request cancels its review and removes its embeddings and review state.
+func (m *Module) review(ctx context.Context, kafkaMessage *confluentkafka.Message, event *models.PullRequestEvent, metadata models.EventMetadata, logger *logrus.Entry) error {
+	pullRequest, pos := models.GetPullRequestKey(event), positionOf(kafkaMessage)
+	ctx, done, newest := m.jobs.start(ctx, pullRequest, pos)
+	defer done()
+	if !newest {
+		logger.WithField("head_sha", event.HeadSHA).Info("a newer push of the pull request was already seen, skipping event")
+		metrics.Get().ObserveReviewCancellation(metrics.SkipSuperseded)
+		return nil
+	}
+
+	if event.Action == models.ActionClosed {
+		if err := m.janitor.ProjectClosed(ctx, models.GetProjectIdentifier(event)); err != nil {
+			return err
+		}
+		if err := m.reviewState.DeleteLastReviewedSHA(ctx, pullRequest); err != nil {
+			logger.WithError(err).Warn("failed to forget last reviewed sha, it expires instead")
+		}
+		m.jobs.forget(pullRequest, pos)
+		return nil
+	}
+
+	if err := m.limiter.Check(event.Owner, event.Repo); err != nil {
+		logger.WithError(err).Warn("review quota exceeded, skipping event")
+		metrics.Get().ObserveReviewLimit(metrics.LimitQuota)
+		if err := m.reviewPublisher.PublishQuotaNote(ctx, event, err); err != nil {
+			logger.WithError(err).Warn("failed to publish quota note")
+		}
+		return nil
+	}
+
+	release, postponed, err := m.acquire(ctx, kafkaMessage, event, logger)
+	if postponed {
+		return nil
+	}
+	if err == nil {
+		usageCtx := assistant.WithTokenUsage(ctx)
+		err = m.process(usageCtx, event, metadata)
+		release()
+		m.limiter.RecordTokens(event.Owner, event.Repo, assistant.TokensUsed(usageCtx))
+	}
+	if err != nil && superseded(ctx) {
+		logger.WithField("head_sha", event.HeadSHA).Info("a newer push of the pull request arrived, review cancelled")
+		metrics.Get().ObserveReviewCancellation(metrics.CancelSuperseded)
+		return nil
+	}
+	return err
+}
+
+// acquire takes a concurrency slot of the repository and its organization. At their limit the message is
+// postponed to the first retry tier, so the worker is free for other repositories; only if that is not
+// possible the worker waits for a slot.
+func (m *Module) acquire(ctx context.Context, kafkaMessage *confluentkafka.Message, event *models.PullRequestEvent, logger *logrus.Entry) (func(), bool, error) {
+	if release, ok := m.limiter.Acquire(event.Owner, event.Repo); ok {
+		return release, false, nil
+	}
+	metrics.Get().ObserveReviewLimit(metrics.LimitConcurrency)
+
+	if m.failureRouter != nil && m.failureRouter.CanPostpone() {
+		topic, err := m.failureRouter.Postpone(kafkaMessage, errors.ErrConcurrencyLimit)
+		if err == nil {
+			logger.Infof("concurrency limit reached, postponed event to %s", topic)
+			return nil, true, nil
+		}
+		logger.WithError(err).Warn("failed to postpone event, waiting for a free slot")
+	}
+
+	release, err := m.limiter.Wait(ctx, event.Owner, event.Repo)
+	return release, false, err
+}
+
+// routeFailure hands a failed message to the retry or dead-letter topics and reports whether its offset may be
+// committed. Routing is retried for the route timeout of the router. A message the brokers reject, or that still
+// cannot be routed while other messages were, is dropped so it cannot hold back its partition. When no message
+// could be routed for the whole timeout, routing is broken and the module fails.
+func (m *Module) routeFailure(ctx context.Context, kafkaMessage *confluentkafka.Message, err error) bool {
+	if m.failureRouter == nil {
+		return false
+	}
+	logger := log.GetLogger().WithField("partition", kafkaMessage.TopicPartition)
+
+	start := time.Now()
+	topic, routeErr := retry.New[string](retry.Options{
+		MaxRetries:  math.MaxInt,
+		Strategy:    retry.ExponentialJitterBackoff(100*time.Millisecond, 10*time.Second),
+		ShouldRetry: func(err error) bool { return !kafka.Rejected(err) },
+		MaxElapsed:  m.failureRouter.RouteTimeout(),
+		Operation:   "failure-routing",
+		OnRetry:     metrics.Get().ObserveRetry,
+	}).Do(ctx, func() (string, error) {
+		return m.failureRouter.Route(kafkaMessage, err, errors.IsRetryable(err))
+	})
+	switch {
+	case routeErr == nil:
+		m.lastRouted.Store(time.Now().UnixNano())
+		metrics.Get().ObserveFailureRouting(topic)
+		return true
+	case ctx.Err() != nil:
+		// the partition was revoked or the shutdown deadline passed, the message is redelivered
+		logger.WithError(routeErr).Warn("routing of failed message was cancelled, leaving it uncommitted")
+		return false
+	case kafka.Rejected(routeErr) || m.lastRouted.Load() > start.UnixNano():
+		logger.WithError(routeErr).Error("failed message cannot be routed, dropping it")
+		metrics.Get().ObserveFailureDrop()
+		return true
+	default:
+		logger.WithError(routeErr).Error("no failed message could be routed, the partition cannot advance past it")
+		m.fail(fmt.Errorf("%w: %v", errors.ErrFailureRouting, routeErr))
+		return false
+	}
+}
+
+func decodeEvent(kafkaMessage *confluentkafka.Message, event any, logger *logrus.Entry) error {
+	if err := json.Unmarshal(kafkaMessage.Value, event); err != nil {
+		logger.WithError(err).Error("failed to unmarshal event")
+		return fmt.Errorf("%w: %v", errors.ErrInvalidEvent, err)
+	}
+	return nil
+}
+
+// checkMetadata rejects events this version cannot read. Events without headers predate them and are accepted
+// as pull request events.
+func checkMetadata(metadata models.EventMetadata) error {
+	if metadata.EventType != "" && metadata.EventType != models.EventTypePullRequest && metadata.EventType != models.EventTypePush {
+		return fmt.Errorf("%w: unsupported event type %q", errors.ErrInvalidEvent, metadata.EventType)
+	}
+	if metadata.SchemaVersion != "" && metadata.SchemaVersion != models.SchemaVersion {
+		return fmt.Errorf("%w: unsupported schema version %q", errors.ErrInvalidEvent, metadata.SchemaVersion)
+	}
+	return nil
+}
+
+func (m *Module) process(ctx context.Context, event *models.PullRequestEvent, metadata models.EventMetadata) error {
+	logger := log.GetLogger().WithFields(logrus.Fields{
+		"clone_url":    event.CloneURL,
+		"branch":       event.Branch,
+		"head_sha":     event.HeadSHA,
+		"delivery_id":  metadata.DeliveryID,
+		"trace_parent": metadata.TraceParent,
 	})
 	logger.Infof("processing pull request number = %v", event.Number)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
-	repoPath, cleanup, err := m.versionControl.Clone(ctx, event.CloneURL, event.Branch)
+	lastSHA := m.lastReviewedSHA(ctx, event)
+	if event.HeadSHA != "" && lastSHA == event.HeadSHA {
+		// a redelivered or replayed webhook, the review of this commit is already on the pull request
+		logger.Info("head sha was already reviewed, skipping event")
+		return nil
+	}
+
+	repoPath, cleanup, err := m.versionControl.Clone(ctx, vsc.CloneRequest{
+		URL:      event.CloneURL,
+		HeadURL:  event.HeadCloneURL,
+		Branch:   event.Branch,
+		SHA:      event.HeadSHA,
+		PRNumber: event.Number,
+	})
 	if err != nil {
 		logger.WithError(err).Error("failed to clone project")
 		return err
@@ -92,13 +400,7 @@ func (m *Module) process(event *models.PullRequestEvent) error {
 		return errors.ErrNoSnippetFound
 	}
 
-	err = m.projectEmbedder.EmbedProject(ctx, models.GetProjectIdentifier(event), snippets)
-	if err != nil {
-		logger.WithError(err).Error("Failed to embed project")
-		return err
-	}
-
-	diff, err := m.versionControl.DownloadUrl(ctx, event.DiffURL)
+	diff, reviewedSince, err := m.downloadDiff(ctx, event, lastSHA)
 	if err != nil {
 		logger.WithError(err).Error("failed to download url")
 		return err
@@ -108,21 +410,75 @@ func (m *Module) process(event *models.PullRequestEvent) error {
 		return nil
 	}
 
-	review, err := m.codeAssistant.PerformTask(ctx, assistant.TaskCodeReview, diff, models.GetProjectIdentifier(event))
+	fullDiff := diff
+	if reviewedSince != "" {
+		fullDiff = ""
+	}
+	overlay, err := m.indexOverlay(ctx, event, snippets, fullDiff)
+	if err != nil {
+		logger.WithError(err).Error("Failed to embed project")
+		return err
+	}
+
+	codeReview, err := m.codeAssistant.ReviewDiffWithOverlay(ctx, diff, overlay)
 	if err != nil {
 		logger.WithError(err).Error("failed to perform coding task")
 		return err
 	}
 
-	err = m.versionControl.PostPRComment(ctx, event.Number, review, event.Owner, event.Repo)
+	result := m.reviewFilter.Apply(event.Owner+"/"+event.Repo, codeReview)
+	if reviewedSince != "" {
+		result.Summary = fmt.Sprintf("_Reviewed the changes pushed since %s._\n\n%s", reviewedSince, result.Summary)
+	}
+	logger.WithFields(logrus.Fields{
+		"findings": len(result.Findings),
+		"filtered": result.FilteredCount(),
+	}).Info("review findings filtered")
+
+	err = m.reviewPublisher.Publish(ctx, event, diff, result)
 	if err != nil {
-		logger.WithError(err).Error("failed to post comment")
+		logger.WithError(err).Error("failed to publish review")
 		return err
 	}
 
+	m.limiter.RecordReview(event.Owner, event.Repo)
+	if event.HeadSHA != "" {
+		if err := m.reviewState.SetLastReviewedSHA(ctx, models.GetPullRequestKey(event), event.HeadSHA); err != nil {
+			logger.WithError(err).Warn("failed to store last reviewed sha")
+		}
+	}
+
 	return nil
 }
 
+// lastReviewedSHA returns the head sha of the previous review of the pull request, or an empty string.
+func (m *Module) lastReviewedSHA(ctx context.Context, event *models.PullRequestEvent) string {
+	lastSHA, ok, err := m.reviewState.GetLastReviewedSHA(ctx, models.GetPullRequestKey(event))
+	if err != nil {
+		log.GetLogger().WithError(err).Warn("failed to load last reviewed sha")
+		return ""
+	}
+	if !ok {
+		return ""
+	}
+	return lastSHA
+}
+
+// downloadDiff returns only the changes pushed since the last review on synchronize events and the whole
+// pull request diff otherwise. The second value is the base sha of an incremental diff.
+func (m *Module) downloadDiff(ctx context.Context, event *models.PullRequestEvent, lastSHA string) (string, string, error) {
+	if event.Action == models.ActionSynchronize && event.HeadSHA != "" && lastSHA != "" {
+		diff, err := m.versionControl.DownloadCompareDiff(ctx, event.Owner, event.Repo, lastSHA, event.HeadSHA)
+		if err == nil {
+			return diff, lastSHA, nil
+		}
+		log.GetLogger().WithField("pr", models.GetPullRequestKey(event)).WithError(err).Warn("failed to download incremental diff, reviewing the whole pull request")
+	}
+
+	diff, err := m.versionControl.DownloadUrl(ctx, event.DiffURL)
+	return diff, "", err
+}
+
 func observeMetrics(start time.Time, err error) {
 	status := metrics.Success
 	if err != nil {
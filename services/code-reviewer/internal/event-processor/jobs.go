@@ -0,0 +1,135 @@
+package event_processor
+
+import (
+	"context"
+	confluentkafka "github.com/confluentinc/confluent-kafka-go/kafka"
+	"go_code_reviewer/pkg/kafka"
+	"go_code_reviewer/services/code-reviewer/internal/errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// latestTTL is how long the newest event of a pull request or branch is kept, far longer than the delay of
+	// any retry tier so the older events still waiting there are skipped. It forgets the branches and the pull
+	// requests closed on another replica.
+	latestTTL = 24 * time.Hour
+	// latestSweepInterval is how often the expired newest events are looked for.
+	latestSweepInterval = time.Hour
+)
+
+// position orders the events of a pull request. Events are keyed by pull request, so they share a partition
+// and the offset tells which push came first; retried events keep the position of the original message.
+type position struct {
+	partition string
+	offset    int64
+}
+
+func positionOf(msg *confluentkafka.Message) position {
+	if topic := kafka.HeaderValue(msg, kafka.HeaderOriginalTopic); topic != "" {
+		offset, err := strconv.ParseInt(kafka.HeaderValue(msg, kafka.HeaderOriginalOffset), 10, 64)
+		if err == nil {
+			return position{partition: topic + "/" + kafka.HeaderValue(msg, kafka.HeaderOriginalPartition), offset: offset}
+		}
+	}
+
+	var topic string
+	if msg.TopicPartition.Topic != nil {
+		topic = *msg.TopicPartition.Topic
+	}
+	return position{partition: topic + "/" + strconv.Itoa(int(msg.TopicPartition.Partition)), offset: int64(msg.TopicPartition.Offset)}
+}
+
+// before reports whether p was published before other. Positions on different partitions, e.g. after the
+// topic was repartitioned, cannot be compared and the event arriving last is taken as the newest.
+func (p position) before(other position) bool {
+	return p.partition == other.partition && p.offset < other.offset
+}
+
+type job struct {
+	cancel context.CancelCauseFunc
+}
+
+// seenPosition is the newest event seen of a pull request and when it was seen.
+type seenPosition struct {
+	position
+	seen time.Time
+}
+
+// jobRegistry keeps the review running for each pull request and the newest event seen of it for latestTTL.
+type jobRegistry struct {
+	now func() time.Time
+
+	mu     sync.Mutex
+	active map[string]*job
+	latest map[string]seenPosition
+	swept  time.Time
+}
+
+func newJobRegistry() *jobRegistry {
+	return &jobRegistry{
+		now:    time.Now,
+		active: make(map[string]*job),
+		latest: make(map[string]seenPosition),
+	}
+}
+
+// start registers the review of the event at pos and cancels the review of an older event of the same pull
+// request. It returns false if a newer event was already seen, in which case the event must be skipped.
+// The returned func unregisters the review once it is done.
+func (r *jobRegistry) start(ctx context.Context, pullRequest string, pos position) (context.Context, func(), bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	r.sweep(now)
+	if latest, ok := r.latest[pullRequest]; ok && pos.before(latest.position) {
+		return ctx, func() {}, false
+	}
+	r.latest[pullRequest] = seenPosition{position: pos, seen: now}
+
+	if running, ok := r.active[pullRequest]; ok {
+		running.cancel(errors.ErrReviewSuperseded)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	current := &job{cancel: cancel}
+	r.active[pullRequest] = current
+	return ctx, func() {
+		cancel(context.Canceled)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.active[pullRequest] == current {
+			delete(r.active, pullRequest)
+		}
+	}, true
+}
+
+// forget drops the newest event seen of the pull request once it is closed, unless an event after pos, e.g. of
+// the reopened pull request, was seen meanwhile.
+func (r *jobRegistry) forget(pullRequest string, pos position) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if latest, ok := r.latest[pullRequest]; ok && latest.position == pos {
+		delete(r.latest, pullRequest)
+	}
+}
+
+// sweep forgets the newest events seen more than latestTTL ago, unless their pull request is being reviewed.
+func (r *jobRegistry) sweep(now time.Time) {
+	if now.Sub(r.swept) < latestSweepInterval {
+		return
+	}
+	r.swept = now
+	for pullRequest, latest := range r.latest {
+		if _, running := r.active[pullRequest]; !running && now.Sub(latest.seen) >= latestTTL {
+			delete(r.latest, pullRequest)
+		}
+	}
+}
+
+// superseded reports whether ctx was cancelled because a newer push of the pull request arrived.
+func superseded(ctx context.Context) bool {
+	return context.Cause(ctx) == errors.ErrReviewSuperseded
+}
@@ -0,0 +1,132 @@
+package janitor
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"go_code_reviewer/pkg/log"
+	"go_code_reviewer/services/api-gateway/pkg/models"
+	"go_code_reviewer/services/code-reviewer/internal/config"
+	"go_code_reviewer/services/code-reviewer/internal/metrics"
+	"go_code_reviewer/services/code-reviewer/internal/repositories"
+	"sync"
+	"time"
+)
+
+// Janitor removes the embeddings of projects nobody reviews anymore: those of closed pull requests right away
+// and, in the background, those not indexed again within the idle TTL. Base indexes of branches are kept, pushes
+// only update them incrementally.
+type Janitor struct {
+	repository repositories.EmbeddingsRepository
+	idleTTL    time.Duration
+	interval   time.Duration
+	now        func() time.Time
+
+	quit chan struct{}
+	done sync.WaitGroup
+}
+
+type Option func(janitor *Janitor)
+
+// WithClock replaces the clock deciding which projects are idle.
+func WithClock(now func() time.Time) Option {
+	return func(janitor *Janitor) {
+		janitor.now = now
+	}
+}
+
+func NewJanitor(repository repositories.EmbeddingsRepository, conf config.JanitorSection, opts ...Option) *Janitor {
+	janitor := &Janitor{
+		repository: repository,
+		idleTTL:    conf.IdleTTL,
+		interval:   conf.Interval,
+		now:        time.Now,
+		quit:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(janitor)
+	}
+	return janitor
+}
+
+// ProjectClosed removes the embeddings of a closed or merged pull request.
+func (j *Janitor) ProjectClosed(ctx context.Context, projectId string) error {
+	if err := j.repository.DeleteProject(ctx, projectId); err != nil {
+		return err
+	}
+	log.GetLogger().WithField("project_id", projectId).Info("removed embeddings of closed pull request")
+	metrics.Get().ObserveProjectRemoval(metrics.RemovalClosed)
+	return nil
+}
+
+// Sweep removes the projects indexed longer than the idle TTL ago and returns how many. Without a TTL it
+// removes none.
+func (j *Janitor) Sweep(ctx context.Context) (int, error) {
+	if j.idleTTL <= 0 {
+		return 0, nil
+	}
+	projects, err := j.repository.ListProjects(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	deadline := j.now().Add(-j.idleTTL)
+	for _, project := range projects {
+		if models.IsBaseProjectIdentifier(project.ProjectId) || !project.IndexedAt.Before(deadline) {
+			continue
+		}
+		if err := j.repository.DeleteProject(ctx, project.ProjectId); err != nil {
+			return removed, err
+		}
+		removed++
+		metrics.Get().ObserveProjectRemoval(metrics.RemovalIdle)
+		log.GetLogger().WithFields(logrus.Fields{
+			"project_id": project.ProjectId,
+			"indexed_at": project.IndexedAt,
+		}).Info("removed embeddings of idle project")
+	}
+	return removed, nil
+}
+
+// Start sweeps every interval until Stop. Without an interval or a TTL it does nothing.
+func (j *Janitor) Start() {
+	if j.interval <= 0 || j.idleTTL <= 0 {
+		return
+	}
+	j.done.Add(1)
+	go func() {
+		defer j.done.Done()
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-j.quit:
+				return
+			case <-ticker.C:
+				j.sweep()
+			}
+		}
+	}()
+}
+
+func (j *Janitor) sweep() {
+	ctx, cancel := context.WithTimeout(context.Background(), j.interval)
+	defer cancel()
+	go func() {
+		select {
+		case <-j.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if _, err := j.Sweep(ctx); err != nil {
+		log.GetLogger().WithError(err).Warn("failed to remove idle projects")
+	}
+}
+
+// Stop cancels a sweep in progress and waits for it.
+func (j *Janitor) Stop() {
+	close(j.quit)
+	j.done.Wait()
+}
@@ -0,0 +1,189 @@
+package limits
+
+import (
+	"context"
+	"fmt"
+	"go_code_reviewer/services/code-reviewer/internal/config"
+	"go_code_reviewer/services/code-reviewer/internal/errors"
+	"sync"
+	"time"
+)
+
+// Limiter enforces the concurrency limits and the daily quotas of repositories and organizations. Usage is
+// kept in memory and resets at midnight UTC.
+type Limiter struct {
+	conf config.LimitsSection
+	now  func() time.Time
+
+	mu                  sync.Mutex
+	repositoriesRunning map[string]int
+	orgsRunning         map[string]int
+	released            chan struct{}
+	day                 string
+	repositoriesUsage   map[string]*usage
+	orgsUsage           map[string]*usage
+}
+
+type usage struct {
+	reviews int
+	tokens  int
+}
+
+type Option func(limiter *Limiter)
+
+// WithClock replaces the clock deciding when the daily quotas reset.
+func WithClock(now func() time.Time) Option {
+	return func(limiter *Limiter) {
+		limiter.now = now
+	}
+}
+
+func NewLimiter(conf config.LimitsSection, opts ...Option) *Limiter {
+	limiter := &Limiter{
+		conf:                conf,
+		now:                 time.Now,
+		repositoriesRunning: make(map[string]int),
+		orgsRunning:         make(map[string]int),
+		released:            make(chan struct{}),
+		repositoriesUsage:   make(map[string]*usage),
+		orgsUsage:           make(map[string]*usage),
+	}
+	for _, opt := range opts {
+		opt(limiter)
+	}
+	return limiter
+}
+
+// Acquire takes a concurrency slot of the repository and one of its organization. It returns false without
+// taking any if either is at its limit; otherwise the returned func gives both slots back.
+func (l *Limiter) Acquire(owner, repo string) (func(), bool) {
+	release, _, ok := l.tryAcquire(owner, repo)
+	return release, ok
+}
+
+// Wait blocks until Acquire succeeds or ctx is done.
+func (l *Limiter) Wait(ctx context.Context, owner, repo string) (func(), error) {
+	for {
+		release, released, ok := l.tryAcquire(owner, repo)
+		if ok {
+			return release, nil
+		}
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// tryAcquire also returns a channel closed on the next release, so a failed attempt can wait for a free slot.
+func (l *Limiter) tryAcquire(owner, repo string) (func(), <-chan struct{}, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	repository := repositoryKey(owner, repo)
+	if atLimit(l.repositoriesRunning[repository], l.conf.Repository.MaxConcurrent) || atLimit(l.orgsRunning[owner], l.conf.Organization.MaxConcurrent) {
+		return nil, l.released, false
+	}
+	l.repositoriesRunning[repository]++
+	l.orgsRunning[owner]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			decrement(l.repositoriesRunning, repository)
+			decrement(l.orgsRunning, owner)
+			close(l.released)
+			l.released = make(chan struct{})
+		})
+	}, nil, true
+}
+
+// Check returns errors.ErrQuotaExceeded once the repository or its organization used up today's review or
+// token quota.
+func (l *Limiter) Check(owner, repo string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rollover()
+
+	repository := repositoryKey(owner, repo)
+	if err := exceeded(l.repositoriesUsage[repository], l.conf.Repository, repository); err != nil {
+		return err
+	}
+	return exceeded(l.orgsUsage[owner], l.conf.Organization, owner)
+}
+
+// RecordReview counts a published review against today's quotas.
+func (l *Limiter) RecordReview(owner, repo string) {
+	l.record(owner, repo, func(usage *usage) {
+		usage.reviews++
+	})
+}
+
+// RecordTokens counts the LLM tokens of a review against today's quotas, whether the review succeeded or not.
+func (l *Limiter) RecordTokens(owner, repo string, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	l.record(owner, repo, func(usage *usage) {
+		usage.tokens += tokens
+	})
+}
+
+func (l *Limiter) record(owner, repo string, update func(usage *usage)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rollover()
+	update(usageOf(l.repositoriesUsage, repositoryKey(owner, repo)))
+	update(usageOf(l.orgsUsage, owner))
+}
+
+// rollover resets the usage on the first call of a new day.
+func (l *Limiter) rollover() {
+	day := l.now().UTC().Format(time.DateOnly)
+	if day == l.day {
+		return
+	}
+	l.day = day
+	l.repositoriesUsage = make(map[string]*usage)
+	l.orgsUsage = make(map[string]*usage)
+}
+
+func exceeded(usage *usage, limit config.LimitSection, name string) error {
+	if usage == nil {
+		return nil
+	}
+	if limit.DailyReviews > 0 && usage.reviews >= limit.DailyReviews {
+		return fmt.Errorf("%w: %s used its %d reviews of the day", errors.ErrQuotaExceeded, name, limit.DailyReviews)
+	}
+	if limit.DailyTokens > 0 && usage.tokens >= limit.DailyTokens {
+		return fmt.Errorf("%w: %s used its %d tokens of the day", errors.ErrQuotaExceeded, name, limit.DailyTokens)
+	}
+	return nil
+}
+
+func usageOf(usages map[string]*usage, key string) *usage {
+	current, ok := usages[key]
+	if !ok {
+		current = &usage{}
+		usages[key] = current
+	}
+	return current
+}
+
+func atLimit(running, limit int) bool {
+	return limit > 0 && running >= limit
+}
+
+func decrement(running map[string]int, key string) {
+	running[key]--
+	if running[key] <= 0 {
+		delete(running, key)
+	}
+}
+
+func repositoryKey(owner, repo string) string {
+	return owner + "/" + repo
+}
@@ -1,8 +1,10 @@
 package metrics
 
 import (
+	"errors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go_code_reviewer/pkg/log"
+	"go_code_reviewer/pkg/retry"
 	"net/http"
 	"sync"
 	"time"
@@ -24,8 +26,17 @@ func Get() *Metrics {
 
 type Metrics struct {
 	kafkaPublishCounter     *prometheus.CounterVec
+	kafkaProduceCounter     *prometheus.CounterVec
 	eventProcessCounter     *prometheus.CounterVec
 	processLatencyHistogram *prometheus.HistogramVec
+	failureRoutingCounter   *prometheus.CounterVec
+	reviewCancelCounter     *prometheus.CounterVec
+	reviewLimitCounter      *prometheus.CounterVec
+	breakerStateCounter     *prometheus.CounterVec
+	breakerOpenGauge        *prometheus.GaugeVec
+	retryObserver           *retry.PrometheusObserver
+	projectRemovalCounter   *prometheus.CounterVec
+	failureDropCounter      prometheus.Counter
 }
 
 func newMetrics() *Metrics {
@@ -37,6 +48,13 @@ func newMetrics() *Metrics {
 			},
 			[]string{"status"},
 		),
+		kafkaProduceCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kafka_produce_total",
+				Help: "Total number of kafka delivery reports",
+			},
+			[]string{"status"},
+		),
 		eventProcessCounter: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "event_process_total",
@@ -52,6 +70,55 @@ func newMetrics() *Metrics {
 			},
 			[]string{"status"},
 		),
+		failureRoutingCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "event_failure_routed_total",
+				Help: "Total number of failed events routed to retry or dead-letter topics",
+			},
+			[]string{"topic"},
+		),
+		reviewCancelCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "review_cancelled_total",
+				Help: "Total number of reviews cancelled or skipped because a newer push arrived",
+			},
+			[]string{"reason"},
+		),
+		reviewLimitCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "review_limited_total",
+				Help: "Total number of reviews postponed by a concurrency limit or skipped by a daily quota",
+			},
+			[]string{"limit"},
+		),
+		breakerStateCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "circuit_breaker_transitions_total",
+				Help: "Total number of circuit breaker state changes",
+			},
+			[]string{"name", "state"},
+		),
+		breakerOpenGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "circuit_breaker_open",
+				Help: "Whether the circuit breaker of a provider rejects calls",
+			},
+			[]string{"name"},
+		),
+		retryObserver: retry.NewPrometheusObserver(),
+		projectRemovalCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "embeddings_projects_removed_total",
+				Help: "Total number of projects removed from the embeddings store",
+			},
+			[]string{"reason"},
+		),
+		failureDropCounter: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "event_failure_dropped_total",
+				Help: "Total number of failed events dropped because they could not be routed",
+			},
+		),
 	}
 }
 
@@ -62,10 +129,29 @@ const (
 	Failure Status = "failure"
 )
 
+const (
+	CancelSuperseded = "superseded"
+	SkipSuperseded   = "skipped"
+)
+
+const (
+	LimitConcurrency = "concurrency"
+	LimitQuota       = "quota"
+)
+
+const (
+	RemovalClosed = "closed"
+	RemovalIdle   = "idle"
+)
+
 func (m *Metrics) ObserveKafkaPublish(status string) {
 	m.kafkaPublishCounter.WithLabelValues(status).Inc()
 }
 
+func (m *Metrics) ObserveKafkaProduce(status string) {
+	m.kafkaProduceCounter.WithLabelValues(status).Inc()
+}
+
 func (m *Metrics) ObserveEventProcessing(status Status) {
 	m.eventProcessCounter.With(prometheus.Labels{"status": string(status)}).Inc()
 }
@@ -74,14 +160,69 @@ func (m *Metrics) ObserveEventProcessingLatency(status Status, start time.Time)
 	m.processLatencyHistogram.With(prometheus.Labels{"status": string(status)}).Observe(time.Since(start).Seconds())
 }
 
-func Init(address string) {
+func (m *Metrics) ObserveFailureRouting(topic string) {
+	m.failureRoutingCounter.WithLabelValues(topic).Inc()
+}
+
+func (m *Metrics) ObserveFailureDrop() {
+	m.failureDropCounter.Inc()
+}
+
+func (m *Metrics) ObserveReviewCancellation(reason string) {
+	m.reviewCancelCounter.WithLabelValues(reason).Inc()
+}
+
+func (m *Metrics) ObserveReviewLimit(limit string) {
+	m.reviewLimitCounter.WithLabelValues(limit).Inc()
+}
+
+// ObserveCircuitBreakerState records a state change of the circuit breaker of a provider.
+func (m *Metrics) ObserveCircuitBreakerState(name, state string) {
+	m.breakerStateCounter.WithLabelValues(name, state).Inc()
+	open := 0.0
+	if state == "open" {
+		open = 1
+	}
+	m.breakerOpenGauge.WithLabelValues(name).Set(open)
+}
+
+func (m *Metrics) ObserveProjectRemoval(reason string) {
+	m.projectRemovalCounter.WithLabelValues(reason).Inc()
+}
+
+// ObserveRetry counts a retried provider call, pass it as retry.Options.OnRetry.
+func (m *Metrics) ObserveRetry(event retry.RetryEvent) {
+	m.retryObserver.OnRetry(event)
+}
+
+type Option func(mux *http.ServeMux)
+
+// WithHandler serves handler next to the metrics, e.g. the health probes.
+func WithHandler(pattern string, handler http.Handler) Option {
+	return func(mux *http.ServeMux) {
+		mux.Handle(pattern, handler)
+	}
+}
+
+// Init registers the collectors and serves them on address. The returned server is shut down on close.
+func Init(address string, opts ...Option) *http.Server {
 	metrics := Get()
-	prometheus.MustRegister(metrics.eventProcessCounter, metrics.processLatencyHistogram, metrics.kafkaPublishCounter)
+	prometheus.MustRegister(metrics.eventProcessCounter, metrics.processLatencyHistogram, metrics.kafkaPublishCounter, metrics.kafkaProduceCounter, metrics.failureRoutingCounter, metrics.reviewCancelCounter, metrics.reviewLimitCounter, metrics.breakerStateCounter, metrics.breakerOpenGauge, metrics.retryObserver, metrics.projectRemovalCounter, metrics.failureDropCounter)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	for _, opt := range opts {
+		opt(mux)
+	}
+	server := &http.Server{
+		Addr:    address,
+		Handler: mux,
+	}
 
 	go func() {
-		http.Handle("/metrics", promhttp.Handler())
-		if err := http.ListenAndServe(address, nil); err != nil {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.GetLogger().WithError(err).Fatal("failed to serve prometheus metrics")
 		}
 	}()
+	return server
 }
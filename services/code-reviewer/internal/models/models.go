@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type Snippet struct {
 	ID        string    `json:"id"`
 	Content   string    `json:"content"`
@@ -7,6 +9,15 @@ type Snippet struct {
 	Language  string    `json:"language"`
 	ProjectId string    `json:"project_id"`
 	Embedding []float32 `json:"embedding,omitempty"`
+	// Symbol is the name of the function, method, type or class the snippet defines, if any.
+	Symbol string `json:"symbol,omitempty"`
+	// StartLine and EndLine are the lines of the file the snippet spans, starting at 1.
+	StartLine int `json:"start_line,omitempty"`
+	EndLine   int `json:"end_line,omitempty"`
+	// References are the names of the functions, methods and types the snippet calls or uses.
+	References []string `json:"references,omitempty"`
+	// Commit is the commit the snippet was parsed at, set for the snippets of base indexes.
+	Commit string `json:"commit,omitempty"`
 }
 
 func NewSnippet(id, content, filename, language string) *Snippet {
@@ -17,3 +28,11 @@ func NewSnippet(id, content, filename, language string) *Snippet {
 		Language: language,
 	}
 }
+
+// ProjectIndex is a project with snippets in the embeddings store. IndexedAt is when snippets were last added,
+// and Commit the commit they were parsed at, if any.
+type ProjectIndex struct {
+	ProjectId string
+	IndexedAt time.Time
+	Commit    string
+}
@@ -58,14 +58,14 @@ func (p *CodeParser) ParseFile(ctx context.Context, content []byte, filename str
 	cursor := sitter.NewTreeCursor(tree.RootNode())
 	var snippets []*models.Snippet
 	if p.isTargetType(cursor.CurrentNode().Type()) {
-		snippets = append(snippets, models.NewSnippet(uuid.New().String(), cursor.CurrentNode().Content(content), filename, string(p.langString)))
+		snippets = append(snippets, p.newSnippet(cursor.CurrentNode(), content, filename))
 	}
 
 	if cursor.GoToFirstChild() {
 		for {
 			node := cursor.CurrentNode()
 			if p.isTargetType(node.Type()) {
-				snippets = append(snippets, models.NewSnippet(uuid.New().String(), node.Content(content), filename, string(p.langString)))
+				snippets = append(snippets, p.newSnippet(node, content, filename))
 			}
 			if !cursor.GoToNextSibling() {
 				break
@@ -75,3 +75,79 @@ func (p *CodeParser) ParseFile(ctx context.Context, content []byte, filename str
 
 	return snippets
 }
+
+func (p *CodeParser) newSnippet(node *sitter.Node, content []byte, filename string) *models.Snippet {
+	snippet := models.NewSnippet(uuid.New().String(), node.Content(content), filename, string(p.langString))
+	snippet.Symbol = definedName(node, content)
+	snippet.StartLine = int(node.StartPoint().Row) + 1
+	snippet.EndLine = int(node.EndPoint().Row) + 1
+	snippet.References = references(node, content, snippet.Symbol)
+	return snippet
+}
+
+// definedName returns the name a definition node declares. A go type declaration names its first type.
+func definedName(node *sitter.Node, content []byte) string {
+	if node.Type() == "type_declaration" {
+		for i := 0; i < int(node.NamedChildCount()); i++ {
+			if spec := node.NamedChild(i); spec.Type() == "type_spec" {
+				return definedName(spec, content)
+			}
+		}
+		return ""
+	}
+	if name := node.ChildByFieldName("name"); name != nil {
+		return name.Content(content)
+	}
+	return ""
+}
+
+// references returns the names of the functions and methods node calls and of the go types it uses, in the order
+// they first appear. Calls through a package or a receiver name the called function or method, and the defined
+// name itself is left out.
+func references(node *sitter.Node, content []byte, defined string) []string {
+	seen := map[string]bool{defined: true}
+	var names []string
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	stack := []*sitter.Node{node}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		switch current.Type() {
+		case "call_expression", "call":
+			add(calleeName(current.ChildByFieldName("function"), content))
+		case "type_identifier":
+			add(current.Content(content))
+		}
+		// children are pushed in reverse to visit them in source order
+		for i := int(current.NamedChildCount()) - 1; i >= 0; i-- {
+			stack = append(stack, current.NamedChild(i))
+		}
+	}
+	return names
+}
+
+// calleeName returns the name of the called function of a go or python call.
+func calleeName(function *sitter.Node, content []byte) string {
+	if function == nil {
+		return ""
+	}
+	switch function.Type() {
+	case "identifier":
+		return function.Content(content)
+	case "selector_expression":
+		if field := function.ChildByFieldName("field"); field != nil {
+			return field.Content(content)
+		}
+	case "attribute":
+		if attribute := function.ChildByFieldName("attribute"); attribute != nil {
+			return attribute.Content(content)
+		}
+	}
+	return ""
+}
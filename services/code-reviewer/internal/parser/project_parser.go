@@ -36,7 +36,7 @@ func (pp *ProjectParser) ParseProject(ctx context.Context, rootPath string) ([]*
 			if readErr != nil {
 				return readErr
 			}
-			fileSnippets := parser.ParseFile(ctx, content, path)
+			fileSnippets := parser.ParseFile(ctx, content, relativePath(rootPath, path))
 			allSnippets = append(allSnippets, fileSnippets...)
 		}
 		return nil
@@ -48,3 +48,13 @@ func (pp *ProjectParser) ParseProject(ctx context.Context, rootPath string) ([]*
 
 	return allSnippets, nil
 }
+
+// relativePath names a file relative to the root, so snippets of the same file match across checkouts. A root
+// that is the file itself gives its base name.
+func relativePath(rootPath, path string) string {
+	filename, err := filepath.Rel(rootPath, path)
+	if err != nil || filename == "." {
+		return filepath.Base(path)
+	}
+	return filepath.ToSlash(filename)
+}
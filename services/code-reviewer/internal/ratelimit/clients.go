@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"context"
+	"go_code_reviewer/services/code-reviewer/internal/embedder"
+	"unicode/utf8"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// charsPerToken is the rough length of a token in English text and code, used to estimate a call before it
+// is sent.
+const charsPerToken = 4
+
+type model struct {
+	llms.Model
+	limiter *Limiter
+}
+
+// NewModel waits for the limiter before every call of llm. The tokens are estimated from the messages and
+// the max tokens of the call, then corrected with the usage the provider reports.
+func NewModel(llm llms.Model, limiter *Limiter) llms.Model {
+	if limiter == nil {
+		return llm
+	}
+	return &model{Model: llm, limiter: limiter}
+}
+
+func (m *model) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	estimated := estimateMessages(messages, options)
+	if err := m.limiter.Wait(ctx, estimated); err != nil {
+		return nil, err
+	}
+
+	resp, err := m.Model.GenerateContent(ctx, messages, options...)
+	if resp != nil {
+		if used, ok := totalTokens(resp); ok {
+			m.limiter.Adjust(used - estimated)
+		}
+	}
+	return resp, err
+}
+
+// Call goes through GenerateContent, the wrapped model would call its own.
+func (m *model) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+type embeddingClient struct {
+	embedder.EmbeddingClient
+	limiter *Limiter
+}
+
+// NewEmbeddingClient waits for the limiter before every call of client, with the tokens estimated from the
+// texts.
+func NewEmbeddingClient(client embedder.EmbeddingClient, limiter *Limiter) embedder.EmbeddingClient {
+	if limiter == nil {
+		return client
+	}
+	return &embeddingClient{EmbeddingClient: client, limiter: limiter}
+}
+
+func (c *embeddingClient) CreateEmbeddings(ctx context.Context, embeddingModel string, texts []string) ([]embedder.Embedding, error) {
+	tokens := 0
+	for _, text := range texts {
+		tokens += estimateTokens(text)
+	}
+	if err := c.limiter.Wait(ctx, tokens); err != nil {
+		return nil, err
+	}
+	return c.EmbeddingClient.CreateEmbeddings(ctx, embeddingModel, texts)
+}
+
+func estimateMessages(messages []llms.MessageContent, options []llms.CallOption) int {
+	tokens := 0
+	for _, message := range messages {
+		for _, part := range message.Parts {
+			if text, ok := part.(llms.TextContent); ok {
+				tokens += estimateTokens(text.Text)
+			}
+		}
+	}
+
+	callOptions := llms.CallOptions{}
+	for _, option := range options {
+		option(&callOptions)
+	}
+	return tokens + callOptions.MaxTokens
+}
+
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + charsPerToken - 1) / charsPerToken
+}
+
+func totalTokens(resp *llms.ContentResponse) (int, bool) {
+	total, found := 0, false
+	for _, choice := range resp.Choices {
+		if tokens, ok := choice.GenerationInfo["TotalTokens"].(int); ok {
+			total += tokens
+			found = true
+		}
+	}
+	return total, found
+}
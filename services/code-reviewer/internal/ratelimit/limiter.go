@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"context"
+	"go_code_reviewer/services/code-reviewer/internal/config"
+	"sync"
+	"time"
+)
+
+// Limiter paces the calls to one model with a token bucket for requests and one for tokens, each refilled
+// with its per-minute limit and holding at most a minute of it. Callers queue in order of arrival.
+type Limiter struct {
+	now func() time.Time
+
+	mu       sync.Mutex
+	requests *bucket
+	tokens   *bucket
+}
+
+type Option func(limiter *Limiter)
+
+// WithClock replaces the clock refilling the buckets.
+func WithClock(now func() time.Time) Option {
+	return func(limiter *Limiter) {
+		limiter.now = now
+	}
+}
+
+// NewLimiter returns nil when conf has no limit, a nil Limiter never waits.
+func NewLimiter(conf config.RateLimitSection, opts ...Option) *Limiter {
+	if conf.RequestsPerMinute <= 0 && conf.TokensPerMinute <= 0 {
+		return nil
+	}
+	limiter := &Limiter{now: time.Now}
+	for _, opt := range opts {
+		opt(limiter)
+	}
+	now := limiter.now()
+	limiter.requests = newBucket(conf.RequestsPerMinute, now)
+	limiter.tokens = newBucket(conf.TokensPerMinute, now)
+	return limiter
+}
+
+// Wait takes one request and tokens from the buckets, blocking until they are refilled or ctx is done. A
+// cancelled wait gives them back.
+func (l *Limiter) Wait(ctx context.Context, tokens int) error {
+	if l == nil {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	now := l.now()
+	requestsDelay, requestsTaken := l.requests.take(1, now)
+	tokensDelay, tokensTaken := l.tokens.take(tokens, now)
+	l.mu.Unlock()
+
+	delay := max(requestsDelay, tokensDelay)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.requests.giveBack(requestsTaken)
+		l.tokens.giveBack(tokensTaken)
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Adjust corrects the tokens taken by Wait, an estimate, once the provider reported the actual usage.
+func (l *Limiter) Adjust(tokens int) {
+	if l == nil || tokens == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens.refill(l.now())
+	l.tokens.giveBack(-float64(tokens))
+}
+
+// bucket may go below zero: a caller reserves its share right away and waits until the bucket refilled it,
+// so later callers queue behind it. A nil bucket is unlimited.
+type bucket struct {
+	capacity float64
+	perNano  float64
+	level    float64
+	last     time.Time
+}
+
+func newBucket(perMinute int, now time.Time) *bucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &bucket{
+		capacity: float64(perMinute),
+		perNano:  float64(perMinute) / float64(time.Minute),
+		level:    float64(perMinute),
+		last:     now,
+	}
+}
+
+// take returns how long the caller waits for n and how much it took, n capped at the capacity so a single
+// call larger than the limit still goes through once the bucket is full.
+func (b *bucket) take(n int, now time.Time) (time.Duration, float64) {
+	if b == nil || n <= 0 {
+		return 0, 0
+	}
+	b.refill(now)
+	taken := min(float64(n), b.capacity)
+	b.level -= taken
+	if b.level >= 0 {
+		return 0, taken
+	}
+	return time.Duration(-b.level / b.perNano), taken
+}
+
+func (b *bucket) giveBack(n float64) {
+	if b == nil {
+		return
+	}
+	b.level = min(b.level+n, b.capacity)
+}
+
+func (b *bucket) refill(now time.Time) {
+	if b == nil {
+		return
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.level = min(b.level+float64(elapsed)*b.perNano, b.capacity)
+		b.last = now
+	}
+}
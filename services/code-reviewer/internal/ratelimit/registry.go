@@ -0,0 +1,29 @@
+package ratelimit
+
+import (
+	"go_code_reviewer/services/code-reviewer/internal/config"
+)
+
+// Registry holds a Limiter per provider and model, so every client calling the same model shares it.
+type Registry struct {
+	limiters map[string]*Limiter
+}
+
+func NewRegistry(conf []config.RateLimitSection, opts ...Option) *Registry {
+	registry := &Registry{limiters: make(map[string]*Limiter)}
+	for _, section := range conf {
+		if limiter := NewLimiter(section, opts...); limiter != nil {
+			registry.limiters[key(section.Provider, section.Model)] = limiter
+		}
+	}
+	return registry
+}
+
+// For returns the Limiter of the model, nil when it is not limited.
+func (r *Registry) For(provider, model string) *Limiter {
+	return r.limiters[key(provider, model)]
+}
+
+func key(provider, model string) string {
+	return provider + "/" + model
+}
@@ -0,0 +1,258 @@
+package repositories
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go_code_reviewer/services/code-reviewer/internal/models"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const diskProjectExtension = ".gob"
+
+// DiskRepository keeps the embeddings of every project in a file of dir and in memory once read, ranking
+// them by cosine similarity. It suits single-node deployments without a vector database.
+type DiskRepository struct {
+	dir string
+
+	mu       sync.RWMutex
+	projects map[string]*diskProject
+}
+
+// diskProject is written as two gob values, the header first, so the projects can be listed without reading
+// their snippets.
+type diskProject struct {
+	header   diskProjectHeader
+	snippets map[string]*models.Snippet
+}
+
+type diskProjectHeader struct {
+	ProjectId string
+	IndexedAt time.Time
+	Commit    string
+}
+
+func NewDiskRepository(dir string) (VectorStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create embeddings directory: %w", err)
+	}
+	return &DiskRepository{
+		dir:      dir,
+		projects: make(map[string]*diskProject),
+	}, nil
+}
+
+// Add replaces the snippets of the same id and writes the project file before returning.
+func (d *DiskRepository) Add(ctx context.Context, snippets []*models.Snippet, projectId string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	project, err := d.load(projectId)
+	if err != nil {
+		return err
+	}
+	updated := &diskProject{
+		header:   diskProjectHeader{ProjectId: projectId, IndexedAt: time.Now(), Commit: commitOf(snippets)},
+		snippets: make(map[string]*models.Snippet, len(project.snippets)+len(snippets)),
+	}
+	for id, snippet := range project.snippets {
+		updated.snippets[id] = snippet
+	}
+	for _, snippet := range snippets {
+		stored := *snippet
+		stored.ProjectId = projectId
+		updated.snippets[snippet.ID] = &stored
+	}
+
+	if err := d.write(updated); err != nil {
+		return err
+	}
+	d.projects[projectId] = updated
+	return nil
+}
+
+func (d *DiskRepository) GetNearestRecord(ctx context.Context, vectorEmbedding []float32, nResult int, projectId string) ([]*models.Snippet, error) {
+	d.mu.RLock()
+	project, ok := d.projects[projectId]
+	d.mu.RUnlock()
+	if !ok {
+		d.mu.Lock()
+		var err error
+		project, err = d.load(projectId)
+		d.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	candidates := make([]*models.Snippet, 0, len(project.snippets))
+	for _, snippet := range project.snippets {
+		candidates = append(candidates, snippet)
+	}
+
+	results := rank(MetricCosine, vectorEmbedding, nResult, candidates)
+	snippets := make([]*models.Snippet, 0, len(results))
+	for _, result := range results {
+		snippets = append(snippets, result.Snippet)
+	}
+	return snippets, nil
+}
+
+func (d *DiskRepository) DeleteProject(ctx context.Context, projectId string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := os.Remove(d.path(projectId)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	delete(d.projects, projectId)
+	return nil
+}
+
+func (d *DiskRepository) DeleteFiles(ctx context.Context, projectId string, filenames []string) error {
+	files := make(map[string]bool, len(filenames))
+	for _, filename := range filenames {
+		files[filename] = true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	project, err := d.load(projectId)
+	if err != nil {
+		return err
+	}
+	updated := &diskProject{
+		header:   project.header,
+		snippets: make(map[string]*models.Snippet, len(project.snippets)),
+	}
+	for id, snippet := range project.snippets {
+		if !files[snippet.Filename] {
+			updated.snippets[id] = snippet
+		}
+	}
+	if len(updated.snippets) == len(project.snippets) {
+		return nil
+	}
+
+	if err := d.write(updated); err != nil {
+		return err
+	}
+	d.projects[projectId] = updated
+	return nil
+}
+
+// ListProjects reads the header of every project file.
+func (d *DiskRepository) ListProjects(ctx context.Context) ([]models.ProjectIndex, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	projects := make([]models.ProjectIndex, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), diskProjectExtension) {
+			continue
+		}
+		header, err := d.readHeader(filepath.Join(d.dir, entry.Name()))
+		if errors.Is(err, fs.ErrNotExist) {
+			// deleted meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, models.ProjectIndex{ProjectId: header.ProjectId, IndexedAt: header.IndexedAt, Commit: header.Commit})
+	}
+	return projects, nil
+}
+
+// load reads the project file unless the project is already in memory. The caller holds the write lock.
+func (d *DiskRepository) load(projectId string) (*diskProject, error) {
+	if project, ok := d.projects[projectId]; ok {
+		return project, nil
+	}
+
+	project := &diskProject{snippets: make(map[string]*models.Snippet)}
+	file, err := os.Open(d.path(projectId))
+	if errors.Is(err, fs.ErrNotExist) {
+		return project, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder := gob.NewDecoder(file)
+	if err := decoder.Decode(&project.header); err != nil {
+		return nil, fmt.Errorf("failed to read embeddings of project %s: %w", projectId, err)
+	}
+	if err := decoder.Decode(&project.snippets); err != nil {
+		return nil, fmt.Errorf("failed to read embeddings of project %s: %w", projectId, err)
+	}
+	d.projects[projectId] = project
+	return project, nil
+}
+
+func (d *DiskRepository) readHeader(path string) (diskProjectHeader, error) {
+	var header diskProjectHeader
+	file, err := os.Open(path)
+	if err != nil {
+		return header, err
+	}
+	defer file.Close()
+
+	if err := gob.NewDecoder(file).Decode(&header); err != nil {
+		return header, fmt.Errorf("failed to read embeddings file %s: %w", path, err)
+	}
+	return header, nil
+}
+
+// write replaces the project file at once, so a crash never leaves a partial file behind.
+func (d *DiskRepository) write(project *diskProject) error {
+	file, err := os.CreateTemp(d.dir, "embeddings-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	encoder := gob.NewEncoder(file)
+	err = encoder.Encode(project.header)
+	if err == nil {
+		err = encoder.Encode(project.snippets)
+	}
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to write embeddings of project %s: %w", project.header.ProjectId, err)
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), d.path(project.header.ProjectId))
+}
+
+func (d *DiskRepository) path(projectId string) string {
+	sum := sha256.Sum256([]byte(projectId))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:16])+diskProjectExtension)
+}
+
+func (d *DiskRepository) Health(context.Context) error {
+	_, err := os.Stat(d.dir)
+	return err
+}
+
+func (d *DiskRepository) Close() error {
+	return nil
+}
@@ -2,42 +2,105 @@ package repositories
 
 import (
 	"context"
+	"fmt"
 	chroma "github.com/amikos-tech/chroma-go/pkg/api/v2"
 	"github.com/amikos-tech/chroma-go/pkg/embeddings"
 	"go_code_reviewer/pkg/log"
+	"go_code_reviewer/pkg/retry"
 	"go_code_reviewer/services/code-reviewer/internal/models"
+	"sort"
+	"time"
 )
 
 type EmbeddingsRepository interface {
 	Add(ctx context.Context, snippets []*models.Snippet, projectId string) error
 	GetNearestRecord(ctx context.Context, vectorEmbedding []float32, nResult int, projectId string) ([]*models.Snippet, error)
+	// DeleteProject removes every snippet of the project. Deleting an unknown project is not an error.
+	DeleteProject(ctx context.Context, projectId string) error
+	// DeleteFiles removes the snippets of filenames from the project, e.g. before the files are indexed again.
+	DeleteFiles(ctx context.Context, projectId string, filenames []string) error
+	ListProjects(ctx context.Context) ([]models.ProjectIndex, error)
 }
 
-const projectIdKey = "project_id"
+// VectorStore is an EmbeddingsRepository backend the service connects to, selected by config.VectorStoreSection.
+// Every backend passes testkit.RunEmbeddingsRepositoryConformance.
+type VectorStore interface {
+	EmbeddingsRepository
+	Health(ctx context.Context) error
+	Close() error
+}
 
-type EmbeddingRepositoryImpl struct {
-	ChromaCollection chroma.Collection
+const (
+	projectIdKey = "project_id"
+	filenameKey  = "filename"
+	indexedAtKey = "indexed_at"
+	commitKey    = "commit"
+	// listPageSize is the number of snippets read at once to list the projects of a store.
+	listPageSize = 1000
+	// projectsCollectionSuffix names the Chroma collection holding a document per project next to its snippets.
+	projectsCollectionSuffix = "_projects"
+)
+
+// projectEmbedding is the embedding of the project documents, which are only read by id and never searched.
+var projectEmbedding = []float32{0}
+
+type repositoryOptions struct {
+	breaker *retry.Breaker
 }
 
-func NewEmbeddingRepository(chromaClient chroma.Client, embeddingFunction embeddings.EmbeddingFunction, collectionName string) EmbeddingsRepository {
-	var chromaCollection chroma.Collection
-	var err error
+type EmbeddingRepositoryOption func(options *repositoryOptions)
+
+// WithBreaker fails the calls to the vector store fast while the breaker is open.
+func WithBreaker(breaker *retry.Breaker) EmbeddingRepositoryOption {
+	return func(options *repositoryOptions) {
+		options.breaker = breaker
+	}
+}
+
+func newRepositoryOptions(opts []EmbeddingRepositoryOption) repositoryOptions {
+	var options repositoryOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
 
+func (o repositoryOptions) guard(fn func() error) error {
+	if o.breaker == nil {
+		return fn()
+	}
+	return o.breaker.Do(fn)
+}
+
+// EmbeddingRepositoryImpl stores the embeddings in a Chroma collection. A second collection keeps a document per
+// project with its latest index time and commit, so listing the projects does not read every snippet.
+type EmbeddingRepositoryImpl struct {
+	ChromaCollection   chroma.Collection
+	ProjectsCollection chroma.Collection
+	chromaClient       chroma.Client
+	repositoryOptions
+}
+
+func NewEmbeddingRepository(chromaClient chroma.Client, embeddingFunction embeddings.EmbeddingFunction, collectionName string, opts ...EmbeddingRepositoryOption) (VectorStore, error) {
+	var createOptions []chroma.CreateCollectionOption
 	if embeddingFunction != nil {
-		chromaCollection, err = chromaClient.GetOrCreateCollection(context.Background(), collectionName, chroma.WithEmbeddingFunctionCreate(embeddingFunction))
-		if err != nil {
-			log.GetLogger().WithError(err).Fatal("failed to get GetOrCreateCollection")
-		}
-	} else {
-		chromaCollection, err = chromaClient.GetOrCreateCollection(context.Background(), collectionName)
-		if err != nil {
-			log.GetLogger().WithError(err).Fatal("failed to get GetOrCreateCollection")
-		}
+		createOptions = append(createOptions, chroma.WithEmbeddingFunctionCreate(embeddingFunction))
+	}
+	chromaCollection, err := chromaClient.GetOrCreateCollection(context.Background(), collectionName, createOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get or create chroma collection %s: %w", collectionName, err)
+	}
+	projectsCollection, err := chromaClient.GetOrCreateCollection(context.Background(), collectionName+projectsCollectionSuffix, createOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get or create chroma collection %s: %w", collectionName+projectsCollectionSuffix, err)
 	}
 
 	return &EmbeddingRepositoryImpl{
-		ChromaCollection: chromaCollection,
-	}
+		ChromaCollection:   chromaCollection,
+		ProjectsCollection: projectsCollection,
+		chromaClient:       chromaClient,
+		repositoryOptions:  newRepositoryOptions(opts),
+	}, nil
 }
 
 func (p *EmbeddingRepositoryImpl) Add(ctx context.Context, snippets []*models.Snippet, projectId string) error {
@@ -45,35 +108,65 @@ func (p *EmbeddingRepositoryImpl) Add(ctx context.Context, snippets []*models.Sn
 	var documents []string
 	var embeddingsList embeddings.Embeddings
 	var metadataList []chroma.DocumentMetadata
+	indexedAt := time.Now().Unix()
 
 	for _, snippet := range snippets {
 		ids = append(ids, chroma.DocumentID(snippet.ID))
 		documents = append(documents, snippet.Content)
 		embeddingsList = append(embeddingsList, embeddings.NewEmbeddingFromFloat32(snippet.Embedding))
 		metadataList = append(metadataList, chroma.NewMetadata(
-			chroma.NewStringAttribute("filename", snippet.Filename),
+			chroma.NewStringAttribute(filenameKey, snippet.Filename),
 			chroma.NewStringAttribute("language", snippet.Language),
 			chroma.NewStringAttribute(projectIdKey, projectId),
+			chroma.NewIntAttribute(indexedAtKey, indexedAt),
+			chroma.NewStringAttribute(commitKey, snippet.Commit),
 		))
 	}
 
-	return p.ChromaCollection.Add(
-		ctx,
-		chroma.WithIDs(ids...),
-		chroma.WithEmbeddings(embeddingsList...),
-		chroma.WithTexts(documents...),
-		chroma.WithMetadatas(metadataList...),
-	)
+	err := p.guard(func() error {
+		return p.ChromaCollection.Add(
+			ctx,
+			chroma.WithIDs(ids...),
+			chroma.WithEmbeddings(embeddingsList...),
+			chroma.WithTexts(documents...),
+			chroma.WithMetadatas(metadataList...),
+		)
+	})
+	if err != nil || len(snippets) == 0 {
+		return err
+	}
+	return p.saveProject(ctx, projectId, time.Unix(indexedAt, 0), commitOf(snippets))
+}
+
+// saveProject writes the document of the project, replacing its previous index time and commit.
+func (p *EmbeddingRepositoryImpl) saveProject(ctx context.Context, projectId string, indexedAt time.Time, commit string) error {
+	return p.guard(func() error {
+		return p.ProjectsCollection.Upsert(
+			ctx,
+			chroma.WithIDs(chroma.DocumentID(projectId)),
+			chroma.WithEmbeddings(embeddings.NewEmbeddingFromFloat32(projectEmbedding)),
+			chroma.WithMetadatas(chroma.NewMetadata(
+				chroma.NewStringAttribute(projectIdKey, projectId),
+				chroma.NewIntAttribute(indexedAtKey, indexedAt.Unix()),
+				chroma.NewStringAttribute(commitKey, commit),
+			)),
+		)
+	})
 }
 
 func (p *EmbeddingRepositoryImpl) GetNearestRecord(ctx context.Context, vectorEmbedding []float32, nResult int, projectId string) ([]*models.Snippet, error) {
 	logger := log.GetLogger()
-	var results, err = p.ChromaCollection.Query(
-		ctx,
-		chroma.WithQueryEmbeddings(embeddings.NewEmbeddingFromFloat32(vectorEmbedding)),
-		chroma.WithNResults(nResult),
-		chroma.WithWhereQuery(chroma.EqString(projectIdKey, projectId)),
-	)
+	var results chroma.QueryResult
+	err := p.guard(func() error {
+		var err error
+		results, err = p.ChromaCollection.Query(
+			ctx,
+			chroma.WithQueryEmbeddings(embeddings.NewEmbeddingFromFloat32(vectorEmbedding)),
+			chroma.WithNResults(nResult),
+			chroma.WithWhereQuery(chroma.EqString(projectIdKey, projectId)),
+		)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -86,7 +179,7 @@ func (p *EmbeddingRepositoryImpl) GetNearestRecord(ctx context.Context, vectorEm
 
 	snippets := make([]*models.Snippet, 0)
 	for i, doc := range documents {
-		filename, _ := metadata[i].GetString("filename")
+		filename, _ := metadata[i].GetString(filenameKey)
 		language, _ := metadata[i].GetString("language")
 		snippets = append(snippets, &models.Snippet{
 			Content:  doc.ContentString(),
@@ -99,3 +192,153 @@ func (p *EmbeddingRepositoryImpl) GetNearestRecord(ctx context.Context, vectorEm
 
 	return snippets, nil
 }
+
+func (p *EmbeddingRepositoryImpl) DeleteProject(ctx context.Context, projectId string) error {
+	err := p.guard(func() error {
+		return p.ChromaCollection.Delete(ctx, chroma.WithWhereDelete(chroma.EqString(projectIdKey, projectId)))
+	})
+	if err != nil {
+		return err
+	}
+	// the document goes last, a failed delete leaves the project listed for the janitor to retry
+	return p.guard(func() error {
+		return p.ProjectsCollection.Delete(ctx, chroma.WithIDsDelete(chroma.DocumentID(projectId)))
+	})
+}
+
+func (p *EmbeddingRepositoryImpl) DeleteFiles(ctx context.Context, projectId string, filenames []string) error {
+	if len(filenames) == 0 {
+		return nil
+	}
+	return p.guard(func() error {
+		return p.ChromaCollection.Delete(ctx, chroma.WithWhereDelete(chroma.And(
+			chroma.EqString(projectIdKey, projectId),
+			chroma.InString(filenameKey, filenames...),
+		)))
+	})
+}
+
+// ListProjects reads the project documents. Stores written before they were kept have none yet: their
+// snippets are read once to write them.
+func (p *EmbeddingRepositoryImpl) ListProjects(ctx context.Context) ([]models.ProjectIndex, error) {
+	projects := newProjectIndexes()
+	var count int
+	err := p.guard(func() error {
+		var err error
+		count, err = p.ProjectsCollection.Count(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return p.backfillProjects(ctx)
+	}
+
+	for offset := 0; offset < count; offset += listPageSize {
+		page, err := p.getPage(ctx, p.ProjectsCollection, offset)
+		if err != nil {
+			return nil, err
+		}
+		addProjects(projects, page)
+		if page.Count() < listPageSize {
+			break
+		}
+	}
+	return projects.list(), nil
+}
+
+// backfillProjects pages through the metadata of every snippet and writes the document of each project found.
+// Snippets added before their index time was stored count as indexed at the zero time.
+func (p *EmbeddingRepositoryImpl) backfillProjects(ctx context.Context) ([]models.ProjectIndex, error) {
+	projects := newProjectIndexes()
+	for offset := 0; ; offset += listPageSize {
+		page, err := p.getPage(ctx, p.ChromaCollection, offset)
+		if err != nil {
+			return nil, err
+		}
+		addProjects(projects, page)
+		if page.Count() < listPageSize {
+			break
+		}
+	}
+
+	list := projects.list()
+	for _, project := range list {
+		if err := p.saveProject(ctx, project.ProjectId, project.IndexedAt, project.Commit); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+func (p *EmbeddingRepositoryImpl) getPage(ctx context.Context, collection chroma.Collection, offset int) (chroma.GetResult, error) {
+	var page chroma.GetResult
+	err := p.guard(func() error {
+		var err error
+		page, err = collection.Get(ctx,
+			chroma.WithIncludeGet(chroma.IncludeMetadatas),
+			chroma.WithLimitGet(listPageSize),
+			chroma.WithOffsetGet(offset),
+		)
+		return err
+	})
+	return page, err
+}
+
+func addProjects(projects projectIndexes, page chroma.GetResult) {
+	for _, metadata := range page.GetMetadatas() {
+		projectId, ok := metadata.GetString(projectIdKey)
+		if !ok {
+			continue
+		}
+		var indexedAt time.Time
+		if unix, ok := metadata.GetInt(indexedAtKey); ok {
+			indexedAt = time.Unix(unix, 0)
+		}
+		commit, _ := metadata.GetString(commitKey)
+		projects.add(projectId, indexedAt, commit)
+	}
+}
+
+func (p *EmbeddingRepositoryImpl) Health(ctx context.Context) error {
+	return p.chromaClient.Heartbeat(ctx)
+}
+
+func (p *EmbeddingRepositoryImpl) Close() error {
+	return p.chromaClient.Close()
+}
+
+// projectIndexes collects the latest index time of every project and the commit indexed then.
+type projectIndexes map[string]models.ProjectIndex
+
+func newProjectIndexes() projectIndexes {
+	return make(projectIndexes)
+}
+
+func (p projectIndexes) add(projectId string, indexedAt time.Time, commit string) {
+	if latest, ok := p[projectId]; !ok || indexedAt.After(latest.IndexedAt) {
+		p[projectId] = models.ProjectIndex{ProjectId: projectId, IndexedAt: indexedAt, Commit: commit}
+	}
+}
+
+func (p projectIndexes) list() []models.ProjectIndex {
+	projects := make([]models.ProjectIndex, 0, len(p))
+	for _, project := range p {
+		projects = append(projects, project)
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		return projects[i].ProjectId < projects[j].ProjectId
+	})
+	return projects
+}
+
+// commitOf returns the commit of the snippets added together, empty if they carry none.
+func commitOf(snippets []*models.Snippet) string {
+	for _, snippet := range snippets {
+		if snippet.Commit != "" {
+			return snippet.Commit
+		}
+	}
+	return ""
+}
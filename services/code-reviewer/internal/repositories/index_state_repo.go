@@ -0,0 +1,94 @@
+package repositories
+
+import (
+	"context"
+	"go_code_reviewer/services/code-reviewer/internal/models"
+	"sync"
+)
+
+// IndexStateRepository remembers the commit the base index of a branch was last built from, so a push only
+// indexes the files changed since.
+type IndexStateRepository interface {
+	GetIndexedSHA(ctx context.Context, projectId string) (string, bool, error)
+	SetIndexedSHA(ctx context.Context, projectId, sha string) error
+}
+
+// IndexRestorer is an EmbeddingsRepository keeping in-memory indexes next to the stored snippets, which are empty
+// after a restart while the snippets are still stored.
+type IndexRestorer interface {
+	// Restored reports whether the indexes hold the project.
+	Restored(projectId string) bool
+	// Restore adds the stored snippets of the project to the indexes only.
+	Restore(projectId string, snippets []*models.Snippet)
+}
+
+type InMemoryIndexStateRepository struct {
+	mu      sync.RWMutex
+	indexes map[string]string
+}
+
+func NewInMemoryIndexStateRepository() IndexStateRepository {
+	return &InMemoryIndexStateRepository{
+		indexes: make(map[string]string),
+	}
+}
+
+func (r *InMemoryIndexStateRepository) GetIndexedSHA(_ context.Context, projectId string) (string, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sha, ok := r.indexes[projectId]
+	return sha, ok, nil
+}
+
+func (r *InMemoryIndexStateRepository) SetIndexedSHA(_ context.Context, projectId, sha string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.indexes[projectId] = sha
+	return nil
+}
+
+// EmbeddingsIndexStateRepository reads the indexed commits back from the embeddings, where the snippets of a base
+// index carry the commit they were parsed at, so a restart resumes from the stored index instead of embedding
+// the branch again. The commits are listed once and then kept in memory. Other replicas may index a branch
+// meanwhile, the next push then indexes the files changed since an older commit again, which is redundant but
+// leaves the same index.
+type EmbeddingsIndexStateRepository struct {
+	repository EmbeddingsRepository
+
+	mu      sync.Mutex
+	loaded  bool
+	indexes map[string]string
+}
+
+func NewEmbeddingsIndexStateRepository(repository EmbeddingsRepository) IndexStateRepository {
+	return &EmbeddingsIndexStateRepository{
+		repository: repository,
+		indexes:    make(map[string]string),
+	}
+}
+
+func (r *EmbeddingsIndexStateRepository) GetIndexedSHA(ctx context.Context, projectId string) (string, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.loaded {
+		projects, err := r.repository.ListProjects(ctx)
+		if err != nil {
+			return "", false, err
+		}
+		for _, project := range projects {
+			if _, ok := r.indexes[project.ProjectId]; !ok && project.Commit != "" {
+				r.indexes[project.ProjectId] = project.Commit
+			}
+		}
+		r.loaded = true
+	}
+	sha, ok := r.indexes[projectId]
+	return sha, ok, nil
+}
+
+func (r *EmbeddingsIndexStateRepository) SetIndexedSHA(_ context.Context, projectId, sha string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.indexes[projectId] = sha
+	return nil
+}
@@ -0,0 +1,264 @@
+package repositories
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"go_code_reviewer/services/code-reviewer/internal/models"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// MemoryRepository is an in-process EmbeddingsRepository with exact search, for tests and small deployments.
+// With a snapshot file it is restored on creation and saved on Snapshot and Close.
+type MemoryRepository struct {
+	metric   Metric
+	snapshot string
+
+	mu        sync.RWMutex
+	snippets  []*models.Snippet
+	index     map[snippetKey]int
+	indexedAt map[string]time.Time
+	// commits is the commit of the snippets last added to each project.
+	commits map[string]string
+}
+
+// memorySnapshot is the content of the snapshot file.
+type memorySnapshot struct {
+	Snippets  []*models.Snippet
+	IndexedAt map[string]time.Time
+	Commits   map[string]string
+}
+
+type snippetKey struct {
+	projectId string
+	id        string
+}
+
+type MemoryRepositoryOption func(repository *MemoryRepository)
+
+// WithMetric ranks the snippets by metric instead of cosine similarity.
+func WithMetric(metric Metric) MemoryRepositoryOption {
+	return func(repository *MemoryRepository) {
+		repository.metric = metric
+	}
+}
+
+// WithSnapshot restores the snippets from path, when it exists, and saves them there on Snapshot and Close.
+func WithSnapshot(path string) MemoryRepositoryOption {
+	return func(repository *MemoryRepository) {
+		repository.snapshot = path
+	}
+}
+
+func NewMemoryRepository(opts ...MemoryRepositoryOption) (*MemoryRepository, error) {
+	repository := &MemoryRepository{
+		metric:    MetricCosine,
+		index:     make(map[snippetKey]int),
+		indexedAt: make(map[string]time.Time),
+		commits:   make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(repository)
+	}
+	if repository.metric != MetricCosine && repository.metric != MetricDot {
+		return nil, fmt.Errorf("unknown similarity metric %q", repository.metric)
+	}
+	if err := repository.restore(); err != nil {
+		return nil, err
+	}
+	return repository, nil
+}
+
+// Add replaces the snippets of the same id in the project.
+func (m *MemoryRepository) Add(ctx context.Context, snippets []*models.Snippet, projectId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, snippet := range snippets {
+		stored := *snippet
+		stored.ProjectId = projectId
+		m.put(&stored)
+	}
+	m.indexedAt[projectId] = time.Now()
+	m.commits[projectId] = commitOf(snippets)
+	return nil
+}
+
+func (m *MemoryRepository) GetNearestRecord(ctx context.Context, vectorEmbedding []float32, nResult int, projectId string) ([]*models.Snippet, error) {
+	results := m.Search(vectorEmbedding, nResult, map[string]string{projectIdKey: projectId})
+	snippets := make([]*models.Snippet, 0, len(results))
+	for _, result := range results {
+		snippets = append(snippets, result.Snippet)
+	}
+	return snippets, nil
+}
+
+// Search returns the nResult snippets most similar to vectorEmbedding with their scores, among those whose
+// metadata equals every value of where. The keys are project_id, filename and language.
+func (m *MemoryRepository) Search(vectorEmbedding []float32, nResult int, where map[string]string) []ScoredSnippet {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	candidates := make([]*models.Snippet, 0, len(m.snippets))
+	for _, snippet := range m.snippets {
+		if matches(snippet, where) {
+			candidates = append(candidates, snippet)
+		}
+	}
+	return rank(m.metric, vectorEmbedding, nResult, candidates)
+}
+
+func (m *MemoryRepository) DeleteProject(ctx context.Context, projectId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(func(snippet *models.Snippet) bool {
+		return snippet.ProjectId == projectId
+	})
+	delete(m.indexedAt, projectId)
+	delete(m.commits, projectId)
+	return nil
+}
+
+func (m *MemoryRepository) DeleteFiles(ctx context.Context, projectId string, filenames []string) error {
+	files := make(map[string]bool, len(filenames))
+	for _, filename := range filenames {
+		files[filename] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(func(snippet *models.Snippet) bool {
+		return snippet.ProjectId == projectId && files[snippet.Filename]
+	})
+	return nil
+}
+
+func (m *MemoryRepository) ListProjects(ctx context.Context) ([]models.ProjectIndex, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	projects := newProjectIndexes()
+	for projectId, indexedAt := range m.indexedAt {
+		projects.add(projectId, indexedAt, m.commits[projectId])
+	}
+	return projects.list(), nil
+}
+
+// Len returns the number of snippets of every project.
+func (m *MemoryRepository) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.snippets)
+}
+
+// Snapshot saves the snippets to the snapshot file, replacing it at once. Without one it does nothing.
+func (m *MemoryRepository) Snapshot() error {
+	if m.snapshot == "" {
+		return nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	file, err := os.CreateTemp(filepath.Dir(m.snapshot), filepath.Base(m.snapshot)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if err := gob.NewEncoder(file).Encode(memorySnapshot{Snippets: m.snippets, IndexedAt: m.indexedAt, Commits: m.commits}); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to write embeddings snapshot: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), m.snapshot)
+}
+
+func (m *MemoryRepository) restore() error {
+	if m.snapshot == "" {
+		return nil
+	}
+	file, err := os.Open(m.snapshot)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var snapshot memorySnapshot
+	if err := gob.NewDecoder(file).Decode(&snapshot); err != nil {
+		return fmt.Errorf("failed to read embeddings snapshot %s: %w", m.snapshot, err)
+	}
+	for _, snippet := range snapshot.Snippets {
+		m.put(snippet)
+	}
+	for projectId, indexedAt := range snapshot.IndexedAt {
+		m.indexedAt[projectId] = indexedAt
+	}
+	for projectId, commit := range snapshot.Commits {
+		m.commits[projectId] = commit
+	}
+	return nil
+}
+
+// remove compacts the snippets matching removed away and rebuilds the index. The caller holds the write lock.
+func (m *MemoryRepository) remove(removed func(snippet *models.Snippet) bool) {
+	kept := m.snippets[:0]
+	for _, snippet := range m.snippets {
+		if !removed(snippet) {
+			kept = append(kept, snippet)
+		}
+	}
+	clear(m.snippets[len(kept):])
+	m.snippets = kept
+	m.index = make(map[snippetKey]int, len(kept))
+	for i, snippet := range kept {
+		m.index[snippetKey{projectId: snippet.ProjectId, id: snippet.ID}] = i
+	}
+}
+
+func (m *MemoryRepository) put(snippet *models.Snippet) {
+	key := snippetKey{projectId: snippet.ProjectId, id: snippet.ID}
+	if i, ok := m.index[key]; ok {
+		m.snippets[i] = snippet
+		return
+	}
+	m.index[key] = len(m.snippets)
+	m.snippets = append(m.snippets, snippet)
+}
+
+func (m *MemoryRepository) Health(context.Context) error {
+	return nil
+}
+
+func (m *MemoryRepository) Close() error {
+	return m.Snapshot()
+}
+
+func matches(snippet *models.Snippet, where map[string]string) bool {
+	for key, value := range where {
+		var actual string
+		switch key {
+		case projectIdKey:
+			actual = snippet.ProjectId
+		case "filename":
+			actual = snippet.Filename
+		case "language":
+			actual = snippet.Language
+		default:
+			return false
+		}
+		if actual != value {
+			return false
+		}
+	}
+	return true
+}
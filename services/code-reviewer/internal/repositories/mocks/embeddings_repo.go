@@ -55,6 +55,34 @@ func (mr *MockEmbeddingsRepositoryMockRecorder) Add(ctx, snippets, projectId any
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Add", reflect.TypeOf((*MockEmbeddingsRepository)(nil).Add), ctx, snippets, projectId)
 }
 
+// DeleteFiles mocks base method.
+func (m *MockEmbeddingsRepository) DeleteFiles(ctx context.Context, projectId string, filenames []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteFiles", ctx, projectId, filenames)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteFiles indicates an expected call of DeleteFiles.
+func (mr *MockEmbeddingsRepositoryMockRecorder) DeleteFiles(ctx, projectId, filenames any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteFiles", reflect.TypeOf((*MockEmbeddingsRepository)(nil).DeleteFiles), ctx, projectId, filenames)
+}
+
+// DeleteProject mocks base method.
+func (m *MockEmbeddingsRepository) DeleteProject(ctx context.Context, projectId string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteProject", ctx, projectId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteProject indicates an expected call of DeleteProject.
+func (mr *MockEmbeddingsRepositoryMockRecorder) DeleteProject(ctx, projectId any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteProject", reflect.TypeOf((*MockEmbeddingsRepository)(nil).DeleteProject), ctx, projectId)
+}
+
 // GetNearestRecord mocks base method.
 func (m *MockEmbeddingsRepository) GetNearestRecord(ctx context.Context, vectorEmbedding []float32, nResult int, projectId string) ([]*models.Snippet, error) {
 	m.ctrl.T.Helper()
@@ -69,3 +97,18 @@ func (mr *MockEmbeddingsRepositoryMockRecorder) GetNearestRecord(ctx, vectorEmbe
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNearestRecord", reflect.TypeOf((*MockEmbeddingsRepository)(nil).GetNearestRecord), ctx, vectorEmbedding, nResult, projectId)
 }
+
+// ListProjects mocks base method.
+func (m *MockEmbeddingsRepository) ListProjects(ctx context.Context) ([]models.ProjectIndex, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListProjects", ctx)
+	ret0, _ := ret[0].([]models.ProjectIndex)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListProjects indicates an expected call of ListProjects.
+func (mr *MockEmbeddingsRepositoryMockRecorder) ListProjects(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListProjects", reflect.TypeOf((*MockEmbeddingsRepository)(nil).ListProjects), ctx)
+}
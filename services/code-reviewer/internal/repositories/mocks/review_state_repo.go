@@ -0,0 +1,85 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: /Users/saeedzare/go_code_reviewer/services/code-reviewer/internal/repositories/review_state_repo.go
+//
+// Generated by this command:
+//
+//	mockgen -source=/Users/saeedzare/go_code_reviewer/services/code-reviewer/internal/repositories/review_state_repo.go -destination=/Users/saeedzare/go_code_reviewer/services/code-reviewer/internal/repositories/mocks/review_state_repo.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockReviewStateRepository is a mock of ReviewStateRepository interface.
+type MockReviewStateRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockReviewStateRepositoryMockRecorder
+	isgomock struct{}
+}
+
+// MockReviewStateRepositoryMockRecorder is the mock recorder for MockReviewStateRepository.
+type MockReviewStateRepositoryMockRecorder struct {
+	mock *MockReviewStateRepository
+}
+
+// NewMockReviewStateRepository creates a new mock instance.
+func NewMockReviewStateRepository(ctrl *gomock.Controller) *MockReviewStateRepository {
+	mock := &MockReviewStateRepository{ctrl: ctrl}
+	mock.recorder = &MockReviewStateRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockReviewStateRepository) EXPECT() *MockReviewStateRepositoryMockRecorder {
+	return m.recorder
+}
+
+// DeleteLastReviewedSHA mocks base method.
+func (m *MockReviewStateRepository) DeleteLastReviewedSHA(ctx context.Context, pullRequestKey string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteLastReviewedSHA", ctx, pullRequestKey)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteLastReviewedSHA indicates an expected call of DeleteLastReviewedSHA.
+func (mr *MockReviewStateRepositoryMockRecorder) DeleteLastReviewedSHA(ctx, pullRequestKey any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteLastReviewedSHA", reflect.TypeOf((*MockReviewStateRepository)(nil).DeleteLastReviewedSHA), ctx, pullRequestKey)
+}
+
+// GetLastReviewedSHA mocks base method.
+func (m *MockReviewStateRepository) GetLastReviewedSHA(ctx context.Context, pullRequestKey string) (string, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLastReviewedSHA", ctx, pullRequestKey)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetLastReviewedSHA indicates an expected call of GetLastReviewedSHA.
+func (mr *MockReviewStateRepositoryMockRecorder) GetLastReviewedSHA(ctx, pullRequestKey any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLastReviewedSHA", reflect.TypeOf((*MockReviewStateRepository)(nil).GetLastReviewedSHA), ctx, pullRequestKey)
+}
+
+// SetLastReviewedSHA mocks base method.
+func (m *MockReviewStateRepository) SetLastReviewedSHA(ctx context.Context, pullRequestKey, sha string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetLastReviewedSHA", ctx, pullRequestKey, sha)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetLastReviewedSHA indicates an expected call of SetLastReviewedSHA.
+func (mr *MockReviewStateRepositoryMockRecorder) SetLastReviewedSHA(ctx, pullRequestKey, sha any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLastReviewedSHA", reflect.TypeOf((*MockReviewStateRepository)(nil).SetLastReviewedSHA), ctx, pullRequestKey, sha)
+}
@@ -0,0 +1,51 @@
+package repositories
+
+import (
+	"context"
+	"go_code_reviewer/services/code-reviewer/internal/models"
+)
+
+// Overlay is the index a pull request is reviewed against: the snippets of the files it changed on top of the
+// base index of the branch it merges into. Without a base project it is the pull request project alone.
+type Overlay struct {
+	ProjectId     string
+	BaseProjectId string
+	// ChangedFiles are shadowed in the base index, including the files the pull request deleted.
+	ChangedFiles []string
+}
+
+// GetNearestRecord returns the nResult nearest snippets of the overlay and the base index, taking them in turns
+// from both and the overlay first. Snippets of the base index from changed files are left out.
+func (o Overlay) GetNearestRecord(ctx context.Context, repository EmbeddingsRepository, vectorEmbedding []float32, nResult int) ([]*models.Snippet, error) {
+	overlay, err := repository.GetNearestRecord(ctx, vectorEmbedding, nResult, o.ProjectId)
+	if err != nil || o.BaseProjectId == "" {
+		return overlay, err
+	}
+
+	// ask for more, the shadowed snippets are dropped
+	base, err := repository.GetNearestRecord(ctx, vectorEmbedding, 2*nResult, o.BaseProjectId)
+	if err != nil {
+		return nil, err
+	}
+	shadowed := make(map[string]bool, len(o.ChangedFiles))
+	for _, filename := range o.ChangedFiles {
+		shadowed[filename] = true
+	}
+	visible := base[:0]
+	for _, snippet := range base {
+		if !shadowed[snippet.Filename] {
+			visible = append(visible, snippet)
+		}
+	}
+
+	snippets := make([]*models.Snippet, 0, nResult)
+	for i := 0; len(snippets) < nResult && (i < len(overlay) || i < len(visible)); i++ {
+		if i < len(overlay) {
+			snippets = append(snippets, overlay[i])
+		}
+		if i < len(visible) && len(snippets) < nResult {
+			snippets = append(snippets, visible[i])
+		}
+	}
+	return snippets, nil
+}
@@ -0,0 +1,229 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go_code_reviewer/services/code-reviewer/internal/models"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxIndexedDimensions is the largest embedding an HNSW index of pgvector accepts, larger ones are searched
+// exactly.
+const maxIndexedDimensions = 2000
+
+// PgvectorRepository stores the embeddings in a PostgreSQL table with the pgvector extension and ranks them
+// by cosine distance. The embedding column takes the size of the first embeddings added, which also creates
+// its HNSW index. The index is searched before the rows of other projects are filtered out; with many projects
+// in one table, enable hnsw.iterative_scan of pgvector 0.8 so a search still finds enough rows of its project.
+type PgvectorRepository struct {
+	pool  *pgxpool.Pool
+	table string
+	// index names the HNSW index of the embeddings and projectsIndex the one ListProjects reads.
+	index         string
+	projectsIndex string
+	repositoryOptions
+
+	mu         sync.Mutex
+	dimensions int
+}
+
+// NewPgvectorRepository connects to dsn and creates the extension and the table if they do not exist.
+func NewPgvectorRepository(ctx context.Context, dsn, table string, opts ...EmbeddingRepositoryOption) (VectorStore, error) {
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+
+	repository := &PgvectorRepository{
+		pool:              pool,
+		table:             pgx.Identifier{table}.Sanitize(),
+		index:             pgx.Identifier{table + "_embedding_idx"}.Sanitize(),
+		projectsIndex:     pgx.Identifier{table + "_indexed_at_idx"}.Sanitize(),
+		repositoryOptions: newRepositoryOptions(opts),
+	}
+	if err := repository.migrate(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return repository, nil
+}
+
+func (p *PgvectorRepository) migrate(ctx context.Context) error {
+	statements := []string{
+		"CREATE EXTENSION IF NOT EXISTS vector",
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			project_id TEXT NOT NULL,
+			id TEXT NOT NULL,
+			content TEXT NOT NULL,
+			filename TEXT NOT NULL,
+			language TEXT NOT NULL,
+			embedding VECTOR NOT NULL,
+			indexed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (project_id, id)
+		)`, p.table),
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS commit_sha TEXT NOT NULL DEFAULT ''", p.table),
+		// ListProjects reads the latest row of every project
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (project_id, indexed_at DESC)", p.projectsIndex, p.table),
+	}
+	for _, statement := range statements {
+		if _, err := p.pool.Exec(ctx, statement); err != nil {
+			return fmt.Errorf("failed to create pgvector table %s: %w", p.table, err)
+		}
+	}
+
+	// the type modifier of a vector column is its size, -1 until the first embeddings are added
+	var dimensions int
+	err := p.pool.QueryRow(ctx, "SELECT atttypmod FROM pg_attribute WHERE attrelid = $1::regclass AND attname = 'embedding'", p.table).Scan(&dimensions)
+	if err != nil {
+		return fmt.Errorf("failed to read the embedding size of %s: %w", p.table, err)
+	}
+	if dimensions <= 0 {
+		return nil
+	}
+	return p.setDimensions(ctx, dimensions)
+}
+
+// setDimensions sizes the embedding column and creates its index the first time embeddings are added. Embeddings
+// of another size than the column are rejected, e.g. after the embedding model changed.
+func (p *PgvectorRepository) setDimensions(ctx context.Context, dimensions int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.dimensions == dimensions {
+		return nil
+	}
+	if p.dimensions != 0 {
+		return fmt.Errorf("embeddings of %d dimensions do not fit the %d of %s", dimensions, p.dimensions, p.table)
+	}
+
+	statements := []string{fmt.Sprintf("ALTER TABLE %s ALTER COLUMN embedding TYPE VECTOR(%d)", p.table, dimensions)}
+	if dimensions <= maxIndexedDimensions {
+		statements = append(statements, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING hnsw (embedding vector_cosine_ops)", p.index, p.table))
+	}
+	for _, statement := range statements {
+		if _, err := p.pool.Exec(ctx, statement); err != nil {
+			return fmt.Errorf("failed to index the embeddings of %s: %w", p.table, err)
+		}
+	}
+	p.dimensions = dimensions
+	return nil
+}
+
+func (p *PgvectorRepository) Add(ctx context.Context, snippets []*models.Snippet, projectId string) error {
+	query := fmt.Sprintf(`INSERT INTO %s (project_id, id, content, filename, language, embedding, commit_sha)
+		VALUES ($1, $2, $3, $4, $5, $6::vector, $7)
+		ON CONFLICT (project_id, id) DO UPDATE
+		SET content = EXCLUDED.content, filename = EXCLUDED.filename, language = EXCLUDED.language, embedding = EXCLUDED.embedding, commit_sha = EXCLUDED.commit_sha, indexed_at = now()`, p.table)
+
+	if len(snippets) == 0 {
+		return nil
+	}
+	if err := p.guard(func() error { return p.setDimensions(ctx, len(snippets[0].Embedding)) }); err != nil {
+		return err
+	}
+
+	batch := &pgx.Batch{}
+	for _, snippet := range snippets {
+		batch.Queue(query, projectId, snippet.ID, snippet.Content, snippet.Filename, snippet.Language, vectorLiteral(snippet.Embedding), snippet.Commit)
+	}
+	return p.guard(func() error {
+		return p.pool.SendBatch(ctx, batch).Close()
+	})
+}
+
+func (p *PgvectorRepository) GetNearestRecord(ctx context.Context, vectorEmbedding []float32, nResult int, projectId string) ([]*models.Snippet, error) {
+	query := fmt.Sprintf(`SELECT id, content, filename, language FROM %s
+		WHERE project_id = $1
+		ORDER BY embedding <=> $2::vector
+		LIMIT $3`, p.table)
+
+	snippets := make([]*models.Snippet, 0)
+	err := p.guard(func() error {
+		rows, err := p.pool.Query(ctx, query, projectId, vectorLiteral(vectorEmbedding), nResult)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			snippet := &models.Snippet{ProjectId: projectId}
+			if err := rows.Scan(&snippet.ID, &snippet.Content, &snippet.Filename, &snippet.Language); err != nil {
+				return err
+			}
+			snippets = append(snippets, snippet)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snippets, nil
+}
+
+func (p *PgvectorRepository) DeleteProject(ctx context.Context, projectId string) error {
+	return p.guard(func() error {
+		_, err := p.pool.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE project_id = $1", p.table), projectId)
+		return err
+	})
+}
+
+func (p *PgvectorRepository) DeleteFiles(ctx context.Context, projectId string, filenames []string) error {
+	if len(filenames) == 0 {
+		return nil
+	}
+	return p.guard(func() error {
+		_, err := p.pool.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE project_id = $1 AND filename = ANY($2)", p.table), projectId, filenames)
+		return err
+	})
+}
+
+func (p *PgvectorRepository) ListProjects(ctx context.Context) ([]models.ProjectIndex, error) {
+	projects := make([]models.ProjectIndex, 0)
+	err := p.guard(func() error {
+		// the commit of the latest indexed row of every project
+		rows, err := p.pool.Query(ctx, fmt.Sprintf("SELECT DISTINCT ON (project_id) project_id, indexed_at, commit_sha FROM %s ORDER BY project_id, indexed_at DESC", p.table))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var project models.ProjectIndex
+			if err := rows.Scan(&project.ProjectId, &project.IndexedAt, &project.Commit); err != nil {
+				return err
+			}
+			projects = append(projects, project)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+func (p *PgvectorRepository) Health(ctx context.Context) error {
+	return p.pool.Ping(ctx)
+}
+
+func (p *PgvectorRepository) Close() error {
+	p.pool.Close()
+	return nil
+}
+
+// vectorLiteral formats an embedding in the text form of pgvector, e.g. [0.1,0.2].
+func vectorLiteral(embedding []float32) string {
+	var builder strings.Builder
+	builder.WriteByte('[')
+	for i, value := range embedding {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(strconv.FormatFloat(float64(value), 'f', -1, 32))
+	}
+	builder.WriteByte(']')
+	return builder.String()
+}
@@ -0,0 +1,311 @@
+package repositories
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go_code_reviewer/pkg/retry"
+	"go_code_reviewer/services/code-reviewer/internal/models"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// qdrantNamespace derives the point ids, qdrant accepts only UUIDs and integers.
+var qdrantNamespace = uuid.MustParse("6f1c5a0e-4a4e-4d1b-9a1e-2c7f0f5b8d3a")
+
+// QdrantRepository stores the embeddings in a Qdrant collection over its HTTP API. The collection is created
+// with the size of the first embeddings added and cosine distance.
+type QdrantRepository struct {
+	httpClient *http.Client
+	address    string
+	apiKey     string
+	collection string
+	repositoryOptions
+
+	mu      sync.Mutex
+	created bool
+}
+
+func NewQdrantRepository(httpClient *http.Client, address, apiKey, collection string, opts ...EmbeddingRepositoryOption) (VectorStore, error) {
+	if address == "" || collection == "" {
+		return nil, fmt.Errorf("qdrant address and collection are required")
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &QdrantRepository{
+		httpClient:        httpClient,
+		address:           strings.TrimSuffix(address, "/"),
+		apiKey:            apiKey,
+		collection:        collection,
+		repositoryOptions: newRepositoryOptions(opts),
+	}, nil
+}
+
+type qdrantPoint struct {
+	ID      string         `json:"id"`
+	Vector  []float32      `json:"vector"`
+	Payload map[string]any `json:"payload"`
+}
+
+type qdrantScoredPoint struct {
+	Payload struct {
+		ID        string `json:"id"`
+		Content   string `json:"content"`
+		Filename  string `json:"filename"`
+		Language  string `json:"language"`
+		ProjectId string `json:"project_id"`
+	} `json:"payload"`
+}
+
+func (q *QdrantRepository) Add(ctx context.Context, snippets []*models.Snippet, projectId string) error {
+	if len(snippets) == 0 {
+		return nil
+	}
+
+	indexedAt := time.Now().Unix()
+	points := make([]qdrantPoint, 0, len(snippets))
+	for _, snippet := range snippets {
+		points = append(points, qdrantPoint{
+			ID:     uuid.NewSHA1(qdrantNamespace, []byte(projectId+"/"+snippet.ID)).String(),
+			Vector: snippet.Embedding,
+			Payload: map[string]any{
+				"id":         snippet.ID,
+				"content":    snippet.Content,
+				filenameKey:  snippet.Filename,
+				"language":   snippet.Language,
+				projectIdKey: projectId,
+				indexedAtKey: indexedAt,
+				commitKey:    snippet.Commit,
+			},
+		})
+	}
+
+	return q.guard(func() error {
+		if err := q.createCollection(ctx, len(snippets[0].Embedding)); err != nil {
+			return err
+		}
+		return q.do(ctx, http.MethodPut, "/points?wait=true", map[string]any{"points": points}, nil)
+	})
+}
+
+func (q *QdrantRepository) GetNearestRecord(ctx context.Context, vectorEmbedding []float32, nResult int, projectId string) ([]*models.Snippet, error) {
+	request := map[string]any{
+		"vector":       vectorEmbedding,
+		"limit":        nResult,
+		"with_payload": true,
+		"filter":       projectFilter(projectId),
+	}
+
+	var response struct {
+		Result []qdrantScoredPoint `json:"result"`
+	}
+	err := q.guard(func() error {
+		return q.do(ctx, http.MethodPost, "/points/search", request, &response)
+	})
+	if isQdrantNotFound(err) {
+		// nothing was added yet
+		return []*models.Snippet{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snippets := make([]*models.Snippet, 0, len(response.Result))
+	for _, point := range response.Result {
+		snippets = append(snippets, &models.Snippet{
+			ID:        point.Payload.ID,
+			Content:   point.Payload.Content,
+			Filename:  point.Payload.Filename,
+			Language:  point.Payload.Language,
+			ProjectId: point.Payload.ProjectId,
+		})
+	}
+	return snippets, nil
+}
+
+func (q *QdrantRepository) DeleteProject(ctx context.Context, projectId string) error {
+	err := q.guard(func() error {
+		return q.do(ctx, http.MethodPost, "/points/delete?wait=true", map[string]any{"filter": projectFilter(projectId)}, nil)
+	})
+	if isQdrantNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (q *QdrantRepository) DeleteFiles(ctx context.Context, projectId string, filenames []string) error {
+	if len(filenames) == 0 {
+		return nil
+	}
+	filter := map[string]any{
+		"must": []any{
+			map[string]any{"key": projectIdKey, "match": map[string]any{"value": projectId}},
+			map[string]any{"key": filenameKey, "match": map[string]any{"any": filenames}},
+		},
+	}
+	err := q.guard(func() error {
+		return q.do(ctx, http.MethodPost, "/points/delete?wait=true", map[string]any{"filter": filter}, nil)
+	})
+	if isQdrantNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// ListProjects scrolls through the payload of every point.
+func (q *QdrantRepository) ListProjects(ctx context.Context) ([]models.ProjectIndex, error) {
+	projects := newProjectIndexes()
+	var offset any
+	for {
+		request := map[string]any{
+			"limit":        listPageSize,
+			"with_payload": []string{projectIdKey, indexedAtKey, commitKey},
+			"with_vector":  false,
+		}
+		if offset != nil {
+			request["offset"] = offset
+		}
+
+		var response struct {
+			Result struct {
+				Points []struct {
+					Payload struct {
+						ProjectId string `json:"project_id"`
+						IndexedAt int64  `json:"indexed_at"`
+						Commit    string `json:"commit"`
+					} `json:"payload"`
+				} `json:"points"`
+				NextPageOffset any `json:"next_page_offset"`
+			} `json:"result"`
+		}
+		err := q.guard(func() error {
+			return q.do(ctx, http.MethodPost, "/points/scroll", request, &response)
+		})
+		if isQdrantNotFound(err) {
+			return projects.list(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, point := range response.Result.Points {
+			projects.add(point.Payload.ProjectId, time.Unix(point.Payload.IndexedAt, 0), point.Payload.Commit)
+		}
+		if response.Result.NextPageOffset == nil {
+			return projects.list(), nil
+		}
+		offset = response.Result.NextPageOffset
+	}
+}
+
+func projectFilter(projectId string) map[string]any {
+	return map[string]any{
+		"must": []any{
+			map[string]any{"key": projectIdKey, "match": map[string]any{"value": projectId}},
+		},
+	}
+}
+
+// createCollection creates the collection and the index of the project id once, unless it already exists.
+func (q *QdrantRepository) createCollection(ctx context.Context, size int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.created {
+		return nil
+	}
+
+	err := q.do(ctx, http.MethodGet, "", nil, nil)
+	if isQdrantNotFound(err) {
+		err = q.do(ctx, http.MethodPut, "", map[string]any{
+			"vectors": map[string]any{"size": size, "distance": "Cosine"},
+		}, nil)
+		if err == nil {
+			err = q.do(ctx, http.MethodPut, "/index?wait=true", map[string]any{
+				"field_name":   projectIdKey,
+				"field_schema": "keyword",
+			}, nil)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create qdrant collection %s: %w", q.collection, err)
+	}
+	q.created = true
+	return nil
+}
+
+func (q *QdrantRepository) Health(ctx context.Context) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, q.address+"/healthz", nil)
+	if err != nil {
+		return err
+	}
+	return q.send(request, nil)
+}
+
+func (q *QdrantRepository) Close() error {
+	q.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// do calls path of the collection with body as JSON and decodes the response into result, when not nil.
+func (q *QdrantRepository) do(ctx context.Context, method, path string, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, q.address+"/collections/"+url.PathEscape(q.collection)+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	return q.send(request, result)
+}
+
+func (q *QdrantRepository) send(request *http.Request, result any) error {
+	if q.apiKey != "" {
+		request.Header.Set("api-key", q.apiKey)
+	}
+	response, err := q.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+		return retry.ClassifyResponse(response, &QdrantError{StatusCode: response.StatusCode, Body: strings.TrimSpace(string(body))})
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// QdrantError is a failed response of Qdrant.
+type QdrantError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *QdrantError) Error() string {
+	return fmt.Sprintf("unexpected qdrant response: %d: %s", e.StatusCode, e.Body)
+}
+
+func isQdrantNotFound(err error) bool {
+	var qdrantErr *QdrantError
+	return errors.As(err, &qdrantErr) && qdrantErr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,94 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	pkgredis "go_code_reviewer/pkg/redis"
+	"sync"
+	"time"
+)
+
+const reviewStateKeyPrefix = "code-reviewer:reviewed-sha:"
+
+type ReviewStateRepository interface {
+	GetLastReviewedSHA(ctx context.Context, pullRequestKey string) (string, bool, error)
+	SetLastReviewedSHA(ctx context.Context, pullRequestKey, sha string) error
+	// DeleteLastReviewedSHA forgets the pull request, e.g. once it is closed.
+	DeleteLastReviewedSHA(ctx context.Context, pullRequestKey string) error
+}
+
+type InMemoryReviewStateRepository struct {
+	mu      sync.RWMutex
+	reviews map[string]string
+}
+
+func NewInMemoryReviewStateRepository() ReviewStateRepository {
+	return &InMemoryReviewStateRepository{
+		reviews: make(map[string]string),
+	}
+}
+
+func (r *InMemoryReviewStateRepository) GetLastReviewedSHA(_ context.Context, pullRequestKey string) (string, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sha, ok := r.reviews[pullRequestKey]
+	return sha, ok, nil
+}
+
+func (r *InMemoryReviewStateRepository) SetLastReviewedSHA(_ context.Context, pullRequestKey, sha string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reviews[pullRequestKey] = sha
+	return nil
+}
+
+func (r *InMemoryReviewStateRepository) DeleteLastReviewedSHA(_ context.Context, pullRequestKey string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.reviews, pullRequestKey)
+	return nil
+}
+
+// RedisReviewStateRepository keeps the last reviewed head commits in Redis, so they survive restarts and
+// rebalances and are shared by the replicas. An entry expires ttl after the last review, in case the pull
+// request is never seen closed.
+type RedisReviewStateRepository struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func NewRedisReviewStateRepository(ctx context.Context, conf pkgredis.Config, ttl time.Duration) (*RedisReviewStateRepository, error) {
+	client, err := pkgredis.NewClient(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisReviewStateRepository{client: client, ttl: ttl}, nil
+}
+
+func (r *RedisReviewStateRepository) GetLastReviewedSHA(ctx context.Context, pullRequestKey string) (string, bool, error) {
+	sha, err := r.client.Get(ctx, reviewStateKeyPrefix+pullRequestKey).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return sha, true, nil
+}
+
+func (r *RedisReviewStateRepository) SetLastReviewedSHA(ctx context.Context, pullRequestKey, sha string) error {
+	return r.client.Set(ctx, reviewStateKeyPrefix+pullRequestKey, sha, r.ttl).Err()
+}
+
+func (r *RedisReviewStateRepository) DeleteLastReviewedSHA(ctx context.Context, pullRequestKey string) error {
+	return r.client.Del(ctx, reviewStateKeyPrefix+pullRequestKey).Err()
+}
+
+func (r *RedisReviewStateRepository) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+func (r *RedisReviewStateRepository) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,68 @@
+package repositories
+
+import (
+	"go_code_reviewer/services/code-reviewer/internal/models"
+	"math"
+	"sort"
+)
+
+// Metric scores how similar two embeddings are, higher is more similar.
+type Metric string
+
+const (
+	MetricCosine Metric = "cosine"
+	MetricDot    Metric = "dot"
+)
+
+// ScoredSnippet is a search result with the score of its Metric.
+type ScoredSnippet struct {
+	Snippet *models.Snippet
+	Score   float64
+}
+
+func (m Metric) score(a, b []float32) float64 {
+	if m == MetricDot {
+		return dotProduct(a, b)
+	}
+	return cosineSimilarity(a, b)
+}
+
+// rank scores every candidate exactly and returns the n most similar, copied without their embeddings.
+func rank(metric Metric, vectorEmbedding []float32, n int, candidates []*models.Snippet) []ScoredSnippet {
+	scored := make([]ScoredSnippet, 0, len(candidates))
+	for _, candidate := range candidates {
+		scored = append(scored, ScoredSnippet{Snippet: candidate, Score: metric.score(vectorEmbedding, candidate.Embedding)})
+	}
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].Score > scored[j].Score
+	})
+
+	scored = scored[:min(max(n, 0), len(scored))]
+	for i := range scored {
+		snippet := *scored[i].Snippet
+		snippet.Embedding = nil
+		scored[i].Snippet = &snippet
+	}
+	return scored
+}
+
+func dotProduct(a, b []float32) float64 {
+	var dot float64
+	for i := range min(len(a), len(b)) {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range min(len(a), len(b)) {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
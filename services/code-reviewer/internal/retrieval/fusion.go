@@ -0,0 +1,37 @@
+package retrieval
+
+import (
+	"go_code_reviewer/services/code-reviewer/internal/models"
+	"sort"
+)
+
+// DefaultFusionK is the constant of reciprocal rank fusion from its paper, it damps the weight of the top ranks.
+const DefaultFusionK = 60
+
+type snippetKey struct {
+	filename string
+	content  string
+}
+
+// FuseRankings merges rankings by reciprocal rank fusion: a snippet scores 1/(k+rank) in every ranking it
+// appears in, rank starting at 1. Snippets of the same file and content are the same snippet, the stores do
+// not all return ids. Ties keep the order of the first ranking a snippet appears in.
+func FuseRankings(k int, rankings ...[]*models.Snippet) []*models.Snippet {
+	scores := make(map[snippetKey]float64)
+	var fused []*models.Snippet
+	for _, ranking := range rankings {
+		for rank, snippet := range ranking {
+			key := snippetKey{filename: snippet.Filename, content: snippet.Content}
+			if _, seen := scores[key]; !seen {
+				fused = append(fused, snippet)
+			}
+			scores[key] += 1 / float64(k+rank+1)
+		}
+	}
+
+	sort.SliceStable(fused, func(i, j int) bool {
+		return scores[snippetKey{filename: fused[i].Filename, content: fused[i].Content}] >
+			scores[snippetKey{filename: fused[j].Filename, content: fused[j].Content}]
+	})
+	return fused
+}
@@ -0,0 +1,219 @@
+package retrieval
+
+import (
+	"fmt"
+	"go_code_reviewer/services/code-reviewer/internal/models"
+	"go_code_reviewer/services/code-reviewer/internal/repositories"
+	"sort"
+	"sync"
+)
+
+// SymbolGraph links the definitions of each project to the snippets referencing them by name, from the symbols
+// and references the parser extracts. Names are not resolved to packages or receivers, so every definition of a
+// name is a candidate. Like LexicalIndex it is kept in memory and filled by Repository.
+type SymbolGraph struct {
+	mu       sync.RWMutex
+	projects map[string]*graphProject
+}
+
+type graphProject struct {
+	snippets map[string]*models.Snippet
+	// definitions and references map a name to the ids of the snippets defining and referencing it.
+	definitions map[string]map[string]bool
+	references  map[string]map[string]bool
+}
+
+// Neighbor is a snippet related to a changed definition.
+type Neighbor struct {
+	Snippet *models.Snippet
+	// Relation describes how the snippet relates to the change, e.g. "used by serve" or "uses parseHeader".
+	Relation string
+}
+
+func NewSymbolGraph() *SymbolGraph {
+	return &SymbolGraph{projects: make(map[string]*graphProject)}
+}
+
+// Add links snippets into the graph of the project, replacing the snippets of the same id.
+func (g *SymbolGraph) Add(projectId string, snippets []*models.Snippet) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	project, ok := g.projects[projectId]
+	if !ok {
+		project = &graphProject{
+			snippets:    make(map[string]*models.Snippet),
+			definitions: make(map[string]map[string]bool),
+			references:  make(map[string]map[string]bool),
+		}
+		g.projects[projectId] = project
+	}
+	for _, snippet := range snippets {
+		project.remove(snippet.ID)
+
+		stored := *snippet
+		stored.Embedding = nil
+		project.snippets[snippet.ID] = &stored
+		if snippet.Symbol != "" {
+			link(project.definitions, snippet.Symbol, snippet.ID)
+		}
+		for _, name := range snippet.References {
+			link(project.references, name, snippet.ID)
+		}
+	}
+}
+
+func (g *SymbolGraph) DeleteProject(projectId string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.projects, projectId)
+}
+
+func (g *SymbolGraph) DeleteFiles(projectId string, filenames []string) {
+	files := make(map[string]bool, len(filenames))
+	for _, filename := range filenames {
+		files[filename] = true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	project, ok := g.projects[projectId]
+	if !ok {
+		return
+	}
+	for id, snippet := range project.snippets {
+		if files[snippet.Filename] {
+			project.remove(id)
+		}
+	}
+}
+
+// Neighbors returns up to limit direct callees and then callers of the definitions of overlay that changedLines
+// fall into, e.g. from review.ChangedLines. They are searched in the overlay and the base index, where the
+// changed files are shadowed. The changed definitions themselves are left out, the diff already shows them.
+func (g *SymbolGraph) Neighbors(overlay repositories.Overlay, changedLines map[string][]int, limit int) []Neighbor {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	shadowed := make(map[string]bool, len(overlay.ChangedFiles))
+	for _, filename := range overlay.ChangedFiles {
+		shadowed[filename] = true
+	}
+	project, ok := g.projects[overlay.ProjectId]
+	if !ok {
+		return nil
+	}
+	// the overlay first, its snippets are the newer ones
+	views := []graphView{{project: project}}
+	if base, ok := g.projects[overlay.BaseProjectId]; ok && overlay.BaseProjectId != "" {
+		views = append(views, graphView{project: base, shadowed: shadowed})
+	}
+
+	changed := views[0].changed(changedLines)
+	seen := make(map[snippetKey]bool, len(changed))
+	for _, snippet := range changed {
+		seen[snippetKey{filename: snippet.Filename, content: snippet.Content}] = true
+	}
+	neighbors := make([]Neighbor, 0, limit)
+	add := func(snippet *models.Snippet, relation string) {
+		key := snippetKey{filename: snippet.Filename, content: snippet.Content}
+		if len(neighbors) < limit && !seen[key] {
+			seen[key] = true
+			neighbors = append(neighbors, Neighbor{Snippet: copySnippet(snippet), Relation: relation})
+		}
+	}
+
+	for _, snippet := range changed {
+		for _, name := range snippet.References {
+			for _, view := range views {
+				for _, callee := range view.linked(view.project.definitions, name) {
+					add(callee, fmt.Sprintf("used by %s", snippet.Symbol))
+				}
+			}
+		}
+	}
+	for _, snippet := range changed {
+		for _, view := range views {
+			for _, caller := range view.linked(view.project.references, snippet.Symbol) {
+				add(caller, fmt.Sprintf("uses %s", snippet.Symbol))
+			}
+		}
+	}
+	return neighbors
+}
+
+// graphView is a project of the graph without its shadowed files.
+type graphView struct {
+	project  *graphProject
+	shadowed map[string]bool
+}
+
+// changed returns the definitions changedLines fall into, by file and line.
+func (v graphView) changed(changedLines map[string][]int) []*models.Snippet {
+	var changed []*models.Snippet
+	for _, snippet := range v.project.snippets {
+		if snippet.Symbol == "" || v.shadowed[snippet.Filename] {
+			continue
+		}
+		for _, line := range changedLines[snippet.Filename] {
+			if line >= snippet.StartLine && line <= snippet.EndLine {
+				changed = append(changed, snippet)
+				break
+			}
+		}
+	}
+	sortByPosition(changed)
+	return changed
+}
+
+// linked returns the snippets links maps name to.
+func (v graphView) linked(links map[string]map[string]bool, name string) []*models.Snippet {
+	var snippets []*models.Snippet
+	for id := range links[name] {
+		if snippet := v.project.snippets[id]; !v.shadowed[snippet.Filename] {
+			snippets = append(snippets, snippet)
+		}
+	}
+	sortByPosition(snippets)
+	return snippets
+}
+
+func sortByPosition(snippets []*models.Snippet) {
+	sort.Slice(snippets, func(i, j int) bool {
+		if snippets[i].Filename != snippets[j].Filename {
+			return snippets[i].Filename < snippets[j].Filename
+		}
+		return snippets[i].StartLine < snippets[j].StartLine
+	})
+}
+
+// remove takes the snippet out of the project. The caller holds the write lock.
+func (p *graphProject) remove(id string) {
+	snippet, ok := p.snippets[id]
+	if !ok {
+		return
+	}
+	unlink(p.definitions, snippet.Symbol, id)
+	for _, name := range snippet.References {
+		unlink(p.references, name, id)
+	}
+	delete(p.snippets, id)
+}
+
+func link(links map[string]map[string]bool, name, id string) {
+	ids, ok := links[name]
+	if !ok {
+		ids = make(map[string]bool)
+		links[name] = ids
+	}
+	ids[id] = true
+}
+
+func unlink(links map[string]map[string]bool, name, id string) {
+	if ids, ok := links[name]; ok {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(links, name)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package retrieval
+
+import (
+	"go_code_reviewer/services/code-reviewer/internal/models"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// BM25 parameters, the usual defaults.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// LexicalIndex ranks the snippets of each project by BM25 over their words and identifiers, and finds the
+// snippets defining a symbol by its name. It is kept in memory and filled by Repository as projects are
+// indexed, so it starts empty after a restart.
+type LexicalIndex struct {
+	mu       sync.RWMutex
+	projects map[string]*lexicalProject
+}
+
+type lexicalProject struct {
+	documents map[string]*document
+	// frequencies counts the documents each term appears in.
+	frequencies map[string]int
+	totalLength int
+}
+
+type document struct {
+	snippet *models.Snippet
+	terms   map[string]int
+	length  int
+}
+
+func NewLexicalIndex() *LexicalIndex {
+	return &LexicalIndex{projects: make(map[string]*lexicalProject)}
+}
+
+// Add indexes snippets, replacing the snippets of the same id.
+func (l *LexicalIndex) Add(projectId string, snippets []*models.Snippet) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	project, ok := l.projects[projectId]
+	if !ok {
+		project = &lexicalProject{documents: make(map[string]*document), frequencies: make(map[string]int)}
+		l.projects[projectId] = project
+	}
+	for _, snippet := range snippets {
+		project.remove(snippet.ID)
+
+		stored := *snippet
+		stored.Embedding = nil
+		doc := &document{snippet: &stored, terms: make(map[string]int)}
+		for _, term := range Tokenize(snippet.Content) {
+			doc.terms[term]++
+			doc.length++
+		}
+		for term := range doc.terms {
+			project.frequencies[term]++
+		}
+		project.totalLength += doc.length
+		project.documents[snippet.ID] = doc
+	}
+}
+
+func (l *LexicalIndex) DeleteProject(projectId string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.projects, projectId)
+}
+
+func (l *LexicalIndex) DeleteFiles(projectId string, filenames []string) {
+	files := make(map[string]bool, len(filenames))
+	for _, filename := range filenames {
+		files[filename] = true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	project, ok := l.projects[projectId]
+	if !ok {
+		return
+	}
+	for id, doc := range project.documents {
+		if files[doc.snippet.Filename] {
+			project.remove(id)
+		}
+	}
+}
+
+// Search returns the nResult snippets of the project ranking highest by BM25 for query. Snippets without any
+// term of the query are left out.
+func (l *LexicalIndex) Search(projectId, query string, nResult int) []*models.Snippet {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	project, ok := l.projects[projectId]
+	if !ok || len(project.documents) == 0 {
+		return []*models.Snippet{}
+	}
+
+	terms := make(map[string]bool)
+	for _, term := range Tokenize(query) {
+		terms[term] = true
+	}
+	count := float64(len(project.documents))
+	averageLength := float64(project.totalLength) / count
+
+	type scored struct {
+		doc   *document
+		score float64
+	}
+	var results []scored
+	for _, doc := range project.documents {
+		score := 0.0
+		for term := range terms {
+			frequency := float64(doc.terms[term])
+			if frequency == 0 {
+				continue
+			}
+			documents := float64(project.frequencies[term])
+			idf := math.Log(1 + (count-documents+0.5)/(documents+0.5))
+			score += idf * frequency * (bm25K1 + 1) / (frequency + bm25K1*(1-bm25B+bm25B*float64(doc.length)/averageLength))
+		}
+		if score > 0 {
+			results = append(results, scored{doc: doc, score: score})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].doc.snippet.ID < results[j].doc.snippet.ID
+	})
+
+	snippets := make([]*models.Snippet, 0, min(nResult, len(results)))
+	for i := 0; i < len(results) && i < nResult; i++ {
+		snippets = append(snippets, copySnippet(results[i].doc.snippet))
+	}
+	return snippets
+}
+
+// Lookup returns the snippets of the project defining one of symbols, in the order of symbols.
+func (l *LexicalIndex) Lookup(projectId string, symbols []string) []*models.Snippet {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	project, ok := l.projects[projectId]
+	if !ok {
+		return []*models.Snippet{}
+	}
+
+	definitions := make(map[string][]*models.Snippet)
+	for _, doc := range project.documents {
+		if doc.snippet.Symbol != "" {
+			definitions[doc.snippet.Symbol] = append(definitions[doc.snippet.Symbol], doc.snippet)
+		}
+	}
+	snippets := make([]*models.Snippet, 0)
+	for _, symbol := range symbols {
+		defined := definitions[symbol]
+		sort.Slice(defined, func(i, j int) bool {
+			return defined[i].Filename < defined[j].Filename
+		})
+		for _, snippet := range defined {
+			snippets = append(snippets, copySnippet(snippet))
+		}
+	}
+	return snippets
+}
+
+// remove takes the document out of the project. The caller holds the write lock.
+func (p *lexicalProject) remove(id string) {
+	doc, ok := p.documents[id]
+	if !ok {
+		return
+	}
+	for term := range doc.terms {
+		if p.frequencies[term]--; p.frequencies[term] == 0 {
+			delete(p.frequencies, term)
+		}
+	}
+	p.totalLength -= doc.length
+	delete(p.documents, id)
+}
+
+func copySnippet(snippet *models.Snippet) *models.Snippet {
+	result := *snippet
+	return &result
+}
+
+// Tokenize splits text into lower case terms: every identifier and, for identifiers in camel or snake case,
+// their parts, so parseHeader matches both parseHeader and "parse the header".
+func Tokenize(text string) []string {
+	var terms []string
+	for _, identifier := range identifiers(text) {
+		lower := strings.ToLower(identifier)
+		terms = append(terms, lower)
+		if parts := splitIdentifier(identifier); len(parts) > 1 {
+			for _, part := range parts {
+				terms = append(terms, strings.ToLower(part))
+			}
+		}
+	}
+	return terms
+}
+
+// identifiers returns the words of text made of letters, digits and underscores that do not start with a digit.
+func identifiers(text string) []string {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	result := fields[:0]
+	for _, field := range fields {
+		if !unicode.IsDigit([]rune(field)[0]) {
+			result = append(result, field)
+		}
+	}
+	return result
+}
+
+// splitIdentifier splits parseHTTPHeader into parse, HTTP and Header, and parse_header into parse and header.
+func splitIdentifier(identifier string) []string {
+	var parts []string
+	for _, word := range strings.Split(identifier, "_") {
+		runes := []rune(word)
+		start := 0
+		for i := 1; i < len(runes); i++ {
+			lowerToUpper := unicode.IsLower(runes[i-1]) && unicode.IsUpper(runes[i])
+			acronymEnd := i+1 < len(runes) && unicode.IsUpper(runes[i-1]) && unicode.IsUpper(runes[i]) && unicode.IsLower(runes[i+1])
+			if lowerToUpper || acronymEnd {
+				parts = append(parts, string(runes[start:i]))
+				start = i
+			}
+		}
+		if start < len(runes) {
+			parts = append(parts, string(runes[start:]))
+		}
+	}
+	return parts
+}
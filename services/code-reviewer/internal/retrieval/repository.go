@@ -0,0 +1,76 @@
+package retrieval
+
+import (
+	"context"
+	"go_code_reviewer/services/code-reviewer/internal/models"
+	"go_code_reviewer/services/code-reviewer/internal/repositories"
+	"sync"
+)
+
+// Index is an in-memory index of the snippets of each project, such as LexicalIndex and SymbolGraph.
+type Index interface {
+	Add(projectId string, snippets []*models.Snippet)
+	DeleteProject(projectId string)
+	DeleteFiles(projectId string, filenames []string)
+}
+
+// Repository keeps the indexes in step with the embeddings repository it wraps. It implements
+// repositories.IndexRestorer for the projects stored before a restart.
+type Repository struct {
+	repositories.EmbeddingsRepository
+	indexes []Index
+
+	mu sync.RWMutex
+	// projects are the projects the indexes hold.
+	projects map[string]bool
+}
+
+func NewRepository(repository repositories.EmbeddingsRepository, indexes ...Index) *Repository {
+	return &Repository{EmbeddingsRepository: repository, indexes: indexes, projects: make(map[string]bool)}
+}
+
+func (r *Repository) Add(ctx context.Context, snippets []*models.Snippet, projectId string) error {
+	if err := r.EmbeddingsRepository.Add(ctx, snippets, projectId); err != nil {
+		return err
+	}
+	r.Restore(projectId, snippets)
+	return nil
+}
+
+func (r *Repository) Restored(projectId string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.projects[projectId]
+}
+
+func (r *Repository) Restore(projectId string, snippets []*models.Snippet) {
+	for _, index := range r.indexes {
+		index.Add(projectId, snippets)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.projects[projectId] = true
+}
+
+func (r *Repository) DeleteProject(ctx context.Context, projectId string) error {
+	if err := r.EmbeddingsRepository.DeleteProject(ctx, projectId); err != nil {
+		return err
+	}
+	for _, index := range r.indexes {
+		index.DeleteProject(projectId)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.projects, projectId)
+	return nil
+}
+
+func (r *Repository) DeleteFiles(ctx context.Context, projectId string, filenames []string) error {
+	if err := r.EmbeddingsRepository.DeleteFiles(ctx, projectId, filenames); err != nil {
+		return err
+	}
+	for _, index := range r.indexes {
+		index.DeleteFiles(projectId, filenames)
+	}
+	return nil
+}
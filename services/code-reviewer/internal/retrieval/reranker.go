@@ -0,0 +1,147 @@
+package retrieval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/tmc/langchaingo/llms"
+	"go_code_reviewer/services/code-reviewer/internal/models"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Reranker picks the n snippets of candidates most useful as context for query, most useful first.
+type Reranker interface {
+	Rerank(ctx context.Context, query string, candidates []*models.Snippet, n int) ([]*models.Snippet, error)
+}
+
+// maxRerankSnippetLength cuts long snippets in the prompt of the LLM reranker, their start is enough to judge them.
+const maxRerankSnippetLength = 2000
+
+var snippetNumberPattern = regexp.MustCompile(`\d+`)
+
+type llmReranker struct {
+	llm llms.Model
+}
+
+// NewLLMReranker asks llm for the numbers of the most useful candidates. Pass the model of assistant.NewGuardedModel,
+// so the tokens count toward the quota of the review.
+func NewLLMReranker(llm llms.Model) Reranker {
+	return &llmReranker{llm: llm}
+}
+
+func (r *llmReranker) Rerank(ctx context.Context, query string, candidates []*models.Snippet, n int) ([]*models.Snippet, error) {
+	var prompt strings.Builder
+	prompt.WriteString("You choose the context for a code review. Rank the code snippets below by how useful they are to review the change.\n")
+	fmt.Fprintf(&prompt, "Answer only with the numbers of the %d most useful snippets, most useful first, separated by commas.\n\n", n)
+	fmt.Fprintf(&prompt, "### Change:\n%s\n\n", query)
+	for i, candidate := range candidates {
+		content := candidate.Content
+		if len(content) > maxRerankSnippetLength {
+			cut := maxRerankSnippetLength
+			for cut > 0 && !utf8.RuneStart(content[cut]) {
+				cut--
+			}
+			content = content[:cut]
+		}
+		fmt.Fprintf(&prompt, "### Snippet %d from file %s:\n%s\n\n", i+1, candidate.Filename, content)
+	}
+
+	answer, err := llms.GenerateFromSinglePrompt(ctx, r.llm, prompt.String(), llms.WithTemperature(0), llms.WithMaxTokens(8*n+16))
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []int
+	for _, number := range snippetNumberPattern.FindAllString(answer, -1) {
+		i, err := strconv.Atoi(number)
+		if err == nil {
+			indexes = append(indexes, i-1)
+		}
+	}
+	reranked := pick(candidates, indexes, n)
+	if len(reranked) == 0 {
+		return nil, fmt.Errorf("llm reranker answered without snippet numbers: %q", answer)
+	}
+	return reranked, nil
+}
+
+type crossEncoderReranker struct {
+	httpClient *http.Client
+	url        string
+	model      string
+	apiKey     string
+}
+
+// NewCrossEncoderReranker scores the candidates with a cross-encoder served at url, e.g. by Cohere, Jina,
+// vLLM or Infinity, that accepts their rerank request.
+func NewCrossEncoderReranker(httpClient *http.Client, url, model, apiKey string) Reranker {
+	return &crossEncoderReranker{httpClient: httpClient, url: url, model: model, apiKey: apiKey}
+}
+
+func (r *crossEncoderReranker) Rerank(ctx context.Context, query string, candidates []*models.Snippet, n int) ([]*models.Snippet, error) {
+	documents := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		documents = append(documents, candidate.Content)
+	}
+	body, err := json.Marshal(map[string]any{
+		"model":     r.model,
+		"query":     query,
+		"documents": documents,
+		"top_n":     n,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if r.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+r.apiKey)
+	}
+	response, err := r.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return nil, fmt.Errorf("reranker returned %s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+
+	var result struct {
+		Results []struct {
+			Index int `json:"index"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode reranker response: %w", err)
+	}
+	indexes := make([]int, 0, len(result.Results))
+	for _, scored := range result.Results {
+		indexes = append(indexes, scored.Index)
+	}
+	return pick(candidates, indexes, n), nil
+}
+
+// pick returns the candidates at indexes, skipping unknown and repeated ones, up to n.
+func pick(candidates []*models.Snippet, indexes []int, n int) []*models.Snippet {
+	picked := make([]*models.Snippet, 0, n)
+	seen := make(map[int]bool)
+	for _, i := range indexes {
+		if i < 0 || i >= len(candidates) || seen[i] || len(picked) == n {
+			continue
+		}
+		seen[i] = true
+		picked = append(picked, candidates[i])
+	}
+	return picked
+}
@@ -0,0 +1,133 @@
+package retrieval
+
+import (
+	"context"
+	"go_code_reviewer/pkg/log"
+	"go_code_reviewer/services/code-reviewer/internal/models"
+	"go_code_reviewer/services/code-reviewer/internal/repositories"
+	"strings"
+)
+
+// defaultCandidates is the number of snippets every ranking contributes before fusion and reranking.
+const defaultCandidates = 20
+
+// Retriever chooses the context of a query from the vector search, BM25 over the lexical index and the
+// definitions of the symbols the query names.
+type Retriever struct {
+	lexical    *LexicalIndex
+	reranker   Reranker
+	fusionK    int
+	candidates int
+}
+
+type RetrieverOption func(retriever *Retriever)
+
+// WithReranker lets reranker choose the final snippets from the fused candidates.
+func WithReranker(reranker Reranker) RetrieverOption {
+	return func(retriever *Retriever) {
+		retriever.reranker = reranker
+	}
+}
+
+// WithFusionK sets the constant of reciprocal rank fusion, DefaultFusionK by default.
+func WithFusionK(k int) RetrieverOption {
+	return func(retriever *Retriever) {
+		if k > 0 {
+			retriever.fusionK = k
+		}
+	}
+}
+
+// WithCandidates sets the number of snippets every ranking contributes.
+func WithCandidates(candidates int) RetrieverOption {
+	return func(retriever *Retriever) {
+		if candidates > 0 {
+			retriever.candidates = candidates
+		}
+	}
+}
+
+func NewRetriever(lexical *LexicalIndex, opts ...RetrieverOption) *Retriever {
+	retriever := &Retriever{
+		lexical:    lexical,
+		fusionK:    DefaultFusionK,
+		candidates: defaultCandidates,
+	}
+	for _, opt := range opts {
+		opt(retriever)
+	}
+	return retriever
+}
+
+// Candidates is the number of snippets to fetch from the vector store for a context of n snippets.
+func (r *Retriever) Candidates(n int) int {
+	return max(n, r.candidates)
+}
+
+// Retrieve returns the n snippets of overlay most relevant to query. nearest is the vector search ranking,
+// fused with BM25 and the definitions of the identifiers in query, then reranked when a reranker is set. A
+// failing reranker keeps the fused order.
+func (r *Retriever) Retrieve(ctx context.Context, overlay repositories.Overlay, query string, nearest []*models.Snippet, n int) []*models.Snippet {
+	symbols := DiffIdentifiers(query)
+	rankings := [][]*models.Snippet{
+		nearest,
+		r.lexical.Lookup(overlay.ProjectId, symbols),
+		r.lexical.Search(overlay.ProjectId, query, r.candidates),
+	}
+	if overlay.BaseProjectId != "" {
+		shadowed := make(map[string]bool, len(overlay.ChangedFiles))
+		for _, filename := range overlay.ChangedFiles {
+			shadowed[filename] = true
+		}
+		rankings = append(rankings,
+			unshadowed(r.lexical.Lookup(overlay.BaseProjectId, symbols), shadowed),
+			unshadowed(r.lexical.Search(overlay.BaseProjectId, query, r.candidates+len(overlay.ChangedFiles)), shadowed),
+		)
+	}
+
+	fused := FuseRankings(r.fusionK, rankings...)
+	if candidates := r.Candidates(n); len(fused) > candidates {
+		fused = fused[:candidates]
+	}
+	if r.reranker != nil && len(fused) > n {
+		reranked, err := r.reranker.Rerank(ctx, query, fused, n)
+		if err == nil {
+			return reranked
+		}
+		log.GetLogger().WithError(err).Warn("failed to rerank context, keeping the fused ranking")
+	}
+	if len(fused) > n {
+		fused = fused[:n]
+	}
+	return fused
+}
+
+func unshadowed(snippets []*models.Snippet, shadowed map[string]bool) []*models.Snippet {
+	visible := snippets[:0]
+	for _, snippet := range snippets {
+		if !shadowed[snippet.Filename] {
+			visible = append(visible, snippet)
+		}
+	}
+	return visible
+}
+
+// DiffIdentifiers returns the identifiers on the added, removed and context lines of diff in the order they
+// first appear, leaving out the file headers.
+func DiffIdentifiers(diff string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, "diff --git") || strings.HasPrefix(line, "index ") ||
+			strings.HasPrefix(line, "--- ") || strings.HasPrefix(line, "+++ ") || strings.HasPrefix(line, "@@") {
+			continue
+		}
+		for _, identifier := range identifiers(line) {
+			if !seen[identifier] {
+				seen[identifier] = true
+				result = append(result, identifier)
+			}
+		}
+	}
+	return result
+}
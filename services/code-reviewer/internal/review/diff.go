@@ -0,0 +1,231 @@
+package review
+
+import (
+	"bufio"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fileDiff is the part of a unified diff that changes one file.
+type fileDiff struct {
+	// oldPath and newPath are empty when the file is added or deleted.
+	oldPath string
+	newPath string
+	lines   []diffLine
+}
+
+// diffLine is a line of a hunk. Kind is '+', '-' or ' '. Line is the line of the new version it is on, or for
+// removed lines the line of the new version that follows them.
+type diffLine struct {
+	kind byte
+	text string
+	line int
+}
+
+// parseDiff splits a unified diff into its files. The lines of a hunk are counted against the lengths in its
+// "@@" header, so a removed "-- x" or an added "++ x" line is content and "---" and "+++" are only read as file
+// headers between hunks.
+func parseDiff(diff string) []*fileDiff {
+	var files []*fileDiff
+	var file *fileDiff
+	oldLeft, newLeft, line := 0, 0, 0
+
+	scanner := bufio.NewScanner(strings.NewReader(diff))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		text := scanner.Text()
+		if oldLeft > 0 || newLeft > 0 {
+			if text == "" {
+				// Some tools strip the trailing space of empty context lines.
+				text = " "
+			}
+			switch text[0] {
+			case '+':
+				file.lines = append(file.lines, diffLine{kind: '+', text: text[1:], line: line})
+				line++
+				newLeft--
+				continue
+			case '-':
+				file.lines = append(file.lines, diffLine{kind: '-', text: text[1:], line: line})
+				oldLeft--
+				continue
+			case ' ':
+				file.lines = append(file.lines, diffLine{kind: ' ', text: text[1:], line: line})
+				line++
+				oldLeft--
+				newLeft--
+				continue
+			case '\\':
+				continue
+			}
+			// The hunk is shorter than its header says; read the line as a header.
+			oldLeft, newLeft = 0, 0
+		}
+
+		switch {
+		case strings.HasPrefix(text, "diff --git "):
+			file = &fileDiff{}
+			files = append(files, file)
+		case strings.HasPrefix(text, "--- "):
+			if file == nil || len(file.lines) > 0 || file.oldPath != "" || file.newPath != "" {
+				file = &fileDiff{}
+				files = append(files, file)
+			}
+			file.oldPath = headerPath(strings.TrimPrefix(text, "--- "), "a/")
+		case strings.HasPrefix(text, "+++ "):
+			if file == nil || len(file.lines) > 0 || file.newPath != "" {
+				file = &fileDiff{}
+				files = append(files, file)
+			}
+			file.newPath = headerPath(strings.TrimPrefix(text, "+++ "), "b/")
+		case strings.HasPrefix(text, "@@") && file != nil:
+			var ok bool
+			line, oldLeft, newLeft, ok = hunkHeader(text)
+			if !ok {
+				oldLeft, newLeft = 0, 0
+			}
+		}
+	}
+
+	return files
+}
+
+// headerPath returns the path of a "---" or "+++" header, or "" for /dev/null.
+func headerPath(path, prefix string) string {
+	// Git ends the path with a tab when it contains spaces.
+	path, _, _ = strings.Cut(path, "\t")
+	if path == "/dev/null" {
+		return ""
+	}
+	return strings.TrimPrefix(path, prefix)
+}
+
+// hunkHeader parses the first line of the new file and the lengths of the old and new ranges from a header like
+// "@@ -10,7 +12,8 @@". An omitted length is 1.
+func hunkHeader(header string) (start, oldLength, newLength int, ok bool) {
+	fields := strings.Fields(header)
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return 0, 0, 0, false
+	}
+	_, oldLength, ok = hunkRange(strings.TrimPrefix(fields[1], "-"))
+	if !ok {
+		return 0, 0, 0, false
+	}
+	start, newLength, ok = hunkRange(strings.TrimPrefix(fields[2], "+"))
+	if !ok {
+		return 0, 0, 0, false
+	}
+	return start, oldLength, newLength, true
+}
+
+func hunkRange(field string) (start, length int, ok bool) {
+	first, count, found := strings.Cut(field, ",")
+	start, err := strconv.Atoi(first)
+	if err != nil {
+		return 0, 0, false
+	}
+	if !found {
+		return start, 1, true
+	}
+	length, err = strconv.Atoi(count)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, length, true
+}
+
+// CommentableLines returns, per file, the lines of the new version that appear in the diff hunks
+// and can therefore carry an inline review comment.
+func CommentableLines(diff string) map[string]map[int]bool {
+	result := make(map[string]map[int]bool)
+	for _, file := range parseDiff(diff) {
+		if file.newPath == "" {
+			continue
+		}
+		lines := make(map[int]bool)
+		for _, line := range file.lines {
+			if line.kind != '-' {
+				lines[line.line] = true
+			}
+		}
+		result[file.newPath] = lines
+	}
+	return result
+}
+
+// Anchor identifies a line of the new version by its code rather than its number, which shifts when lines are
+// added above. Occurrence counts the lines of the file in the diff hunks with the same code up to and including
+// this one, so common lines like "}" or "return err" stay apart. The zero Anchor is a line outside the hunks.
+type Anchor struct {
+	// Code is the content of the line with the whitespace collapsed.
+	Code       string
+	Occurrence int
+}
+
+// LineAnchors returns, per file, the anchor of every line of the new version that appears in the diff hunks.
+func LineAnchors(diff string) map[string]map[int]Anchor {
+	result := make(map[string]map[int]Anchor)
+	for _, file := range parseDiff(diff) {
+		if file.newPath == "" {
+			continue
+		}
+		lines := make(map[int]Anchor)
+		occurrences := make(map[string]int)
+		for _, line := range file.lines {
+			if line.kind == '-' {
+				continue
+			}
+			code := strings.Join(strings.Fields(line.text), " ")
+			occurrences[code]++
+			lines[line.line] = Anchor{Code: code, Occurrence: occurrences[code]}
+		}
+		result[file.newPath] = lines
+	}
+	return result
+}
+
+// ChangedFiles returns the sorted paths the diff adds, modifies or deletes. Renamed files are listed with both
+// their old and new path.
+func ChangedFiles(diff string) []string {
+	files := make(map[string]bool)
+	for _, file := range parseDiff(diff) {
+		if file.oldPath != "" {
+			files[file.oldPath] = true
+		}
+		if file.newPath != "" {
+			files[file.newPath] = true
+		}
+	}
+
+	result := make([]string, 0, len(files))
+	for file := range files {
+		result = append(result, file)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// ChangedLines returns, per file, the sorted lines of the new version the diff adds, and for removed lines the
+// line of the new version that follows them. Deleted files have no new version and are left out.
+func ChangedLines(diff string) map[string][]int {
+	result := make(map[string][]int)
+	for _, file := range parseDiff(diff) {
+		if file.newPath == "" {
+			continue
+		}
+		changed := make(map[int]bool)
+		for _, line := range file.lines {
+			if line.kind != ' ' {
+				changed[line.line] = true
+			}
+		}
+		sorted := make([]int, 0, len(changed))
+		for line := range changed {
+			sorted = append(sorted, line)
+		}
+		sort.Ints(sorted)
+		result[file.newPath] = sorted
+	}
+	return result
+}
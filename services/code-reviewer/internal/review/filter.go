@@ -0,0 +1,117 @@
+package review
+
+import (
+	"go_code_reviewer/services/code-reviewer/internal/config"
+	"sort"
+	"strings"
+)
+
+type FilterReason string
+
+const (
+	ReasonDisabledCategory FilterReason = "disabled_category"
+	ReasonLowSeverity      FilterReason = "low_severity"
+	ReasonLowConfidence    FilterReason = "low_confidence"
+	ReasonDuplicate        FilterReason = "duplicate"
+	ReasonFileLimit        FilterReason = "file_limit"
+	ReasonPRLimit          FilterReason = "pr_limit"
+)
+
+type Result struct {
+	Summary  string
+	Findings []*Finding
+	Filtered map[FilterReason]int
+}
+
+func (r *Result) FilteredCount() int {
+	total := 0
+	for _, count := range r.Filtered {
+		total += count
+	}
+	return total
+}
+
+type Filter struct {
+	config config.ReviewSection
+}
+
+func NewFilter(config config.ReviewSection) *Filter {
+	return &Filter{config: config}
+}
+
+// Apply drops the findings that are not worth a comment on the given repository ("owner/repo")
+// and records how many findings were dropped for each reason.
+func (f *Filter) Apply(repository string, review *Review) *Result {
+	result := &Result{
+		Summary:  review.Summary,
+		Filtered: make(map[FilterReason]int),
+	}
+
+	disabled := f.disabledCategories(repository)
+	minSeverity := Severity(f.config.MinSeverity).Rank()
+	seen := make(map[string]*Finding)
+	var kept []*Finding
+	for _, finding := range review.Findings {
+		switch {
+		case disabled[finding.Category]:
+			result.Filtered[ReasonDisabledCategory]++
+			continue
+		case finding.Severity.Rank() < minSeverity:
+			result.Filtered[ReasonLowSeverity]++
+			continue
+		case finding.Confidence < f.config.MinConfidence:
+			result.Filtered[ReasonLowConfidence]++
+			continue
+		}
+
+		key := duplicateKey(finding)
+		if previous, ok := seen[key]; ok {
+			if finding.Severity.Rank() > previous.Severity.Rank() {
+				*previous = *finding
+			}
+			result.Filtered[ReasonDuplicate]++
+			continue
+		}
+		seen[key] = finding
+		kept = append(kept, finding)
+	}
+
+	sort.SliceStable(kept, func(i, j int) bool {
+		if kept[i].Severity.Rank() != kept[j].Severity.Rank() {
+			return kept[i].Severity.Rank() > kept[j].Severity.Rank()
+		}
+		return kept[i].Confidence > kept[j].Confidence
+	})
+
+	perFile := make(map[string]int)
+	for _, finding := range kept {
+		if f.config.MaxCommentsPerFile > 0 && perFile[finding.File] >= f.config.MaxCommentsPerFile {
+			result.Filtered[ReasonFileLimit]++
+			continue
+		}
+		if f.config.MaxCommentsPerPR > 0 && len(result.Findings) >= f.config.MaxCommentsPerPR {
+			result.Filtered[ReasonPRLimit]++
+			continue
+		}
+		perFile[finding.File]++
+		result.Findings = append(result.Findings, finding)
+	}
+
+	return result
+}
+
+func (f *Filter) disabledCategories(repository string) map[string]bool {
+	disabled := make(map[string]bool)
+	for _, category := range f.config.DisabledCategories {
+		disabled[strings.ToLower(category)] = true
+	}
+	for _, category := range f.config.Repositories[repository].DisabledCategories {
+		disabled[strings.ToLower(category)] = true
+	}
+	return disabled
+}
+
+func duplicateKey(finding *Finding) string {
+	message := strings.Join(strings.Fields(strings.ToLower(finding.Message)), " ")
+	return finding.File + "|" + message
+}
@@ -0,0 +1,88 @@
+package review
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityMinor    Severity = "minor"
+	SeverityMajor    Severity = "major"
+	SeverityCritical Severity = "critical"
+)
+
+var severityRanks = map[Severity]int{
+	SeverityInfo:     0,
+	SeverityMinor:    1,
+	SeverityMajor:    2,
+	SeverityCritical: 3,
+}
+
+// Rank orders severities from info (0) to critical (3); unknown values rank as info.
+func (s Severity) Rank() int {
+	return severityRanks[Severity(strings.ToLower(string(s)))]
+}
+
+type Finding struct {
+	File       string   `json:"file"`
+	Line       int      `json:"line"`
+	Severity   Severity `json:"severity"`
+	Confidence float64  `json:"confidence"`
+	Category   string   `json:"category"`
+	Message    string   `json:"message"`
+}
+
+// Fingerprint identifies a finding across pushes by its file, category and anchor from LineAnchors. The line
+// shifts as the file changes and the llm words the message differently on every run, so both are left out. A
+// finding outside the diff hunks has no anchor and is told apart by its normalized message instead.
+func (f *Finding) Fingerprint(anchor Anchor) string {
+	key := f.File + "|" + f.Category + "|"
+	if anchor == (Anchor{}) {
+		key += "message|" + normalizeMessage(f.Message)
+	} else {
+		key += fmt.Sprintf("%d|%s", anchor.Occurrence, anchor.Code)
+	}
+	sum := sha1.Sum([]byte(key))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// normalizeMessage lowercases message and collapses its whitespace and trailing punctuation.
+func normalizeMessage(message string) string {
+	return strings.TrimRight(strings.Join(strings.Fields(strings.ToLower(message)), " "), ".!")
+}
+
+type Review struct {
+	Summary  string     `json:"summary"`
+	Findings []*Finding `json:"findings"`
+}
+
+var ErrNoReviewFound = errors.New("no json review found in llm output")
+
+// Parse extracts the structured review from the llm output, tolerating markdown code fences around the json.
+func Parse(output string) (*Review, error) {
+	start := strings.Index(output, "{")
+	end := strings.LastIndex(output, "}")
+	if start == -1 || end < start {
+		return nil, ErrNoReviewFound
+	}
+
+	var review Review
+	if err := json.Unmarshal([]byte(output[start:end+1]), &review); err != nil {
+		return nil, err
+	}
+
+	for _, finding := range review.Findings {
+		finding.Severity = Severity(strings.ToLower(strings.TrimSpace(string(finding.Severity))))
+		finding.Category = strings.ToLower(strings.TrimSpace(finding.Category))
+		finding.File = strings.TrimPrefix(strings.TrimSpace(finding.File), "b/")
+	}
+
+	return &review, nil
+}
@@ -0,0 +1,201 @@
+package review
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"go_code_reviewer/pkg/log"
+	"go_code_reviewer/services/api-gateway/pkg/models"
+	"go_code_reviewer/services/code-reviewer/internal/vsc"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// resolveWindow is how many lines away from a changed line a thread may be to count as fixed by the change.
+const resolveWindow = 3
+
+const (
+	SummaryMarker  = "<!-- go-code-reviewer:summary -->"
+	QuotaMarker    = "<!-- go-code-reviewer:quota -->"
+	resolvedMarker = "<!-- go-code-reviewer:resolved -->"
+)
+
+var (
+	findingMarkerPattern = regexp.MustCompile(`<!-- go-code-reviewer:finding:([0-9a-f]+) -->`)
+	// findingCategoryPattern matches the severity and category line renderInlineFinding writes.
+	findingCategoryPattern = regexp.MustCompile("(?m)^`[^`]*` _(.*)_$")
+)
+
+type Publisher struct {
+	versionControl vsc.VersionControlSystem
+}
+
+func NewPublisher(versionControl vsc.VersionControlSystem) *Publisher {
+	return &Publisher{versionControl: versionControl}
+}
+
+// Publish keeps a single summary comment per pull request up to date, posts inline threads only for
+// findings that do not have an open thread yet and marks the threads of vanished findings near changed lines as resolved.
+func (p *Publisher) Publish(ctx context.Context, event *models.PullRequestEvent, diff string, result *Result) error {
+	logger := log.GetLogger().WithField("pr_number", event.Number)
+
+	previous, err := p.versionControl.ListReviewComments(ctx, event.Number, event.Owner, event.Repo)
+	if err != nil {
+		logger.WithError(err).Error("failed to list previous review comments")
+		return err
+	}
+
+	// several threads may share a fingerprint, e.g. when a finding was posted again after its thread was resolved
+	open := make(map[string][]*vsc.ReviewComment)
+	var threads []*vsc.ReviewComment
+	for _, comment := range previous {
+		match := findingMarkerPattern.FindStringSubmatch(comment.Body)
+		if match == nil || strings.Contains(comment.Body, resolvedMarker) {
+			continue
+		}
+		open[match[1]] = append(open[match[1]], comment)
+		threads = append(threads, comment)
+	}
+
+	commentable := CommentableLines(diff)
+	anchors := LineAnchors(diff)
+	summary := &Result{Summary: result.Summary, Filtered: result.Filtered}
+	// claimed holds the ids of the threads a current finding keeps open
+	claimed := make(map[int64]bool)
+	fingerprints := make([]string, len(result.Findings))
+	matched := make([]bool, len(result.Findings))
+	for i, finding := range result.Findings {
+		fingerprints[i] = finding.Fingerprint(anchors[finding.File][finding.Line])
+		if thread := nearestThread(open[fingerprints[i]], claimed, finding, -1); thread != nil {
+			claimed[thread.ID] = true
+			matched[i] = true
+		}
+	}
+
+	var inline []*vsc.ReviewComment
+	stillOpen := 0
+	for i, finding := range result.Findings {
+		if matched[i] {
+			stillOpen++
+			continue
+		}
+		// the line of the finding changed, an open thread of the same category close to it is taken as the same
+		if thread := nearestThread(threadsOfCategory(threads, finding.Category), claimed, finding, resolveWindow); thread != nil {
+			claimed[thread.ID] = true
+			stillOpen++
+			continue
+		}
+		if commentable[finding.File][finding.Line] {
+			inline = append(inline, &vsc.ReviewComment{
+				Path: finding.File,
+				Line: finding.Line,
+				Body: renderInlineFinding(fingerprints[i], finding),
+			})
+			continue
+		}
+		summary.Findings = append(summary.Findings, finding)
+	}
+
+	changed := ChangedLines(diff)
+	resolved := 0
+	for _, comment := range threads {
+		// a finding away from the lines the diff changes cannot have been fixed by it, an incremental review
+		// does not even see it again
+		if claimed[comment.ID] || !nearChange(changed[comment.Path], comment.Line) {
+			continue
+		}
+		if err := p.versionControl.EditReviewComment(ctx, event.Owner, event.Repo, comment.ID, renderResolved(comment.Body)); err != nil {
+			logger.WithError(err).Error("failed to mark review comment as resolved")
+			return err
+		}
+		resolved++
+	}
+
+	if len(inline) > 0 {
+		if err := p.versionControl.CreateReviewComments(ctx, event.Number, event.Owner, event.Repo, inline); err != nil {
+			logger.WithError(err).Error("failed to create inline review comments")
+			return err
+		}
+	}
+
+	logger.WithFields(logrus.Fields{
+		"new_inline": len(inline),
+		"still_open": stillOpen,
+		"resolved":   resolved,
+	}).Info("review published")
+
+	body := SummaryMarker + "\n" + Render(summary) + "\n\n" + renderThreadStatus(len(inline), stillOpen, resolved)
+	return p.versionControl.UpsertPRComment(ctx, event.Number, body, event.Owner, event.Repo, SummaryMarker)
+}
+
+// PublishQuotaNote tells the author that the push was not reviewed because a daily quota is used up. The note
+// is a single comment per pull request, updated on every skipped push.
+func (p *Publisher) PublishQuotaNote(ctx context.Context, event *models.PullRequestEvent, reason error) error {
+	body := fmt.Sprintf("%s\nThanks for the push! %s was not reviewed because of a quota (%s). Reviews resume tomorrow (UTC), push again then to get one.",
+		QuotaMarker, shortSHA(event.HeadSHA), reason)
+	return p.versionControl.UpsertPRComment(ctx, event.Number, body, event.Owner, event.Repo, QuotaMarker)
+}
+
+// nearestThread returns the thread on the file of finding that is closest to its line, ties going to the older
+// thread. Threads in claimed already belong to another finding, threads further than window lines away are
+// skipped unless window is negative.
+func nearestThread(threads []*vsc.ReviewComment, claimed map[int64]bool, finding *Finding, window int) *vsc.ReviewComment {
+	var nearest *vsc.ReviewComment
+	distance := 0
+	for _, thread := range threads {
+		if claimed[thread.ID] || thread.Path != finding.File {
+			continue
+		}
+		d := thread.Line - finding.Line
+		if d < 0 {
+			d = -d
+		}
+		if window >= 0 && d > window {
+			continue
+		}
+		if nearest == nil || d < distance || d == distance && thread.ID < nearest.ID {
+			nearest, distance = thread, d
+		}
+	}
+	return nearest
+}
+
+// threadsOfCategory returns the threads whose finding has category.
+func threadsOfCategory(threads []*vsc.ReviewComment, category string) []*vsc.ReviewComment {
+	var result []*vsc.ReviewComment
+	for _, thread := range threads {
+		if match := findingCategoryPattern.FindStringSubmatch(thread.Body); match != nil && match[1] == category {
+			result = append(result, thread)
+		}
+	}
+	return result
+}
+
+// nearChange reports whether line is within resolveWindow lines of one of the sorted changed lines.
+func nearChange(changed []int, line int) bool {
+	i := sort.SearchInts(changed, line-resolveWindow)
+	return i < len(changed) && changed[i] <= line+resolveWindow
+}
+
+func shortSHA(sha string) string {
+	if sha == "" {
+		return "It"
+	}
+	if len(sha) > 7 {
+		sha = sha[:7]
+	}
+	return "`" + sha + "`"
+}
+
+func renderInlineFinding(fingerprint string, finding *Finding) string {
+	return fmt.Sprintf("<!-- go-code-reviewer:finding:%s -->\n`%s` _%s_\n%s", fingerprint, finding.Severity, finding.Category, strings.TrimSpace(finding.Message))
+}
+
+func renderResolved(body string) string {
+	return fmt.Sprintf("%s\n**Resolved:** no longer reported on the latest commit.\n\n<details><summary>Original comment</summary>\n\n%s\n</details>", resolvedMarker, body)
+}
+
+func renderThreadStatus(created, stillOpen, resolved int) string {
+	return fmt.Sprintf("<sub>Inline comments: %d new, %d still open, %d resolved.</sub>", created, stillOpen, resolved)
+}
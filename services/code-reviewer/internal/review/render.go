@@ -0,0 +1,60 @@
+package review
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var reasonDescriptions = map[FilterReason]string{
+	ReasonDisabledCategory: "in disabled categories",
+	ReasonLowSeverity:      "below the severity threshold",
+	ReasonLowConfidence:    "below the confidence threshold",
+	ReasonDuplicate:        "duplicated",
+	ReasonFileLimit:        "over the per-file limit",
+	ReasonPRLimit:          "over the per-PR limit",
+}
+
+func Render(result *Result) string {
+	var builder strings.Builder
+	if result.Summary != "" {
+		builder.WriteString(strings.TrimSpace(result.Summary))
+		builder.WriteString("\n\n")
+	}
+
+	for _, finding := range result.Findings {
+		builder.WriteString(RenderFinding(finding))
+		builder.WriteString("\n\n")
+	}
+
+	if result.FilteredCount() > 0 {
+		builder.WriteString(renderFilteredNote(result))
+	}
+
+	return strings.TrimSpace(builder.String())
+}
+
+func RenderFinding(finding *Finding) string {
+	location := finding.File
+	if finding.Line > 0 {
+		location = fmt.Sprintf("%s:%d", finding.File, finding.Line)
+	}
+	return fmt.Sprintf("**%s** `%s` _%s_\n%s", location, finding.Severity, finding.Category, strings.TrimSpace(finding.Message))
+}
+
+func renderFilteredNote(result *Result) string {
+	reasons := make([]string, 0, len(result.Filtered))
+	for reason := range result.Filtered {
+		reasons = append(reasons, string(reason))
+	}
+	sort.Strings(reasons)
+
+	parts := make([]string, 0, len(reasons))
+	for _, reason := range reasons {
+		if count := result.Filtered[FilterReason(reason)]; count > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", count, reasonDescriptions[FilterReason(reason)]))
+		}
+	}
+
+	return fmt.Sprintf("<sub>%d findings were hidden: %s.</sub>", result.FilteredCount(), strings.Join(parts, ", "))
+}
@@ -3,6 +3,7 @@ package internal
 import (
 	"context"
 	"errors"
+	"fmt"
 	chroma "github.com/amikos-tech/chroma-go/pkg/api/v2"
 	chromaembedding "github.com/amikos-tech/chroma-go/pkg/embeddings/openai"
 	"github.com/google/go-github/v58/github"
@@ -10,38 +11,70 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/tmc/langchaingo/llms"
 	langchainopenai "github.com/tmc/langchaingo/llms/openai"
+	"go_code_reviewer/pkg/app"
 	"go_code_reviewer/pkg/kafka"
 	"go_code_reviewer/pkg/log"
+	"go_code_reviewer/pkg/redis"
 	"go_code_reviewer/pkg/retry"
 	"go_code_reviewer/services/code-reviewer/internal/assistant"
 	"go_code_reviewer/services/code-reviewer/internal/config"
 	"go_code_reviewer/services/code-reviewer/internal/embedder"
 	eventprocessor "go_code_reviewer/services/code-reviewer/internal/event-processor"
+	"go_code_reviewer/services/code-reviewer/internal/janitor"
+	"go_code_reviewer/services/code-reviewer/internal/limits"
 	"go_code_reviewer/services/code-reviewer/internal/metrics"
 	"go_code_reviewer/services/code-reviewer/internal/parser"
+	"go_code_reviewer/services/code-reviewer/internal/ratelimit"
 	"go_code_reviewer/services/code-reviewer/internal/repositories"
+	"go_code_reviewer/services/code-reviewer/internal/retrieval"
+	"go_code_reviewer/services/code-reviewer/internal/review"
 	"go_code_reviewer/services/code-reviewer/internal/vsc"
 	"golang.org/x/oauth2"
 	"net/http"
 	"time"
 )
 
+const (
+	defaultShutdownTimeout = time.Minute
+	// defaultReviewStateTTL keeps a pull request without reviews for a quarter before it is reviewed in full again.
+	defaultReviewStateTTL  = 90 * 24 * time.Hour
+	metricsShutdownTimeout = 5 * time.Second
+	// maxRetryDelay is the longest a provider may ask a review to wait; beyond it the event goes to a retry topic.
+	maxRetryDelay = time.Minute
+)
+
 type Service struct {
+	config          *config.Config
 	embeddingClient embedder.EmbeddingClient
 	llm             llms.Model
-	chromaClient    chroma.Client
+	llmProvider     *openai.Client
+	vectorStore     repositories.VectorStore
 	vscClient       vsc.VersionControlSystem
 	kafkaConsumer   kafka.Consumer
+	kafkaProducer   kafka.Producer
+	metricsServer   *http.Server
+	eventProcessor  *eventprocessor.Module
+	rateLimits      *ratelimit.Registry
+	janitor         *janitor.Janitor
+	// embeddings is the vector store, kept in step with the lexical index and the symbol graph when enabled.
+	embeddings  repositories.EmbeddingsRepository
+	lexical     *retrieval.LexicalIndex
+	symbolGraph *retrieval.SymbolGraph
+
+	reviewState repositories.ReviewStateRepository
+	// reviewStateStore is the redis behind reviewState, nil when it is kept in memory.
+	reviewStateStore *repositories.RedisReviewStateRepository
 }
 
-func (s *Service) Start() {
-	logger := log.GetLogger()
+func (s *Service) Register(lifecycle *app.Lifecycle) error {
 	serviceConfig, err := config.LoadConfig("./config.yaml")
 	if err != nil {
-		logger.WithError(err).Fatal("failed to load config.yaml")
+		return fmt.Errorf("failed to load config.yaml: %w", err)
 	}
+	s.config = serviceConfig
+	s.rateLimits = ratelimit.NewRegistry(serviceConfig.RateLimits)
 
-	logger.WithFields(logrus.Fields{
+	log.GetLogger().WithFields(logrus.Fields{
 		"llm_model":       serviceConfig.LLM.Model,
 		"llm_temperature": serviceConfig.LLM.Temperature,
 		"llm_max_tokens":  serviceConfig.LLM.MaxTokens,
@@ -49,88 +82,400 @@ func (s *Service) Start() {
 		"embedding_model": serviceConfig.Embedding.Model,
 	}).Info("Config loaded for code reviewer")
 
-	if err := s.ConnectToServices(serviceConfig); err != nil {
-		logger.WithError(err).Fatal("failed to connect to services")
+	// the event processor depends on everything else, so it is stopped first and its reviews in progress
+	// finish while the clients they use are still open
+	return lifecycle.Register(
+		app.Component{
+			Name: "metrics",
+			Start: func(ctx context.Context) error {
+				return s.startMetrics(lifecycle)
+			},
+			Stop: s.stopMetrics,
+		},
+		app.Component{Name: "embedding", Start: s.connectEmbedding},
+		app.Component{Name: "llm", Start: s.connectLLM, Health: s.llmHealth},
+		app.Component{Name: "vector-store", Start: s.connectVectorStore, Stop: s.closeVectorStore, Health: s.vectorStoreHealth},
+		app.Component{Name: "janitor", DependsOn: []string{"vector-store"}, Start: s.startJanitor, Stop: s.stopJanitor},
+		app.Component{Name: "github", Start: s.connectGithub, Health: s.githubHealth},
+		app.Component{Name: "review-state", Start: s.connectReviewState, Stop: s.closeReviewState, Health: s.reviewStateHealth},
+		app.Component{Name: "kafka-producer", Start: s.connectKafkaProducer, Stop: s.closeKafkaProducer, Health: s.kafkaProducerHealth},
+		app.Component{Name: "kafka-consumer", DependsOn: []string{"metrics"}, Start: s.connectKafkaConsumer, Stop: s.closeKafkaConsumer, Health: s.kafkaConsumerHealth},
+		app.Component{
+			Name:      "event-processor",
+			DependsOn: []string{"embedding", "llm", "vector-store", "janitor", "github", "review-state", "kafka-producer", "kafka-consumer"},
+			Start: func(ctx context.Context) error {
+				return s.startEventProcessor(lifecycle)
+			},
+			Stop:   s.stopEventProcessor,
+			Health: s.eventProcessorHealth,
+		},
+	)
+}
+
+// startMetrics serves the health probes next to the metrics, so they share the prometheus address.
+func (s *Service) startMetrics(lifecycle *app.Lifecycle) error {
+	health := app.NewHealthCache(lifecycle, app.DefaultHealthCacheTTL)
+	s.metricsServer = metrics.Init(s.config.Prometheus.Address,
+		metrics.WithHandler("GET /liveness", app.LivenessHandler()),
+		metrics.WithHandler("GET /readiness", health.ReadinessHandler()),
+	)
+	return nil
+}
+
+func (s *Service) stopMetrics(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, metricsShutdownTimeout)
+	defer cancel()
+	return s.metricsServer.Shutdown(ctx)
+}
+
+func (s *Service) connectEmbedding(context.Context) error {
+	embeddingClientConfig := openai.DefaultConfig(s.config.LLM.OpenApiKey)
+	if s.config.Embedding.APIBaseURL == "" {
+		return errors.New("empty embedding api base url")
 	}
+	embeddingClientConfig.BaseURL = s.config.Embedding.APIBaseURL
+	embeddingClientConfig.HTTPClient = &http.Client{Transport: retry.NewOpenAITransport(http.DefaultTransport)}
+	embeddingClient := embedder.NewOpenAiEmbeddingClient(openai.NewClientWithConfig(embeddingClientConfig))
+	embeddingClient = ratelimit.NewEmbeddingClient(embeddingClient, s.rateLimits.For(s.config.Embedding.Provider, s.config.Embedding.Model))
+	s.embeddingClient = embedder.NewRetryingEmbeddingClient(embeddingClient, retry.New[[]embedder.Embedding](retry.Options{
+		MaxRetries: 3,
+		Strategy:   retry.ExponentialJitterBackoff(500*time.Millisecond, 10*time.Second),
+		Classify:   retry.ClassifyOpenAI,
+		MaxDelay:   maxRetryDelay,
+		Breaker:    s.newBreaker("embedding"),
+		Operation:  "embedding",
+		OnRetry:    metrics.Get().ObserveRetry,
+	}))
+	return nil
+}
 
-	openaiEmbeddingFunc, err := chromaembedding.NewOpenAIEmbeddingFunction(
-		serviceConfig.LLM.OpenApiKey,
-		chromaembedding.WithBaseURL(serviceConfig.Embedding.APIBaseURL),
-		chromaembedding.WithModel(chromaembedding.EmbeddingModel(serviceConfig.Embedding.Model)),
+func (s *Service) connectLLM(context.Context) error {
+	llm, err := langchainopenai.New(
+		langchainopenai.WithBaseURL(s.config.LLM.APIBaseURL),
+		langchainopenai.WithModel(s.config.LLM.Model),
+		langchainopenai.WithToken(s.config.LLM.OpenApiKey),
+		langchainopenai.WithHTTPClient(&http.Client{Transport: retry.NewOpenAITransport(http.DefaultTransport)}),
 	)
 	if err != nil {
-		logger.WithError(err).Fatal("failed to create openai embedding function")
+		return err
 	}
+	s.llm = ratelimit.NewModel(llm, s.rateLimits.For(s.config.LLM.Provider, s.config.LLM.Model))
 
-	embeddingsRepo := repositories.NewEmbeddingRepository(s.chromaClient, openaiEmbeddingFunc, serviceConfig.ChromaDB.CollectionName)
-	projectParser := parser.NewProjectParser(map[string]*parser.CodeParser{
-		".py": parser.NewCodeParser(parser.LanguagePython),
-		".go": parser.NewCodeParser(parser.LanguageGo),
-	})
+	providerConfig := openai.DefaultConfig(s.config.LLM.OpenApiKey)
+	providerConfig.BaseURL = s.config.LLM.APIBaseURL
+	s.llmProvider = openai.NewClientWithConfig(providerConfig)
+	return nil
+}
 
-	projectEmbedder := embedder.NewProjectEmbedder(s.embeddingClient, embeddingsRepo, serviceConfig.Embedding.Model)
-	codeAssistant := assistant.NewAssistant(serviceConfig, embeddingsRepo, s.llm, s.embeddingClient)
-	eventProcessor := eventprocessor.NewModule(projectParser, projectEmbedder, codeAssistant, s.vscClient, s.kafkaConsumer, serviceConfig.WorkerCount)
+// llmHealth lists the models of the provider, which needs both a reachable endpoint and a valid api key.
+func (s *Service) llmHealth(ctx context.Context) error {
+	_, err := s.llmProvider.ListModels(ctx)
+	return err
+}
 
-	eventProcessor.Start()
-	err = s.kafkaConsumer.Start()
+// connectVectorStore connects the backend selected in config, Chroma unless another one is set.
+func (s *Service) connectVectorStore(ctx context.Context) error {
+	conf := s.config.VectorStore
+	backend := conf.Backend
+	if backend == "" {
+		backend = config.VectorStoreChroma
+	}
+	breaker := repositories.WithBreaker(s.newBreaker(backend))
+
+	var vectorStore repositories.VectorStore
+	var err error
+	switch backend {
+	case config.VectorStoreChroma:
+		vectorStore, err = s.connectChroma(breaker)
+	case config.VectorStorePgvector:
+		vectorStore, err = repositories.NewPgvectorRepository(ctx, conf.Pgvector.DSN, conf.Pgvector.Table, breaker)
+	case config.VectorStoreQdrant:
+		vectorStore, err = repositories.NewQdrantRepository(nil, conf.Qdrant.Address, conf.Qdrant.APIKey, conf.Qdrant.Collection, breaker)
+	case config.VectorStoreDisk:
+		vectorStore, err = repositories.NewDiskRepository(conf.Disk.Dir)
+	case config.VectorStoreMemory:
+		vectorStore, err = s.newMemoryRepository(conf.Memory)
+	default:
+		return fmt.Errorf("unknown vector store backend %q", backend)
+	}
 	if err != nil {
-		logger.WithError(err).Fatal("failed to start kafka consumer")
+		return err
 	}
+	s.vectorStore = vectorStore
+	s.embeddings = vectorStore
+	var indexes []retrieval.Index
+	if s.config.Retrieval.Hybrid {
+		s.lexical = retrieval.NewLexicalIndex()
+		indexes = append(indexes, s.lexical)
+	}
+	if s.config.Retrieval.CallGraph.Enabled {
+		s.symbolGraph = retrieval.NewSymbolGraph()
+		indexes = append(indexes, s.symbolGraph)
+	}
+	if len(indexes) > 0 {
+		s.embeddings = retrieval.NewRepository(vectorStore, indexes...)
+	}
+	return nil
 }
 
-func (s *Service) Close() {
-	s.chromaClient.Close()
-	s.kafkaConsumer.Close()
+func (s *Service) connectChroma(opts ...repositories.EmbeddingRepositoryOption) (repositories.VectorStore, error) {
+	chromaClient, err := chroma.NewHTTPClient(chroma.WithBaseURL(s.config.ChromaDB.Address))
+	if err != nil {
+		return nil, err
+	}
+	openaiEmbeddingFunc, err := chromaembedding.NewOpenAIEmbeddingFunction(
+		s.config.LLM.OpenApiKey,
+		chromaembedding.WithBaseURL(s.config.Embedding.APIBaseURL),
+		chromaembedding.WithModel(chromaembedding.EmbeddingModel(s.config.Embedding.Model)),
+	)
+	if err != nil {
+		_ = chromaClient.Close()
+		return nil, fmt.Errorf("failed to create openai embedding function: %w", err)
+	}
+	vectorStore, err := repositories.NewEmbeddingRepository(chromaClient, openaiEmbeddingFunc, s.config.ChromaDB.CollectionName, opts...)
+	if err != nil {
+		_ = chromaClient.Close()
+		return nil, err
+	}
+	return vectorStore, nil
 }
 
-func (s *Service) ConnectToServices(serviceConfig *config.Config) error {
-	// connect to embedding client
-	embeddingClientConfig := openai.DefaultConfig(serviceConfig.LLM.OpenApiKey)
-	if serviceConfig.Embedding.APIBaseURL == "" {
-		return errors.New("empty embedding api base url")
+func (s *Service) newMemoryRepository(conf config.MemorySection) (repositories.VectorStore, error) {
+	var opts []repositories.MemoryRepositoryOption
+	if conf.Metric != "" {
+		opts = append(opts, repositories.WithMetric(repositories.Metric(conf.Metric)))
 	}
-	embeddingClientConfig.BaseURL = serviceConfig.Embedding.APIBaseURL
-	s.embeddingClient = embedder.NewOpenAiEmbeddingClient(openai.NewClientWithConfig(embeddingClientConfig), embedder.WithRetrier(retry.New[openai.EmbeddingResponse](retry.Options{
-		MaxRetries: 3,
-		Strategy:   retry.ExponentialJitterBackoff(500*time.Millisecond, 10*time.Second),
-	})))
-
-	// connect to llm client
-	llm, err := langchainopenai.New(langchainopenai.WithBaseURL(serviceConfig.LLM.APIBaseURL), langchainopenai.WithModel(serviceConfig.LLM.Model), langchainopenai.WithToken(serviceConfig.LLM.OpenApiKey))
+	if conf.Snapshot != "" {
+		opts = append(opts, repositories.WithSnapshot(conf.Snapshot))
+	}
+	repository, err := repositories.NewMemoryRepository(opts...)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return repository, nil
+}
+
+// startJanitor removes the embeddings of idle projects in the background.
+func (s *Service) startJanitor(context.Context) error {
+	s.janitor = janitor.NewJanitor(s.embeddings, s.config.Janitor)
+	s.janitor.Start()
+	return nil
+}
+
+func (s *Service) stopJanitor(context.Context) error {
+	s.janitor.Stop()
+	return nil
+}
+
+func (s *Service) closeVectorStore(context.Context) error {
+	return s.vectorStore.Close()
+}
+
+func (s *Service) vectorStoreHealth(ctx context.Context) error {
+	return s.vectorStore.Health(ctx)
+}
+
+func (s *Service) githubHealth(ctx context.Context) error {
+	return s.vscClient.VerifyToken(ctx)
+}
+
+func (s *Service) connectReviewState(ctx context.Context) error {
+	stateConfig := s.config.ReviewState
+	if stateConfig.TTL <= 0 {
+		stateConfig.TTL = defaultReviewStateTTL
+	}
+	if stateConfig.Redis.Address == "" {
+		log.GetLogger().Warn("no redis configured for the review state, reviewed commits are forgotten on restart")
+		s.reviewState = repositories.NewInMemoryReviewStateRepository()
+		return nil
 	}
-	s.llm = llm
 
-	// connect to chroma db client
-	chromaClient, err := chroma.NewHTTPClient(chroma.WithBaseURL(serviceConfig.ChromaDB.Address))
+	store, err := repositories.NewRedisReviewStateRepository(ctx, redis.Config{
+		Address:  stateConfig.Redis.Address,
+		Password: stateConfig.Redis.Password,
+		DB:       stateConfig.Redis.DB,
+	}, stateConfig.TTL)
 	if err != nil {
 		return err
 	}
-	s.chromaClient = chromaClient
+	s.reviewState, s.reviewStateStore = store, store
+	return nil
+}
 
-	// connect to github
-	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: serviceConfig.Github.AccessToken})
+func (s *Service) closeReviewState(context.Context) error {
+	if s.reviewStateStore == nil {
+		return nil
+	}
+	return s.reviewStateStore.Close()
+}
+
+func (s *Service) reviewStateHealth(ctx context.Context) error {
+	if s.reviewStateStore == nil {
+		return nil
+	}
+	return s.reviewStateStore.Ping(ctx)
+}
+
+func (s *Service) connectGithub(context.Context) error {
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: s.config.Github.AccessToken})
 	tc := oauth2.NewClient(context.Background(), ts)
-	s.vscClient = vsc.NewGithub(github.NewClient(tc), vsc.WithRetry(retry.New[*http.Response](retry.Options{
+	githubOptions := []vsc.GithubOption{vsc.WithRetry(retry.New[*http.Response](retry.Options{
 		MaxRetries: 3,
 		Strategy:   retry.ExponentialJitterBackoff(500*time.Millisecond, 10*time.Second),
-	})))
+		Classify:   retry.ClassifyGithub,
+		MaxDelay:   maxRetryDelay,
+		Breaker:    s.newBreaker("github"),
+		Operation:  "github",
+		OnRetry:    metrics.Get().ObserveRetry,
+	}))}
+	if cacheConfig := s.config.Github.RepositoryCache; cacheConfig.Dir != "" {
+		repositoryCache, err := vsc.NewRepositoryCache(cacheConfig.Dir, cacheConfig.MaxSizeMB*1024*1024)
+		if err != nil {
+			return err
+		}
+		githubOptions = append(githubOptions, vsc.WithRepositoryCache(repositoryCache))
+	}
+	s.vscClient = vsc.NewGithub(github.NewClient(tc), githubOptions...)
+	return nil
+}
 
-	// connect to prometheus
-	metrics.Init(serviceConfig.Prometheus.Address)
+func (s *Service) connectKafkaProducer(context.Context) error {
+	kafkaProducer, err := kafka.NewProducer(kafka.ProducerConfig{
+		Brokers:         s.config.Kafka.Brokers,
+		DeliveryTimeout: s.config.Kafka.DeliveryTimeout,
+	}, kafka.WithProducerMetricsHandler(metrics.Get().ObserveKafkaProduce))
+	if err != nil {
+		return err
+	}
+	s.kafkaProducer = kafkaProducer
+	return nil
+}
 
-	// connect to kafka
-	s.kafkaConsumer, err = kafka.NewConsumer(kafka.ConsumerConfig{
-		Brokers:    serviceConfig.Kafka.Brokers,
-		GroupID:    serviceConfig.Kafka.GroupID,
-		Topics:     []string{serviceConfig.Kafka.Topics},
-		AutoOffset: serviceConfig.Kafka.AutoOffset,
+func (s *Service) closeKafkaProducer(context.Context) error {
+	s.kafkaProducer.Close()
+	return nil
+}
+
+func (s *Service) kafkaProducerHealth(ctx context.Context) error {
+	return s.kafkaProducer.Health(ctx)
+}
+
+func (s *Service) connectKafkaConsumer(context.Context) error {
+	topics := []string{s.config.Kafka.Topics}
+	for _, retryTopic := range s.config.Kafka.RetryTopics {
+		topics = append(topics, retryTopic.Topic)
+	}
+	kafkaConsumer, err := kafka.NewConsumer(kafka.ConsumerConfig{
+		Brokers:      s.config.Kafka.Brokers,
+		GroupID:      s.config.Kafka.GroupID,
+		Topics:       topics,
+		AutoOffset:   s.config.Kafka.AutoOffset,
+		DrainTimeout: s.config.Kafka.DrainTimeout,
 	}, kafka.WithMetricsHandler(metrics.Get().ObserveKafkaPublish))
 	if err != nil {
 		return err
 	}
+	s.kafkaConsumer = kafkaConsumer
+	return nil
+}
 
+// closeKafkaConsumer leaves the consumer group once the event processor has committed its completed reviews.
+func (s *Service) closeKafkaConsumer(context.Context) error {
+	return s.kafkaConsumer.Close()
+}
+
+func (s *Service) kafkaConsumerHealth(ctx context.Context) error {
+	return s.kafkaConsumer.Health(ctx)
+}
+
+func (s *Service) startEventProcessor(lifecycle *app.Lifecycle) error {
+	projectParser := parser.NewProjectParser(map[string]*parser.CodeParser{
+		".py": parser.NewCodeParser(parser.LanguagePython),
+		".go": parser.NewCodeParser(parser.LanguageGo),
+	})
+
+	llmBreaker := s.newBreaker("llm")
+	assistantOptions := []assistant.Option{assistant.WithBreaker(llmBreaker), assistant.WithRetryObserver(metrics.Get().ObserveRetry)}
+	if s.lexical != nil {
+		retriever, err := s.newRetriever(assistant.NewGuardedModel(s.llm, llmBreaker, metrics.Get().ObserveRetry))
+		if err != nil {
+			return err
+		}
+		assistantOptions = append(assistantOptions, assistant.WithRetriever(retriever))
+	}
+	if s.symbolGraph != nil {
+		assistantOptions = append(assistantOptions, assistant.WithSymbolGraph(s.symbolGraph, s.config.Retrieval.CallGraph.MaxSnippets))
+	}
+
+	projectEmbedder := embedder.NewProjectEmbedder(s.embeddingClient, s.embeddings, s.config.Embedding.Model)
+	codeAssistant := assistant.NewAssistant(s.config, s.embeddings, s.llm, s.embeddingClient, assistantOptions...)
+	s.eventProcessor = eventprocessor.NewModule(projectParser, projectEmbedder, codeAssistant, review.NewFilter(s.config.Review), s.reviewState, repositories.NewEmbeddingsIndexStateRepository(s.embeddings), s.vscClient, s.kafkaConsumer, kafka.NewFailureRouter(s.kafkaProducer, retryConfig(s.config.Kafka)), limits.NewLimiter(s.config.Limits), s.janitor, s.config.WorkerCount)
+
+	s.eventProcessor.Start()
+	lifecycle.Go("event-processor", s.eventProcessor.Wait)
+	lifecycle.Go("kafka-consumer", s.kafkaConsumer.Start)
 	return nil
 }
+
+func (s *Service) eventProcessorHealth(ctx context.Context) error {
+	return s.eventProcessor.Health(ctx)
+}
+
+// newRetriever fuses the vector search with the lexical index and the configured reranker, which calls llm.
+func (s *Service) newRetriever(llm llms.Model) (*retrieval.Retriever, error) {
+	conf := s.config.Retrieval
+	opts := []retrieval.RetrieverOption{retrieval.WithCandidates(conf.Candidates), retrieval.WithFusionK(conf.FusionK)}
+	switch conf.Reranker.Type {
+	case "":
+	case config.RerankerLLM:
+		opts = append(opts, retrieval.WithReranker(retrieval.NewLLMReranker(llm)))
+	case config.RerankerCrossEncoder:
+		httpClient := &http.Client{Timeout: 30 * time.Second}
+		opts = append(opts, retrieval.WithReranker(retrieval.NewCrossEncoderReranker(httpClient, conf.Reranker.URL, conf.Reranker.Model, conf.Reranker.APIKey)))
+	default:
+		return nil, fmt.Errorf("unknown reranker %q", conf.Reranker.Type)
+	}
+	return retrieval.NewRetriever(s.lexical, opts...), nil
+}
+
+// stopEventProcessor stops polling and lets the reviews in progress finish until the shutdown timeout, so
+// the offsets of every completed review are committed before the consumer leaves the group.
+func (s *Service) stopEventProcessor(ctx context.Context) error {
+	s.kafkaConsumer.Stop()
+
+	shutdownTimeout := s.config.ShutdownTimeout
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	defer cancel()
+	if err := s.eventProcessor.Shutdown(ctx); err != nil {
+		log.GetLogger().WithError(err).Warn("reviews in progress were cancelled")
+	}
+	return nil
+}
+
+// newBreaker guards the calls to a provider, so an outage fails reviews fast instead of piling up retries.
+func (s *Service) newBreaker(name string) *retry.Breaker {
+	breakerConfig := s.config.CircuitBreaker
+	return retry.NewBreaker(retry.BreakerOptions{
+		Name:             name,
+		Window:           breakerConfig.Window,
+		MinRequests:      breakerConfig.MinRequests,
+		FailureRate:      breakerConfig.FailureRate,
+		CoolDown:         breakerConfig.CoolDown,
+		HalfOpenRequests: breakerConfig.HalfOpenRequests,
+		OnStateChange: func(name string, from, to retry.State) {
+			log.GetLogger().WithField("breaker", name).Warnf("circuit breaker changed from %s to %s", from, to)
+			metrics.Get().ObserveCircuitBreakerState(name, to.String())
+		},
+	})
+}
+
+func retryConfig(kafkaConfig config.KafkaSection) kafka.RetryConfig {
+	retryConfig := kafka.RetryConfig{DeadLetterTopic: kafkaConfig.DeadLetterTopic, RouteTimeout: kafkaConfig.RouteTimeout}
+	for _, retryTopic := range kafkaConfig.RetryTopics {
+		retryConfig.Tiers = append(retryConfig.Tiers, kafka.RetryTier{Topic: retryTopic.Topic, Delay: retryTopic.Delay})
+	}
+	return retryConfig
+}
@@ -9,11 +9,15 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"strings"
 )
 
 type Github struct {
 	githubClient *github.Client
 	retrier      retry.Retrier[*http.Response]
+	cache        *RepositoryCache
+	// httpClient is the authenticated client of githubClient, the anonymous rate limit is far lower.
+	httpClient *http.Client
 }
 
 type GithubOption func(github *Github)
@@ -24,9 +28,19 @@ func WithRetry(retrier retry.Retrier[*http.Response]) GithubOption {
 	}
 }
 
+func WithRepositoryCache(cache *RepositoryCache) GithubOption {
+	return func(github *Github) {
+		github.cache = cache
+	}
+}
+
 func NewGithub(githubClient *github.Client, opts ...GithubOption) VersionControlSystem {
 	g := &Github{
 		githubClient: githubClient,
+		httpClient:   http.DefaultClient,
+	}
+	if githubClient != nil {
+		g.httpClient = githubClient.Client()
 	}
 
 	for _, opt := range opts {
@@ -34,7 +48,7 @@ func NewGithub(githubClient *github.Client, opts ...GithubOption) VersionControl
 	}
 
 	if g.retrier == nil {
-		g.retrier = retry.New[*http.Response](retry.Options{MaxRetries: 1})
+		g.retrier = retry.New[*http.Response](retry.Options{MaxRetries: 1, Classify: retry.ClassifyGithub})
 	}
 
 	return g
@@ -48,18 +62,22 @@ func (g *Github) DownloadUrl(ctx context.Context, url string) (string, error) {
 
 	req.Header.Set("Accept", "application/vnd.github.v3.diff")
 	resp, err := g.retrier.Do(ctx, func() (*http.Response, error) {
-		return http.DefaultClient.Do(req)
+		resp, err := g.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 300 {
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			return nil, retry.ClassifyResponse(resp, fmt.Errorf("unexpected response: %d\nBody: %s", resp.StatusCode, string(body)))
+		}
+		return resp, nil
 	})
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("unexpected response: %d\nBody: %s", resp.StatusCode, string(body))
-	}
-
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return "", err
@@ -68,7 +86,34 @@ func (g *Github) DownloadUrl(ctx context.Context, url string) (string, error) {
 	return string(data), nil
 }
 
-func (g *Github) Clone(ctx context.Context, url, branch string) (string, func() error, error) {
+func (g *Github) DownloadCompareDiff(ctx context.Context, owner, repo, base, head string) (string, error) {
+	var diff string
+	_, err := g.retrier.Do(ctx, func() (*http.Response, error) {
+		var err error
+		diff, _, err = g.githubClient.Repositories.CompareCommitsRaw(ctx, owner, repo, base, head, github.RawOptions{Type: github.Diff})
+		return nil, err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return diff, nil
+}
+
+// Clone checks out exactly request.SHA. The commit is fetched from the head repository first and from the
+// pull request ref of the base repository if that fails, which also covers forks and deleted branches.
+func (g *Github) Clone(ctx context.Context, request CloneRequest) (string, func() error, error) {
+	if g.cache != nil {
+		var dir string
+		var cleanup func() error
+		_, err := g.retrier.Do(ctx, func() (*http.Response, error) {
+			var err error
+			dir, cleanup, err = g.cache.Checkout(ctx, request)
+			return nil, err
+		})
+		return dir, cleanup, err
+	}
+
 	dir, err := os.MkdirTemp("", "gh-pr-*")
 	if err != nil {
 		return "", nil, err
@@ -79,9 +124,10 @@ func (g *Github) Clone(ctx context.Context, url, branch string) (string, func()
 	}
 
 	_, err = g.retrier.Do(ctx, func() (*http.Response, error) {
-		cmd := exec.CommandContext(ctx, "git", "clone", "--depth=1", "--branch", branch, url, dir)
-		_, err = cmd.CombinedOutput()
-		return nil, err
+		if request.SHA == "" {
+			return nil, runGit(ctx, "", "clone", "--depth=1", "--branch", request.Branch, request.URL, dir)
+		}
+		return nil, fetchCommit(ctx, dir, request)
 	})
 	if err != nil {
 		_ = cleanup()
@@ -91,6 +137,50 @@ func (g *Github) Clone(ctx context.Context, url, branch string) (string, func()
 	return dir, cleanup, nil
 }
 
+func fetchCommit(ctx context.Context, dir string, request CloneRequest) error {
+	if err := runGit(ctx, dir, "init", "--quiet"); err != nil {
+		return err
+	}
+
+	headURL := request.HeadURL
+	if headURL == "" {
+		headURL = request.URL
+	}
+	err := runGit(ctx, dir, "fetch", "--quiet", "--depth=1", headURL, request.SHA)
+	if err != nil && request.PRNumber > 0 {
+		err = runGit(ctx, dir, "fetch", "--quiet", "--depth=1", request.URL, fmt.Sprintf("refs/pull/%d/head", request.PRNumber))
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := runGit(ctx, dir, "checkout", "--quiet", "--detach", "FETCH_HEAD"); err != nil {
+		return err
+	}
+
+	output, err := exec.CommandContext(ctx, "git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return err
+	}
+	if checkedOut := strings.TrimSpace(string(output)); checkedOut != request.SHA {
+		return fmt.Errorf("%w: checked out %s, expected %s", ErrHeadMismatch, checkedOut, request.SHA)
+	}
+
+	return nil
+}
+
+func runGit(ctx context.Context, dir string, args ...string) error {
+	command := args[0]
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	output, err := exec.CommandContext(ctx, "git", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s: %w: %s", command, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
 func (g *Github) PostPRComment(ctx context.Context, prNumber int, body, owner, repo string) error {
 	comment := &github.IssueComment{Body: &body}
 	_, err := g.retrier.Do(ctx, func() (*http.Response, error) {
@@ -102,3 +192,118 @@ func (g *Github) PostPRComment(ctx context.Context, prNumber int, body, owner, r
 	}
 	return nil
 }
+
+// UpsertPRComment edits the first pull request comment containing marker, or posts a new comment if there is none.
+func (g *Github) UpsertPRComment(ctx context.Context, prNumber int, body, owner, repo, marker string) error {
+	existing, err := g.findPRComment(ctx, prNumber, owner, repo, marker)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return g.PostPRComment(ctx, prNumber, body, owner, repo)
+	}
+
+	_, err = g.retrier.Do(ctx, func() (*http.Response, error) {
+		_, _, err := g.githubClient.Issues.EditComment(ctx, owner, repo, existing.GetID(), &github.IssueComment{Body: &body})
+		return nil, err
+	})
+	return err
+}
+
+func (g *Github) findPRComment(ctx context.Context, prNumber int, owner, repo, marker string) (*github.IssueComment, error) {
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		var comments []*github.IssueComment
+		var resp *github.Response
+		_, err := g.retrier.Do(ctx, func() (*http.Response, error) {
+			var err error
+			comments, resp, err = g.githubClient.Issues.ListComments(ctx, owner, repo, prNumber, opts)
+			return nil, err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, comment := range comments {
+			if strings.Contains(comment.GetBody(), marker) {
+				return comment, nil
+			}
+		}
+
+		if resp == nil || resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func (g *Github) ListReviewComments(ctx context.Context, prNumber int, owner, repo string) ([]*ReviewComment, error) {
+	var result []*ReviewComment
+	opts := &github.PullRequestListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		var comments []*github.PullRequestComment
+		var resp *github.Response
+		_, err := g.retrier.Do(ctx, func() (*http.Response, error) {
+			var err error
+			comments, resp, err = g.githubClient.PullRequests.ListComments(ctx, owner, repo, prNumber, opts)
+			return nil, err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, comment := range comments {
+			result = append(result, &ReviewComment{
+				ID:   comment.GetID(),
+				Path: comment.GetPath(),
+				Line: comment.GetLine(),
+				Body: comment.GetBody(),
+			})
+		}
+
+		if resp == nil || resp.NextPage == 0 {
+			return result, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// CreateReviewComments posts all comments as inline threads of a single pull request review.
+func (g *Github) CreateReviewComments(ctx context.Context, prNumber int, owner, repo string, comments []*ReviewComment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+
+	draftComments := make([]*github.DraftReviewComment, 0, len(comments))
+	for _, comment := range comments {
+		draftComments = append(draftComments, &github.DraftReviewComment{
+			Path: github.String(comment.Path),
+			Line: github.Int(comment.Line),
+			Side: github.String("RIGHT"),
+			Body: github.String(comment.Body),
+		})
+	}
+
+	_, err := g.retrier.Do(ctx, func() (*http.Response, error) {
+		_, _, err := g.githubClient.PullRequests.CreateReview(ctx, owner, repo, prNumber, &github.PullRequestReviewRequest{
+			Event:    github.String("COMMENT"),
+			Comments: draftComments,
+		})
+		return nil, err
+	})
+	return err
+}
+
+func (g *Github) EditReviewComment(ctx context.Context, owner, repo string, commentID int64, body string) error {
+	_, err := g.retrier.Do(ctx, func() (*http.Response, error) {
+		_, _, err := g.githubClient.PullRequests.EditComment(ctx, owner, repo, commentID, &github.PullRequestComment{Body: &body})
+		return nil, err
+	})
+	return err
+}
+
+// VerifyToken asks for the authenticated user, which GitHub rejects without a valid token.
+func (g *Github) VerifyToken(ctx context.Context) error {
+	_, _, err := g.githubClient.Users.Get(ctx, "")
+	return err
+}
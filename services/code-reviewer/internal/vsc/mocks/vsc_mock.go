@@ -11,6 +11,7 @@ package mocks
 
 import (
 	context "context"
+	vsc "go_code_reviewer/services/code-reviewer/internal/vsc"
 	reflect "reflect"
 
 	gomock "go.uber.org/mock/gomock"
@@ -41,9 +42,9 @@ func (m *MockVersionControlSystem) EXPECT() *MockVersionControlSystemMockRecorde
 }
 
 // Clone mocks base method.
-func (m *MockVersionControlSystem) Clone(ctx context.Context, url, branch string) (string, func() error, error) {
+func (m *MockVersionControlSystem) Clone(ctx context.Context, request vsc.CloneRequest) (string, func() error, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Clone", ctx, url, branch)
+	ret := m.ctrl.Call(m, "Clone", ctx, request)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(func() error)
 	ret2, _ := ret[2].(error)
@@ -51,9 +52,38 @@ func (m *MockVersionControlSystem) Clone(ctx context.Context, url, branch string
 }
 
 // Clone indicates an expected call of Clone.
-func (mr *MockVersionControlSystemMockRecorder) Clone(ctx, url, branch any) *gomock.Call {
+func (mr *MockVersionControlSystemMockRecorder) Clone(ctx, request any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Clone", reflect.TypeOf((*MockVersionControlSystem)(nil).Clone), ctx, url, branch)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Clone", reflect.TypeOf((*MockVersionControlSystem)(nil).Clone), ctx, request)
+}
+
+// CreateReviewComments mocks base method.
+func (m *MockVersionControlSystem) CreateReviewComments(ctx context.Context, prNumber int, owner, repo string, comments []*vsc.ReviewComment) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateReviewComments", ctx, prNumber, owner, repo, comments)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateReviewComments indicates an expected call of CreateReviewComments.
+func (mr *MockVersionControlSystemMockRecorder) CreateReviewComments(ctx, prNumber, owner, repo, comments any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateReviewComments", reflect.TypeOf((*MockVersionControlSystem)(nil).CreateReviewComments), ctx, prNumber, owner, repo, comments)
+}
+
+// DownloadCompareDiff mocks base method.
+func (m *MockVersionControlSystem) DownloadCompareDiff(ctx context.Context, owner, repo, base, head string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DownloadCompareDiff", ctx, owner, repo, base, head)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DownloadCompareDiff indicates an expected call of DownloadCompareDiff.
+func (mr *MockVersionControlSystemMockRecorder) DownloadCompareDiff(ctx, owner, repo, base, head any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadCompareDiff", reflect.TypeOf((*MockVersionControlSystem)(nil).DownloadCompareDiff), ctx, owner, repo, base, head)
 }
 
 // DownloadUrl mocks base method.
@@ -71,6 +101,35 @@ func (mr *MockVersionControlSystemMockRecorder) DownloadUrl(ctx, url any) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadUrl", reflect.TypeOf((*MockVersionControlSystem)(nil).DownloadUrl), ctx, url)
 }
 
+// EditReviewComment mocks base method.
+func (m *MockVersionControlSystem) EditReviewComment(ctx context.Context, owner, repo string, commentID int64, body string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EditReviewComment", ctx, owner, repo, commentID, body)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EditReviewComment indicates an expected call of EditReviewComment.
+func (mr *MockVersionControlSystemMockRecorder) EditReviewComment(ctx, owner, repo, commentID, body any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EditReviewComment", reflect.TypeOf((*MockVersionControlSystem)(nil).EditReviewComment), ctx, owner, repo, commentID, body)
+}
+
+// ListReviewComments mocks base method.
+func (m *MockVersionControlSystem) ListReviewComments(ctx context.Context, prNumber int, owner, repo string) ([]*vsc.ReviewComment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListReviewComments", ctx, prNumber, owner, repo)
+	ret0, _ := ret[0].([]*vsc.ReviewComment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListReviewComments indicates an expected call of ListReviewComments.
+func (mr *MockVersionControlSystemMockRecorder) ListReviewComments(ctx, prNumber, owner, repo any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListReviewComments", reflect.TypeOf((*MockVersionControlSystem)(nil).ListReviewComments), ctx, prNumber, owner, repo)
+}
+
 // PostPRComment mocks base method.
 func (m *MockVersionControlSystem) PostPRComment(ctx context.Context, prNumber int, body, owner, repo string) error {
 	m.ctrl.T.Helper()
@@ -84,3 +143,31 @@ func (mr *MockVersionControlSystemMockRecorder) PostPRComment(ctx, prNumber, bod
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PostPRComment", reflect.TypeOf((*MockVersionControlSystem)(nil).PostPRComment), ctx, prNumber, body, owner, repo)
 }
+
+// UpsertPRComment mocks base method.
+func (m *MockVersionControlSystem) UpsertPRComment(ctx context.Context, prNumber int, body, owner, repo, marker string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpsertPRComment", ctx, prNumber, body, owner, repo, marker)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpsertPRComment indicates an expected call of UpsertPRComment.
+func (mr *MockVersionControlSystemMockRecorder) UpsertPRComment(ctx, prNumber, body, owner, repo, marker any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertPRComment", reflect.TypeOf((*MockVersionControlSystem)(nil).UpsertPRComment), ctx, prNumber, body, owner, repo, marker)
+}
+
+// VerifyToken mocks base method.
+func (m *MockVersionControlSystem) VerifyToken(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifyToken", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// VerifyToken indicates an expected call of VerifyToken.
+func (mr *MockVersionControlSystemMockRecorder) VerifyToken(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyToken", reflect.TypeOf((*MockVersionControlSystem)(nil).VerifyToken), ctx)
+}
@@ -0,0 +1,221 @@
+package vsc
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"go_code_reviewer/pkg/log"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RepositoryCache keeps a bare mirror per repository on local disk, so a checkout only fetches the objects
+// that are missing and then adds a throwaway worktree for the job.
+type RepositoryCache struct {
+	root     string
+	maxBytes int64
+
+	mu      sync.Mutex
+	mirrors map[string]*mirror
+}
+
+type mirror struct {
+	mu        sync.Mutex
+	worktrees int
+}
+
+func NewRepositoryCache(root string, maxBytes int64) (*RepositoryCache, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	return &RepositoryCache{
+		root:     root,
+		maxBytes: maxBytes,
+		mirrors:  make(map[string]*mirror),
+	}, nil
+}
+
+func (c *RepositoryCache) Checkout(ctx context.Context, request CloneRequest) (string, func() error, error) {
+	mirrorPath := c.mirrorPath(request.URL)
+	m := c.mirror(mirrorPath)
+
+	m.mu.Lock()
+	sha, err := c.fetch(ctx, mirrorPath, request)
+	if err != nil {
+		m.mu.Unlock()
+		return "", nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "gh-pr-*")
+	if err != nil {
+		m.mu.Unlock()
+		return "", nil, err
+	}
+	if err := runGit(ctx, mirrorPath, "worktree", "add", "--quiet", "--detach", dir, sha); err != nil {
+		m.mu.Unlock()
+		_ = os.RemoveAll(dir)
+		return "", nil, err
+	}
+	m.worktrees++
+	m.mu.Unlock()
+
+	cleanup := func() error {
+		m.mu.Lock()
+		err := runGit(context.Background(), mirrorPath, "worktree", "remove", "--force", dir)
+		m.worktrees--
+		m.mu.Unlock()
+
+		_ = os.RemoveAll(dir)
+		now := time.Now()
+		_ = os.Chtimes(mirrorPath, now, now)
+		c.evict()
+		return err
+	}
+
+	return dir, cleanup, nil
+}
+
+// fetch brings the requested commit into the mirror and returns its sha.
+func (c *RepositoryCache) fetch(ctx context.Context, mirrorPath string, request CloneRequest) (string, error) {
+	if _, err := os.Stat(mirrorPath); os.IsNotExist(err) {
+		if err := runGit(ctx, "", "clone", "--quiet", "--bare", request.URL, mirrorPath); err != nil {
+			_ = os.RemoveAll(mirrorPath)
+			return "", err
+		}
+	} else if err := runGit(ctx, mirrorPath, "worktree", "prune"); err != nil {
+		return "", err
+	}
+
+	if request.SHA == "" {
+		if err := runGit(ctx, mirrorPath, "fetch", "--quiet", request.URL, request.Branch); err != nil {
+			return "", err
+		}
+		output, err := exec.CommandContext(ctx, "git", "-C", mirrorPath, "rev-parse", "FETCH_HEAD").Output()
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(output)), nil
+	}
+
+	if hasCommit(ctx, mirrorPath, request.SHA) {
+		return request.SHA, nil
+	}
+
+	headURL := request.HeadURL
+	if headURL == "" {
+		headURL = request.URL
+	}
+	err := runGit(ctx, mirrorPath, "fetch", "--quiet", headURL, request.SHA)
+	if err != nil && request.PRNumber > 0 {
+		err = runGit(ctx, mirrorPath, "fetch", "--quiet", request.URL, fmt.Sprintf("refs/pull/%d/head", request.PRNumber))
+	}
+	if err != nil {
+		return "", err
+	}
+	if !hasCommit(ctx, mirrorPath, request.SHA) {
+		return "", fmt.Errorf("%w: %s is not reachable from the pull request ref", ErrHeadMismatch, request.SHA)
+	}
+
+	return request.SHA, nil
+}
+
+func hasCommit(ctx context.Context, mirrorPath, sha string) bool {
+	return exec.CommandContext(ctx, "git", "-C", mirrorPath, "cat-file", "-e", sha+"^{commit}").Run() == nil
+}
+
+func (c *RepositoryCache) mirrorPath(url string) string {
+	sum := sha1.Sum([]byte(url))
+	return filepath.Join(c.root, hex.EncodeToString(sum[:])[:16]+".git")
+}
+
+func (c *RepositoryCache) mirror(path string) *mirror {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, ok := c.mirrors[path]
+	if !ok {
+		m = &mirror{}
+		c.mirrors[path] = m
+	}
+	return m
+}
+
+type mirrorUsage struct {
+	path     string
+	size     int64
+	lastUsed time.Time
+}
+
+// evict removes the least recently used mirrors that have no active worktree until the cache fits in maxBytes.
+func (c *RepositoryCache) evict() {
+	if c.maxBytes <= 0 {
+		return
+	}
+	logger := log.GetLogger()
+
+	entries, err := os.ReadDir(c.root)
+	if err != nil {
+		logger.WithError(err).Warn("failed to read repository cache")
+		return
+	}
+
+	var usages []mirrorUsage
+	var total int64
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(c.root, entry.Name())
+		size := directorySize(path)
+		total += size
+		usages = append(usages, mirrorUsage{path: path, size: size, lastUsed: info.ModTime()})
+	}
+
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].lastUsed.Before(usages[j].lastUsed)
+	})
+
+	for _, usage := range usages {
+		if total <= c.maxBytes {
+			return
+		}
+		m := c.mirror(usage.path)
+		if !m.mu.TryLock() {
+			continue
+		}
+		if m.worktrees == 0 {
+			if err := os.RemoveAll(usage.path); err != nil {
+				logger.WithError(err).Warn("failed to evict repository mirror")
+			} else {
+				total -= usage.size
+				logger.WithField("mirror", usage.path).Info("evicted repository mirror")
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+func directorySize(path string) int64 {
+	var size int64
+	_ = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -2,10 +2,35 @@ package vsc
 
 import (
 	"context"
+	"errors"
 )
 
+var ErrHeadMismatch = errors.New("checked out commit does not match the pull request head")
+
+type CloneRequest struct {
+	URL      string
+	HeadURL  string
+	Branch   string
+	SHA      string
+	PRNumber int
+}
+
+type ReviewComment struct {
+	ID   int64
+	Path string
+	Line int
+	Body string
+}
+
 type VersionControlSystem interface {
 	DownloadUrl(ctx context.Context, url string) (string, error)
-	Clone(ctx context.Context, url, branch string) (string, func() error, error)
+	DownloadCompareDiff(ctx context.Context, owner, repo, base, head string) (string, error)
+	Clone(ctx context.Context, request CloneRequest) (string, func() error, error)
 	PostPRComment(ctx context.Context, prNumber int, body, owner, repo string) error
+	UpsertPRComment(ctx context.Context, prNumber int, body, owner, repo, marker string) error
+	ListReviewComments(ctx context.Context, prNumber int, owner, repo string) ([]*ReviewComment, error)
+	CreateReviewComments(ctx context.Context, prNumber int, owner, repo string, comments []*ReviewComment) error
+	EditReviewComment(ctx context.Context, owner, repo string, commentID int64, body string) error
+	// VerifyToken fails if the access token is missing, expired or revoked.
+	VerifyToken(ctx context.Context) error
 }
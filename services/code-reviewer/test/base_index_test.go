@@ -0,0 +1,374 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"go.uber.org/mock/gomock"
+	"go_code_reviewer/services/api-gateway/pkg/models"
+	"go_code_reviewer/services/code-reviewer/internal/embedder"
+	codemodels "go_code_reviewer/services/code-reviewer/internal/models"
+	"go_code_reviewer/services/code-reviewer/internal/repositories"
+	"go_code_reviewer/services/code-reviewer/internal/retrieval"
+	"go_code_reviewer/services/code-reviewer/internal/vsc"
+	"go_code_reviewer/services/code-reviewer/testkit"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const mainChangeDiff = "diff --git a/main.go b/main.go\n" +
+	"--- a/main.go\n" +
+	"+++ b/main.go\n" +
+	"@@ -1,3 +1,3 @@\n" +
+	" package main\n" +
+	"-func main() {}\n" +
+	"+func main() { run() }\n"
+
+func TestOverlay_ShadowsBaseSnippetsOfChangedFiles(t *testing.T) {
+	repository, err := repositories.NewMemoryRepository()
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, repository.Add(ctx, []*codemodels.Snippet{
+		{ID: "1", Content: "func main() {}", Filename: "main.go", Embedding: []float32{1, 0}},
+		{ID: "2", Content: "func util() {}", Filename: "util.go", Embedding: []float32{1, 0.2}},
+		{ID: "3", Content: "func removed() {}", Filename: "removed.go", Embedding: []float32{1, 0.1}},
+	}, "owner/repo@main"))
+	require.NoError(t, repository.Add(ctx, []*codemodels.Snippet{
+		{ID: "1", Content: "func main() { run() }", Filename: "main.go", Embedding: []float32{1, 0}},
+	}, "owner/repo/feature/1"))
+
+	overlay := repositories.Overlay{
+		ProjectId:     "owner/repo/feature/1",
+		BaseProjectId: "owner/repo@main",
+		ChangedFiles:  []string{"main.go", "removed.go"},
+	}
+	snippets, err := overlay.GetNearestRecord(ctx, repository, []float32{1, 0}, 5)
+	require.NoError(t, err)
+	require.Len(t, snippets, 2)
+	assert.Equal(t, "func main() { run() }", snippets[0].Content)
+	assert.Equal(t, "func util() {}", snippets[1].Content)
+
+	snippets, err = repositories.Overlay{ProjectId: "owner/repo@main"}.GetNearestRecord(ctx, repository, []float32{1, 0}, 5)
+	require.NoError(t, err)
+	assert.Len(t, snippets, 3, "without a base project the overlay is a plain project")
+}
+
+func TestIsBaseProjectIdentifier(t *testing.T) {
+	prEvent := testkit.GenerateRandomPullRequestEvent()
+	prEvent.Branch = "feature@v2/fix"
+	assert.False(t, models.IsBaseProjectIdentifier(models.GetProjectIdentifier(prEvent)))
+	assert.True(t, models.IsBaseProjectIdentifier(models.GetBaseProjectIdentifier(prEvent.Owner, prEvent.Repo, "release/v2")))
+}
+
+func TestProcessPushEvent_IndexesOnlyChangedFiles(t *testing.T) {
+	service := testkit.NewService(t)
+	repository, err := repositories.NewMemoryRepository()
+	require.NoError(t, err)
+	service.EmbeddingsRepository = repository
+	service.KafkaConsumer.EXPECT().Start().Times(1)
+	ch := make(chan *kafka.Message, 1)
+	service.KafkaConsumer.EXPECT().Channel().Return(ch).AnyTimes()
+	service.KafkaConsumer.EXPECT().Context(gomock.Any()).Return(context.Background()).AnyTimes()
+
+	push := &models.PushEvent{
+		Owner:     "MSaeed1381",
+		Repo:      "message-broker",
+		Branch:    "main",
+		CloneURL:  "https://github.com/MSaeed1381/message-broker.git",
+		BeforeSHA: "9049f1265b7d61be4a8904a9a27120d2064dab3b",
+		HeadSHA:   "6dcb09b5b57875f334f61aebed695e2e4193db5e",
+	}
+	baseProjectId := models.GetBaseProjectIdentifier(push.Owner, push.Repo, push.Branch)
+	ctx := context.Background()
+	require.NoError(t, repository.Add(ctx, []*codemodels.Snippet{
+		{ID: "1", Content: "func main() {}", Filename: "main.go", Embedding: []float32{1, 0}},
+		{ID: "2", Content: "func util() {}", Filename: "util.go", Embedding: []float32{0, 1}},
+	}, baseProjectId))
+	require.NoError(t, service.IndexState.SetIndexedSHA(ctx, baseProjectId, push.BeforeSHA))
+
+	marshal, err := json.Marshal(push)
+	require.NoError(t, err)
+	kafkaMessage := &kafka.Message{
+		Key:   []byte(baseProjectId),
+		Value: marshal,
+		Headers: []kafka.Header{
+			{Key: models.HeaderEventType, Value: []byte(models.EventTypePush)},
+			{Key: models.HeaderSchemaVersion, Value: []byte(models.SchemaVersion)},
+		},
+	}
+	ch <- kafkaMessage
+
+	dirPath := writeProject(t, map[string]string{
+		"main.go": "package main\n\nfunc main() { run() }\n",
+		"util.go": "package main\n\nfunc util() {}\n",
+	})
+	service.VSCClient.EXPECT().Clone(gomock.Any(), vsc.CloneRequest{URL: push.CloneURL, Branch: push.Branch, SHA: push.HeadSHA}).Return(dirPath, func() error { return nil }, nil).Times(1)
+	service.VSCClient.EXPECT().DownloadCompareDiff(gomock.Any(), push.Owner, push.Repo, push.BeforeSHA, push.HeadSHA).Return(mainChangeDiff, nil).Times(1)
+	// util.go is unchanged and not embedded again
+	service.EmbeddingClient.EXPECT().CreateEmbeddings(gomock.Any(), gomock.Any(), []string{"func main() { run() }"}).Return([]embedder.Embedding{{Embedding: []float32{1, 0}}}, nil).Times(1)
+	committed := make(chan struct{})
+	service.KafkaConsumer.EXPECT().CommitMessage(kafkaMessage).DoAndReturn(func(*kafka.Message) error {
+		close(committed)
+		return nil
+	}).Times(1)
+
+	service.Start()
+	select {
+	case <-committed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("push event was not committed")
+	}
+
+	snippets, err := repository.GetNearestRecord(ctx, []float32{1, 1}, 5, baseProjectId)
+	require.NoError(t, err)
+	var contents []string
+	for _, snippet := range snippets {
+		contents = append(contents, snippet.Content)
+	}
+	assert.ElementsMatch(t, []string{"func main() { run() }", "func util() {}"}, contents)
+	sha, ok, err := service.IndexState.GetIndexedSHA(ctx, baseProjectId)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, push.HeadSHA, sha)
+}
+
+func TestProcessPushEvent_ResumesFromStoredIndexAfterRestart(t *testing.T) {
+	service := testkit.NewService(t)
+	memory, err := repositories.NewMemoryRepository()
+	require.NoError(t, err)
+	push := &models.PushEvent{
+		Owner:     "MSaeed1381",
+		Repo:      "message-broker",
+		Branch:    "main",
+		CloneURL:  "https://github.com/MSaeed1381/message-broker.git",
+		BeforeSHA: "9049f1265b7d61be4a8904a9a27120d2064dab3b",
+		HeadSHA:   "6dcb09b5b57875f334f61aebed695e2e4193db5e",
+	}
+	baseProjectId := models.GetBaseProjectIdentifier(push.Owner, push.Repo, push.Branch)
+	ctx := context.Background()
+	// stored before the restart, the lexical index starts empty
+	require.NoError(t, memory.Add(ctx, []*codemodels.Snippet{
+		{ID: "1", Content: "func main() {}", Filename: "main.go", Embedding: []float32{1, 0}, Commit: push.BeforeSHA},
+		{ID: "2", Content: "func util() {}", Filename: "util.go", Embedding: []float32{0, 1}, Commit: push.BeforeSHA},
+	}, baseProjectId))
+	lexical := retrieval.NewLexicalIndex()
+	service.EmbeddingsRepository = retrieval.NewRepository(memory, lexical)
+	service.IndexState = repositories.NewEmbeddingsIndexStateRepository(memory)
+	service.KafkaConsumer.EXPECT().Start().Times(1)
+	ch := make(chan *kafka.Message, 1)
+	service.KafkaConsumer.EXPECT().Channel().Return(ch).AnyTimes()
+	service.KafkaConsumer.EXPECT().Context(gomock.Any()).Return(context.Background()).AnyTimes()
+
+	marshal, err := json.Marshal(push)
+	require.NoError(t, err)
+	kafkaMessage := &kafka.Message{
+		Key:   []byte(baseProjectId),
+		Value: marshal,
+		Headers: []kafka.Header{
+			{Key: models.HeaderEventType, Value: []byte(models.EventTypePush)},
+			{Key: models.HeaderSchemaVersion, Value: []byte(models.SchemaVersion)},
+		},
+	}
+	ch <- kafkaMessage
+
+	dirPath := writeProject(t, map[string]string{
+		"main.go": "package main\n\nfunc main() { run() }\n",
+		"util.go": "package main\n\nfunc util() {}\n",
+	})
+	service.VSCClient.EXPECT().Clone(gomock.Any(), vsc.CloneRequest{URL: push.CloneURL, Branch: push.Branch, SHA: push.HeadSHA}).Return(dirPath, func() error { return nil }, nil).Times(1)
+	service.VSCClient.EXPECT().DownloadCompareDiff(gomock.Any(), push.Owner, push.Repo, push.BeforeSHA, push.HeadSHA).Return(mainChangeDiff, nil).Times(1)
+	// only the changed file is embedded, the stored index is not built again
+	service.EmbeddingClient.EXPECT().CreateEmbeddings(gomock.Any(), gomock.Any(), []string{"func main() { run() }"}).Return([]embedder.Embedding{{Embedding: []float32{1, 0}}}, nil).Times(1)
+	committed := make(chan struct{})
+	service.KafkaConsumer.EXPECT().CommitMessage(kafkaMessage).DoAndReturn(func(*kafka.Message) error {
+		close(committed)
+		return nil
+	}).Times(1)
+
+	service.Start()
+	select {
+	case <-committed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("push event was not committed")
+	}
+
+	var restored []string
+	for _, snippet := range lexical.Search(baseProjectId, "main util run", 5) {
+		restored = append(restored, snippet.Content)
+	}
+	assert.ElementsMatch(t, []string{"func main() { run() }", "func util() {}"}, restored, "the lexical index is rebuilt from the clone")
+	projects, err := memory.ListProjects(ctx)
+	require.NoError(t, err)
+	require.Len(t, projects, 1)
+	assert.Equal(t, push.HeadSHA, projects[0].Commit, "the indexed commit is stored with the snippets")
+	sha, ok, err := repositories.NewEmbeddingsIndexStateRepository(memory).GetIndexedSHA(ctx, baseProjectId)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, push.HeadSHA, sha, "another replica or restart resumes from the pushed commit")
+}
+
+func TestProcessPullRequestEvent_EmbedsChangedFilesOverBaseIndex(t *testing.T) {
+	service := testkit.NewService(t)
+	repository, err := repositories.NewMemoryRepository()
+	require.NoError(t, err)
+	service.EmbeddingsRepository = repository
+	service.KafkaConsumer.EXPECT().Start().Times(1)
+	ch := make(chan *kafka.Message, 1)
+	service.KafkaConsumer.EXPECT().Channel().Return(ch).AnyTimes()
+	service.KafkaConsumer.EXPECT().Context(gomock.Any()).Return(context.Background()).AnyTimes()
+
+	prEvent := testkit.GenerateRandomPullRequestEvent()
+	prEvent.BaseBranch = "main"
+	prEvent.DefaultBranch = "main"
+	marshal, err := json.Marshal(prEvent)
+	require.NoError(t, err)
+	kafkaMessage := &kafka.Message{Value: marshal}
+	ch <- kafkaMessage
+
+	baseDir := writeProject(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n",
+		"util.go": "package main\n\nfunc util() {}\n",
+	})
+	headDir := writeProject(t, map[string]string{
+		"main.go": "package main\n\nfunc main() { run() }\n",
+		"util.go": "package main\n\nfunc util() {}\n",
+	})
+	service.VSCClient.EXPECT().Clone(gomock.Any(), vsc.CloneRequest{URL: prEvent.CloneURL, Branch: "main", SHA: prEvent.BaseSHA}).Return(baseDir, func() error { return nil }, nil).Times(1)
+	service.VSCClient.EXPECT().Clone(gomock.Any(), testkit.CloneRequest(prEvent)).Return(headDir, func() error { return nil }, nil).Times(1)
+	// the diff of an opened pull request is downloaded once, for the overlay and the review
+	service.VSCClient.EXPECT().DownloadUrl(gomock.Any(), prEvent.DiffURL).Return(mainChangeDiff, nil).Times(1)
+
+	var mu sync.Mutex
+	var embedded [][]string
+	service.EmbeddingClient.EXPECT().CreateEmbeddings(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, texts []string) ([]embedder.Embedding, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		embedded = append(embedded, texts)
+		result := make([]embedder.Embedding, len(texts))
+		for i := range result {
+			result[i] = embedder.Embedding{Embedding: []float32{1, float32(i)}}
+		}
+		return result, nil
+	}).Times(3)
+
+	var prompt string
+	service.LLM.EXPECT().GenerateContent(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, messages []llms.MessageContent, _ ...llms.CallOption) (*llms.ContentResponse, error) {
+			prompt = fmt.Sprint(messages[0].Parts[0])
+			return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "LGTM"}}}, nil
+		}).Times(1)
+	service.VSCClient.EXPECT().ListReviewComments(gomock.Any(), prEvent.Number, prEvent.Owner, prEvent.Repo).Return(nil, nil).Times(1)
+	service.VSCClient.EXPECT().UpsertPRComment(gomock.Any(), prEvent.Number, gomock.Any(), prEvent.Owner, prEvent.Repo, gomock.Any()).Return(nil).Times(1)
+	committed := make(chan struct{})
+	service.KafkaConsumer.EXPECT().CommitMessage(kafkaMessage).DoAndReturn(func(*kafka.Message) error {
+		close(committed)
+		return nil
+	}).Times(1)
+
+	service.Start()
+	select {
+	case <-committed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pull request event was not committed")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, [][]string{
+		{"func main() {}", "func util() {}"},
+		{"func main() { run() }"},
+		{mainChangeDiff},
+	}, embedded, "the base branch is indexed once, the pull request only embeds its changed files")
+	_, retrieved, _ := strings.Cut(prompt, "### Context:")
+	assert.Contains(t, retrieved, "func main() { run() }")
+	assert.Contains(t, retrieved, "func util() {}")
+	assert.NotContains(t, retrieved, "func main() {}", "the changed file of the base branch is shadowed")
+
+	sha, ok, err := service.IndexState.GetIndexedSHA(context.Background(), models.GetBaseProjectIdentifier(prEvent.Owner, prEvent.Repo, "main"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, prEvent.BaseSHA, sha)
+}
+
+func TestProcessPullRequestEvent_EmbedsWholeProjectIntoOtherBranches(t *testing.T) {
+	service := testkit.NewService(t)
+	repository, err := repositories.NewMemoryRepository()
+	require.NoError(t, err)
+	service.EmbeddingsRepository = repository
+	service.KafkaConsumer.EXPECT().Start().Times(1)
+	ch := make(chan *kafka.Message, 1)
+	service.KafkaConsumer.EXPECT().Channel().Return(ch).AnyTimes()
+	service.KafkaConsumer.EXPECT().Context(gomock.Any()).Return(context.Background()).AnyTimes()
+
+	prEvent := testkit.GenerateRandomPullRequestEvent()
+	prEvent.BaseBranch = "release"
+	prEvent.DefaultBranch = "main"
+	marshal, err := json.Marshal(prEvent)
+	require.NoError(t, err)
+	kafkaMessage := &kafka.Message{Value: marshal}
+	ch <- kafkaMessage
+
+	headDir := writeProject(t, map[string]string{
+		"main.go": "package main\n\nfunc main() { run() }\n",
+		"util.go": "package main\n\nfunc util() {}\n",
+	})
+	// the release branch is not cloned, only the head of the pull request
+	service.VSCClient.EXPECT().Clone(gomock.Any(), testkit.CloneRequest(prEvent)).Return(headDir, func() error { return nil }, nil).Times(1)
+	service.VSCClient.EXPECT().DownloadUrl(gomock.Any(), prEvent.DiffURL).Return(mainChangeDiff, nil).Times(1)
+
+	var mu sync.Mutex
+	var embedded [][]string
+	service.EmbeddingClient.EXPECT().CreateEmbeddings(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, texts []string) ([]embedder.Embedding, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		embedded = append(embedded, texts)
+		result := make([]embedder.Embedding, len(texts))
+		for i := range result {
+			result[i] = embedder.Embedding{Embedding: []float32{1, float32(i)}}
+		}
+		return result, nil
+	}).Times(2)
+	service.LLM.EXPECT().GenerateContent(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(&llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "LGTM"}}}, nil).Times(1)
+	service.VSCClient.EXPECT().ListReviewComments(gomock.Any(), prEvent.Number, prEvent.Owner, prEvent.Repo).Return(nil, nil).Times(1)
+	service.VSCClient.EXPECT().UpsertPRComment(gomock.Any(), prEvent.Number, gomock.Any(), prEvent.Owner, prEvent.Repo, gomock.Any()).Return(nil).Times(1)
+	committed := make(chan struct{})
+	service.KafkaConsumer.EXPECT().CommitMessage(kafkaMessage).DoAndReturn(func(*kafka.Message) error {
+		close(committed)
+		return nil
+	}).Times(1)
+
+	service.Start()
+	select {
+	case <-committed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pull request event was not committed")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, [][]string{
+		{"func main() { run() }", "func util() {}"},
+		{mainChangeDiff},
+	}, embedded, "a pull request into a branch without pushes forwarded embeds the whole project")
+	_, ok, err := service.IndexState.GetIndexedSHA(context.Background(), models.GetBaseProjectIdentifier(prEvent.Owner, prEvent.Repo, "release"))
+	require.NoError(t, err)
+	assert.False(t, ok, "no base index is built for the release branch")
+}
+
+// writeProject writes files into a temporary directory and returns it.
+func writeProject(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	return dir
+}
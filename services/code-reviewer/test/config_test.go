@@ -0,0 +1,21 @@
+package test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go_code_reviewer/services/code-reviewer/internal/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig_RequiresDeadLetterTopic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("kafka:\n  topics: \"pr-events\"\n"), 0o600))
+
+	_, err := config.LoadConfig(path)
+	assert.ErrorContains(t, err, "dead_letter_topic")
+
+	_, err = config.LoadConfig("./config.yaml")
+	assert.NoError(t, err)
+}
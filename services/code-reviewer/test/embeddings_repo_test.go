@@ -0,0 +1,113 @@
+package test
+
+import (
+	"context"
+	"errors"
+	chroma "github.com/amikos-tech/chroma-go/pkg/api/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"go_code_reviewer/pkg/retry"
+	"go_code_reviewer/services/code-reviewer/internal/mocks"
+	"go_code_reviewer/services/code-reviewer/internal/models"
+	"go_code_reviewer/services/code-reviewer/internal/repositories"
+	"testing"
+	"time"
+)
+
+func TestEmbeddingRepository_BreakerFailsFastWhileChromaIsDown(t *testing.T) {
+	controller := gomock.NewController(t)
+	chromaClient := mocks.NewMockClient(controller)
+	collection := mocks.NewMockCollection(controller)
+	chromaClient.EXPECT().GetOrCreateCollection(gomock.Any(), "coderag").Return(collection, nil).Times(1)
+	chromaClient.EXPECT().GetOrCreateCollection(gomock.Any(), "coderag_projects").Return(mocks.NewMockCollection(controller), nil).Times(1)
+
+	var states []retry.State
+	breaker := retry.NewBreaker(retry.BreakerOptions{
+		Name:        "chroma",
+		MinRequests: 2,
+		OnStateChange: func(_ string, _, to retry.State) {
+			states = append(states, to)
+		},
+	})
+	repository, err := repositories.NewEmbeddingRepository(chromaClient, nil, "coderag", repositories.WithBreaker(breaker))
+	require.NoError(t, err)
+
+	errUnavailable := errors.New("chroma unavailable")
+	collection.EXPECT().Add(gomock.Any(), gomock.Any()).Return(errUnavailable).Times(2)
+	snippets := []*models.Snippet{{ID: "snippet-1", Content: "package main"}}
+	for i := 0; i < 2; i++ {
+		assert.ErrorIs(t, repository.Add(context.Background(), snippets, "project-123"), errUnavailable)
+	}
+
+	// Chroma is not called again until the cool-down passed
+	assert.ErrorIs(t, repository.Add(context.Background(), snippets, "project-123"), retry.ErrCircuitOpen)
+	_, err = repository.GetNearestRecord(context.Background(), []float32{0.1}, 5, "project-123")
+	assert.ErrorIs(t, err, retry.ErrCircuitOpen)
+	assert.Equal(t, []retry.State{retry.StateOpen}, states)
+}
+
+func TestEmbeddingRepository_ChromaUnavailable(t *testing.T) {
+	controller := gomock.NewController(t)
+	chromaClient := mocks.NewMockClient(controller)
+	chromaClient.EXPECT().GetOrCreateCollection(gomock.Any(), "coderag").Return(nil, errors.New("connection refused"))
+
+	_, err := repositories.NewEmbeddingRepository(chromaClient, nil, "coderag")
+	assert.ErrorContains(t, err, "connection refused")
+}
+
+func TestEmbeddingRepository_ListsProjectsFromTheirDocuments(t *testing.T) {
+	controller := gomock.NewController(t)
+	chromaClient := mocks.NewMockClient(controller)
+	collection := mocks.NewMockCollection(controller)
+	projects := mocks.NewMockCollection(controller)
+	chromaClient.EXPECT().GetOrCreateCollection(gomock.Any(), "coderag").Return(collection, nil).Times(1)
+	chromaClient.EXPECT().GetOrCreateCollection(gomock.Any(), "coderag_projects").Return(projects, nil).Times(1)
+	repository, err := repositories.NewEmbeddingRepository(chromaClient, nil, "coderag")
+	require.NoError(t, err)
+
+	metadata := func(projectId string, indexedAt int64, commit string) chroma.DocumentMetadata {
+		return chroma.NewDocumentMetadata(
+			chroma.NewStringAttribute("project_id", projectId),
+			chroma.NewIntAttribute("indexed_at", indexedAt),
+			chroma.NewStringAttribute("commit", commit),
+		)
+	}
+
+	t.Run("snippets are read once when the store has no project documents", func(t *testing.T) {
+		projects.EXPECT().Count(gomock.Any()).Return(0, nil)
+		collection.EXPECT().Get(gomock.Any(), gomock.Any()).Return(&chroma.GetResultImpl{
+			Ids:       chroma.DocumentIDs{"1", "2"},
+			Metadatas: chroma.DocumentMetadatas{metadata("project-1", 100, "old"), metadata("project-1", 200, "new")},
+		}, nil)
+		projects.EXPECT().Upsert(gomock.Any(), gomock.Any()).Return(nil)
+
+		listed, err := repository.ListProjects(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []models.ProjectIndex{{ProjectId: "project-1", IndexedAt: time.Unix(200, 0), Commit: "new"}}, listed)
+	})
+
+	t.Run("the project documents are read without the snippets", func(t *testing.T) {
+		projects.EXPECT().Count(gomock.Any()).Return(1, nil)
+		projects.EXPECT().Get(gomock.Any(), gomock.Any()).Return(&chroma.GetResultImpl{
+			Ids:       chroma.DocumentIDs{"project-1"},
+			Metadatas: chroma.DocumentMetadatas{metadata("project-1", 300, "newest")},
+		}, nil)
+
+		listed, err := repository.ListProjects(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []models.ProjectIndex{{ProjectId: "project-1", IndexedAt: time.Unix(300, 0), Commit: "newest"}}, listed)
+	})
+
+	t.Run("adding snippets writes the document and deleting the project removes it", func(t *testing.T) {
+		collection.EXPECT().Add(gomock.Any(), gomock.Any()).Return(nil)
+		projects.EXPECT().Upsert(gomock.Any(), gomock.Any()).Return(nil)
+		require.NoError(t, repository.Add(context.Background(), []*models.Snippet{{ID: "snippet-1", Content: "package main", Commit: "abc"}}, "project-1"))
+
+		gomock.InOrder(
+			collection.EXPECT().Delete(gomock.Any(), gomock.Any()).Return(nil),
+			projects.EXPECT().Delete(gomock.Any(), gomock.Any()).Return(nil),
+		)
+		require.NoError(t, repository.DeleteProject(context.Background(), "project-1"))
+	})
+}
@@ -7,13 +7,17 @@ import (
 	"github.com/google/go-github/v58/github"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go_code_reviewer/pkg/retry"
 	"go_code_reviewer/services/code-reviewer/internal/vsc"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDownloadUrl_Success(t *testing.T) {
@@ -34,6 +38,19 @@ func TestDownloadUrl_Success(t *testing.T) {
 	assert.Equal(t, expectedDiff, actualDiff)
 }
 
+func TestDownloadUrl_UsesAuthenticatedClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		fmt.Fprint(w, "diff")
+	}))
+	defer server.Close()
+
+	g := vsc.NewGithub(github.NewClient(nil).WithAuthToken("token"))
+	diff, err := g.DownloadUrl(context.Background(), server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "diff", diff)
+}
+
 func TestDownloadUrl_Error(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, "GET", r.Method)
@@ -48,12 +65,54 @@ func TestDownloadUrl_Error(t *testing.T) {
 	assert.Contains(t, err.Error(), "unexpected response: 404")
 }
 
+func TestDownloadUrl_HonorsRetryAfter(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, "diff")
+	}))
+	defer server.Close()
+
+	g := vsc.NewGithub(nil, vsc.WithRetry(retry.New[*http.Response](retry.Options{
+		MaxRetries: 3,
+		Strategy:   retry.ExponentialBackoff(time.Hour),
+		Classify:   retry.ClassifyGithub,
+	})))
+	diff, err := g.DownloadUrl(context.Background(), server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "diff", diff)
+	assert.Equal(t, 2, calls)
+}
+
+func TestDownloadUrl_DoesNotRetryClientErrors(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	g := vsc.NewGithub(nil, vsc.WithRetry(retry.New[*http.Response](retry.Options{
+		MaxRetries: 3,
+		Strategy:   retry.ExponentialBackoff(time.Millisecond),
+		Classify:   retry.ClassifyGithub,
+	})))
+	_, err := g.DownloadUrl(context.Background(), server.URL)
+	assert.True(t, retry.IsPermanent(err))
+	assert.Equal(t, 1, calls)
+}
+
 func TestClone_Success(t *testing.T) {
 	repoURL := "https://github.com/git-fixtures/basic.git"
 	branch := "master"
 
 	g := vsc.NewGithub(nil)
-	dir, cleanup, err := g.Clone(context.Background(), repoURL, branch)
+	dir, cleanup, err := g.Clone(context.Background(), vsc.CloneRequest{URL: repoURL, Branch: branch})
 	require.NoError(t, err)
 	require.NotNil(t, cleanup)
 
@@ -71,6 +130,59 @@ func TestClone_Success(t *testing.T) {
 	assert.True(t, os.IsNotExist(err), "directory should not exist after cleanup")
 }
 
+func TestClone_ChecksOutExactSHA(t *testing.T) {
+	origin, firstSHA, secondSHA := createLocalRepository(t)
+
+	g := vsc.NewGithub(nil)
+	dir, cleanup, err := g.Clone(context.Background(), vsc.CloneRequest{URL: "file://" + origin, Branch: "main", SHA: firstSHA})
+	require.NoError(t, err)
+	defer cleanup()
+	assert.Equal(t, firstSHA, gitOutput(t, dir, "rev-parse", "HEAD"))
+
+	// the head repository is gone (deleted fork), so the commit comes from the pull request ref
+	forkDir, forkCleanup, err := g.Clone(context.Background(), vsc.CloneRequest{
+		URL:      "file://" + origin,
+		HeadURL:  "file://" + filepath.Join(t.TempDir(), "deleted-fork"),
+		Branch:   "feature",
+		SHA:      secondSHA,
+		PRNumber: 7,
+	})
+	require.NoError(t, err)
+	defer forkCleanup()
+	assert.Equal(t, secondSHA, gitOutput(t, forkDir, "rev-parse", "HEAD"))
+
+	_, _, err = g.Clone(context.Background(), vsc.CloneRequest{
+		URL:      "file://" + origin,
+		HeadURL:  "file://" + filepath.Join(t.TempDir(), "deleted-fork"),
+		SHA:      firstSHA,
+		PRNumber: 7,
+	})
+	require.ErrorIs(t, err, vsc.ErrHeadMismatch)
+}
+
+func createLocalRepository(t *testing.T) (string, string, string) {
+	dir := t.TempDir()
+	gitOutput(t, dir, "init", "--quiet", "--initial-branch=main")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644))
+	gitOutput(t, dir, "add", ".")
+	gitOutput(t, dir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "first")
+	firstSHA := gitOutput(t, dir, "rev-parse", "HEAD")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644))
+	gitOutput(t, dir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-am", "second")
+	secondSHA := gitOutput(t, dir, "rev-parse", "HEAD")
+	gitOutput(t, dir, "update-ref", "refs/pull/7/head", secondSHA)
+	gitOutput(t, dir, "reset", "--quiet", "--hard", firstSHA)
+
+	return dir, firstSHA, secondSHA
+}
+
+func gitOutput(t *testing.T, dir string, args ...string) string {
+	output, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	require.NoError(t, err, string(output))
+	return strings.TrimSpace(string(output))
+}
+
 func TestPostPRComment_Success(t *testing.T) {
 	owner := "test-owner"
 	repo := "test-repo"
@@ -102,3 +214,62 @@ func TestPostPRComment_Success(t *testing.T) {
 	err = g.PostPRComment(context.Background(), prNumber, expectedBody, owner, repo)
 	assert.NoError(t, err)
 }
+
+func TestUpsertPRComment_EditsExistingComment(t *testing.T) {
+	owner := "test-owner"
+	repo := "test-repo"
+	prNumber := 42
+	marker := "<!-- marker -->"
+	expectedBody := marker + "\nupdated review"
+
+	edited := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == fmt.Sprintf("/api/v3/repos/%s/%s/issues/%d/comments", owner, repo, prNumber):
+			fmt.Fprint(w, `[{"id": 7, "body": "a human comment"}, {"id": 8, "body": "<!-- marker -->\nold review"}]`)
+		case r.Method == "PATCH" && r.URL.Path == fmt.Sprintf("/api/v3/repos/%s/%s/issues/comments/8", owner, repo):
+			var comment github.IssueComment
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&comment))
+			assert.Equal(t, expectedBody, comment.GetBody())
+			edited = true
+			fmt.Fprint(w, `{"id": 8}`)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	testClient, err := github.NewClient(server.Client()).WithEnterpriseURLs(server.URL, server.URL)
+	require.NoError(t, err)
+
+	g := vsc.NewGithub(testClient)
+	err = g.UpsertPRComment(context.Background(), prNumber, expectedBody, owner, repo, marker)
+	require.NoError(t, err)
+	assert.True(t, edited)
+}
+
+func TestVerifyToken_RejectsBadCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v3/user", r.URL.Path)
+		if r.Header.Get("Authorization") != "Bearer valid-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"message": "Bad credentials"}`)
+			return
+		}
+		fmt.Fprint(w, `{"login": "code-reviewer-bot"}`)
+	}))
+	defer server.Close()
+
+	for token, valid := range map[string]bool{"valid-token": true, "revoked-token": false} {
+		testClient, err := github.NewClient(server.Client()).WithAuthToken(token).WithEnterpriseURLs(server.URL, server.URL)
+		require.NoError(t, err)
+
+		err = vsc.NewGithub(testClient).VerifyToken(context.Background())
+		if valid {
+			assert.NoError(t, err)
+		} else {
+			assert.Error(t, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go_code_reviewer/services/code-reviewer/internal/config"
+	"go_code_reviewer/services/code-reviewer/internal/janitor"
+	"go_code_reviewer/services/code-reviewer/internal/models"
+	"go_code_reviewer/services/code-reviewer/internal/repositories"
+	"testing"
+	"time"
+)
+
+func TestJanitor_SweepRemovesIdleProjects(t *testing.T) {
+	repository, err := repositories.NewMemoryRepository()
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, repository.Add(ctx, []*models.Snippet{{ID: "1", Content: "func Old()", Embedding: []float32{1, 0}}}, "project-old"))
+
+	now := time.Now().Add(30 * time.Minute)
+	sweeper := janitor.NewJanitor(repository, config.JanitorSection{IdleTTL: time.Hour}, janitor.WithClock(func() time.Time { return now }))
+	removed, err := sweeper.Sweep(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, removed, "projects indexed within the TTL are kept")
+
+	now = now.Add(time.Hour)
+	removed, err = sweeper.Sweep(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	projects, err := repository.ListProjects(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, projects)
+}
+
+func TestJanitor_SweepWithoutTTLKeepsProjects(t *testing.T) {
+	repository, err := repositories.NewMemoryRepository()
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, repository.Add(ctx, []*models.Snippet{{ID: "1", Content: "func Old()", Embedding: []float32{1, 0}}}, "project-old"))
+
+	sweeper := janitor.NewJanitor(repository, config.JanitorSection{}, janitor.WithClock(func() time.Time { return time.Now().Add(24 * 365 * time.Hour) }))
+	removed, err := sweeper.Sweep(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+	assert.Equal(t, 1, repository.Len())
+}
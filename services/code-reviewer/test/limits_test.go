@@ -0,0 +1,98 @@
+package test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go_code_reviewer/services/code-reviewer/internal/config"
+	"go_code_reviewer/services/code-reviewer/internal/errors"
+	"go_code_reviewer/services/code-reviewer/internal/limits"
+	"testing"
+	"time"
+)
+
+func TestLimiterAcquire_RepositoryAndOrganizationLimits(t *testing.T) {
+	limiter := limits.NewLimiter(config.LimitsSection{
+		Repository:   config.LimitSection{MaxConcurrent: 1},
+		Organization: config.LimitSection{MaxConcurrent: 2},
+	})
+
+	releaseAPI, ok := limiter.Acquire("acme", "api")
+	require.True(t, ok)
+	_, ok = limiter.Acquire("acme", "api")
+	assert.False(t, ok, "repository is at its limit")
+
+	releaseWeb, ok := limiter.Acquire("acme", "web")
+	require.True(t, ok)
+	_, ok = limiter.Acquire("acme", "docs")
+	assert.False(t, ok, "organization is at its limit")
+
+	_, ok = limiter.Acquire("other", "api")
+	assert.True(t, ok, "other organizations are not affected")
+
+	releaseAPI()
+	releaseAPI()
+	_, ok = limiter.Acquire("acme", "api")
+	assert.True(t, ok, "a released slot can be taken again, releasing twice frees it once")
+	_, ok = limiter.Acquire("acme", "docs")
+	assert.False(t, ok)
+	releaseWeb()
+}
+
+func TestLimiterWait_UnblocksOnRelease(t *testing.T) {
+	limiter := limits.NewLimiter(config.LimitsSection{Repository: config.LimitSection{MaxConcurrent: 1}})
+	release, ok := limiter.Acquire("acme", "api")
+	require.True(t, ok)
+
+	acquired := make(chan struct{})
+	go func() {
+		waitRelease, err := limiter.Wait(context.Background(), "acme", "api")
+		assert.NoError(t, err)
+		waitRelease()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("wait returned while the repository was at its limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("wait did not return after the slot was released")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, ok = limiter.Acquire("acme", "api")
+	require.True(t, ok)
+	_, err := limiter.Wait(ctx, "acme", "api")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestLimiterCheck_DailyQuotasResetAtMidnight(t *testing.T) {
+	now := time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC)
+	limiter := limits.NewLimiter(config.LimitsSection{
+		Repository:   config.LimitSection{DailyReviews: 2},
+		Organization: config.LimitSection{DailyTokens: 1000},
+	}, limits.WithClock(func() time.Time { return now }))
+
+	require.NoError(t, limiter.Check("acme", "api"))
+	limiter.RecordReview("acme", "api")
+	limiter.RecordReview("acme", "api")
+	err := limiter.Check("acme", "api")
+	assert.ErrorIs(t, err, errors.ErrQuotaExceeded)
+	assert.Contains(t, err.Error(), "acme/api")
+	assert.NoError(t, limiter.Check("acme", "web"))
+
+	limiter.RecordTokens("acme", "web", 1000)
+	err = limiter.Check("acme", "docs")
+	assert.ErrorIs(t, err, errors.ErrQuotaExceeded)
+	assert.Contains(t, err.Error(), "1000 tokens")
+
+	now = now.Add(2 * time.Hour)
+	assert.NoError(t, limiter.Check("acme", "api"))
+	assert.NoError(t, limiter.Check("acme", "docs"))
+}
@@ -0,0 +1,131 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"go.uber.org/mock/gomock"
+	"go_code_reviewer/services/code-reviewer/internal/assistant"
+	"go_code_reviewer/services/code-reviewer/internal/config"
+	"go_code_reviewer/services/code-reviewer/internal/embedder"
+	mockembedder "go_code_reviewer/services/code-reviewer/internal/embedder/mocks"
+	"go_code_reviewer/services/code-reviewer/internal/mocks"
+	"go_code_reviewer/services/code-reviewer/internal/models"
+	"go_code_reviewer/services/code-reviewer/internal/repositories"
+	"go_code_reviewer/services/code-reviewer/testkit"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryRepository_Conformance(t *testing.T) {
+	for _, metric := range []repositories.Metric{repositories.MetricCosine, repositories.MetricDot} {
+		t.Run(string(metric), func(t *testing.T) {
+			testkit.RunEmbeddingsRepositoryConformance(t, func(t *testing.T) repositories.EmbeddingsRepository {
+				repository, err := repositories.NewMemoryRepository(repositories.WithMetric(metric))
+				require.NoError(t, err)
+				return repository
+			})
+		})
+	}
+}
+
+func TestMemoryRepository_SearchFiltersByMetadata(t *testing.T) {
+	repository, err := repositories.NewMemoryRepository(repositories.WithMetric(repositories.MetricDot))
+	require.NoError(t, err)
+	require.NoError(t, repository.Add(context.Background(), []*models.Snippet{
+		{ID: "1", Content: "func Add()", Filename: "math.go", Language: "go", Embedding: []float32{2, 0}},
+		{ID: "2", Content: "def add():", Filename: "math.py", Language: "python", Embedding: []float32{3, 0}},
+		{ID: "3", Content: "func Sub()", Filename: "sub.go", Language: "go", Embedding: []float32{1, 1}},
+	}, "project-123"))
+
+	results := repository.Search([]float32{1, 0}, 5, map[string]string{"project_id": "project-123", "language": "go"})
+	require.Len(t, results, 2)
+	assert.Equal(t, "func Add()", results[0].Snippet.Content)
+	assert.Equal(t, 2.0, results[0].Score)
+	assert.Equal(t, "func Sub()", results[1].Snippet.Content)
+	assert.Nil(t, results[0].Snippet.Embedding, "results do not leak the stored embeddings")
+
+	assert.Len(t, repository.Search([]float32{1, 0}, 5, map[string]string{"filename": "math.py"}), 1)
+	assert.Empty(t, repository.Search([]float32{1, 0}, 5, map[string]string{"branch": "main"}), "unknown keys match nothing")
+}
+
+func TestMemoryRepository_ReplacesSnippetsOfTheSameId(t *testing.T) {
+	repository, err := repositories.NewMemoryRepository()
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, repository.Add(ctx, []*models.Snippet{{ID: "1", Content: "old", Embedding: []float32{1, 0}}}, "project-123"))
+	require.NoError(t, repository.Add(ctx, []*models.Snippet{{ID: "1", Content: "new", Embedding: []float32{1, 0}}}, "project-123"))
+	require.NoError(t, repository.Add(ctx, []*models.Snippet{{ID: "1", Content: "other", Embedding: []float32{1, 0}}}, "project-456"))
+
+	assert.Equal(t, 2, repository.Len())
+	nearest, err := repository.GetNearestRecord(ctx, []float32{1, 0}, 5, "project-123")
+	require.NoError(t, err)
+	require.Len(t, nearest, 1)
+	assert.Equal(t, "new", nearest[0].Content)
+}
+
+func TestMemoryRepository_RestoresSnapshot(t *testing.T) {
+	snapshot := filepath.Join(t.TempDir(), "embeddings.snapshot")
+	repository, err := repositories.NewMemoryRepository(repositories.WithSnapshot(snapshot))
+	require.NoError(t, err)
+	require.NoError(t, repository.Add(context.Background(), []*models.Snippet{
+		{ID: "1", Content: "package main", Filename: "main.go", Language: "go", Embedding: []float32{1, 0}},
+	}, "project-123"))
+	require.NoError(t, repository.Close())
+
+	restored, err := repositories.NewMemoryRepository(repositories.WithSnapshot(snapshot))
+	require.NoError(t, err)
+	nearest, err := restored.GetNearestRecord(context.Background(), []float32{1, 0}, 5, "project-123")
+	require.NoError(t, err)
+	require.Len(t, nearest, 1)
+	assert.Equal(t, "package main", nearest[0].Content)
+}
+
+func TestMemoryRepository_UnknownMetric(t *testing.T) {
+	_, err := repositories.NewMemoryRepository(repositories.WithMetric("euclidean"))
+	assert.Error(t, err)
+}
+
+func TestAssistant_RetrievesContextFromMemoryRepository(t *testing.T) {
+	controller := gomock.NewController(t)
+	embeddingClient := mockembedder.NewMockEmbeddingClient(controller)
+	llm := mocks.NewMockModel(controller)
+	repository, err := repositories.NewMemoryRepository()
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	snippets := []*models.Snippet{
+		{ID: "1", Content: "func Sub(a, b int) int { return a - b }", Filename: "sub.go", Language: "go"},
+		{ID: "2", Content: "func Add(a, b int) int { return a + b }", Filename: "add.go", Language: "go"},
+	}
+	embeddingClient.EXPECT().CreateEmbeddings(ctx, "text-embedding-3-small", gomock.Len(2)).
+		Return([]embedder.Embedding{{Embedding: []float32{0, 1}}, {Embedding: []float32{1, 0}}}, nil)
+	projectEmbedder := embedder.NewProjectEmbedder(embeddingClient, repository, "text-embedding-3-small")
+	require.NoError(t, projectEmbedder.EmbedProject(ctx, "project-123", snippets))
+
+	cfg := &config.Config{
+		Tasks: config.TasksSection{
+			CodeReview: config.TaskConfig{
+				Prompts: config.PromptSection{ZeroShot: "Review this: {{.text}} with context: {{.context}}"},
+			},
+		},
+	}
+	queryText := "does Add overflow?"
+	embeddingClient.EXPECT().CreateEmbeddings(ctx, gomock.Any(), []string{queryText}).
+		Return([]embedder.Embedding{{Embedding: []float32{0.9, 0.1}}}, nil)
+
+	var prompt string
+	llm.EXPECT().GenerateContent(ctx, gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, messages []llms.MessageContent, _ ...llms.CallOption) (*llms.ContentResponse, error) {
+			prompt = fmt.Sprint(messages[0].Parts[0])
+			return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "no"}}}, nil
+		})
+
+	response, err := assistant.NewAssistant(cfg, repository, llm, embeddingClient).PerformTask(ctx, assistant.TaskCodeReview, queryText, "project-123")
+	require.NoError(t, err)
+	assert.Equal(t, "no", response)
+	assert.Contains(t, prompt, "--- Context Snippet 0 from file add.go ---\nfunc Add(a, b int) int { return a + b }")
+	assert.Contains(t, prompt, "--- Context Snippet 1 from file sub.go ---")
+}
@@ -1,16 +1,30 @@
 package test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tmc/langchaingo/llms"
 	"go.uber.org/mock/gomock"
+	pkgkafka "go_code_reviewer/pkg/kafka"
+	"go_code_reviewer/pkg/retry"
+	"go_code_reviewer/services/api-gateway/pkg/models"
+	"go_code_reviewer/services/code-reviewer/internal/config"
 	"go_code_reviewer/services/code-reviewer/internal/embedder"
+	serviceErrors "go_code_reviewer/services/code-reviewer/internal/errors"
+	"go_code_reviewer/services/code-reviewer/internal/limits"
 	"go_code_reviewer/services/code-reviewer/internal/mocks"
+	codemodels "go_code_reviewer/services/code-reviewer/internal/models"
+	"go_code_reviewer/services/code-reviewer/internal/repositories"
+	"go_code_reviewer/services/code-reviewer/internal/review"
+	"go_code_reviewer/services/code-reviewer/internal/vsc"
 	"go_code_reviewer/services/code-reviewer/testkit"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -21,6 +35,7 @@ func TestProcessPullRequestEvent(t *testing.T) {
 	service.KafkaConsumer.EXPECT().Start().Times(1)
 	ch := make(chan *kafka.Message, 1)
 	service.KafkaConsumer.EXPECT().Channel().Return(ch).AnyTimes()
+	service.KafkaConsumer.EXPECT().Context(gomock.Any()).Return(context.Background()).AnyTimes()
 
 	llmReview := "reject"
 	prEvent := testkit.GenerateRandomPullRequestEvent()
@@ -53,7 +68,7 @@ func main() {
 }`
 	err = os.WriteFile(filePath, []byte(goCode), 0644)
 	require.NoError(t, err)
-	service.VSCClient.EXPECT().Clone(gomock.Any(), prEvent.CloneURL, prEvent.Branch).Return(dirPath, cleanup, nil).Times(1)
+	service.VSCClient.EXPECT().Clone(gomock.Any(), testkit.CloneRequest(prEvent)).Return(dirPath, cleanup, nil).Times(1)
 	service.EmbeddingClient.EXPECT().CreateEmbeddings(gomock.Any(), gomock.Any(), []string{`func main() {
 	fmt.Println("Hello, World!")
 }`}).Return([]embedder.Embedding{{
@@ -77,9 +92,532 @@ func main() {
 		GenerateContent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
 		Return(&llms.ContentResponse{
 			Choices: []*llms.ContentChoice{{Content: llmReview}}}, nil).Times(1)
-	service.VSCClient.EXPECT().PostPRComment(gomock.Any(), prEvent.Number, llmReview, prEvent.Owner, prEvent.Repo).Return(nil).Times(1)
+	service.VSCClient.EXPECT().ListReviewComments(gomock.Any(), prEvent.Number, prEvent.Owner, prEvent.Repo).Return(nil, nil).Times(1)
+	expectedBody := review.SummaryMarker + "\n" + llmReview + "\n\n<sub>Inline comments: 0 new, 0 still open, 0 resolved.</sub>"
+	service.VSCClient.EXPECT().UpsertPRComment(gomock.Any(), prEvent.Number, expectedBody, prEvent.Owner, prEvent.Repo, review.SummaryMarker).Return(nil).Times(1)
 	service.KafkaConsumer.EXPECT().CommitMessage(kafkaMessage).Return(nil).Times(1)
 
 	service.Start()
 	time.Sleep(1 * time.Second)
 }
+
+func TestProcessSynchronizeEvent_ReviewsOnlyNewCommits(t *testing.T) {
+	service := testkit.NewService(t)
+	service.ChromaClient.EXPECT().GetOrCreateCollection(gomock.Any(), "coderag").Return(service.ChromaCollection, nil).Times(1)
+	service.KafkaConsumer.EXPECT().Start().Times(1)
+	ch := make(chan *kafka.Message, 1)
+	service.KafkaConsumer.EXPECT().Channel().Return(ch).AnyTimes()
+	service.KafkaConsumer.EXPECT().Context(gomock.Any()).Return(context.Background()).AnyTimes()
+
+	prEvent := testkit.GenerateRandomPullRequestEvent()
+	prEvent.Action = models.ActionSynchronize
+	lastReviewedSHA := "a10867b14bb761a232cd80139fbd4c0d33264240"
+	require.NoError(t, service.ReviewState.SetLastReviewedSHA(context.Background(), models.GetPullRequestKey(prEvent), lastReviewedSHA))
+
+	marshal, err := json.Marshal(prEvent)
+	require.NoError(t, err)
+	kafkaMessage := &kafka.Message{Value: marshal}
+	ch <- kafkaMessage
+
+	dirPath, err := os.MkdirTemp("", "gh-pr-*")
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dirPath, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644)
+	require.NoError(t, err)
+	cleanup := func() error {
+		return os.RemoveAll(dirPath)
+	}
+
+	incrementalDiff := "+++ b/main.go\n@@ -1,3 +1,3 @@\n-func main() {}\n+func main() { run() }\n"
+	service.VSCClient.EXPECT().Clone(gomock.Any(), testkit.CloneRequest(prEvent)).Return(dirPath, cleanup, nil).Times(1)
+	service.EmbeddingClient.EXPECT().CreateEmbeddings(gomock.Any(), gomock.Any(), gomock.Any()).Return([]embedder.Embedding{{Embedding: []float32{1, 2, 3}}}, nil).Times(2)
+	service.ChromaCollection.EXPECT().Add(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(1)
+	service.VSCClient.EXPECT().DownloadCompareDiff(gomock.Any(), prEvent.Owner, prEvent.Repo, lastReviewedSHA, prEvent.HeadSHA).Return(incrementalDiff, nil).Times(1)
+	service.VSCClient.EXPECT().DownloadUrl(gomock.Any(), gomock.Any()).Times(0)
+
+	queryResult := mocks.NewMockQueryResult(gomock.NewController(t))
+	service.ChromaCollection.EXPECT().Query(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(queryResult, nil).Times(1)
+	queryResult.EXPECT().GetDocumentsGroups().Times(1)
+	service.LLM.EXPECT().
+		GenerateContent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(&llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "looks good"}}}, nil).Times(1)
+	service.VSCClient.EXPECT().ListReviewComments(gomock.Any(), prEvent.Number, prEvent.Owner, prEvent.Repo).Return(nil, nil).Times(1)
+	service.VSCClient.EXPECT().UpsertPRComment(gomock.Any(), prEvent.Number, gomock.Any(), prEvent.Owner, prEvent.Repo, review.SummaryMarker).
+		DoAndReturn(func(_ context.Context, _ int, body, _, _, _ string) error {
+			assert.Contains(t, body, "Reviewed the changes pushed since "+lastReviewedSHA)
+			return nil
+		}).Times(1)
+	service.KafkaConsumer.EXPECT().CommitMessage(kafkaMessage).Return(nil).Times(1)
+
+	service.Start()
+	time.Sleep(1 * time.Second)
+
+	sha, ok, err := service.ReviewState.GetLastReviewedSHA(context.Background(), models.GetPullRequestKey(prEvent))
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, prEvent.HeadSHA, sha)
+}
+
+func TestProcessInvalidEvent_RoutesToDeadLetterTopic(t *testing.T) {
+	service := testkit.NewService(t)
+	service.ChromaClient.EXPECT().GetOrCreateCollection(gomock.Any(), "coderag").Return(service.ChromaCollection, nil).Times(1)
+	service.KafkaConsumer.EXPECT().Start().Times(1)
+	ch := make(chan *kafka.Message, 1)
+	service.KafkaConsumer.EXPECT().Channel().Return(ch).AnyTimes()
+	service.KafkaConsumer.EXPECT().Context(gomock.Any()).Return(context.Background()).AnyTimes()
+
+	topic := "pr-events"
+	kafkaMessage := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0, Offset: 12},
+		Value:          []byte("not json"),
+	}
+	ch <- kafkaMessage
+
+	service.KafkaProducer.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(msg *kafka.Message) error {
+		assert.Equal(t, "pr-events-dlq", *msg.TopicPartition.Topic)
+		assert.Equal(t, "12", pkgkafka.HeaderValue(msg, pkgkafka.HeaderOriginalOffset))
+		assert.Contains(t, pkgkafka.HeaderValue(msg, pkgkafka.HeaderFailureReason), "invalid pull request event")
+		return nil
+	}).Times(1)
+	service.KafkaConsumer.EXPECT().CommitMessage(kafkaMessage).Return(nil).Times(1)
+
+	service.Start()
+	time.Sleep(500 * time.Millisecond)
+}
+
+func TestProcessRoutingFailure_RetriesForTheRouteTimeout(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		failures  int
+		err       error
+		cancelled bool
+		committed bool
+		failed    bool
+		attempts  int32
+	}{
+		{name: "routed on a later attempt", failures: 2, err: pkgkafka.ErrDeliveryTimeout, committed: true, attempts: 3},
+		{name: "rejected by the brokers", failures: 1, err: kafka.NewError(kafka.ErrMsgSizeTooLarge, "message too large", false), committed: true, attempts: 1},
+		{name: "cancelled while routing", failures: 1, err: pkgkafka.ErrDeliveryTimeout, cancelled: true},
+		{name: "never routed", failures: 1000, err: pkgkafka.ErrDeliveryTimeout, failed: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			service := testkit.NewService(t)
+			service.ChromaClient.EXPECT().GetOrCreateCollection(gomock.Any(), "coderag").Return(service.ChromaCollection, nil).Times(1)
+			service.KafkaConsumer.EXPECT().Start().Times(1)
+			ch := make(chan *kafka.Message, 1)
+			service.KafkaConsumer.EXPECT().Channel().Return(ch).AnyTimes()
+			partitionCtx, revoke := context.WithCancel(context.Background())
+			defer revoke()
+			service.KafkaConsumer.EXPECT().Context(gomock.Any()).Return(partitionCtx).AnyTimes()
+
+			topic := "pr-events"
+			kafkaMessage := &kafka.Message{
+				TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0, Offset: 12},
+				Value:          []byte("not json"),
+			}
+			ch <- kafkaMessage
+
+			var attempts atomic.Int32
+			service.KafkaProducer.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(*kafka.Message) error {
+				if tt.cancelled {
+					revoke()
+				}
+				if int(attempts.Add(1)) <= tt.failures {
+					return tt.err
+				}
+				return nil
+			}).AnyTimes()
+			committed := make(chan struct{})
+			if tt.committed {
+				service.KafkaConsumer.EXPECT().CommitMessage(kafkaMessage).DoAndReturn(func(*kafka.Message) error {
+					close(committed)
+					return nil
+				}).Times(1)
+			}
+
+			service.Start()
+			failed := make(chan error, 1)
+			go func() { failed <- service.EventProcessor.Wait() }()
+			switch {
+			case tt.committed:
+				select {
+				case <-committed:
+				case <-time.After(5 * time.Second):
+					t.Fatal("routed message was not committed")
+				}
+				assert.Equal(t, tt.attempts, attempts.Load())
+			case tt.failed:
+				select {
+				case err := <-failed:
+					assert.ErrorIs(t, err, serviceErrors.ErrFailureRouting)
+				case <-time.After(5 * time.Second):
+					t.Fatal("the module did not fail")
+				}
+				assert.Greater(t, attempts.Load(), int32(2), "routing is retried for the route timeout")
+			default:
+				// the route timeout of the test config is a second
+				assert.Never(t, func() bool { return service.EventProcessor.Health(context.Background()) != nil }, 1500*time.Millisecond, 50*time.Millisecond,
+					"a cancelled message is left for its next consumer")
+			}
+			if !tt.failed {
+				assert.NoError(t, service.EventProcessor.Health(context.Background()))
+			}
+		})
+	}
+}
+
+func TestShutdown_CancelsReviewsAfterDeadline(t *testing.T) {
+	service := testkit.NewService(t)
+	service.ChromaClient.EXPECT().GetOrCreateCollection(gomock.Any(), "coderag").Return(service.ChromaCollection, nil).Times(1)
+	service.KafkaConsumer.EXPECT().Start().Times(1)
+	ch := make(chan *kafka.Message, 1)
+	service.KafkaConsumer.EXPECT().Channel().Return(ch).AnyTimes()
+	service.KafkaConsumer.EXPECT().Context(gomock.Any()).Return(context.Background()).AnyTimes()
+
+	prEvent := testkit.GenerateRandomPullRequestEvent()
+	marshal, err := json.Marshal(prEvent)
+	require.NoError(t, err)
+	ch <- &kafka.Message{Value: marshal}
+
+	cloning := make(chan struct{})
+	service.VSCClient.EXPECT().Clone(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, _ any) (string, func() error, error) {
+		close(cloning)
+		<-ctx.Done()
+		return "", nil, ctx.Err()
+	}).Times(1)
+	// a cancelled review is neither committed nor routed, so it is redelivered after the restart
+
+	service.Start()
+	<-cloning
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err = service.EventProcessor.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestProcessUnsupportedSchemaVersion_RoutesToDeadLetterTopic(t *testing.T) {
+	service := testkit.NewService(t)
+	service.ChromaClient.EXPECT().GetOrCreateCollection(gomock.Any(), "coderag").Return(service.ChromaCollection, nil).Times(1)
+	service.KafkaConsumer.EXPECT().Start().Times(1)
+	ch := make(chan *kafka.Message, 1)
+	service.KafkaConsumer.EXPECT().Channel().Return(ch).AnyTimes()
+	service.KafkaConsumer.EXPECT().Context(gomock.Any()).Return(context.Background()).AnyTimes()
+
+	prEvent := testkit.GenerateRandomPullRequestEvent()
+	marshal, err := json.Marshal(prEvent)
+	require.NoError(t, err)
+
+	topic := "pr-events"
+	kafkaMessage := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0, Offset: 3},
+		Key:            []byte(models.GetPullRequestKey(prEvent)),
+		Value:          marshal,
+		Headers: []kafka.Header{
+			{Key: models.HeaderEventType, Value: []byte(models.EventTypePullRequest)},
+			{Key: models.HeaderSchemaVersion, Value: []byte("2")},
+			{Key: models.HeaderDeliveryID, Value: []byte("72d3162e-cc78-11e3-81ab-4c9367dc0958")},
+		},
+	}
+	ch <- kafkaMessage
+
+	// the event is not cloned or reviewed, only routed with its key and headers intact
+	service.KafkaProducer.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(msg *kafka.Message) error {
+		assert.Equal(t, "pr-events-dlq", *msg.TopicPartition.Topic)
+		assert.Equal(t, kafkaMessage.Key, msg.Key)
+		assert.Equal(t, "72d3162e-cc78-11e3-81ab-4c9367dc0958", pkgkafka.HeaderValue(msg, models.HeaderDeliveryID))
+		assert.Contains(t, pkgkafka.HeaderValue(msg, pkgkafka.HeaderFailureReason), "unsupported schema version")
+		return nil
+	}).Times(1)
+	service.KafkaConsumer.EXPECT().CommitMessage(kafkaMessage).Return(nil).Times(1)
+
+	service.Start()
+	time.Sleep(500 * time.Millisecond)
+}
+
+func TestProcessAlreadyReviewedHeadSHA_IsSkipped(t *testing.T) {
+	service := testkit.NewService(t)
+	service.ChromaClient.EXPECT().GetOrCreateCollection(gomock.Any(), "coderag").Return(service.ChromaCollection, nil).Times(1)
+	service.KafkaConsumer.EXPECT().Start().Times(1)
+	ch := make(chan *kafka.Message, 1)
+	service.KafkaConsumer.EXPECT().Channel().Return(ch).AnyTimes()
+	service.KafkaConsumer.EXPECT().Context(gomock.Any()).Return(context.Background()).AnyTimes()
+
+	prEvent := testkit.GenerateRandomPullRequestEvent()
+	require.NoError(t, service.ReviewState.SetLastReviewedSHA(context.Background(), models.GetPullRequestKey(prEvent), prEvent.HeadSHA))
+	marshal, err := json.Marshal(prEvent)
+	require.NoError(t, err)
+
+	kafkaMessage := &kafka.Message{Value: marshal}
+	ch <- kafkaMessage
+
+	// nothing is cloned, reviewed or posted, the message is only committed
+	service.KafkaConsumer.EXPECT().CommitMessage(kafkaMessage).Return(nil).Times(1)
+
+	service.Start()
+	time.Sleep(500 * time.Millisecond)
+}
+
+func TestProcessClosedEvent_RemovesEmbeddingsWithoutReview(t *testing.T) {
+	service := testkit.NewService(t)
+	repository, err := repositories.NewMemoryRepository()
+	require.NoError(t, err)
+	service.EmbeddingsRepository = repository
+	service.KafkaConsumer.EXPECT().Start().Times(1)
+	ch := make(chan *kafka.Message, 1)
+	service.KafkaConsumer.EXPECT().Channel().Return(ch).AnyTimes()
+	service.KafkaConsumer.EXPECT().Context(gomock.Any()).Return(context.Background()).AnyTimes()
+
+	prEvent := testkit.GenerateRandomPullRequestEvent()
+	prEvent.Action = models.ActionClosed
+	projectId := models.GetProjectIdentifier(prEvent)
+	require.NoError(t, repository.Add(context.Background(), []*codemodels.Snippet{{ID: "1", Content: "func main() {}", Embedding: []float32{1, 0}}}, projectId))
+	require.NoError(t, repository.Add(context.Background(), []*codemodels.Snippet{{ID: "1", Content: "func main() {}", Embedding: []float32{1, 0}}}, "other-project"))
+	require.NoError(t, service.ReviewState.SetLastReviewedSHA(context.Background(), models.GetPullRequestKey(prEvent), prEvent.HeadSHA))
+
+	marshal, err := json.Marshal(prEvent)
+	require.NoError(t, err)
+	kafkaMessage := &kafka.Message{Value: marshal}
+	ch <- kafkaMessage
+
+	// nothing is cloned, reviewed or posted, the embeddings are removed and the message is committed
+	committed := make(chan struct{})
+	service.KafkaConsumer.EXPECT().CommitMessage(kafkaMessage).DoAndReturn(func(*kafka.Message) error {
+		close(committed)
+		return nil
+	}).Times(1)
+
+	service.Start()
+	select {
+	case <-committed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("closed event was not committed")
+	}
+
+	projects, err := repository.ListProjects(context.Background())
+	require.NoError(t, err)
+	require.Len(t, projects, 1)
+	assert.Equal(t, "other-project", projects[0].ProjectId)
+	_, reviewed, err := service.ReviewState.GetLastReviewedSHA(context.Background(), models.GetPullRequestKey(prEvent))
+	require.NoError(t, err)
+	assert.False(t, reviewed, "the review state of a closed pull request is pruned")
+}
+
+func TestProcessNewerPush_CancelsSupersededReview(t *testing.T) {
+	service := testkit.NewService(t)
+	service.ChromaClient.EXPECT().GetOrCreateCollection(gomock.Any(), "coderag").Return(service.ChromaCollection, nil).Times(1)
+	service.KafkaConsumer.EXPECT().Start().Times(1)
+	ch := make(chan *kafka.Message, 1)
+	service.KafkaConsumer.EXPECT().Channel().Return(ch).AnyTimes()
+	service.KafkaConsumer.EXPECT().Context(gomock.Any()).Return(context.Background()).AnyTimes()
+
+	topic := "pr-events"
+	message := func(headSHA string, offset kafka.Offset) *kafka.Message {
+		prEvent := testkit.GenerateRandomPullRequestEvent()
+		prEvent.HeadSHA = headSHA
+		marshal, err := json.Marshal(prEvent)
+		require.NoError(t, err)
+		return &kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0, Offset: offset},
+			Key:            []byte(models.GetPullRequestKey(prEvent)),
+			Value:          marshal,
+		}
+	}
+	first, second, stale := message("first", 5), message("second", 6), message("stale", 4)
+
+	cloning := make(chan struct{})
+	service.VSCClient.EXPECT().Clone(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, req vsc.CloneRequest) (string, func() error, error) {
+		if req.SHA == "first" {
+			close(cloning)
+			<-ctx.Done()
+			return "", nil, ctx.Err()
+		}
+		return "", nil, errors.New("clone failed")
+	}).Times(2)
+	// only the failed review of the newest push is retried
+	service.KafkaProducer.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(msg *kafka.Message) error {
+		assert.Equal(t, second.Value, msg.Value)
+		return nil
+	}).Times(1)
+	committed := make(chan *kafka.Message, 3)
+	service.KafkaConsumer.EXPECT().CommitMessage(gomock.Any()).DoAndReturn(func(msg *kafka.Message) error {
+		committed <- msg
+		return nil
+	}).Times(3)
+
+	service.Start()
+	ch <- first
+	<-cloning
+	ch <- second
+	assert.ElementsMatch(t, []*kafka.Message{first, second}, []*kafka.Message{<-committed, <-committed})
+
+	// an older push delivered after the newer one is skipped without a review
+	ch <- stale
+	assert.Equal(t, stale, <-committed)
+}
+
+// countingReviewState counts the lookups of the last reviewed sha, made by every review that is not skipped.
+type countingReviewState struct {
+	repositories.ReviewStateRepository
+	lookups atomic.Int32
+}
+
+func (s *countingReviewState) GetLastReviewedSHA(ctx context.Context, key string) (string, bool, error) {
+	s.lookups.Add(1)
+	return s.ReviewStateRepository.GetLastReviewedSHA(ctx, key)
+}
+
+func TestProcessOlderPush_IsReviewedOnceTheNewestIsForgotten(t *testing.T) {
+	service := testkit.NewService(t)
+	service.ChromaClient.EXPECT().GetOrCreateCollection(gomock.Any(), "coderag").Return(service.ChromaCollection, nil).Times(1)
+	service.KafkaConsumer.EXPECT().Start().Times(1)
+	ch := make(chan *kafka.Message, 1)
+	service.KafkaConsumer.EXPECT().Channel().Return(ch).AnyTimes()
+	service.KafkaConsumer.EXPECT().Context(gomock.Any()).Return(context.Background()).AnyTimes()
+
+	var elapsed atomic.Int64
+	start := time.Now()
+	service.Clock = func() time.Time { return start.Add(time.Duration(elapsed.Load())) }
+	reviewState := &countingReviewState{ReviewStateRepository: repositories.NewInMemoryReviewStateRepository()}
+	service.ReviewState = reviewState
+
+	// every head sha was already reviewed, a review that is not skipped only looks it up
+	prEvent := testkit.GenerateRandomPullRequestEvent()
+	require.NoError(t, reviewState.SetLastReviewedSHA(context.Background(), models.GetPullRequestKey(prEvent), prEvent.HeadSHA))
+	marshal, err := json.Marshal(prEvent)
+	require.NoError(t, err)
+	topic := "pr-events"
+	message := func(offset kafka.Offset) *kafka.Message {
+		return &kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0, Offset: offset},
+			Key:            []byte(models.GetPullRequestKey(prEvent)),
+			Value:          marshal,
+		}
+	}
+	committed := make(chan *kafka.Message, 1)
+	service.KafkaConsumer.EXPECT().CommitMessage(gomock.Any()).DoAndReturn(func(msg *kafka.Message) error {
+		committed <- msg
+		return nil
+	}).Times(3)
+
+	service.Start()
+	ch <- message(10)
+	<-committed
+	ch <- message(5)
+	<-committed
+	assert.Equal(t, int32(1), reviewState.lookups.Load(), "the older push is skipped")
+
+	elapsed.Store(int64(25 * time.Hour))
+	ch <- message(5)
+	<-committed
+	assert.Equal(t, int32(2), reviewState.lookups.Load(), "the newest push is forgotten after a day")
+}
+
+func TestProcessQuotaExceeded_PostsNoteInsteadOfReview(t *testing.T) {
+	service := testkit.NewService(t)
+	service.ChromaClient.EXPECT().GetOrCreateCollection(gomock.Any(), "coderag").Return(service.ChromaCollection, nil).Times(1)
+	service.KafkaConsumer.EXPECT().Start().Times(1)
+	ch := make(chan *kafka.Message, 1)
+	service.KafkaConsumer.EXPECT().Channel().Return(ch).AnyTimes()
+	service.KafkaConsumer.EXPECT().Context(gomock.Any()).Return(context.Background()).AnyTimes()
+
+	prEvent := testkit.GenerateRandomPullRequestEvent()
+	service.Limiter = limits.NewLimiter(config.LimitsSection{Organization: config.LimitSection{DailyReviews: 1}})
+	service.Limiter.RecordReview(prEvent.Owner, "another-repo")
+
+	marshal, err := json.Marshal(prEvent)
+	require.NoError(t, err)
+	kafkaMessage := &kafka.Message{Value: marshal}
+	ch <- kafkaMessage
+
+	// the push is not cloned or reviewed, the author gets a single note that is updated on later pushes
+	service.VSCClient.EXPECT().UpsertPRComment(gomock.Any(), prEvent.Number, gomock.Any(), prEvent.Owner, prEvent.Repo, review.QuotaMarker).DoAndReturn(
+		func(_ context.Context, _ int, body, _, _, _ string) error {
+			assert.Contains(t, body, "`"+prEvent.HeadSHA[:7]+"` was not reviewed")
+			assert.Contains(t, body, "used its 1 reviews of the day")
+			return nil
+		}).Times(1)
+	service.KafkaConsumer.EXPECT().CommitMessage(kafkaMessage).Return(nil).Times(1)
+
+	service.Start()
+	time.Sleep(500 * time.Millisecond)
+}
+
+func TestProcessConcurrencyLimit_PostponesEvent(t *testing.T) {
+	service := testkit.NewService(t)
+	service.ChromaClient.EXPECT().GetOrCreateCollection(gomock.Any(), "coderag").Return(service.ChromaCollection, nil).Times(1)
+	service.KafkaConsumer.EXPECT().Start().Times(1)
+	ch := make(chan *kafka.Message, 1)
+	service.KafkaConsumer.EXPECT().Channel().Return(ch).AnyTimes()
+	service.KafkaConsumer.EXPECT().Context(gomock.Any()).Return(context.Background()).AnyTimes()
+	service.Limiter = limits.NewLimiter(config.LimitsSection{Repository: config.LimitSection{MaxConcurrent: 1}})
+
+	topic := "pr-events"
+	message := func(number int, offset kafka.Offset) *kafka.Message {
+		prEvent := testkit.GenerateRandomPullRequestEvent()
+		prEvent.Number = number
+		marshal, err := json.Marshal(prEvent)
+		require.NoError(t, err)
+		return &kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0, Offset: offset},
+			Key:            []byte(models.GetPullRequestKey(prEvent)),
+			Value:          marshal,
+		}
+	}
+	running, waiting := message(1, 1), message(2, 2)
+
+	cloning, postponed := make(chan struct{}), make(chan struct{})
+	service.VSCClient.EXPECT().Clone(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ vsc.CloneRequest) (string, func() error, error) {
+		close(cloning)
+		<-postponed
+		return "", nil, errors.New("clone failed")
+	}).Times(1)
+	service.KafkaProducer.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(msg *kafka.Message) error {
+		if string(msg.Key) == string(waiting.Key) {
+			// postponed without counting a failed attempt
+			assert.Equal(t, "pr-events-retry-1m", *msg.TopicPartition.Topic)
+			assert.Equal(t, "0", pkgkafka.HeaderValue(msg, pkgkafka.HeaderAttempt))
+			close(postponed)
+			return nil
+		}
+		assert.Equal(t, "1", pkgkafka.HeaderValue(msg, pkgkafka.HeaderAttempt))
+		return nil
+	}).Times(2)
+	committed := make(chan *kafka.Message, 2)
+	service.KafkaConsumer.EXPECT().CommitMessage(gomock.Any()).DoAndReturn(func(msg *kafka.Message) error {
+		committed <- msg
+		return nil
+	}).Times(2)
+
+	service.Start()
+	ch <- running
+	<-cloning
+	ch <- waiting
+	assert.ElementsMatch(t, []*kafka.Message{running, waiting}, []*kafka.Message{<-committed, <-committed})
+}
+
+func TestProcessPermanentProviderError_RoutesToDeadLetterTopic(t *testing.T) {
+	service := testkit.NewService(t)
+	service.ChromaClient.EXPECT().GetOrCreateCollection(gomock.Any(), "coderag").Return(service.ChromaCollection, nil).Times(1)
+	service.KafkaConsumer.EXPECT().Start().Times(1)
+	ch := make(chan *kafka.Message, 1)
+	service.KafkaConsumer.EXPECT().Channel().Return(ch).AnyTimes()
+	service.KafkaConsumer.EXPECT().Context(gomock.Any()).Return(context.Background()).AnyTimes()
+
+	prEvent := testkit.GenerateRandomPullRequestEvent()
+	marshal, err := json.Marshal(prEvent)
+	require.NoError(t, err)
+	topic := "pr-events"
+	kafkaMessage := &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic}, Value: marshal}
+	ch <- kafkaMessage
+
+	// a repository that is gone will not come back on the retry topics
+	service.VSCClient.EXPECT().Clone(gomock.Any(), gomock.Any()).Return("", nil, retry.Permanent(errors.New("repository not found"))).Times(1)
+	service.KafkaProducer.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(msg *kafka.Message) error {
+		assert.Equal(t, "pr-events-dlq", *msg.TopicPartition.Topic)
+		return nil
+	}).Times(1)
+	service.KafkaConsumer.EXPECT().CommitMessage(kafkaMessage).Return(nil).Times(1)
+
+	service.Start()
+	time.Sleep(500 * time.Millisecond)
+}
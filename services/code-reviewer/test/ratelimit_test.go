@@ -0,0 +1,134 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"go.uber.org/mock/gomock"
+	"go_code_reviewer/pkg/retry"
+	"go_code_reviewer/services/code-reviewer/internal/config"
+	"go_code_reviewer/services/code-reviewer/internal/embedder"
+	embeddermock "go_code_reviewer/services/code-reviewer/internal/embedder/mocks"
+	"go_code_reviewer/services/code-reviewer/internal/mocks"
+	"go_code_reviewer/services/code-reviewer/internal/ratelimit"
+	"testing"
+	"time"
+)
+
+func newTestRateLimiter(conf config.RateLimitSection) (*ratelimit.Limiter, *time.Time) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	return ratelimit.NewLimiter(conf, ratelimit.WithClock(func() time.Time { return now })), &now
+}
+
+func waitBriefly(limiter *ratelimit.Limiter, tokens int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	return limiter.Wait(ctx, tokens)
+}
+
+func TestRateLimiter_QueuesUntilRequestsRefill(t *testing.T) {
+	limiter, now := newTestRateLimiter(config.RateLimitSection{RequestsPerMinute: 2})
+
+	require.NoError(t, waitBriefly(limiter, 0))
+	require.NoError(t, waitBriefly(limiter, 0))
+	assert.ErrorIs(t, waitBriefly(limiter, 0), context.DeadlineExceeded)
+
+	*now = now.Add(30 * time.Second)
+	assert.NoError(t, waitBriefly(limiter, 0), "the cancelled wait gave its request back")
+	assert.ErrorIs(t, waitBriefly(limiter, 0), context.DeadlineExceeded)
+}
+
+func TestRateLimiter_AdjustsEstimatedTokens(t *testing.T) {
+	limiter, _ := newTestRateLimiter(config.RateLimitSection{TokensPerMinute: 100})
+
+	require.NoError(t, waitBriefly(limiter, 100))
+	assert.ErrorIs(t, waitBriefly(limiter, 50), context.DeadlineExceeded)
+
+	limiter.Adjust(-50)
+	assert.NoError(t, waitBriefly(limiter, 50))
+}
+
+func TestRateLimiter_CallLargerThanLimitGoesThrough(t *testing.T) {
+	limiter, _ := newTestRateLimiter(config.RateLimitSection{TokensPerMinute: 100})
+	assert.NoError(t, waitBriefly(limiter, 1000))
+}
+
+func TestRateLimitRegistry_SharesLimiterPerModel(t *testing.T) {
+	registry := ratelimit.NewRegistry([]config.RateLimitSection{
+		{Provider: "openai", Model: "gpt-4.1-mini", RequestsPerMinute: 10},
+		{Provider: "openai", Model: "text-embedding-3-small"},
+	})
+
+	assert.NotNil(t, registry.For("openai", "gpt-4.1-mini"))
+	assert.Same(t, registry.For("openai", "gpt-4.1-mini"), registry.For("openai", "gpt-4.1-mini"))
+	assert.Nil(t, registry.For("openai", "text-embedding-3-small"), "zero limits are unlimited")
+	assert.Nil(t, registry.For("azure", "gpt-4.1-mini"))
+}
+
+func TestRateLimitedModel_WaitsBeforeCalling(t *testing.T) {
+	controller := gomock.NewController(t)
+	llm := mocks.NewMockModel(controller)
+	limiter, _ := newTestRateLimiter(config.RateLimitSection{RequestsPerMinute: 1})
+	model := ratelimit.NewModel(llm, limiter)
+
+	messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "review this")}
+	llm.EXPECT().GenerateContent(gomock.Any(), messages, gomock.Any()).Return(&llms.ContentResponse{
+		Choices: []*llms.ContentChoice{{Content: "looks good", GenerationInfo: map[string]any{"TotalTokens": 12}}},
+	}, nil)
+
+	resp, err := model.GenerateContent(context.Background(), messages)
+	require.NoError(t, err)
+	assert.Equal(t, "looks good", resp.Choices[0].Content)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = model.GenerateContent(ctx, messages)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "the second call queues and never reaches the model")
+}
+
+func TestRateLimitedEmbeddingClient_WaitsBeforeCalling(t *testing.T) {
+	controller := gomock.NewController(t)
+	client := embeddermock.NewMockEmbeddingClient(controller)
+	limiter, _ := newTestRateLimiter(config.RateLimitSection{TokensPerMinute: 4})
+	embeddingClient := ratelimit.NewEmbeddingClient(client, limiter)
+
+	client.EXPECT().CreateEmbeddings(gomock.Any(), "text-embedding-3-small", []string{"func main() {}"}).Return(nil, nil)
+	_, err := embeddingClient.CreateEmbeddings(context.Background(), "text-embedding-3-small", []string{"func main() {}"})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = embeddingClient.CreateEmbeddings(ctx, "text-embedding-3-small", []string{"func main() {}"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRetryingEmbeddingClient_WaitsForLimiterOnEveryAttempt(t *testing.T) {
+	controller := gomock.NewController(t)
+	client := embeddermock.NewMockEmbeddingClient(controller)
+	limiter, _ := newTestRateLimiter(config.RateLimitSection{RequestsPerMinute: 2})
+	embeddingClient := embedder.NewRetryingEmbeddingClient(ratelimit.NewEmbeddingClient(client, limiter), retry.New[[]embedder.Embedding](retry.Options{
+		MaxRetries: 3,
+		Strategy:   func(int) time.Duration { return time.Millisecond },
+	}))
+
+	gomock.InOrder(
+		client.EXPECT().CreateEmbeddings(gomock.Any(), "text-embedding-3-small", gomock.Any()).Return(nil, errors.New("server error")),
+		client.EXPECT().CreateEmbeddings(gomock.Any(), "text-embedding-3-small", gomock.Any()).Return([]embedder.Embedding{{Embedding: []float32{1}}}, nil),
+	)
+	embeddings, err := embeddingClient.CreateEmbeddings(context.Background(), "text-embedding-3-small", []string{"func main() {}"})
+	require.NoError(t, err)
+	assert.Len(t, embeddings, 1)
+
+	assert.ErrorIs(t, waitBriefly(limiter, 0), context.DeadlineExceeded, "both attempts took a request")
+}
+
+func TestRateLimitedClients_WithoutLimiter(t *testing.T) {
+	controller := gomock.NewController(t)
+	llm := mocks.NewMockModel(controller)
+	client := embeddermock.NewMockEmbeddingClient(controller)
+
+	assert.Same(t, llm, ratelimit.NewModel(llm, nil))
+	assert.Same(t, client, ratelimit.NewEmbeddingClient(client, nil))
+}
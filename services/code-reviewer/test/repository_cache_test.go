@@ -0,0 +1,76 @@
+package test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go_code_reviewer/services/code-reviewer/internal/vsc"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestRepositoryCache_Checkout(t *testing.T) {
+	origin, firstSHA, secondSHA := createLocalRepository(t)
+	cacheDir := t.TempDir()
+	cache, err := vsc.NewRepositoryCache(cacheDir, 0)
+	require.NoError(t, err)
+
+	dir, cleanup, err := cache.Checkout(context.Background(), vsc.CloneRequest{URL: "file://" + origin, SHA: firstSHA})
+	require.NoError(t, err)
+	assert.Equal(t, firstSHA, gitOutput(t, dir, "rev-parse", "HEAD"))
+	require.NoError(t, cleanup())
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err), "worktree should be removed after cleanup")
+
+	mirrors, err := os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	require.Len(t, mirrors, 1)
+
+	// the second commit is only reachable from the pull request ref, so the mirror has to fetch it
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dir, cleanup, err := cache.Checkout(context.Background(), vsc.CloneRequest{URL: "file://" + origin, SHA: secondSHA, PRNumber: 7})
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer cleanup()
+			content, err := os.ReadFile(filepath.Join(dir, "main.go"))
+			assert.NoError(t, err)
+			assert.Contains(t, string(content), "func main()")
+		}()
+	}
+	wg.Wait()
+
+	mirrors, err = os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	assert.Len(t, mirrors, 1)
+}
+
+func TestRepositoryCache_EvictsLeastRecentlyUsedMirror(t *testing.T) {
+	firstOrigin, firstSHA, _ := createLocalRepository(t)
+	secondOrigin, secondSHA, _ := createLocalRepository(t)
+	cacheDir := t.TempDir()
+	cache, err := vsc.NewRepositoryCache(cacheDir, 1)
+	require.NoError(t, err)
+
+	_, firstCleanup, err := cache.Checkout(context.Background(), vsc.CloneRequest{URL: "file://" + firstOrigin, SHA: firstSHA})
+	require.NoError(t, err)
+	_, secondCleanup, err := cache.Checkout(context.Background(), vsc.CloneRequest{URL: "file://" + secondOrigin, SHA: secondSHA})
+	require.NoError(t, err)
+
+	// the second mirror still has a worktree, so only the first one can be evicted
+	require.NoError(t, firstCleanup())
+	mirrors, err := os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	assert.Len(t, mirrors, 1)
+
+	require.NoError(t, secondCleanup())
+	mirrors, err = os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	assert.Len(t, mirrors, 0)
+}
@@ -0,0 +1,303 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"go.uber.org/mock/gomock"
+	"go_code_reviewer/pkg/retry"
+	"go_code_reviewer/services/code-reviewer/internal/assistant"
+	"go_code_reviewer/services/code-reviewer/internal/config"
+	"go_code_reviewer/services/code-reviewer/internal/embedder"
+	mockembedder "go_code_reviewer/services/code-reviewer/internal/embedder/mocks"
+	"go_code_reviewer/services/code-reviewer/internal/mocks"
+	"go_code_reviewer/services/code-reviewer/internal/models"
+	"go_code_reviewer/services/code-reviewer/internal/parser"
+	"go_code_reviewer/services/code-reviewer/internal/repositories"
+	"go_code_reviewer/services/code-reviewer/internal/retrieval"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+const parseHeaderDiff = "diff --git a/server.go b/server.go\n" +
+	"--- a/server.go\n" +
+	"+++ b/server.go\n" +
+	"@@ -1,3 +1,4 @@\n" +
+	" func serve(request string) {\n" +
+	"-\thandle(request)\n" +
+	"+\theader := parseHeader(request)\n" +
+	"+\thandle(header)\n"
+
+func contentsOf(snippets []*models.Snippet) []string {
+	contents := make([]string, 0, len(snippets))
+	for _, snippet := range snippets {
+		contents = append(contents, snippet.Content)
+	}
+	return contents
+}
+
+func TestTokenize_SplitsIdentifiers(t *testing.T) {
+	assert.Equal(t,
+		[]string{"parsehttpheader", "parse", "http", "header", "max_size", "max", "size", "v2"},
+		retrieval.Tokenize("parseHTTPHeader(max_size, 42, v2)"))
+}
+
+func TestLexicalIndex(t *testing.T) {
+	lexical := retrieval.NewLexicalIndex()
+	lexical.Add("project", []*models.Snippet{
+		{ID: "1", Content: "func parseHeader(request string) Header {}", Filename: "header.go", Symbol: "parseHeader"},
+		{ID: "2", Content: "func handle(header Header) {}", Filename: "handler.go", Symbol: "handle"},
+		{ID: "3", Content: "func main() { serve() }", Filename: "main.go", Symbol: "main"},
+	})
+
+	t.Run("search ranks the snippets sharing rare terms first", func(t *testing.T) {
+		snippets := lexical.Search("project", "header := parseHeader(request)", 5)
+		require.Len(t, snippets, 2, "snippets without a term of the query are left out")
+		assert.Equal(t, "header.go", snippets[0].Filename)
+		assert.Equal(t, "handler.go", snippets[1].Filename)
+		assert.Empty(t, lexical.Search("other", "parseHeader", 5))
+	})
+
+	t.Run("lookup finds definitions by symbol", func(t *testing.T) {
+		snippets := lexical.Lookup("project", []string{"handle", "unknown", "parseHeader"})
+		assert.Equal(t, []string{"handler.go", "header.go"}, []string{snippets[0].Filename, snippets[1].Filename})
+	})
+
+	t.Run("snippets are replaced and deleted", func(t *testing.T) {
+		lexical.Add("project", []*models.Snippet{{ID: "1", Content: "func parseLine() {}", Filename: "header.go", Symbol: "parseLine"}})
+		assert.Empty(t, lexical.Lookup("project", []string{"parseHeader"}))
+		assert.Len(t, lexical.Lookup("project", []string{"parseLine"}), 1)
+
+		lexical.DeleteFiles("project", []string{"header.go"})
+		assert.Empty(t, lexical.Search("project", "parseLine", 5))
+		lexical.DeleteProject("project")
+		assert.Empty(t, lexical.Search("project", "main", 5))
+	})
+}
+
+func TestParser_ExtractsDefinedSymbols(t *testing.T) {
+	goParser := parser.NewCodeParser(parser.LanguageGo)
+	snippets := goParser.ParseFile(context.Background(), []byte("package main\n\n"+
+		"type Server struct{}\n\n"+
+		"func (s *Server) Serve() {}\n\n"+
+		"func parseHeader() {}\n"), "main.go")
+	var symbols []string
+	for _, snippet := range snippets {
+		symbols = append(symbols, snippet.Symbol)
+	}
+	assert.Equal(t, []string{"Server", "Serve", "parseHeader"}, symbols)
+
+	pythonParser := parser.NewCodeParser(parser.LanguagePython)
+	snippets = pythonParser.ParseFile(context.Background(), []byte("class Server:\n    pass\n\ndef parse_header():\n    pass\n"), "main.py")
+	symbols = nil
+	for _, snippet := range snippets {
+		symbols = append(symbols, snippet.Symbol)
+	}
+	assert.Equal(t, []string{"Server", "parse_header"}, symbols)
+}
+
+func TestDiffIdentifiers_SkipsHeaders(t *testing.T) {
+	assert.Equal(t,
+		[]string{"func", "serve", "request", "string", "handle", "header", "parseHeader"},
+		retrieval.DiffIdentifiers(parseHeaderDiff))
+}
+
+func TestFuseRankings_PrefersSnippetsRankedByBoth(t *testing.T) {
+	a := &models.Snippet{Content: "a", Filename: "a.go"}
+	b := &models.Snippet{Content: "b", Filename: "b.go"}
+	c := &models.Snippet{Content: "c", Filename: "c.go"}
+	d := &models.Snippet{Content: "d", Filename: "d.go"}
+
+	fused := retrieval.FuseRankings(retrieval.DefaultFusionK,
+		[]*models.Snippet{a, b, c},
+		[]*models.Snippet{{Content: "c", Filename: "c.go"}, d},
+	)
+	assert.Equal(t, []string{"c", "a", "b", "d"}, contentsOf(fused), "b and d tie, b was ranked first")
+}
+
+func TestLLMReranker_PicksNumberedSnippets(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	llm := mocks.NewMockModel(ctrl)
+	candidates := []*models.Snippet{
+		{Content: "func a() {}", Filename: "a.go"},
+		{Content: "func b() {}", Filename: "b.go"},
+		{Content: "func c() {}", Filename: "c.go"},
+	}
+
+	var prompt string
+	llm.EXPECT().GenerateContent(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, messages []llms.MessageContent, _ ...llms.CallOption) (*llms.ContentResponse, error) {
+			prompt = fmt.Sprint(messages[0].Parts[0])
+			return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "3, 3, 7, 1"}}}, nil
+		}).Times(1)
+
+	reranked, err := retrieval.NewLLMReranker(llm).Rerank(context.Background(), parseHeaderDiff, candidates, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"func c() {}", "func a() {}"}, contentsOf(reranked), "repeated and unknown numbers are skipped")
+	assert.Contains(t, prompt, "### Snippet 2 from file b.go:\nfunc b() {}")
+
+	llm.EXPECT().GenerateContent(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(&llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "none of them"}}}, nil).Times(1)
+	_, err = retrieval.NewLLMReranker(llm).Rerank(context.Background(), parseHeaderDiff, candidates, 2)
+	assert.Error(t, err)
+}
+
+func TestLLMReranker_ThroughGuardedModel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	llm := mocks.NewMockModel(ctrl)
+	reranker := retrieval.NewLLMReranker(assistant.NewGuardedModel(llm, nil, nil))
+	candidates := []*models.Snippet{
+		{Content: strings.Repeat("é", 1500), Filename: "a.go"},
+		{Content: "func b() {}", Filename: "b.go"},
+	}
+
+	var prompt string
+	gomock.InOrder(
+		llm.EXPECT().GenerateContent(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, retry.RetryAfter(errors.New("rate limited"), time.Millisecond)),
+		llm.EXPECT().GenerateContent(gomock.Any(), gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, messages []llms.MessageContent, _ ...llms.CallOption) (*llms.ContentResponse, error) {
+				prompt = fmt.Sprint(messages[0].Parts[0])
+				return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "2", GenerationInfo: map[string]any{"TotalTokens": 42}}}}, nil
+			}),
+	)
+
+	ctx := assistant.WithTokenUsage(context.Background())
+	reranked, err := reranker.Rerank(ctx, parseHeaderDiff, candidates, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"func b() {}"}, contentsOf(reranked))
+	assert.Equal(t, 42, assistant.TokensUsed(ctx), "the tokens of the reranker count toward the quota")
+	assert.True(t, utf8.ValidString(prompt), "long snippets are cut between runes")
+	assert.Contains(t, prompt, strings.Repeat("é", 1000)+"\n\n### Snippet 2")
+}
+
+func TestCrossEncoderReranker(t *testing.T) {
+	candidates := []*models.Snippet{
+		{Content: "func a() {}", Filename: "a.go"},
+		{Content: "func b() {}", Filename: "b.go"},
+	}
+
+	t.Run("orders the candidates by the returned results", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+			var request struct {
+				Model     string   `json:"model"`
+				Query     string   `json:"query"`
+				Documents []string `json:"documents"`
+				TopN      int      `json:"top_n"`
+			}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+			assert.Equal(t, "rerank-v3", request.Model)
+			assert.Equal(t, []string{"func a() {}", "func b() {}"}, request.Documents)
+			assert.Equal(t, 1, request.TopN)
+			_, _ = w.Write([]byte(`{"results":[{"index":1,"relevance_score":0.9},{"index":0,"relevance_score":0.1}]}`))
+		}))
+		defer server.Close()
+
+		reranker := retrieval.NewCrossEncoderReranker(server.Client(), server.URL, "rerank-v3", "secret")
+		reranked, err := reranker.Rerank(context.Background(), "query", candidates, 1)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"func b() {}"}, contentsOf(reranked))
+	})
+
+	t.Run("fails on an error status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "invalid model", http.StatusBadRequest)
+		}))
+		defer server.Close()
+
+		reranker := retrieval.NewCrossEncoderReranker(server.Client(), server.URL, "unknown", "")
+		_, err := reranker.Rerank(context.Background(), "query", candidates, 1)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid model")
+	})
+}
+
+type failingReranker struct{}
+
+func (failingReranker) Rerank(context.Context, string, []*models.Snippet, int) ([]*models.Snippet, error) {
+	return nil, errors.New("reranker unavailable")
+}
+
+func TestRetriever_AddsDefinitionsAndShadowsBase(t *testing.T) {
+	lexical := retrieval.NewLexicalIndex()
+	lexical.Add("owner/repo@main", []*models.Snippet{
+		{ID: "1", Content: "func parseHeader(request string) string {}", Filename: "header.go", Symbol: "parseHeader"},
+		{ID: "2", Content: "func serve(request string) { handle(request) }", Filename: "server.go", Symbol: "serve"},
+	})
+	lexical.Add("owner/repo/feature/1", []*models.Snippet{
+		{ID: "1", Content: "func serve(request string) { handle(parseHeader(request)) }", Filename: "server.go", Symbol: "serve"},
+	})
+	overlay := repositories.Overlay{
+		ProjectId:     "owner/repo/feature/1",
+		BaseProjectId: "owner/repo@main",
+		ChangedFiles:  []string{"server.go"},
+	}
+	nearest := []*models.Snippet{{Content: "func unrelated() {}", Filename: "unrelated.go"}}
+
+	retriever := retrieval.NewRetriever(lexical, retrieval.WithReranker(failingReranker{}))
+	snippets := retriever.Retrieve(context.Background(), overlay, parseHeaderDiff, nearest, 2)
+	require.Len(t, snippets, 2, "a failing reranker keeps the fused ranking")
+	assert.Equal(t, "func serve(request string) { handle(parseHeader(request)) }", snippets[0].Content)
+	assert.Equal(t, "func parseHeader(request string) string {}", snippets[1].Content)
+	assert.Equal(t, 20, retriever.Candidates(5))
+}
+
+func TestAssistant_HybridRetrievalAddsCalledDefinition(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	embeddingClient := mockembedder.NewMockEmbeddingClient(ctrl)
+	llm := mocks.NewMockModel(ctrl)
+	ctx := context.Background()
+
+	memory, err := repositories.NewMemoryRepository()
+	require.NoError(t, err)
+	lexical := retrieval.NewLexicalIndex()
+	repository := retrieval.NewRepository(memory, lexical)
+	snippets := []*models.Snippet{
+		{ID: "serve", Content: "func serve(request string) { handle(request) }", Filename: "server.go", Symbol: "serve", Embedding: []float32{1, 0}},
+		{ID: "parseHeader", Content: "func parseHeader(raw string) string {}", Filename: "header.go", Symbol: "parseHeader", Embedding: []float32{0, 1}},
+	}
+	// nearer to the diff than parseHeader, the vector search alone fills the context with them
+	for i := 0; i < 5; i++ {
+		snippets = append(snippets, &models.Snippet{
+			ID:        fmt.Sprintf("noise%d", i),
+			Content:   fmt.Sprintf("func noise%d() {}", i),
+			Filename:  fmt.Sprintf("noise%d.go", i),
+			Embedding: []float32{1, 0.1 * float32(i+1)},
+		})
+	}
+	require.NoError(t, repository.Add(ctx, snippets, "proj-1"))
+
+	cfg := &config.Config{
+		Tasks: config.TasksSection{
+			CodeReview: config.TaskConfig{
+				Prompts: config.PromptSection{ZeroShot: "Review this: {{.text}}\n### Context:\n{{.context}}"},
+			},
+		},
+		LLM: config.LLMSection{MaxTokens: 100},
+	}
+	retriever := retrieval.NewRetriever(lexical, retrieval.WithCandidates(5))
+	assistantModule := assistant.NewAssistant(cfg, repository, llm, embeddingClient, assistant.WithRetriever(retriever))
+
+	embeddingClient.EXPECT().CreateEmbeddings(gomock.Any(), gomock.Any(), []string{parseHeaderDiff}).
+		Return([]embedder.Embedding{{Embedding: []float32{1, 0}}}, nil).Times(1)
+	var prompt string
+	llm.EXPECT().GenerateContent(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, messages []llms.MessageContent, _ ...llms.CallOption) (*llms.ContentResponse, error) {
+			prompt = fmt.Sprint(messages[0].Parts[0])
+			return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "LGTM"}}}, nil
+		}).Times(1)
+
+	_, err = assistantModule.ReviewDiff(ctx, parseHeaderDiff, "proj-1")
+	require.NoError(t, err)
+	_, retrieved, _ := strings.Cut(prompt, "### Context:")
+	assert.Contains(t, retrieved, "func parseHeader(raw string) string {}", "the definition of a called symbol is retrieved though its embedding is far")
+	assert.Contains(t, retrieved, "func serve(request string) { handle(request) }")
+}
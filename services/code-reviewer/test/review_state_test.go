@@ -0,0 +1,42 @@
+package test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go_code_reviewer/pkg/redis"
+	"go_code_reviewer/pkg/redis/redistest"
+	"go_code_reviewer/services/code-reviewer/internal/repositories"
+	"testing"
+	"time"
+)
+
+func TestRedisReviewStateRepository(t *testing.T) {
+	server := redistest.NewServer(t, "secret")
+	ctx := context.Background()
+	state, err := repositories.NewRedisReviewStateRepository(ctx, redis.Config{Address: server.Address(), Password: "secret"}, time.Hour)
+	require.NoError(t, err)
+	defer state.Close()
+
+	_, ok, err := state.GetLastReviewedSHA(ctx, "owner/repo#1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, state.SetLastReviewedSHA(ctx, "owner/repo#1", "abc123"))
+	assert.Equal(t, time.Hour, server.TTL("code-reviewer:reviewed-sha:owner/repo#1"))
+
+	// another replica, or this one after a restart, sees the review
+	other, err := repositories.NewRedisReviewStateRepository(ctx, redis.Config{Address: server.Address(), Password: "secret"}, time.Hour)
+	require.NoError(t, err)
+	defer other.Close()
+	sha, ok, err := other.GetLastReviewedSHA(ctx, "owner/repo#1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "abc123", sha)
+
+	require.NoError(t, other.DeleteLastReviewedSHA(ctx, "owner/repo#1"))
+	_, ok, err = state.GetLastReviewedSHA(ctx, "owner/repo#1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Zero(t, server.Keys())
+}
@@ -0,0 +1,347 @@
+package test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"go_code_reviewer/services/code-reviewer/internal/config"
+	"go_code_reviewer/services/code-reviewer/internal/review"
+	"go_code_reviewer/services/code-reviewer/internal/vsc"
+	vscmock "go_code_reviewer/services/code-reviewer/internal/vsc/mocks"
+	"go_code_reviewer/services/code-reviewer/testkit"
+	"strings"
+	"testing"
+)
+
+func TestParseReview(t *testing.T) {
+	output := "```json\n" + `{"summary": "Thanks @author!", "findings": [
+		{"file": "b/main.go", "line": 7, "severity": "MAJOR", "confidence": 0.9, "category": "Bug", "message": "nil pointer dereference"}
+	]}` + "\n```"
+
+	result, err := review.Parse(output)
+	require.NoError(t, err)
+	assert.Equal(t, "Thanks @author!", result.Summary)
+	require.Len(t, result.Findings, 1)
+	assert.Equal(t, &review.Finding{
+		File:       "main.go",
+		Line:       7,
+		Severity:   review.SeverityMajor,
+		Confidence: 0.9,
+		Category:   "bug",
+		Message:    "nil pointer dereference",
+	}, result.Findings[0])
+
+	_, err = review.Parse("looks good to me")
+	require.ErrorIs(t, err, review.ErrNoReviewFound)
+}
+
+func TestFilter_Apply(t *testing.T) {
+	filter := review.NewFilter(config.ReviewSection{
+		MinSeverity:        "minor",
+		MinConfidence:      0.5,
+		MaxCommentsPerFile: 2,
+		MaxCommentsPerPR:   3,
+		DisabledCategories: []string{"documentation"},
+		Repositories: map[string]config.RepositoryReviewSection{
+			"owner/repo": {DisabledCategories: []string{"style"}},
+		},
+	})
+
+	codeReview := &review.Review{
+		Summary: "summary",
+		Findings: []*review.Finding{
+			{File: "a.go", Line: 1, Severity: review.SeverityCritical, Confidence: 0.9, Category: "bug", Message: "race condition"},
+			{File: "a.go", Line: 9, Severity: review.SeverityMajor, Confidence: 0.9, Category: "bug", Message: "Race   condition"},
+			{File: "a.go", Line: 2, Severity: review.SeverityMajor, Confidence: 0.8, Category: "performance", Message: "allocation in loop"},
+			{File: "a.go", Line: 3, Severity: review.SeverityMinor, Confidence: 0.7, Category: "readability", Message: "long function"},
+			{File: "b.go", Line: 1, Severity: review.SeverityMinor, Confidence: 0.6, Category: "security", Message: "unchecked input"},
+			{File: "c.go", Line: 1, Severity: review.SeverityMinor, Confidence: 0.6, Category: "testing", Message: "missing test"},
+			{File: "a.go", Line: 4, Severity: review.SeverityInfo, Confidence: 0.9, Category: "bug", Message: "nit"},
+			{File: "a.go", Line: 5, Severity: review.SeverityMajor, Confidence: 0.2, Category: "bug", Message: "maybe wrong"},
+			{File: "a.go", Line: 6, Severity: review.SeverityMajor, Confidence: 0.9, Category: "documentation", Message: "add docs"},
+			{File: "a.go", Line: 7, Severity: review.SeverityMajor, Confidence: 0.9, Category: "style", Message: "rename"},
+		},
+	}
+
+	result := filter.Apply("owner/repo", codeReview)
+	require.Len(t, result.Findings, 3)
+	assert.Equal(t, "race condition", result.Findings[0].Message)
+	assert.Equal(t, "allocation in loop", result.Findings[1].Message)
+	assert.Equal(t, "unchecked input", result.Findings[2].Message)
+	assert.Equal(t, map[review.FilterReason]int{
+		review.ReasonDisabledCategory: 2,
+		review.ReasonLowSeverity:      1,
+		review.ReasonLowConfidence:    1,
+		review.ReasonDuplicate:        1,
+		review.ReasonFileLimit:        1,
+		review.ReasonPRLimit:          1,
+	}, result.Filtered)
+
+	other := filter.Apply("owner/other", &review.Review{Findings: []*review.Finding{
+		{File: "a.go", Severity: review.SeverityMajor, Confidence: 0.9, Category: "style", Message: "rename"},
+	}})
+	assert.Len(t, other.Findings, 1)
+}
+
+func TestRender(t *testing.T) {
+	body := review.Render(&review.Result{
+		Summary: "Nice work!",
+		Findings: []*review.Finding{
+			{File: "main.go", Line: 3, Severity: review.SeverityMajor, Category: "bug", Message: "ignored error"},
+		},
+		Filtered: map[review.FilterReason]int{review.ReasonLowSeverity: 2, review.ReasonDuplicate: 1},
+	})
+
+	assert.Equal(t, "Nice work!\n\n"+
+		"**main.go:3** `major` _bug_\nignored error\n\n"+
+		"<sub>3 findings were hidden: 1 duplicated, 2 below the severity threshold.</sub>", body)
+}
+
+func TestFinding_FingerprintIgnoresWording(t *testing.T) {
+	first := &review.Finding{File: "main.go", Line: 12, Category: "bug", Message: "The error returned by Close is ignored."}
+	second := &review.Finding{File: "main.go", Line: 14, Category: "bug", Message: "Close() can fail, handle its error"}
+	assert.Equal(t, first.Fingerprint(review.Anchor{Code: "defer file.Close()", Occurrence: 1}), second.Fingerprint(review.Anchor{Code: "defer file.Close()", Occurrence: 1}))
+	assert.NotEqual(t, first.Fingerprint(review.Anchor{Code: "defer file.Close()", Occurrence: 1}), second.Fingerprint(review.Anchor{Code: "return nil", Occurrence: 1}))
+}
+
+func TestFinding_FingerprintTellsCommonLinesApart(t *testing.T) {
+	finding := &review.Finding{File: "main.go", Line: 12, Category: "bug", Message: "the error is dropped"}
+	assert.NotEqual(t, finding.Fingerprint(review.Anchor{Code: "return err", Occurrence: 1}), finding.Fingerprint(review.Anchor{Code: "return err", Occurrence: 2}))
+
+	// outside the diff hunks there is no anchor, the message tells the findings apart
+	other := &review.Finding{File: "main.go", Line: 40, Category: "bug", Message: "The error is dropped."}
+	unrelated := &review.Finding{File: "main.go", Line: 80, Category: "bug", Message: "the loop never ends"}
+	assert.Equal(t, other.Fingerprint(review.Anchor{}), finding.Fingerprint(review.Anchor{}))
+	assert.NotEqual(t, unrelated.Fingerprint(review.Anchor{}), finding.Fingerprint(review.Anchor{}))
+}
+
+func TestCommentableLines(t *testing.T) {
+	diff := "diff --git a/main.go b/main.go\n" +
+		"--- a/main.go\n" +
+		"+++ b/main.go\n" +
+		"@@ -3,4 +3,5 @@ import \"fmt\"\n" +
+		" func main() {\n" +
+		"-\tfmt.Println(\"Hello, World!\")\n" +
+		"+\tfmt.Println(\"Hello, Word!\")\n" +
+		"+\tfmt.Println(\"Bye\")\n" +
+		" }\n" +
+		"diff --git a/old.go b/old.go\n" +
+		"--- a/old.go\n" +
+		"+++ /dev/null\n" +
+		"@@ -1 +0,0 @@\n" +
+		"-package old\n"
+
+	lines := review.CommentableLines(diff)
+	assert.Equal(t, map[string]map[int]bool{
+		"main.go": {3: true, 4: true, 5: true, 6: true},
+	}, lines)
+}
+
+func TestLineAnchors(t *testing.T) {
+	diff := "diff --git a/main.go b/main.go\n" +
+		"--- a/main.go\n" +
+		"+++ b/main.go\n" +
+		"@@ -3,3 +3,3 @@\n" +
+		" func main() {\n" +
+		"-\tfmt.Println(\"Hello, World!\")\n" +
+		"+\tfmt.Println(  \"Hello, Word!\")\n" +
+		" }\n" +
+		"@@ -10,1 +10,2 @@\n" +
+		" func run() {\n" +
+		"+}\n"
+
+	assert.Equal(t, map[string]map[int]review.Anchor{
+		"main.go": {
+			3:  {Code: "func main() {", Occurrence: 1},
+			4:  {Code: "fmt.Println( \"Hello, Word!\")", Occurrence: 1},
+			5:  {Code: "}", Occurrence: 1},
+			10: {Code: "func run() {", Occurrence: 1},
+			11: {Code: "}", Occurrence: 2},
+		},
+	}, review.LineAnchors(diff))
+}
+
+func TestChangedFiles(t *testing.T) {
+	diff := "diff --git a/main.go b/main.go\n" +
+		"--- a/main.go\n" +
+		"+++ b/main.go\n" +
+		"@@ -1,2 +1,2 @@\n" +
+		"--- a/removed.go\n" +
+		"+++ b/added.go\n" +
+		"diff --git a/old.go b/old.go\n" +
+		"--- a/old.go\n" +
+		"+++ /dev/null\n" +
+		"@@ -1 +0,0 @@\n" +
+		"-package old\n" +
+		"diff --git a/lib/new.go b/lib/new.go\n" +
+		"--- /dev/null\n" +
+		"+++ b/lib/new.go\n" +
+		"@@ -0,0 +1 @@\n" +
+		"+package lib\n"
+
+	assert.Equal(t, []string{"lib/new.go", "main.go", "old.go"}, review.ChangedFiles(diff), "lines of the hunks are not headers")
+}
+
+func TestChangedLines(t *testing.T) {
+	diff := "diff --git a/main.go b/main.go\n" +
+		"--- a/main.go\n" +
+		"+++ b/main.go\n" +
+		"@@ -3,5 +3,5 @@\n" +
+		" func main() {\n" +
+		"-\trun()\n" +
+		"+\tstart()\n" +
+		" }\n" +
+		" \n" +
+		"-func run() {}\n" +
+		"@@ -20,1 +19,2 @@\n" +
+		" var x = 1\n" +
+		"+var y = 2\n" +
+		"diff --git a/old.go b/old.go\n" +
+		"--- a/old.go\n" +
+		"+++ /dev/null\n" +
+		"@@ -1 +0,0 @@\n" +
+		"-package old\n"
+
+	assert.Equal(t, map[string][]int{"main.go": {4, 7, 20}}, review.ChangedLines(diff), "removed lines are placed at the line following them")
+}
+
+func TestDiff_ReadsHunkLinesAsContent(t *testing.T) {
+	// a removed "-- x" line and an added "++ x" line look like file headers to a reader that does not count
+	// the lines of the hunk
+	diff := "diff --git a/query.sql b/query.sql\n" +
+		"--- a/query.sql\n" +
+		"+++ b/query.sql\n" +
+		"@@ -1,3 +1,3 @@\n" +
+		"-- a/comment\n" +
+		"++ b/comment\n" +
+		" SELECT 1;\n" +
+		"\n" +
+		"diff --git a/lib.go b/lib.go\n" +
+		"--- a/lib.go\n" +
+		"+++ b/lib.go\n" +
+		"@@ -5 +5 @@\n" +
+		"-var x = 1\n" +
+		"+var x = 2\n" +
+		"\\ No newline at end of file\n"
+
+	assert.Equal(t, []string{"lib.go", "query.sql"}, review.ChangedFiles(diff))
+	assert.Equal(t, map[string][]int{"query.sql": {1}, "lib.go": {5}}, review.ChangedLines(diff))
+	assert.Equal(t, map[string]map[int]bool{
+		"query.sql": {1: true, 2: true, 3: true},
+		"lib.go":    {5: true},
+	}, review.CommentableLines(diff))
+	assert.Equal(t, review.Anchor{Code: "+ b/comment", Occurrence: 1}, review.LineAnchors(diff)["query.sql"][1])
+}
+
+func TestPublisher_Publish(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	vscClient := vscmock.NewMockVersionControlSystem(ctrl)
+	publisher := review.NewPublisher(vscClient)
+	event := testkit.GenerateRandomPullRequestEvent()
+	diff := "+++ b/main.go\n@@ -1,2 +1,3 @@\n package main\n+var x = 1\n+var y = 2\n"
+
+	kept := &review.Finding{File: "main.go", Line: 2, Severity: review.SeverityMajor, Category: "bug", Message: "still there"}
+	gone := &review.Finding{File: "main.go", Line: 3, Severity: review.SeverityMajor, Category: "bug", Message: "fixed by the author"}
+	inline := &review.Finding{File: "main.go", Line: 3, Severity: review.SeverityMinor, Category: "style", Message: "rename y"}
+	outside := &review.Finding{File: "other.go", Line: 10, Severity: review.SeverityMinor, Category: "style", Message: "not in diff"}
+
+	vscClient.EXPECT().ListReviewComments(gomock.Any(), event.Number, event.Owner, event.Repo).Return([]*vsc.ReviewComment{
+		{ID: 1, Path: "main.go", Line: 2, Body: "<!-- go-code-reviewer:finding:" + kept.Fingerprint(review.Anchor{Code: "var x = 1", Occurrence: 1}) + " -->\nstill there"},
+		{ID: 2, Path: "main.go", Line: 3, Body: "<!-- go-code-reviewer:finding:" + gone.Fingerprint(review.Anchor{Code: "var y = 2", Occurrence: 1}) + " -->\nfixed by the author"},
+		{ID: 3, Path: "main.go", Line: 1, Body: "a human comment"},
+		// far from the changed lines, the diff cannot have fixed it
+		{ID: 4, Path: "main.go", Line: 40, Body: "<!-- go-code-reviewer:finding:0123456789ab -->\nunrelated"},
+	}, nil)
+	vscClient.EXPECT().EditReviewComment(gomock.Any(), event.Owner, event.Repo, int64(2), gomock.Any()).
+		DoAndReturn(func(_ context.Context, _, _ string, _ int64, body string) error {
+			assert.Contains(t, body, "**Resolved:**")
+			return nil
+		})
+	vscClient.EXPECT().CreateReviewComments(gomock.Any(), event.Number, event.Owner, event.Repo, gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ int, _, _ string, comments []*vsc.ReviewComment) error {
+			require.Len(t, comments, 1)
+			assert.Equal(t, "main.go", comments[0].Path)
+			assert.Equal(t, 3, comments[0].Line)
+			assert.Contains(t, comments[0].Body, inline.Fingerprint(review.Anchor{Code: "var y = 2", Occurrence: 1}))
+			return nil
+		})
+	vscClient.EXPECT().UpsertPRComment(gomock.Any(), event.Number, gomock.Any(), event.Owner, event.Repo, review.SummaryMarker).
+		DoAndReturn(func(_ context.Context, _ int, body, _, _, _ string) error {
+			assert.True(t, strings.HasPrefix(body, review.SummaryMarker))
+			assert.Contains(t, body, "**other.go:10**")
+			assert.NotContains(t, body, "still there")
+			assert.Contains(t, body, "Inline comments: 1 new, 1 still open, 1 resolved.")
+			return nil
+		})
+
+	err := publisher.Publish(context.Background(), event, diff, &review.Result{
+		Summary:  "summary",
+		Findings: []*review.Finding{kept, inline, outside},
+	})
+	require.NoError(t, err)
+}
+
+func TestPublisher_KeepsThreadsOfRewordedFindings(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	vscClient := vscmock.NewMockVersionControlSystem(ctrl)
+	publisher := review.NewPublisher(vscClient)
+	event := testkit.GenerateRandomPullRequestEvent()
+	diff := "+++ b/main.go\n@@ -1,2 +1,4 @@\n package main\n+var x = 1\n+var y = 2\n+var z = 3\n"
+
+	same := &review.Finding{File: "main.go", Line: 2, Severity: review.SeverityMajor, Category: "bug", Message: "x is never read"}
+	moved := &review.Finding{File: "main.go", Line: 4, Severity: review.SeverityMinor, Category: "style", Message: "z could be a constant"}
+	vscClient.EXPECT().ListReviewComments(gomock.Any(), event.Number, event.Owner, event.Repo).Return([]*vsc.ReviewComment{
+		{ID: 1, Path: "main.go", Line: 2, Body: "<!-- go-code-reviewer:finding:" + same.Fingerprint(review.Anchor{Code: "var x = 1", Occurrence: 1}) + " -->\n`major` _bug_\nthe variable x is unused"},
+		// the line of the thread was edited since, so its anchor differs
+		{ID: 2, Path: "main.go", Line: 3, Body: "<!-- go-code-reviewer:finding:0123456789ab -->\n`minor` _style_\nmake z a constant"},
+	}, nil)
+	vscClient.EXPECT().EditReviewComment(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	vscClient.EXPECT().CreateReviewComments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	vscClient.EXPECT().UpsertPRComment(gomock.Any(), event.Number, gomock.Any(), event.Owner, event.Repo, review.SummaryMarker).
+		DoAndReturn(func(_ context.Context, _ int, body, _, _, _ string) error {
+			assert.Contains(t, body, "Inline comments: 0 new, 2 still open, 0 resolved.")
+			return nil
+		})
+
+	err := publisher.Publish(context.Background(), event, diff, &review.Result{
+		Summary:  "summary",
+		Findings: []*review.Finding{same, moved},
+	})
+	require.NoError(t, err)
+}
+
+func TestPublisher_TellsThreadsOfCommonLinesApart(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	vscClient := vscmock.NewMockVersionControlSystem(ctrl)
+	publisher := review.NewPublisher(vscClient)
+	event := testkit.GenerateRandomPullRequestEvent()
+	diff := "+++ b/main.go\n@@ -1,2 +1,12 @@\n package main\n+func a() {\n+}\n+\n+\n+\n+\n+\n+\n+func b() {\n+}\n"
+
+	first := &review.Finding{File: "main.go", Line: 3, Severity: review.SeverityMajor, Category: "bug", Message: "a never returns"}
+	second := &review.Finding{File: "main.go", Line: 11, Severity: review.SeverityMajor, Category: "bug", Message: "b never returns"}
+	fingerprint := first.Fingerprint(review.Anchor{Code: "}", Occurrence: 1})
+	vscClient.EXPECT().ListReviewComments(gomock.Any(), event.Number, event.Owner, event.Repo).Return([]*vsc.ReviewComment{
+		{ID: 1, Path: "main.go", Line: 3, Body: "<!-- go-code-reviewer:finding:" + fingerprint + " -->\n`major` _bug_\na never returns"},
+		// posted again on a push that had the line in another place
+		{ID: 2, Path: "main.go", Line: 5, Body: "<!-- go-code-reviewer:finding:" + fingerprint + " -->\n`major` _bug_\na never returns"},
+	}, nil)
+	vscClient.EXPECT().EditReviewComment(gomock.Any(), event.Owner, event.Repo, int64(2), gomock.Any()).Return(nil)
+	vscClient.EXPECT().CreateReviewComments(gomock.Any(), event.Number, event.Owner, event.Repo, gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ int, _, _ string, comments []*vsc.ReviewComment) error {
+			require.Len(t, comments, 1)
+			assert.Equal(t, 11, comments[0].Line, "the second closing brace is another line")
+			return nil
+		})
+	vscClient.EXPECT().UpsertPRComment(gomock.Any(), event.Number, gomock.Any(), event.Owner, event.Repo, review.SummaryMarker).
+		DoAndReturn(func(_ context.Context, _ int, body, _, _, _ string) error {
+			assert.Contains(t, body, "Inline comments: 1 new, 1 still open, 1 resolved.")
+			return nil
+		})
+
+	err := publisher.Publish(context.Background(), event, diff, &review.Result{
+		Summary:  "summary",
+		Findings: []*review.Finding{first, second},
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,159 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"go.uber.org/mock/gomock"
+	"go_code_reviewer/services/code-reviewer/internal/assistant"
+	"go_code_reviewer/services/code-reviewer/internal/config"
+	"go_code_reviewer/services/code-reviewer/internal/embedder"
+	mockembedder "go_code_reviewer/services/code-reviewer/internal/embedder/mocks"
+	"go_code_reviewer/services/code-reviewer/internal/mocks"
+	"go_code_reviewer/services/code-reviewer/internal/models"
+	"go_code_reviewer/services/code-reviewer/internal/parser"
+	"go_code_reviewer/services/code-reviewer/internal/repositories"
+	"go_code_reviewer/services/code-reviewer/internal/retrieval"
+	"strings"
+	"testing"
+)
+
+const serverSource = "package main\n" +
+	"\n" +
+	"type Server struct {\n" +
+	"\theader Header\n" +
+	"}\n" +
+	"\n" +
+	"func (s *Server) Serve(request string) {\n" +
+	"\th := parseHeader(request)\n" +
+	"\tfmt.Println(h)\n" +
+	"}\n"
+
+func TestParser_ExtractsLinesAndReferences(t *testing.T) {
+	snippets := parser.NewCodeParser(parser.LanguageGo).ParseFile(context.Background(), []byte(serverSource), "server.go")
+	require.Len(t, snippets, 2)
+	assert.Equal(t, []int{3, 5}, []int{snippets[0].StartLine, snippets[0].EndLine})
+	assert.Equal(t, []string{"Header"}, snippets[0].References)
+	assert.Equal(t, []int{7, 10}, []int{snippets[1].StartLine, snippets[1].EndLine})
+	assert.Equal(t, []string{"Server", "string", "parseHeader", "Println"}, snippets[1].References)
+
+	snippets = parser.NewCodeParser(parser.LanguagePython).ParseFile(context.Background(),
+		[]byte("def serve(request):\n    header = parse_header(request)\n    self.handle(header)\n    serve(request)\n"), "server.py")
+	require.Len(t, snippets, 1)
+	assert.Equal(t, []string{"parse_header", "handle"}, snippets[0].References, "recursive calls are left out")
+}
+
+func graphSnippet(id, filename, symbol string, start, end int, references ...string) *models.Snippet {
+	return &models.Snippet{
+		ID:         id,
+		Content:    fmt.Sprintf("func %s() { /* %s */ }", symbol, id),
+		Filename:   filename,
+		Symbol:     symbol,
+		StartLine:  start,
+		EndLine:    end,
+		References: references,
+	}
+}
+
+func TestSymbolGraph_Neighbors(t *testing.T) {
+	graph := retrieval.NewSymbolGraph()
+	graph.Add("owner/repo@main", []*models.Snippet{
+		graphSnippet("1", "header.go", "parseHeader", 1, 3),
+		graphSnippet("2", "server.go", "serve", 1, 4, "parseHeader", "handle"),
+		graphSnippet("3", "handler.go", "handle", 1, 2),
+		graphSnippet("4", "main.go", "main", 1, 3, "serve"),
+	})
+	graph.Add("owner/repo/feature/1", []*models.Snippet{
+		graphSnippet("1", "server.go", "serve", 1, 4, "parseHeader", "logRequest"),
+		graphSnippet("2", "server.go", "logRequest", 6, 8),
+	})
+	overlay := repositories.Overlay{
+		ProjectId:     "owner/repo/feature/1",
+		BaseProjectId: "owner/repo@main",
+		ChangedFiles:  []string{"server.go"},
+	}
+	changedLines := map[string][]int{"server.go": {2}}
+
+	neighbors := graph.Neighbors(overlay, changedLines, 5)
+	var described []string
+	for _, neighbor := range neighbors {
+		described = append(described, neighbor.Snippet.Filename+" "+neighbor.Relation)
+	}
+	assert.Equal(t, []string{
+		"header.go used by serve",
+		"server.go used by serve",
+		"main.go uses serve",
+	}, described, "callees come first, the old serve of the base is shadowed")
+	assert.Equal(t, "logRequest", neighbors[1].Snippet.Symbol)
+
+	assert.Len(t, graph.Neighbors(overlay, changedLines, 1), 1)
+	assert.Empty(t, graph.Neighbors(overlay, map[string][]int{"server.go": {5}}, 5), "no definition spans the line")
+
+	graph.DeleteFiles("owner/repo@main", []string{"main.go"})
+	assert.Len(t, graph.Neighbors(overlay, changedLines, 5), 2)
+	graph.DeleteProject("owner/repo/feature/1")
+	assert.Empty(t, graph.Neighbors(overlay, changedLines, 5))
+}
+
+func TestAssistant_SymbolGraphPutsCallersAndCalleesFirst(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	embeddingClient := mockembedder.NewMockEmbeddingClient(ctrl)
+	llm := mocks.NewMockModel(ctrl)
+	ctx := context.Background()
+
+	goParser := parser.NewCodeParser(parser.LanguageGo)
+	var snippets []*models.Snippet
+	snippets = append(snippets, goParser.ParseFile(ctx, []byte(serverSource), "server.go")...)
+	snippets = append(snippets, goParser.ParseFile(ctx, []byte("package main\n\nfunc parseHeader(raw string) string { return raw }\n"), "header.go")...)
+	snippets = append(snippets, goParser.ParseFile(ctx, []byte("package main\n\nfunc main() {\n\tnew(Server).Serve(\"GET /\")\n}\n"), "main.go")...)
+	snippets = append(snippets, goParser.ParseFile(ctx, []byte("package main\n\nfunc unrelated() {}\n"), "unrelated.go")...)
+	for i, snippet := range snippets {
+		snippet.Embedding = []float32{1, float32(i)}
+	}
+	// the unrelated snippet is the nearest to the diff
+	snippets[len(snippets)-1].Embedding = []float32{1, 100}
+
+	memory, err := repositories.NewMemoryRepository()
+	require.NoError(t, err)
+	graph := retrieval.NewSymbolGraph()
+	repository := retrieval.NewRepository(memory, graph)
+	require.NoError(t, repository.Add(ctx, snippets, "proj-1"))
+
+	cfg := &config.Config{
+		Tasks: config.TasksSection{
+			CodeReview: config.TaskConfig{
+				Prompts: config.PromptSection{ZeroShot: "Review this: {{.text}}\n### Context:\n{{.context}}"},
+			},
+		},
+		LLM: config.LLMSection{MaxTokens: 100},
+	}
+	assistantModule := assistant.NewAssistant(cfg, repository, llm, embeddingClient, assistant.WithSymbolGraph(graph, 5))
+
+	diff := "diff --git a/server.go b/server.go\n" +
+		"--- a/server.go\n" +
+		"+++ b/server.go\n" +
+		"@@ -8,2 +8,2 @@\n" +
+		"-\th := parseHeader(request)\n" +
+		"+\th := parseHeader(strings.TrimSpace(request))\n" +
+		" \tfmt.Println(h)\n"
+	embeddingClient.EXPECT().CreateEmbeddings(gomock.Any(), gomock.Any(), []string{diff}).
+		Return([]embedder.Embedding{{Embedding: []float32{1, 100}}}, nil).Times(1)
+	var prompt string
+	llm.EXPECT().GenerateContent(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, messages []llms.MessageContent, _ ...llms.CallOption) (*llms.ContentResponse, error) {
+			prompt = fmt.Sprint(messages[0].Parts[0])
+			return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "LGTM"}}}, nil
+		}).Times(1)
+
+	_, err = assistantModule.ReviewDiff(ctx, diff, "proj-1")
+	require.NoError(t, err)
+	_, retrieved, _ := strings.Cut(prompt, "### Context:")
+	graphContext := "--- Context Snippet 0 from file server.go, used by Serve ---\ntype Server struct {\n\theader Header\n}\n\n" +
+		"--- Context Snippet 1 from file header.go, used by Serve ---\nfunc parseHeader(raw string) string { return raw }\n\n" +
+		"--- Context Snippet 2 from file main.go, uses Serve ---\nfunc main() {\n\tnew(Server).Serve(\"GET /\")\n}\n\n"
+	assert.True(t, strings.HasPrefix(strings.TrimPrefix(retrieved, "\n"), graphContext), "the neighbors precede the search results:\n%s", retrieved)
+	assert.Contains(t, retrieved, "--- Context Snippet 3 from file unrelated.go ---")
+	assert.Equal(t, 1, strings.Count(retrieved, "func parseHeader"), "the search results do not repeat the neighbors")
+}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"context"
+	chroma "github.com/amikos-tech/chroma-go/pkg/api/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go_code_reviewer/services/code-reviewer/internal/models"
+	"go_code_reviewer/services/code-reviewer/internal/repositories"
+	"go_code_reviewer/services/code-reviewer/testkit"
+	"os"
+	"testing"
+)
+
+// The external backends are checked when their address is given, e.g. PGVECTOR_TEST_DSN=postgres://... go test.
+
+func TestDiskRepository_Conformance(t *testing.T) {
+	testkit.RunEmbeddingsRepositoryConformance(t, func(t *testing.T) repositories.EmbeddingsRepository {
+		repository, err := repositories.NewDiskRepository(t.TempDir())
+		require.NoError(t, err)
+		return repository
+	})
+}
+
+func TestDiskRepository_KeepsEmbeddingsAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	repository, err := repositories.NewDiskRepository(dir)
+	require.NoError(t, err)
+	snippet := &models.Snippet{ID: "snippet-1", Content: "package main", Filename: "main.go", Language: "go", Embedding: []float32{1, 0}}
+	require.NoError(t, repository.Add(context.Background(), []*models.Snippet{snippet}, "project-123"))
+
+	reopened, err := repositories.NewDiskRepository(dir)
+	require.NoError(t, err)
+	nearest, err := reopened.GetNearestRecord(context.Background(), []float32{1, 0}, 5, "project-123")
+	require.NoError(t, err)
+	require.Len(t, nearest, 1)
+	assert.Equal(t, "package main", nearest[0].Content)
+	assert.NoError(t, reopened.Health(context.Background()))
+}
+
+func TestPgvectorRepository_Conformance(t *testing.T) {
+	dsn := os.Getenv("PGVECTOR_TEST_DSN")
+	if dsn == "" {
+		t.Skip("PGVECTOR_TEST_DSN is not set")
+	}
+	testkit.RunEmbeddingsRepositoryConformance(t, func(t *testing.T) repositories.EmbeddingsRepository {
+		repository, err := repositories.NewPgvectorRepository(context.Background(), dsn, "code_embeddings_test")
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = repository.Close() })
+		return repository
+	})
+}
+
+func TestQdrantRepository_Conformance(t *testing.T) {
+	address := os.Getenv("QDRANT_TEST_URL")
+	if address == "" {
+		t.Skip("QDRANT_TEST_URL is not set")
+	}
+	collection := "coderag_test_" + uuid.NewString()
+	testkit.RunEmbeddingsRepositoryConformance(t, func(t *testing.T) repositories.EmbeddingsRepository {
+		repository, err := repositories.NewQdrantRepository(nil, address, os.Getenv("QDRANT_API_KEY"), collection)
+		require.NoError(t, err)
+		return repository
+	})
+}
+
+func TestChromaRepository_Conformance(t *testing.T) {
+	address := os.Getenv("CHROMA_TEST_URL")
+	if address == "" {
+		t.Skip("CHROMA_TEST_URL is not set")
+	}
+	chromaClient, err := chroma.NewHTTPClient(chroma.WithBaseURL(address))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = chromaClient.Close() })
+
+	collection := "coderag_test_" + uuid.NewString()
+	testkit.RunEmbeddingsRepositoryConformance(t, func(t *testing.T) repositories.EmbeddingsRepository {
+		repository, err := repositories.NewEmbeddingRepository(chromaClient, nil, collection)
+		require.NoError(t, err)
+		return repository
+	})
+}
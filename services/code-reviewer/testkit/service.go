@@ -6,6 +6,7 @@ import (
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/prompts"
 	"go.uber.org/mock/gomock"
+	"go_code_reviewer/pkg/kafka"
 	kafkamocks "go_code_reviewer/pkg/kafka/mocks"
 	"go_code_reviewer/pkg/log"
 	"go_code_reviewer/services/api-gateway/pkg/models"
@@ -14,13 +15,18 @@ import (
 	"go_code_reviewer/services/code-reviewer/internal/embedder"
 	embeddermock "go_code_reviewer/services/code-reviewer/internal/embedder/mocks"
 	eventprocessor "go_code_reviewer/services/code-reviewer/internal/event-processor"
+	"go_code_reviewer/services/code-reviewer/internal/janitor"
+	"go_code_reviewer/services/code-reviewer/internal/limits"
 	"go_code_reviewer/services/code-reviewer/internal/mocks"
 	"go_code_reviewer/services/code-reviewer/internal/parser"
 	"go_code_reviewer/services/code-reviewer/internal/repositories"
 	repositoriesmock "go_code_reviewer/services/code-reviewer/internal/repositories/mocks"
+	"go_code_reviewer/services/code-reviewer/internal/review"
+	"go_code_reviewer/services/code-reviewer/internal/vsc"
 	vscmock "go_code_reviewer/services/code-reviewer/internal/vsc/mocks"
 	"strings"
 	"testing"
+	"time"
 )
 
 type Service struct {
@@ -29,8 +35,20 @@ type Service struct {
 	ChromaClient     *mocks.MockClient
 	VSCClient        *vscmock.MockVersionControlSystem
 	KafkaConsumer    *kafkamocks.MockConsumer
+	KafkaProducer    *kafkamocks.MockProducer
 	EmbeddingRepo    *repositoriesmock.MockEmbeddingsRepository
 	ChromaCollection *mocks.MockCollection
+	ChromaProjects   *mocks.MockCollection
+	ReviewState      repositories.ReviewStateRepository
+	IndexState       repositories.IndexStateRepository
+	EventProcessor   *eventprocessor.Module
+	// Limiter replaces the limits of config.yaml when set before Start.
+	Limiter *limits.Limiter
+	// Clock replaces the clock of the event processor when set before Start.
+	Clock func() time.Time
+	// EmbeddingsRepository replaces the Chroma mocks when set before Start, e.g. with a MemoryRepository for
+	// real retrieval.
+	EmbeddingsRepository repositories.EmbeddingsRepository
 }
 
 func NewService(t *testing.T) *Service {
@@ -41,8 +59,12 @@ func NewService(t *testing.T) *Service {
 		ChromaClient:     mocks.NewMockClient(controller),
 		VSCClient:        vscmock.NewMockVersionControlSystem(controller),
 		KafkaConsumer:    kafkamocks.NewMockConsumer(controller),
+		KafkaProducer:    kafkamocks.NewMockProducer(controller),
 		EmbeddingRepo:    repositoriesmock.NewMockEmbeddingsRepository(controller),
 		ChromaCollection: mocks.NewMockCollection(controller),
+		ChromaProjects:   mocks.NewMockCollection(controller),
+		ReviewState:      repositories.NewInMemoryReviewStateRepository(),
+		IndexState:       repositories.NewInMemoryIndexStateRepository(),
 	}
 }
 
@@ -53,7 +75,16 @@ func (s *Service) Start() {
 		logger.WithError(err).Fatal("failed to load config.yaml")
 	}
 
-	embeddingsRepo := repositories.NewEmbeddingRepository(s.ChromaClient, nil, serviceConfig.ChromaDB.CollectionName)
+	embeddingsRepo := s.EmbeddingsRepository
+	if embeddingsRepo == nil {
+		// the project documents are written on every index and read by the janitor only
+		s.ChromaClient.EXPECT().GetOrCreateCollection(gomock.Any(), serviceConfig.ChromaDB.CollectionName+"_projects").Return(s.ChromaProjects, nil).AnyTimes()
+		s.ChromaProjects.EXPECT().Upsert(gomock.Any(), gomock.Any()).AnyTimes()
+		embeddingsRepo, err = repositories.NewEmbeddingRepository(s.ChromaClient, nil, serviceConfig.ChromaDB.CollectionName)
+		if err != nil {
+			logger.WithError(err).Fatal("failed to create embeddings repository")
+		}
+	}
 	projectParser := parser.NewProjectParser(map[string]*parser.CodeParser{
 		".py": parser.NewCodeParser(parser.LanguagePython),
 		".go": parser.NewCodeParser(parser.LanguageGo),
@@ -61,25 +92,54 @@ func (s *Service) Start() {
 
 	projectEmbedder := embedder.NewProjectEmbedder(s.EmbeddingClient, embeddingsRepo, serviceConfig.Embedding.Model)
 	codeAssistant := assistant.NewAssistant(serviceConfig, embeddingsRepo, s.LLM, s.EmbeddingClient)
-	eventProcessor := eventprocessor.NewModule(projectParser, projectEmbedder, codeAssistant, s.VSCClient, s.KafkaConsumer, serviceConfig.WorkerCount)
+	if s.Limiter == nil {
+		s.Limiter = limits.NewLimiter(serviceConfig.Limits)
+	}
+	var opts []eventprocessor.Option
+	if s.Clock != nil {
+		opts = append(opts, eventprocessor.WithClock(s.Clock))
+	}
+	s.EventProcessor = eventprocessor.NewModule(projectParser, projectEmbedder, codeAssistant, review.NewFilter(serviceConfig.Review), s.ReviewState, s.IndexState, s.VSCClient, s.KafkaConsumer, kafka.NewFailureRouter(s.KafkaProducer, retryConfig(serviceConfig.Kafka)), s.Limiter, janitor.NewJanitor(embeddingsRepo, serviceConfig.Janitor), serviceConfig.WorkerCount, opts...)
 
-	eventProcessor.Start()
+	s.EventProcessor.Start()
 	err = s.KafkaConsumer.Start()
 	if err != nil {
 		logger.WithError(err).Fatal("failed to start kafka consumer")
 	}
 }
 
+func retryConfig(kafkaConfig config.KafkaSection) kafka.RetryConfig {
+	retryConfig := kafka.RetryConfig{DeadLetterTopic: kafkaConfig.DeadLetterTopic, RouteTimeout: kafkaConfig.RouteTimeout}
+	for _, retryTopic := range kafkaConfig.RetryTopics {
+		retryConfig.Tiers = append(retryConfig.Tiers, kafka.RetryTier{Topic: retryTopic.Topic, Delay: retryTopic.Delay})
+	}
+	return retryConfig
+}
+
 func GenerateRandomPullRequestEvent() *models.PullRequestEvent {
 	return &models.PullRequestEvent{
-		Owner:    "MSaeed1381",
-		Repo:     "message-broker",
-		Number:   51,
-		CloneURL: "https://github.com/MSaeed1381/message-broker.git",
-		Branch:   "MSaeed1381-patch-52",
-		Title:    "Update main.go",
-		Author:   "MSaeed1381",
-		DiffURL:  "https://github.com/MSaeed1381/message-broker/pull/51.diff",
+		Owner:        "MSaeed1381",
+		Repo:         "message-broker",
+		Number:       51,
+		Action:       "opened",
+		CloneURL:     "https://github.com/MSaeed1381/message-broker.git",
+		HeadCloneURL: "https://github.com/MSaeed1381/message-broker.git",
+		Branch:       "MSaeed1381-patch-52",
+		HeadSHA:      "6dcb09b5b57875f334f61aebed695e2e4193db5e",
+		BaseSHA:      "9049f1265b7d61be4a8904a9a27120d2064dab3b",
+		Title:        "Update main.go",
+		Author:       "MSaeed1381",
+		DiffURL:      "https://github.com/MSaeed1381/message-broker/pull/51.diff",
+	}
+}
+
+func CloneRequest(event *models.PullRequestEvent) vsc.CloneRequest {
+	return vsc.CloneRequest{
+		URL:      event.CloneURL,
+		HeadURL:  event.HeadCloneURL,
+		Branch:   event.Branch,
+		SHA:      event.HeadSHA,
+		PRNumber: event.Number,
 	}
 }
 
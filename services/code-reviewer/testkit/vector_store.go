@@ -0,0 +1,165 @@
+package testkit
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go_code_reviewer/services/code-reviewer/internal/models"
+	"go_code_reviewer/services/code-reviewer/internal/repositories"
+	"testing"
+	"time"
+)
+
+// RunEmbeddingsRepositoryConformance checks the behaviour every EmbeddingsRepository backend shares. Each case
+// uses projects of its own, so newRepository may return repositories of the same store.
+func RunEmbeddingsRepositoryConformance(t *testing.T, newRepository func(t *testing.T) repositories.EmbeddingsRepository) {
+	ctx := context.Background()
+	snippets := func() []*models.Snippet {
+		return []*models.Snippet{
+			{ID: uuid.NewString(), Content: "func Add(a, b int) int", Filename: "math.go", Language: "go", Embedding: []float32{1, 0, 0}},
+			{ID: uuid.NewString(), Content: "func Sub(a, b int) int", Filename: "math.go", Language: "go", Embedding: []float32{0.8, 0.6, 0}},
+			{ID: uuid.NewString(), Content: "def parse(text):", Filename: "parser.py", Language: "python", Embedding: []float32{0, 0, 1}},
+		}
+	}
+
+	t.Run("unknown project has no snippets", func(t *testing.T) {
+		repository := newRepository(t)
+		nearest, err := repository.GetNearestRecord(ctx, []float32{1, 0, 0}, 5, uuid.NewString())
+		require.NoError(t, err)
+		assert.Empty(t, nearest)
+	})
+
+	t.Run("nearest snippets come first", func(t *testing.T) {
+		repository := newRepository(t)
+		projectId := uuid.NewString()
+		require.NoError(t, repository.Add(ctx, snippets(), projectId))
+
+		nearest, err := repository.GetNearestRecord(ctx, []float32{1, 0.1, 0}, 2, projectId)
+		require.NoError(t, err)
+		require.Len(t, nearest, 2)
+		assert.Equal(t, "func Add(a, b int) int", nearest[0].Content)
+		assert.Equal(t, "math.go", nearest[0].Filename)
+		assert.Equal(t, "go", nearest[0].Language)
+		assert.Equal(t, "func Sub(a, b int) int", nearest[1].Content)
+	})
+
+	t.Run("results are limited to the number asked for", func(t *testing.T) {
+		repository := newRepository(t)
+		projectId := uuid.NewString()
+		require.NoError(t, repository.Add(ctx, snippets(), projectId))
+
+		nearest, err := repository.GetNearestRecord(ctx, []float32{0, 0, 1}, 10, projectId)
+		require.NoError(t, err)
+		require.Len(t, nearest, 3)
+		assert.Equal(t, "def parse(text):", nearest[0].Content)
+	})
+
+	t.Run("projects are isolated", func(t *testing.T) {
+		repository := newRepository(t)
+		projectId, otherProjectId := uuid.NewString(), uuid.NewString()
+		require.NoError(t, repository.Add(ctx, snippets(), projectId))
+		require.NoError(t, repository.Add(ctx, snippets()[2:], otherProjectId))
+
+		nearest, err := repository.GetNearestRecord(ctx, []float32{1, 0, 0}, 10, otherProjectId)
+		require.NoError(t, err)
+		require.Len(t, nearest, 1)
+		assert.Equal(t, "def parse(text):", nearest[0].Content)
+	})
+
+	t.Run("snippets added later are found", func(t *testing.T) {
+		repository := newRepository(t)
+		projectId := uuid.NewString()
+		all := snippets()
+		require.NoError(t, repository.Add(ctx, all[2:], projectId))
+		require.NoError(t, repository.Add(ctx, all[:2], projectId))
+
+		nearest, err := repository.GetNearestRecord(ctx, []float32{1, 0, 0}, 1, projectId)
+		require.NoError(t, err)
+		require.Len(t, nearest, 1)
+		assert.Equal(t, "func Add(a, b int) int", nearest[0].Content)
+	})
+
+	t.Run("deleted projects have no snippets", func(t *testing.T) {
+		repository := newRepository(t)
+		projectId, otherProjectId := uuid.NewString(), uuid.NewString()
+		require.NoError(t, repository.Add(ctx, snippets(), projectId))
+		require.NoError(t, repository.Add(ctx, snippets(), otherProjectId))
+
+		require.NoError(t, repository.DeleteProject(ctx, projectId))
+		require.NoError(t, repository.DeleteProject(ctx, uuid.NewString()), "deleting an unknown project is not an error")
+
+		nearest, err := repository.GetNearestRecord(ctx, []float32{1, 0, 0}, 5, projectId)
+		require.NoError(t, err)
+		assert.Empty(t, nearest)
+		nearest, err = repository.GetNearestRecord(ctx, []float32{1, 0, 0}, 5, otherProjectId)
+		require.NoError(t, err)
+		assert.Len(t, nearest, 3)
+	})
+
+	t.Run("deleted files have no snippets", func(t *testing.T) {
+		repository := newRepository(t)
+		projectId, otherProjectId := uuid.NewString(), uuid.NewString()
+		require.NoError(t, repository.Add(ctx, snippets(), projectId))
+		require.NoError(t, repository.Add(ctx, snippets(), otherProjectId))
+
+		require.NoError(t, repository.DeleteFiles(ctx, projectId, []string{"math.go", "unknown.go"}))
+
+		nearest, err := repository.GetNearestRecord(ctx, []float32{1, 0, 0}, 5, projectId)
+		require.NoError(t, err)
+		require.Len(t, nearest, 1)
+		assert.Equal(t, "parser.py", nearest[0].Filename)
+		nearest, err = repository.GetNearestRecord(ctx, []float32{1, 0, 0}, 5, otherProjectId)
+		require.NoError(t, err)
+		assert.Len(t, nearest, 3, "other projects keep their files")
+	})
+
+	t.Run("projects are listed with their index time", func(t *testing.T) {
+		repository := newRepository(t)
+		projectId := uuid.NewString()
+		before := time.Now().Add(-time.Second)
+		require.NoError(t, repository.Add(ctx, snippets(), projectId))
+
+		projects, err := repository.ListProjects(ctx)
+		require.NoError(t, err)
+		var indexed *models.ProjectIndex
+		for i := range projects {
+			if projects[i].ProjectId == projectId {
+				indexed = &projects[i]
+			}
+		}
+		require.NotNil(t, indexed, "project %s is listed", projectId)
+		assert.WithinRange(t, indexed.IndexedAt, before, time.Now().Add(time.Second))
+
+		require.NoError(t, repository.DeleteProject(ctx, projectId))
+		projects, err = repository.ListProjects(ctx)
+		require.NoError(t, err)
+		assert.NotContains(t, projects, *indexed)
+	})
+
+	t.Run("projects are listed with their indexed commit", func(t *testing.T) {
+		repository := newRepository(t)
+		projectId := uuid.NewString()
+		withCommit := func(commit string) []*models.Snippet {
+			result := snippets()
+			for _, snippet := range result {
+				snippet.Commit = commit
+			}
+			return result
+		}
+		require.NoError(t, repository.Add(ctx, withCommit("9049f12"), projectId))
+		// the index time has a resolution of a second in some stores
+		time.Sleep(1100 * time.Millisecond)
+		require.NoError(t, repository.Add(ctx, withCommit("6dcb09b")[:1], projectId))
+
+		projects, err := repository.ListProjects(ctx)
+		require.NoError(t, err)
+		for _, project := range projects {
+			if project.ProjectId == projectId {
+				assert.Equal(t, "6dcb09b", project.Commit, "the commit of the latest snippets")
+				return
+			}
+		}
+		t.Fatalf("project %s is not listed", projectId)
+	})
+}